@@ -21,22 +21,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"time"
 
+	"zombiezen.com/go/goray/internal/distrib"
+	"zombiezen.com/go/goray/internal/goray"
 	"zombiezen.com/go/goray/internal/job"
 	"zombiezen.com/go/goray/internal/log"
 
+	_ "zombiezen.com/go/goray/internal/backgrounds"
 	_ "zombiezen.com/go/goray/internal/cameras"
+	_ "zombiezen.com/go/goray/internal/filters"
 	_ "zombiezen.com/go/goray/internal/integrators"
-	_ "zombiezen.com/go/goray/internal/lights"
+	"zombiezen.com/go/goray/internal/lights"
 	_ "zombiezen.com/go/goray/internal/materials"
+	_ "zombiezen.com/go/goray/internal/samplers"
 	_ "zombiezen.com/go/goray/internal/shaders/texmap"
 	"zombiezen.com/go/goray/internal/textures"
 	_ "zombiezen.com/go/goray/internal/textures"
+	"zombiezen.com/go/goray/internal/volumes"
 	"zombiezen.com/go/goray/internal/yamlscene"
 )
 
@@ -50,6 +65,20 @@ var (
 	imagePath    string
 	cpuprofile   string
 	debug        int
+
+	progressive bool
+	progSetup   goray.Progressive
+	snapshots   bool
+	timeout     time.Duration
+	region      regionFlag
+	crop        bool
+	aovs        aovFlag
+	aovFiles    bool
+	eyeFiles    bool
+
+	workerAddress string
+	workers       string
+	tileTimeout   time.Duration
 )
 
 func main() {
@@ -60,8 +89,23 @@ func main() {
 	flag.StringVar(&outputFormat, "f", job.DefaultFormat, "output format (default: "+job.DefaultFormat+")")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write CPU profile to file")
 	flag.IntVar(&debug, "d", 0, "set debug verbosity level")
-	flag.StringVar(&imagePath, "t", ".", "texture directory (default: current directory)")
+	flag.StringVar(&imagePath, "t", ".", "texture and voxel grid directory (default: current directory)")
 	maxProcs := flag.Int("procs", 1, "set the number of processors to use")
+	flag.BoolVar(&progressive, "progressive", false, "render in passes over the whole image")
+	flag.IntVar(&progSetup.PassSamples, "passsamples", 1, "samples per pixel in each progressive pass")
+	flag.IntVar(&progSetup.MaxSamples, "samples", 0, "stop a progressive render at this many samples per pixel")
+	flag.DurationVar(&progSetup.MaxTime, "time", 0, "stop a progressive render after this much time (e.g. 20m)")
+	flag.Float64Var(&progSetup.NoiseTarget, "noise", 0, "stop a progressive render once its noise estimate falls below this")
+	flag.BoolVar(&snapshots, "snapshots", false, "write the output after every progressive pass")
+	flag.DurationVar(&timeout, "timeout", 0, "cancel the render if it takes longer than this")
+	flag.Var(&region, "region", "only render the pixels in x0,y0,x1,y1")
+	flag.BoolVar(&crop, "crop", false, "output only the region instead of a full-size image")
+	flag.Var(&aovs, "aovs", "also render the comma-separated AOVs (e.g. depth,normal,albedo)")
+	flag.BoolVar(&aovFiles, "aovfiles", false, "write each AOV to its own file, even if the output format has layers")
+	flag.BoolVar(&eyeFiles, "eyefiles", false, "write each eye of a stereo camera to its own file")
+	flag.StringVar(&workerAddress, "worker", "", "run as a worker process that listens on this address")
+	flag.StringVar(&workers, "workers", "", "render on the comma-separated worker addresses")
+	flag.DurationVar(&tileTimeout, "tiletimeout", 0, "drop a worker that takes longer than this to render a tile")
 
 	flag.Usage = printInstructions
 	flag.Parse()
@@ -77,6 +121,8 @@ func main() {
 		printInstructions()
 	case showVersion:
 		printVersion()
+	case workerAddress != "":
+		exitCode = worker()
 	default:
 		exitCode = singleFile()
 	}
@@ -90,6 +136,7 @@ func setupLogging() {
 func printInstructions() {
 	fmt.Println("USAGE: goray [OPTIONS] FILE")
 	fmt.Println("       goray -http=:PORT [OPTIONS]")
+	fmt.Println("       goray -worker=ADDRESS [OPTIONS]")
 	fmt.Println("OPTIONS:")
 	flag.PrintDefaults()
 }
@@ -134,16 +181,20 @@ func singleFile() int {
 	}
 	defer inFile.Close()
 
-	// Open output file
+	// Open output file.  A stereo render with -eyefiles does not write to
+	// the output file, so it is not created until something is written.
 	if outputPath == "" {
 		outputPath = "goray" + formatStruct.Extension
 	}
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		log.Criticalf("Error opening output file: %v", err)
-		return 1
+	out := &rewriter{path: outputPath}
+	if !eyeFiles {
+		out.f, err = os.Create(outputPath)
+		if err != nil {
+			log.Criticalf("Error opening output file: %v", err)
+			return 1
+		}
 	}
-	defer outFile.Close()
+	defer out.Close()
 
 	// Set up profile file
 	var cpuprofileFile *os.File
@@ -159,20 +210,85 @@ func singleFile() int {
 	// Create job
 	j := job.New("job", inFile, yamlscene.Params{
 		"ImageLoader":  textures.NewImageLoader(imagePath),
+		"GridLoader":   volumes.NewGridLoader(imagePath),
+		"IESLoader":    lights.NewIESLoader(imagePath),
 		"OutputFormat": formatStruct,
 	})
 	ch := j.StatusChan()
 	j.SceneLog = log.Default
 	j.RenderLog = log.Default
+	j.Region = image.Rectangle(region)
+	j.Crop = crop
+	j.AOVs = aovs
+	if aovFiles || !formatStruct.Layers {
+		j.AOVWriter = func(a goray.AOV) (io.WriteCloser, error) {
+			return os.Create(aovPath(outputPath, a))
+		}
+	}
+	if eyeFiles {
+		j.EyeWriter = func(e goray.Eye) (io.WriteCloser, error) {
+			return os.Create(eyePath(outputPath, e))
+		}
+		if j.AOVWriter != nil {
+			j.EyeAOVWriter = func(e goray.Eye, a goray.AOV) (io.WriteCloser, error) {
+				return os.Create(aovPath(eyePath(outputPath, e), a))
+			}
+		}
+	}
+	if workers != "" {
+		j.Coordinator = &distrib.Coordinator{
+			Workers:     strings.Split(workers, ","),
+			TextureDir:  imagePath,
+			TileTimeout: tileTimeout,
+		}
+	}
+	if progressive {
+		j.Progressive = &progSetup
+		j.Snapshot = func(img *goray.Image, p goray.Progress) {
+			log.Infof("Pass %d: %d samples per pixel in %v (noise %.4f)", p.Pass, p.Samples, p.Elapsed, p.Noise)
+			if !snapshots {
+				return
+			}
+			if err := formatStruct.Encode(out, img); err != nil {
+				log.Errorf("Error writing snapshot: %v", err)
+			}
+			out.Rewind()
+		}
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	go func() {
 		if cpuprofileFile != nil {
 			pprof.StartCPUProfile(cpuprofileFile)
 		}
-		j.Render(outFile)
+		j.RenderContext(ctx, out)
+	}()
+
+	// Cancel the job on interrupt
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		log.Infof("Canceling...")
+		j.Cancel()
 	}()
 
 	// Log progress
+	lastCode, lastTenth := job.StatusCode(-1), 0
 	for stat := range ch {
+		if stat.Code == lastCode {
+			if tenth := int(stat.Progress * 10); stat.Code == job.StatusRendering && tenth > lastTenth {
+				log.Infof("Rendered %.0f%%, about %v left", stat.Progress*100, stat.ETA.Round(time.Second))
+				lastTenth = tenth
+			}
+			continue
+		}
+		lastCode = stat.Code
 		switch stat.Code {
 		case job.StatusReading:
 			log.Infof("Reading scene file...")
@@ -187,6 +303,9 @@ func singleFile() int {
 			pprof.StopCPUProfile()
 		case job.StatusDone:
 			log.Infof("TOTAL TIME: %v", stat.TotalTime())
+		case job.StatusCanceled:
+			log.Criticalf("Canceled: %v", stat.Error)
+			return 1
 		case job.StatusError:
 			log.Criticalf("Error: %v", stat.Error)
 			return 1
@@ -195,6 +314,120 @@ func singleFile() int {
 	return 0
 }
 
+// worker serves render tiles to coordinators until the process is killed.
+func worker() int {
+	l, err := net.Listen("tcp", workerAddress)
+	if err != nil {
+		log.Criticalf("Error starting worker: %v", err)
+		return 1
+	}
+	log.Infof("Worker listening on %v", l.Addr())
+	if err := distrib.Serve(l, distrib.NewWorker(log.Default)); err != nil {
+		log.Criticalf("Error serving: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// A regionFlag is a rectangle given on the command line as x0,y0,x1,y1.
+type regionFlag image.Rectangle
+
+func (r *regionFlag) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+}
+
+func (r *regionFlag) Set(s string) error {
+	var x0, y0, x1, y1 int
+	if _, err := fmt.Sscanf(s, "%d,%d,%d,%d", &x0, &y0, &x1, &y1); err != nil {
+		return errors.New("region must be x0,y0,x1,y1")
+	}
+	rect := image.Rect(x0, y0, x1, y1)
+	if rect.Empty() {
+		return errors.New("region must not be empty")
+	}
+	*r = regionFlag(rect)
+	return nil
+}
+
+// An aovFlag is a list of AOVs given on the command line as comma-separated
+// names.
+type aovFlag []goray.AOV
+
+func (f *aovFlag) String() string {
+	names := make([]string, len(*f))
+	for i, a := range *f {
+		names[i] = a.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *aovFlag) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		a, err := goray.ParseAOV(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		*f = append(*f, a)
+	}
+	return nil
+}
+
+// aovPath returns the path that an AOV is written to when it is written to its
+// own file: the output path with the AOV's name before the extension.
+func aovPath(output string, a goray.AOV) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "." + a.String() + ext
+}
+
+// eyePath returns the path that an eye of a stereo camera is written to: the
+// output path with the eye's name before the extension.
+func eyePath(output string, e goray.Eye) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "." + e.String() + ext
+}
+
+// A rewriter is a file that can be rewritten from the beginning.  After
+// Rewind is called, the next write replaces the contents of the file.  If f is
+// nil, the file at path is created on the first write.
+type rewriter struct {
+	f      *os.File
+	path   string
+	rewind bool
+}
+
+// Close closes the file if it was opened.
+func (w *rewriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// Rewind causes the next write to start over at the beginning of the file.
+func (w *rewriter) Rewind() {
+	w.rewind = true
+}
+
+func (w *rewriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		f, err := os.Create(w.path)
+		if err != nil {
+			return 0, err
+		}
+		w.f = f
+	}
+	if w.rewind {
+		if err := w.f.Truncate(0); err != nil {
+			return 0, err
+		}
+		if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		w.rewind = false
+	}
+	return w.f.Write(p)
+}
+
 // A filterLogger can hide messages below a given severity.
 type filterLogger struct {
 	log.Logger
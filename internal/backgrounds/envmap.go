@@ -0,0 +1,146 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package backgrounds provides the backgrounds that surround a scene.
+package backgrounds
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/textures"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yaml/parser"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// envMap is a background that surrounds the scene with an equirectangular
+// image.
+type envMap struct {
+	image     *goray.Image
+	mapping   latLong
+	intensity float64
+	light     *sphereLight
+}
+
+var _ goray.Background = &envMap{}
+
+// NewEnvMap creates a background from an equirectangular image.  The image is
+// turned counter-clockwise by rotation degrees around the up direction (as
+// seen from above) and its colors are multiplied by intensity.  If samples is
+// positive, the background is also a light that is importance sampled by the
+// brightness of its pixels.
+func NewEnvMap(img *goray.Image, up vec64.Vector, rotation, intensity float64, samples int) goray.Background {
+	bg := &envMap{
+		image:     img,
+		mapping:   newLatLong(up, rotation),
+		intensity: intensity,
+	}
+	if samples > 0 {
+		bg.light = newSphereLight(bg.mapping, bg.lookup, img.Width, img.Height, func(x, y int) color.Color {
+			return color.ScalarMul(img.Pixel(x, y), intensity)
+		}, samples)
+	}
+	return bg
+}
+
+// lookup returns the radiance of the background in a direction.
+func (bg *envMap) lookup(dir vec64.Vector) color.Color {
+	x, y := bg.mapping.toImage(dir)
+	w, h := bg.image.Width, bg.image.Height
+	xf, yf := x*float64(w)-0.5, y*float64(h)-0.5
+	x0, y0 := int(math.Floor(xf)), int(math.Floor(yf))
+	dx, dy := xf-float64(x0), yf-float64(y0)
+
+	// Wrap around horizontally and stop at the poles.
+	x1 := (x0 + 1) % w
+	x0 = (x0 + w) % w
+	y1 := y0 + 1
+	if y0 < 0 {
+		y0 = 0
+	}
+	if y1 >= h {
+		y1 = h - 1
+	}
+
+	c00, c10 := bg.image.Pixel(x0, y0), bg.image.Pixel(x1, y0)
+	c01, c11 := bg.image.Pixel(x0, y1), bg.image.Pixel(x1, y1)
+	col := color.Mix(color.Mix(c11, c01, dx), color.Mix(c10, c00, dx), dy)
+	return color.ScalarMul(col, bg.intensity)
+}
+
+func (bg *envMap) Color(r goray.Ray, state *goray.RenderState, filtered bool) color.Color {
+	return bg.lookup(r.Dir)
+}
+
+func (bg *envMap) Light() goray.Light {
+	if bg.light == nil {
+		return nil
+	}
+	return bg.light
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"backgrounds/envmap"] = yamldata.ConstructorFunc(constructEnvMap)
+}
+
+func constructEnvMap(n parser.Node, ud interface{}) (interface{}, error) {
+	mm, ok := n.(*parser.Mapping)
+	if !ok {
+		return nil, errors.New("Constructor requires a mapping")
+	}
+	params, _ := ud.(yamlscene.Params)
+	loader, ok := params["ImageLoader"].(textures.ImageLoader)
+	if !ok {
+		return nil, errors.New("No image loader provided")
+	}
+
+	m := yamldata.Map(mm.Map()).Copy()
+	name, ok := m["name"].(string)
+	if !ok {
+		return nil, errors.New("Environment map must contain name")
+	}
+	up, ok := m.SetDefault("up", vec64.Vector{0, 1, 0}).(vec64.Vector)
+	if !ok || up.IsZero() {
+		return nil, errors.New("Environment map up must be a nonzero vector")
+	}
+	rotation, _ := yamldata.AsFloat(m.SetDefault("rotation", 0.0))
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	light, ok := yamldata.AsBool(m.SetDefault("light", true))
+	if !ok {
+		return nil, errors.New("Environment map light must be a boolean")
+	}
+	if !light {
+		samples = 0
+	}
+
+	img, err := loader.LoadImage(name)
+	if err != nil {
+		return nil, err
+	}
+	if img.Width == 0 || img.Height == 0 {
+		return nil, errors.New("Environment map image is empty")
+	}
+	return NewEnvMap(img, up, rotation, intensity, samples), nil
+}
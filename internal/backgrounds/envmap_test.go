@@ -0,0 +1,105 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backgrounds
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+)
+
+// testEnvLight returns the light of an environment map whose rows alternate
+// between bright, dim and black, including the rows at both poles.
+func testEnvLight(t *testing.T) *sphereLight {
+	const w, h = 16, 8
+	img := goray.NewImage(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v float64
+			switch y {
+			case 0, 3, h - 1:
+				// black
+			default:
+				v = 0.1 + float64((x*7+y*3)%5)
+			}
+			img.Pix[y*w+x] = color.RGBA{v, v * 0.5, v * 0.25, 1}
+		}
+	}
+	bg := NewEnvMap(img, vec64.Vector{0, 0, 1}, 30, 1, 1)
+	l, ok := bg.Light().(*sphereLight)
+	if !ok {
+		t.Fatal("environment map has no light")
+	}
+	return l
+}
+
+func TestEnvMapSamplePdf(t *testing.T) {
+	l := testEnvLight(t)
+	w, h := l.dist.Width(), l.dist.Height()
+	counts := make([]int, w*h)
+	const n = 200000
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		dir, pdf := l.sample(rng.Float64(), rng.Float64())
+		if pdf <= 0 {
+			t.Fatalf("sample picked %v with pdf %v", dir, pdf)
+		}
+		if want := l.pdf(dir); math.Abs(pdf-want) > 1e-6*want {
+			t.Fatalf("sample picked %v with pdf %v; pdf(%v) = %v", dir, pdf, dir, want)
+		}
+		x, y := l.mapping.toImage(dir)
+		counts[int(y*float64(h))*w+int(x*float64(w))]++
+	}
+
+	// Each cell should be picked in proportion to the density over it.
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			want := n * l.dist.Pdf(float64(x)+0.5, float64(y)+0.5) / float64(w*h)
+			got := float64(counts[y*w+x])
+			if math.Abs(got-want) > 5*math.Sqrt(want)+1 {
+				t.Errorf("cell (%d, %d) picked %v times; want about %.1f", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEnvMapPdfIntegral(t *testing.T) {
+	l := testEnvLight(t)
+
+	// Integrate over a grid finer than the light's cells.  The density is
+	// pi times the solid angle density, like the densities of materials.
+	const w, h = 256, 128
+	dTheta, dPhi := math.Pi/h, 2*math.Pi/w
+	sum := 0.0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dir, sinTheta := l.mapping.fromImage((float64(x)+0.5)/w, (float64(y)+0.5)/h)
+			sum += l.pdf(dir) / math.Pi * sinTheta * dTheta * dPhi
+		}
+	}
+	if math.Abs(sum-1) > 1e-3 {
+		t.Errorf("pdf integrates to %v over the sphere; want 1", sum)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backgrounds
+
+import (
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/vecutil"
+)
+
+// latLong maps directions onto an equirectangular image.  The top row of the
+// image is straight up and the center of the image is along -u, where u and v
+// are the axes that vecutil.CreateCS builds around up.
+type latLong struct {
+	up, u, v vec64.Vector
+	rotation float64 // rotation is the fraction of a turn around up
+}
+
+// newLatLong creates a mapping that is turned counter-clockwise by rotation
+// degrees around up (as seen from above).
+func newLatLong(up vec64.Vector, rotation float64) latLong {
+	m := latLong{up: up.Normalize(), rotation: rotation / 360}
+	m.u, m.v = vecutil.CreateCS(m.up)
+	return m
+}
+
+// toImage finds the image coordinates of a direction, both in [0, 1).
+func (m latLong) toImage(dir vec64.Vector) (x, y float64) {
+	dir = dir.Normalize()
+	x = math.Atan2(vec64.Dot(dir, m.v), -vec64.Dot(dir, m.u))/(2*math.Pi) + 0.5 + m.rotation
+	x -= math.Floor(x)
+	y = 0.5 - math.Asin(math.Max(-1, math.Min(1, vec64.Dot(dir, m.up))))/math.Pi
+	return x, math.Max(0, math.Min(y, math.Nextafter(1, 0)))
+}
+
+// fromImage finds the direction of a point on the image.  sinTheta is the
+// sine of the angle between the direction and up.
+func (m latLong) fromImage(x, y float64) (dir vec64.Vector, sinTheta float64) {
+	phi := 2 * math.Pi * (x - m.rotation - 0.5)
+	theta := math.Pi * y
+	sinTheta = math.Sin(theta)
+	horiz := vec64.Add(m.u.Scale(-math.Cos(phi)), m.v.Scale(math.Sin(phi)))
+	return vec64.Add(m.up.Scale(math.Cos(theta)), horiz.Scale(sinTheta)), sinTheta
+}
@@ -0,0 +1,174 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backgrounds
+
+import (
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/vecutil"
+)
+
+// sphereLight is the light given off by a background that surrounds the
+// scene.  Directions are sampled in proportion to the brightness of the cells
+// of a grid laid over the background with a latLong mapping.
+type sphereLight struct {
+	mapping  latLong
+	radiance func(dir vec64.Vector) color.Color
+	dist     sampleutil.Pdf2D
+	power    color.Color // power is the radiance integrated over the sphere
+	samples  int
+
+	center vec64.Vector
+	radius float64
+}
+
+var _ goray.Light = &sphereLight{}
+var _ goray.LightIntersecter = &sphereLight{}
+
+// newSphereLight creates a light from a background's radiance.  cell returns
+// the average radiance of a cell of a w by h grid.
+func newSphereLight(m latLong, radiance func(vec64.Vector) color.Color, w, h int, cell func(x, y int) color.Color, samples int) *sphereLight {
+	f := make([]float64, w*h)
+	power := color.Black
+	for y := 0; y < h; y++ {
+		// Rows near the poles cover less of the sphere.
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(h))
+		for x := 0; x < w; x++ {
+			c := color.ScalarMul(cell(x, y), sinTheta)
+			f[y*w+x] = color.Energy(c)
+			power = color.Add(power, c)
+		}
+	}
+	return &sphereLight{
+		mapping:  m,
+		radiance: radiance,
+		dist:     sampleutil.NewPdf2D(f, w),
+		power:    color.ScalarMul(power, 2*math.Pi*math.Pi/float64(w*h)),
+		samples:  samples,
+	}
+}
+
+func (l *sphereLight) LightFlags() uint { return goray.LightTypeInfinite }
+func (l *sphereLight) NumSamples() int  { return l.samples }
+
+func (l *sphereLight) SetScene(scene *goray.Scene) {
+	b := scene.Bound()
+	l.center = b.Center()
+	l.radius = vec64.Sub(b.Max, l.center).Length()
+}
+
+// sample picks a direction toward the background.  The returned PDF is the
+// solid angle density multiplied by pi, like the PDFs of materials.
+func (l *sphereLight) sample(s1, s2 float64) (dir vec64.Vector, pdf float64) {
+	x, y, pdfImage := l.dist.Sample(s1, s2)
+	dir, sinTheta := l.mapping.fromImage(x/float64(l.dist.Width()), y/float64(l.dist.Height()))
+	if sinTheta <= 0 {
+		return dir, 0
+	}
+	// The grid covers 2*pi by pi radians.
+	return dir, pdfImage / (2 * math.Pi * sinTheta)
+}
+
+// pdf returns the density of sample picking a direction.
+func (l *sphereLight) pdf(dir vec64.Vector) float64 {
+	x, y := l.mapping.toImage(dir)
+	sinTheta := math.Sin(math.Pi * y)
+	if sinTheta <= 0 {
+		return 0
+	}
+	return l.dist.Pdf(x*float64(l.dist.Width()), y*float64(l.dist.Height())) / (2 * math.Pi * sinTheta)
+}
+
+func (l *sphereLight) TotalEnergy() color.Color {
+	// The light passes through a disk as wide as the scene from every
+	// direction.
+	return color.ScalarMul(l.power, l.radius*l.radius)
+}
+
+// emit picks a ray of light that enters the scene from the background.  The
+// returned PDFs are the density of the ray's origin on a disk that covers the
+// scene and the density of its direction (multiplied by pi, like sample).
+func (l *sphereLight) emit(s1, s2, s3, s4 float64) (col color.Color, r goray.Ray, areaPdf, dirPdf float64) {
+	dir, dirPdf := l.sample(s3, s4)
+	if dirPdf <= 0 {
+		return color.Black, r, 0, 0
+	}
+	du, dv := vecutil.CreateCS(dir)
+	u, v := sampleutil.ShirleyDisk(s1, s2)
+	offset := vec64.Add(du.Scale(u), dv.Scale(v))
+	r.From = vec64.Add(l.center, vec64.Add(dir, offset).Scale(l.radius))
+	r.Dir = dir.Negate()
+	return l.radiance(dir), r, 1 / (math.Pi * l.radius * l.radius), dirPdf
+}
+
+func (l *sphereLight) EmitPhoton(s1, s2, s3, s4 float64) (color.Color, goray.Ray, float64) {
+	col, r, areaPdf, dirPdf := l.emit(s1, s2, s3, s4)
+	if dirPdf <= 0 {
+		return col, r, 0
+	}
+	return col, r, 1 / (areaPdf * dirPdf)
+}
+
+func (l *sphereLight) EmitSample(s *goray.LightSample) (vec64.Vector, color.Color) {
+	col, r, areaPdf, dirPdf := l.emit(s.S1, s.S2, s.S3, s.S4)
+	s.Point.Position = r.From
+	s.AreaPdf, s.DirPdf = areaPdf, dirPdf
+	s.Flags = l.LightFlags()
+	return r.Dir, color.ScalarDiv(col, math.Pi)
+}
+
+func (l *sphereLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	return 1 / (math.Pi * l.radius * l.radius), l.pdf(wo.Negate()), 1
+}
+
+func (l *sphereLight) CanIlluminate(pt vec64.Vector) bool {
+	return true
+}
+
+func (l *sphereLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) bool {
+	dir, pdf := l.sample(s.S1, s.S2)
+	if pdf <= 0 {
+		return false
+	}
+	wi.Dir = dir
+	wi.TMax = -1
+	s.Color = l.radiance(dir)
+	s.Pdf = pdf
+	s.Flags = l.LightFlags()
+	s.Point.Position = vec64.Add(sp.Position, dir.Scale(2*l.radius))
+	return true
+}
+
+func (l *sphereLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	return l.pdf(vec64.Sub(spLight.Position, sp.Position))
+}
+
+func (l *sphereLight) Intersect(r goray.Ray) (dist float64, col color.Color, ipdf float64, ok bool) {
+	pdf := l.pdf(r.Dir)
+	if pdf <= 0 {
+		return
+	}
+	return math.Inf(1), l.radiance(r.Dir), 1 / pdf, true
+}
@@ -0,0 +1,275 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backgrounds
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/lights"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// Sun and sky constants
+const (
+	// sunIlluminance is the illuminance of the sun outside of the atmosphere
+	// in lux.  Sky radiances are scaled so that this much light has a color of
+	// one.
+	sunIlluminance = 127500.0
+
+	// sunAngularRadius is the angular radius of the sun's disk in radians.
+	sunAngularRadius = 0.2665 * math.Pi / 180
+)
+
+// sunSky is the daylight sky from "A Practical Analytic Model for Daylight"
+// by Preetham, Shirley, and Smits.
+type sunSky struct {
+	up, sunDir vec64.Vector
+
+	// perez holds the coefficients of the luminance and chromaticity
+	// distributions, and zenith holds their values at the zenith (divided by
+	// the distribution at the zenith).
+	perez  [3][5]float64
+	zenith [3]float64
+
+	skyIntensity float64
+	sunRadiance  color.Color
+	cosSunRadius float64
+
+	sky *sphereLight
+	sun goray.Light
+}
+
+var _ goray.LightsBackground = &sunSky{}
+
+// NewSunSky creates a daylight background with the sun shining from sunDir.
+// Turbidity is the haziness of the atmosphere: 2 is a very clear sky and 10 is
+// hazy.  If samples is positive, the background lights the scene with a
+// directional sun and a sky light that is importance sampled.
+func NewSunSky(sunDir, up vec64.Vector, turbidity, sunIntensity, skyIntensity float64, samples int) (goray.Background, error) {
+	if turbidity < 1 {
+		return nil, errors.New("Turbidity must be at least 1")
+	}
+	up, sunDir = up.Normalize(), sunDir.Normalize()
+	cosThetaSun := vec64.Dot(sunDir, up)
+	if cosThetaSun <= 0 {
+		return nil, errors.New("Sun is below the horizon")
+	}
+	thetaSun := math.Acos(math.Min(cosThetaSun, 1))
+
+	T := turbidity
+	bg := &sunSky{
+		up:     up,
+		sunDir: sunDir,
+		perez: [3][5]float64{
+			{0.1787*T - 1.4630, -0.3554*T + 0.4275, -0.0227*T + 5.3251, 0.1206*T - 2.5771, -0.0670*T + 0.3703},
+			{-0.0193*T - 0.2592, -0.0665*T + 0.0008, -0.0004*T + 0.2125, -0.0641*T - 0.8989, -0.0033*T + 0.0452},
+			{-0.0167*T - 0.2608, -0.0950*T + 0.0092, -0.0079*T + 0.2102, -0.0441*T - 1.6537, -0.0109*T + 0.0529},
+		},
+		skyIntensity: skyIntensity,
+		cosSunRadius: math.Cos(sunAngularRadius),
+	}
+
+	// Zenith luminance (in kcd/m^2) and chromaticity
+	chi := (4.0/9.0 - T/120) * (math.Pi - 2*thetaSun)
+	th, th2, th3 := thetaSun, thetaSun*thetaSun, thetaSun*thetaSun*thetaSun
+	bg.zenith[0] = ((4.0453*T-4.9710)*math.Tan(chi) - 0.2155*T + 2.4192) * 1000
+	bg.zenith[1] = T*T*(0.00166*th3-0.00375*th2+0.00209*th) +
+		T*(-0.02903*th3+0.06377*th2-0.03202*th+0.00394) +
+		(0.11693*th3 - 0.21196*th2 + 0.06052*th + 0.25886)
+	bg.zenith[2] = T*T*(0.00275*th3-0.00610*th2+0.00317*th) +
+		T*(-0.04214*th3+0.08970*th2-0.04153*th+0.00516) +
+		(0.15346*th3 - 0.26756*th2 + 0.06670*th + 0.26688)
+	for i := range bg.zenith {
+		bg.zenith[i] /= bg.perezFunc(i, 1, thetaSun)
+	}
+
+	sunCol := color.ScalarMul(sunTransmittance(thetaSun, T), sunIntensity)
+	solidAngle := 2 * math.Pi * (1 - bg.cosSunRadius)
+	bg.sunRadiance = color.ScalarMul(sunCol, math.Pi/solidAngle)
+
+	if samples > 0 {
+		const w, h = 128, 64
+		m := newLatLong(up, 0)
+		bg.sky = newSphereLight(m, bg.skyColor, w, h, func(x, y int) color.Color {
+			dir, _ := m.fromImage((float64(x)+0.5)/w, (float64(y)+0.5)/h)
+			return bg.skyColor(dir)
+		}, samples)
+		bg.sun = lights.NewDirectional(sunDir, sunCol, 1)
+	}
+	return bg, nil
+}
+
+// perezFunc evaluates the Perez distribution of the luminance (i = 0) or a
+// chromaticity coordinate (i = 1, 2).  cosTheta is the cosine of the angle
+// from the zenith and gamma is the angle from the sun.
+func (bg *sunSky) perezFunc(i int, cosTheta, gamma float64) float64 {
+	p := &bg.perez[i]
+	cosGamma := math.Cos(gamma)
+	return (1 + p[0]*math.Exp(p[1]/cosTheta)) * (1 + p[2]*math.Exp(p[3]*gamma) + p[4]*cosGamma*cosGamma)
+}
+
+// skyColor returns the radiance of the sky in a direction, without the sun.
+func (bg *sunSky) skyColor(dir vec64.Vector) color.Color {
+	dir = dir.Normalize()
+	cosTheta := vec64.Dot(dir, bg.up)
+	if cosTheta <= 0 {
+		return color.Black
+	}
+	gamma := math.Acos(math.Max(-1, math.Min(1, vec64.Dot(dir, bg.sunDir))))
+	Y := bg.zenith[0] * bg.perezFunc(0, cosTheta, gamma)
+	x := bg.zenith[1] * bg.perezFunc(1, cosTheta, gamma)
+	y := bg.zenith[2] * bg.perezFunc(2, cosTheta, gamma)
+	if y <= 0 {
+		return color.Black
+	}
+
+	// Convert from xyY to linear sRGB.
+	X, Z := x/y*Y, (1-x-y)/y*Y
+	scale := math.Pi / sunIlluminance * bg.skyIntensity
+	return color.RGB{
+		R: math.Max(0, 3.2406*X-1.5372*Y-0.4986*Z) * scale,
+		G: math.Max(0, -0.9689*X+1.8758*Y+0.0415*Z) * scale,
+		B: math.Max(0, 0.0557*X-0.2040*Y+1.0570*Z) * scale,
+	}
+}
+
+// sunTransmittance returns the fraction of the sun's light that makes it
+// through the atmosphere, from Rayleigh and aerosol scattering.
+func sunTransmittance(thetaSun, turbidity float64) color.Color {
+	// Relative optical mass of the air
+	deg := thetaSun * 180 / math.Pi
+	m := 1 / (math.Cos(thetaSun) + 0.15*math.Pow(93.885-deg, -1.253))
+	beta := 0.04608*turbidity - 0.04586
+
+	var tau [3]float64
+	for i, lambda := range [3]float64{0.650, 0.570, 0.475} {
+		rayleigh := math.Exp(-0.008735 * math.Pow(lambda, -4.08) * m)
+		aerosol := math.Exp(-beta * math.Pow(lambda, -1.3) * m)
+		tau[i] = rayleigh * aerosol
+	}
+	return color.RGB{R: tau[0], G: tau[1], B: tau[2]}
+}
+
+func (bg *sunSky) Color(r goray.Ray, state *goray.RenderState, filtered bool) color.Color {
+	col := bg.skyColor(r.Dir)
+	if vec64.Dot(r.Dir.Normalize(), bg.sunDir) >= bg.cosSunRadius {
+		col = color.Add(col, bg.sunRadiance)
+	}
+	return col
+}
+
+func (bg *sunSky) Light() goray.Light {
+	if bg.sky == nil {
+		return nil
+	}
+	return bg.sky
+}
+
+func (bg *sunSky) Lights() []goray.Light {
+	if bg.sky == nil {
+		return nil
+	}
+	return []goray.Light{bg.sky, bg.sun}
+}
+
+// sunDirection finds the direction of the sun at a place and time, using the
+// approximations from the appendix of Preetham et al.  Latitude and longitude
+// are in degrees (north and east are positive), hour is the local standard
+// time in hours, and timezone is the offset of the local standard time from
+// UTC in hours.
+func sunDirection(latitude, longitude float64, date time.Time, hour, timezone float64, up, north vec64.Vector) vec64.Vector {
+	up = up.Normalize()
+	north = vec64.Sub(north, up.Scale(vec64.Dot(north, up))).Normalize()
+	east := vec64.Cross(north, up)
+
+	J := float64(date.YearDay())
+	solarTime := hour + 0.170*math.Sin(4*math.Pi*(J-80)/373) - 0.129*math.Sin(2*math.Pi*(J-8)/355) + (longitude-15*timezone)/15
+	declination := 0.4093 * math.Sin(2*math.Pi*(J-81)/368)
+	hourAngle := math.Pi * (solarTime - 12) / 12
+	lat := latitude * math.Pi / 180
+
+	sinAlt := math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle)
+	// The azimuth is measured from north toward east.  Both of these are
+	// multiplied by the cosine of the altitude.
+	sinAz := -math.Cos(declination) * math.Sin(hourAngle)
+	cosAz := math.Sin(declination)*math.Cos(lat) - math.Cos(declination)*math.Sin(lat)*math.Cos(hourAngle)
+	return vec64.Add(up.Scale(sinAlt), vec64.Add(north.Scale(cosAz), east.Scale(sinAz))).Normalize()
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"backgrounds/sunsky"] = yamlscene.MapConstruct(constructSunSky)
+}
+
+func constructSunSky(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	up, ok := m.SetDefault("up", vec64.Vector{0, 1, 0}).(vec64.Vector)
+	if !ok || up.IsZero() {
+		return nil, errors.New("Sun and sky up must be a nonzero vector")
+	}
+	turbidity, _ := yamldata.AsFloat(m.SetDefault("turbidity", 3.0))
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	sunIntensity, _ := yamldata.AsFloat(m.SetDefault("sunIntensity", 1.0))
+	skyIntensity, _ := yamldata.AsFloat(m.SetDefault("skyIntensity", 1.0))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	light, ok := yamldata.AsBool(m.SetDefault("light", true))
+	if !ok {
+		return nil, errors.New("Sun and sky light must be a boolean")
+	}
+	if !light {
+		samples = 0
+	}
+
+	var sunDir vec64.Vector
+	switch {
+	case m.HasKeys("sunDirection"):
+		sunDir, ok = m["sunDirection"].(vec64.Vector)
+		if !ok || sunDir.IsZero() {
+			return nil, errors.New("Sun direction must be a nonzero vector")
+		}
+	case m.HasKeys("latitude", "longitude", "date", "time"):
+		latitude, _ := yamldata.AsFloat(m["latitude"])
+		longitude, _ := yamldata.AsFloat(m["longitude"])
+		hour, ok := yamldata.AsFloat(m["time"])
+		if !ok {
+			return nil, errors.New("Sun time must be a number of hours")
+		}
+		dateString, _ := m["date"].(string)
+		date, err := time.Parse("2006-01-02", dateString)
+		if err != nil {
+			return nil, errors.New("Sun date must be formatted like 2006-01-02")
+		}
+		timezone, _ := yamldata.AsFloat(m.SetDefault("timezone", math.Floor(longitude/15+0.5)))
+		north, ok := m.SetDefault("north", vec64.Vector{0, 0, -1}).(vec64.Vector)
+		if !ok || north.IsZero() || vec64.Cross(north, up).IsZero() {
+			return nil, errors.New("Sun and sky north must be a vector that is not parallel to up")
+		}
+		sunDir = sunDirection(latitude, longitude, date, hour, timezone, up, north)
+	default:
+		return nil, errors.New("Sun and sky must have sunDirection or latitude, longitude, date, and time")
+	}
+	return NewSunSky(sunDir, up, turbidity, intensity*sunIntensity, intensity*skyIntensity, samples)
+}
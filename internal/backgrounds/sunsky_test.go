@@ -0,0 +1,123 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backgrounds
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+)
+
+func TestSunDirectionNoon(t *testing.T) {
+	up, north := vec64.Vector{0, 1, 0}, vec64.Vector{0, 0, -1}
+	equinoxes := []time.Time{
+		time.Date(2011, time.March, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2011, time.September, 23, 0, 0, 0, 0, time.UTC),
+	}
+	for _, date := range equinoxes {
+		// The equation of time moves solar noon by a few minutes, which
+		// matters least away from the equator.
+		for _, lat := range []float64{23.5, 40, -33.9, 60} {
+			dir := sunDirection(lat, 0, date, 12, 0, up, north)
+			alt := math.Asin(vec64.Dot(dir, up)) * 180 / math.Pi
+			if want := 90 - math.Abs(lat); math.Abs(alt-want) > 1 {
+				t.Errorf("sun altitude at noon on %s at latitude %v = %.2f; want %.2f", date.Format("2006-01-02"), lat, alt, want)
+			}
+			// The noon sun is toward the equator.
+			if d := vec64.Dot(dir, north); lat > 0 && d >= 0 || lat < 0 && d <= 0 {
+				t.Errorf("noon sun on %s at latitude %v = %v; not toward the equator", date.Format("2006-01-02"), lat, dir)
+			}
+		}
+	}
+}
+
+func newTestSunSky(t *testing.T, samples int) *sunSky {
+	bg, err := NewSunSky(vec64.Vector{1, 2, -1}, vec64.Vector{0, 1, 0}, 3, 1, 1, samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bg.(*sunSky)
+}
+
+func TestSunSkyZenith(t *testing.T) {
+	const T = 3.0
+	bg := newTestSunSky(t, 0)
+	thetaSun := math.Acos(vec64.Dot(bg.sunDir, bg.up))
+
+	// Preetham et al. give the luminance of the zenith in kcd/m^2.
+	chi := (4.0/9.0 - T/120) * (math.Pi - 2*thetaSun)
+	want := ((4.0453*T-4.9710)*math.Tan(chi) - 0.2155*T + 2.4192) * 1000
+
+	col := bg.skyColor(bg.up)
+	lum := (0.2126*col.Red() + 0.7152*col.Green() + 0.0722*col.Blue()) * sunIlluminance / math.Pi
+	if math.Abs(lum-want) > 0.01*want {
+		t.Errorf("zenith luminance = %v; want %v", lum, want)
+	}
+}
+
+func TestSunSkyColor(t *testing.T) {
+	bg := newTestSunSky(t, 0)
+	r := goray.Ray{Dir: bg.sunDir}
+	got := color.Sub(bg.Color(r, nil, false), bg.skyColor(r.Dir))
+	if math.Abs(got.Red()-bg.sunRadiance.Red()) > 1e-9 || math.Abs(got.Blue()-bg.sunRadiance.Blue()) > 1e-9 {
+		t.Errorf("sun color = %v; want %v", got, bg.sunRadiance)
+	}
+
+	// Just outside of the sun's disk, only the sky is seen.
+	off := vec64.Add(bg.sunDir, vec64.Cross(bg.sunDir, bg.up).Normalize().Scale(2*sunAngularRadius)).Normalize()
+	r = goray.Ray{Dir: off}
+	if c, sky := bg.Color(r, nil, false), bg.skyColor(off); c != sky {
+		t.Errorf("color next to the sun = %v; want sky %v", c, sky)
+	}
+}
+
+func TestSunSkyLights(t *testing.T) {
+	bg := newTestSunSky(t, 4)
+	ls := bg.Lights()
+	if len(ls) != 2 {
+		t.Fatalf("len(Lights()) = %d; want 2", len(ls))
+	}
+	if ls[0] != bg.Light() {
+		t.Error("Lights()[0] is not the sky light")
+	}
+	if _, ok := ls[1].(goray.DiracLight); !ok {
+		t.Errorf("Lights()[1] = %T; want the sun's directional light", ls[1])
+	}
+
+	m := yamldata.Map{"sunDirection": vec64.Vector{1, 2, -1}, "light": false}
+	v, err := constructSunSky(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bg := v.(goray.LightsBackground); bg.Light() != nil || len(bg.Lights()) != 0 {
+		t.Errorf("light: false gave lights %v", bg.Lights())
+	}
+
+	m["light"] = 0
+	if _, err := constructSunSky(m); err == nil {
+		t.Error("light: 0 did not give an error")
+	}
+}
@@ -51,6 +51,19 @@ func Union(b1, b2 Bound) Bound {
 	return newBound
 }
 
+// Intersection returns the overlap of two bounding boxes.  If the boxes do not
+// overlap, then ok is false.
+func Intersection(b1, b2 Bound) (bd Bound, ok bool) {
+	for axis := range bd.Min {
+		bd.Min[axis] = math.Max(b1.Min[axis], b2.Min[axis])
+		bd.Max[axis] = math.Min(b1.Max[axis], b2.Max[axis])
+		if bd.Min[axis] > bd.Max[axis] {
+			return Bound{}, false
+		}
+	}
+	return bd, true
+}
+
 // Cross checks whether a given ray crosses the bound.
 // from specifies a point where the ray starts.
 // ray specifies the direction the ray is in.
@@ -101,3 +101,18 @@ func TestSize(t *testing.T) {
 		t.Errorf("%#v != {5, 3, 4}", size)
 	}
 }
+
+func TestIntersection(t *testing.T) {
+	b1 := Bound{vec64.Vector{0, 0, 0}, vec64.Vector{2, 2, 2}}
+	b2 := Bound{vec64.Vector{1, -1, 1}, vec64.Vector{3, 1, 4}}
+	bd, ok := Intersection(b1, b2)
+	want := Bound{vec64.Vector{1, 0, 1}, vec64.Vector{2, 1, 2}}
+	if !ok || bd != want {
+		t.Errorf("Intersection(%v, %v) = %v, %t (wanted %v, true)", b1, b2, bd, ok, want)
+	}
+
+	b3 := Bound{vec64.Vector{0, 3, 0}, vec64.Vector{1, 4, 1}}
+	if bd, ok := Intersection(b1, b3); ok {
+		t.Errorf("Intersection(%v, %v) = %v, true (wanted false)", b1, b3, bd)
+	}
+}
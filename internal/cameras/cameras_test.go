@@ -0,0 +1,221 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cameras
+
+import (
+	"math"
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+)
+
+var (
+	testPos  = vec64.Vector{1, 2, 3}
+	testLook = vec64.Vector{0, 2, 0}
+	testUp   = vec64.Vector{1, 3, 3}
+)
+
+var projectTests = []struct {
+	Name   string
+	Camera goray.Camera
+}{
+	{"perspective", NewPerspective(testPos, testLook, testUp, 40, 30, 1, 1, 0, Disk1, NoBias, 0)},
+	{"equirectangular", NewEquirectangular(testPos, testLook, testUp, 64, 32)},
+	{"fisheye/equidistant", NewFisheye(testPos, testLook, testUp, 40, 30, 200, Equidistant)},
+	{"fisheye/equisolid", NewFisheye(testPos, testLook, testUp, 40, 30, 180, Equisolid)},
+	{"cubemap/cross", NewCubeMap(testPos, testLook, testUp, 8, CrossLayout)},
+	{"cubemap/strip", NewCubeMap(testPos, testLook, testUp, 8, StripLayout)},
+	{"stereo/offAxis", newTestStereo(NewPerspective(testPos, testLook, testUp, 40, 30, 1, 1, 0, Disk1, NoBias, 0), 0.5, 5, OffAxis, SideBySide)},
+	{"stereo/parallel", newTestStereo(NewPerspective(testPos, testLook, testUp, 40, 30, 1, 1, 0, Disk1, NoBias, 0), 0.5, 0, Parallel, OverUnder)},
+	// The ODS projection is only exact for eyes that are close together.
+	{"stereo/ods", newTestStereo(NewEquirectangular(testPos, testLook, testUp, 64, 32), 0.065, 5, OmniDirectional, OverUnder)},
+}
+
+func newTestStereo(cam goray.Camera, iod, convergence float64, mode StereoMode, layout StereoLayout) goray.Camera {
+	s, err := NewStereo(cam, iod, convergence, mode, layout)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// pixelSolidAngle estimates the solid angle that a square pixel around (x, y)
+// covers by differentiating the camera's ray directions.
+func pixelSolidAngle(cam goray.ShutterCamera, x, y, time float64) float64 {
+	const h = 1e-4
+	dir := func(x, y float64) vec64.Vector {
+		r, _ := cam.ShootRayAt(x, y, 0, 0, time)
+		return r.Dir
+	}
+	dx := vec64.Sub(dir(x+h, y), dir(x-h, y)).Scale(1 / (2 * h))
+	dy := vec64.Sub(dir(x, y+h), dir(x, y-h)).Scale(1 / (2 * h))
+	return vec64.Cross(dx, dy).Length()
+}
+
+// checkProject shoots rays through a grid of positions and checks that
+// Project maps them back to the same positions, with the solid angle density
+// of picking the ray's direction by sampling the image uniformly.
+func checkProject(t *testing.T, name string, cam goray.ShutterCamera, time float64) {
+	w, h := float64(cam.ResolutionX()), float64(cam.ResolutionY())
+	n := 0
+	for y := 0.13; y < h; y += h / 7 {
+		for x := 0.29; x < w; x += w / 11 {
+			r, wt := cam.ShootRayAt(x, y, 0, 0, time)
+			if wt == 0 {
+				continue
+			}
+			// Skip positions whose neighborhood is not all on the image.
+			if wt1, wt2 := shootWeight(cam, x+0.01, y+0.01, time), shootWeight(cam, x-0.01, y-0.01, time); wt1 == 0 || wt2 == 0 {
+				continue
+			}
+			n++
+			var lu, lv float64
+			pdf, ok := cam.Project(r, &lu, &lv)
+			if !ok {
+				t.Errorf("%s: Project(ShootRay(%.2f, %.2f)) failed", name, x, y)
+				continue
+			}
+			if math.Abs(lu-x) > 1e-6 || math.Abs(lv-y) > 1e-6 {
+				t.Errorf("%s: Project(ShootRay(%.2f, %.2f)) = (%.6f, %.6f)", name, x, y, lu, lv)
+			}
+			want := 1 / (w * h * pixelSolidAngle(cam, x, y, time))
+			if math.Abs(pdf-want) > 1e-3*want {
+				t.Errorf("%s: pdf at (%.2f, %.2f) = %v; want %v", name, x, y, pdf, want)
+			}
+		}
+	}
+	if n == 0 {
+		t.Errorf("%s: no rays shot", name)
+	}
+}
+
+func shootWeight(cam goray.ShutterCamera, x, y, time float64) float64 {
+	_, wt := cam.ShootRayAt(x, y, 0, 0, time)
+	return wt
+}
+
+func TestProject(t *testing.T) {
+	for _, test := range projectTests {
+		checkProject(t, test.Name, test.Camera.(goray.ShutterCamera), 0)
+	}
+}
+
+func TestProjectMoving(t *testing.T) {
+	keys := []Keyframe{
+		{Time: 0, Position: testPos, Look: testLook, Up: testUp},
+		{Time: 1, Position: vec64.Vector{-1, 1, 2}, Look: vec64.Vector{0, 0, -1}, Up: vec64.Vector{-1, 2, 2}},
+	}
+	for _, test := range projectTests {
+		cam := test.Camera.(goray.ShutterCamera)
+		// A stereo camera moves with the camera that it wraps.
+		mc := goray.Camera(cam)
+		if s, ok := cam.(*stereo); ok {
+			mc = s.cam
+		}
+		if !SetMotion(mc, 0, 1, keys) {
+			t.Errorf("%s: SetMotion failed", test.Name)
+			continue
+		}
+		checkProject(t, test.Name, cam, 0.25)
+		// Moving cameras are interpolated for every ray, which must not
+		// allocate.
+		if n := testing.AllocsPerRun(10, func() { cam.ShootRayAt(1, 1, 0, 0, 0.25) }); n > 0 {
+			t.Errorf("%s: ShootRayAt made %v allocations", test.Name, n)
+		}
+		if r, _ := cam.ShootRay(1, 1, 0, 0); r.Time != 0 {
+			t.Errorf("%s: ShootRay time = %v; want 0", test.Name, r.Time)
+		}
+		SetMotion(mc, 0, 0, nil)
+	}
+}
+
+var lensTests = []struct {
+	Name     string
+	Settings yamldata.Map
+	FOV      float64 // FOV is the horizontal field of view in degrees
+	Aperture float64 // Aperture is the radius of the lens in scene units
+	Exposure float64
+}{
+	{"default", yamldata.Map{}, 53.13, 0, 1},
+	{"fov", yamldata.Map{"fov": 90.0}, 90, 0, 1},
+	{"focalLength", yamldata.Map{"focalLength": 50.0}, 39.60, 0, 1},
+	{"focalLength/apsc", yamldata.Map{"focalLength": 35.0, "sensorWidth": 23.6}, 37.27, 0, 1},
+	{"aperture", yamldata.Map{"aperture": 0.25, "dofDistance": 3.0}, 53.13, 0.25, 1},
+	// A 50mm lens at f/2 is 25mm wide.
+	{"fStop", yamldata.Map{"focalLength": 50.0, "fStop": 2.0, "dofDistance": 3.0}, 39.60, 0.0125, 1},
+	{"fStop/centimeters", yamldata.Map{"focalLength": 50.0, "fStop": 2.0, "dofDistance": 300.0, "metersPerUnit": 0.01}, 39.60, 1.25, 1},
+	{"fStop/pinhole", yamldata.Map{"focalLength": 50.0, "fStop": 2.0}, 39.60, 0, 1},
+	// f/16 at 1/100s and ISO 100 (the sunny 16 rule) fills the film at
+	// 1.2 * 100 * 16^2 / (0.01 * 100) = 30720 cd/m^2.
+	{"exposure/sunny16", yamldata.Map{"fStop": 16.0, "shutterTime": 0.01, "iso": 100.0}, 53.13, 0, 1.0 / 30720},
+	// Opening up two stops and shortening the shutter by two stops keeps the
+	// exposure the same.
+	{"exposure/reciprocity", yamldata.Map{"fStop": 8.0, "shutterTime": 0.0025, "iso": 100.0}, 53.13, 0, 1.0 / 30720},
+	{"exposure/iso", yamldata.Map{"fStop": 16.0, "shutterTime": 0.01, "iso": 400.0}, 53.13, 0, 4.0 / 30720},
+}
+
+func TestPerspectiveLens(t *testing.T) {
+	for _, test := range lensTests {
+		m := yamldata.Map{"position": testPos, "look": testLook, "up": testUp, "width": 40, "height": 30}
+		for k, v := range test.Settings {
+			m[k] = v
+		}
+		v, err := constructPerspective(m)
+		if err != nil {
+			t.Errorf("%s: %v", test.Name, err)
+			continue
+		}
+		cam := v.(*perspective)
+		if fov := 2 * math.Atan(0.5/cam.focalDistance) * 180 / math.Pi; math.Abs(fov-test.FOV) > 0.01 {
+			t.Errorf("%s: fov = %.2f; want %.2f", test.Name, fov, test.FOV)
+		}
+		if math.Abs(cam.aperture-test.Aperture) > 1e-9 {
+			t.Errorf("%s: aperture = %v; want %v", test.Name, cam.aperture, test.Aperture)
+		}
+		if math.Abs(cam.Exposure()-test.Exposure) > 1e-9*test.Exposure {
+			t.Errorf("%s: exposure = %v; want %v", test.Name, cam.Exposure(), test.Exposure)
+		}
+	}
+}
+
+func TestPerspectiveLensErrors(t *testing.T) {
+	tests := []yamldata.Map{
+		{"fov": 180.0},
+		{"fov": 45.0, "focalLength": 50.0},
+		{"focalLength": -50.0},
+		{"focalLength": 50.0, "sensorWidth": 0.0},
+		{"fStop": 2.0, "aperture": 0.1},
+		{"fStop": 2.0, "metersPerUnit": 0.0},
+		{"fStop": 2.0, "iso": 100.0},
+		{"fStop": 2.0, "shutterTime": 0.0, "iso": 100.0},
+	}
+	for _, settings := range tests {
+		m := yamldata.Map{"position": testPos, "look": testLook, "up": testUp, "width": 40, "height": 30}
+		for k, v := range settings {
+			m[k] = v
+		}
+		if _, err := constructPerspective(m); err == nil {
+			t.Errorf("constructPerspective(%v) did not give an error", settings)
+		}
+	}
+}
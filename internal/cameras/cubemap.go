@@ -0,0 +1,211 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cameras
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// A CubeLayout determines where the faces of a cube map are placed in the
+// image.
+type CubeLayout int
+
+// Cube map layouts
+const (
+	// CrossLayout places the faces in a horizontal cross, four faces wide and
+	// three faces high.  The middle row holds the left, front, right, and back
+	// faces, with the top and bottom faces above and below the front.
+	CrossLayout CubeLayout = iota
+	// StripLayout places the faces in a single row, in the order right, left,
+	// top, bottom, front, back (+X, -X, +Y, -Y, +Z, -Z in camera space).
+	StripLayout
+)
+
+// cubeFace is one face of a cube map, given as camera-space directions.  up
+// points toward the top of the face's square in the image.
+type cubeFace struct {
+	forward, right, up vec64.Vector
+}
+
+// cubeFaces are the faces in the order of StripLayout.  Each face is seen
+// from inside of the cube, so faces that share an edge line up.
+var cubeFaces = [6]cubeFace{
+	{vec64.Vector{1, 0, 0}, vec64.Vector{0, 0, -1}, vec64.Vector{0, 1, 0}},  // right
+	{vec64.Vector{-1, 0, 0}, vec64.Vector{0, 0, 1}, vec64.Vector{0, 1, 0}},  // left
+	{vec64.Vector{0, 1, 0}, vec64.Vector{1, 0, 0}, vec64.Vector{0, 0, -1}},  // top
+	{vec64.Vector{0, -1, 0}, vec64.Vector{1, 0, 0}, vec64.Vector{0, 0, 1}},  // bottom
+	{vec64.Vector{0, 0, 1}, vec64.Vector{1, 0, 0}, vec64.Vector{0, 1, 0}},   // front
+	{vec64.Vector{0, 0, -1}, vec64.Vector{-1, 0, 0}, vec64.Vector{0, 1, 0}}, // back
+}
+
+// cubeCells holds the column and row of each face in a layout.
+var cubeCells = map[CubeLayout][6][2]int{
+	CrossLayout: {{2, 1}, {0, 1}, {1, 0}, {1, 2}, {1, 1}, {3, 1}},
+	StripLayout: {{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}},
+}
+
+// cubeMap is a camera that renders the six faces of a cube around it, each
+// with a 90 degree field of view.
+type cubeMap struct {
+	size   int
+	layout CubeLayout
+	frame
+	motion
+}
+
+var _ goray.ShutterCamera = &cubeMap{}
+
+// NewCubeMap creates a cube map camera with faces that are size pixels wide.
+// The image is 4*size by 3*size for CrossLayout and 6*size by size for
+// StripLayout; the parts of a cross outside of the faces are not rendered.
+func NewCubeMap(pos, look, up vec64.Vector, size int, layout CubeLayout) goray.Camera {
+	c := &cubeMap{size: size, layout: layout}
+	c.start(c, pos, look, up)
+	return c
+}
+
+func (c *cubeMap) setView(pos, look, up vec64.Vector) {
+	c.frame = newFrame(pos, look, up)
+}
+
+func (c *cubeMap) frameAt(t float64) frame { return c.moveFrame(c.frame, t) }
+
+func (c *cubeMap) ResolutionX() int {
+	if c.layout == StripLayout {
+		return 6 * c.size
+	}
+	return 4 * c.size
+}
+
+func (c *cubeMap) ResolutionY() int {
+	if c.layout == StripLayout {
+		return c.size
+	}
+	return 3 * c.size
+}
+
+func (c *cubeMap) SampleLens() bool { return false }
+
+// face finds the face that covers a cell of the image, or -1 if no face does.
+func (c *cubeMap) face(col, row int) int {
+	for i, cell := range cubeCells[c.layout] {
+		if cell[0] == col && cell[1] == row {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *cubeMap) ShootRayAt(x, y, u, v, time float64) (r goray.Ray, wt float64) {
+	fr := c.frameAt(time)
+	r = goray.Ray{
+		From: fr.eye,
+		Dir:  fr.forward,
+		TMax: -1.0,
+		Time: time,
+	}
+	size := float64(c.size)
+	col, row := int(math.Floor(x/size)), int(math.Floor(y/size))
+	i := c.face(col, row)
+	if i < 0 {
+		return r, 0
+	}
+
+	// Map the position in the face to [-1, 1].
+	s := 2*(x/size-float64(col)) - 1
+	t := 1 - 2*(y/size-float64(row))
+	f := cubeFaces[i]
+	d := vec64.Sum(f.forward, f.right.Scale(s), f.up.Scale(t))
+	r.Dir = fr.toWorld(d).Normalize()
+	return r, 1
+}
+
+// Project finds the fragment that a ray leaving the eye would have been shot
+// through.
+func (c *cubeMap) Project(wo goray.Ray, lu, lv *float64) (pdf float64, changed bool) {
+	fr := c.frameAt(wo.Time)
+	d := fr.toCamera(wo.Dir)
+
+	// The face that the ray goes through is the one facing the direction's
+	// largest component.
+	i, cos := -1, 0.0
+	for j, f := range cubeFaces {
+		if dot := vec64.Dot(f.forward, d); dot > cos {
+			i, cos = j, dot
+		}
+	}
+	if i < 0 {
+		return
+	}
+	f := cubeFaces[i]
+	s := vec64.Dot(f.right, d) / cos
+	t := vec64.Dot(f.up, d) / cos
+	cell := cubeCells[c.layout][i]
+	size := float64(c.size)
+	*lu = (float64(cell[0]) + (s+1)/2) * size
+	*lv = (float64(cell[1]) + (1-t)/2) * size
+
+	// Each face is a plane one unit away that is two units wide, so a pixel
+	// covers (2/size)^2 of it.  Converting to solid angle gives 1/cos^3.
+	area := float64(c.ResolutionX() * c.ResolutionY())
+	return size * size / (4 * area * cos * cos * cos), true
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"cameras/cubemap"] = yamlscene.MapConstruct(constructCubeMap)
+}
+
+func constructCubeMap(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	pos, look, up, err := constructView(m)
+	if err != nil {
+		return nil, err
+	}
+	size, ok := yamldata.AsInt(m["size"])
+	if !ok || size <= 0 {
+		return nil, errors.New("Cube map camera must have a face size")
+	}
+
+	var layout CubeLayout
+	switch m.SetDefault("layout", "cross") {
+	case "cross":
+		layout = CrossLayout
+	case "strip":
+		layout = StripLayout
+	default:
+		return nil, errors.New("Unknown cube map layout")
+	}
+
+	open, close, keys, err := constructMotion(m, pos, look, up)
+	if err != nil {
+		return nil, err
+	}
+
+	cam := NewCubeMap(pos, look, up, size, layout)
+	SetMotion(cam, open, close, keys)
+	return cam, nil
+}
@@ -0,0 +1,187 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cameras
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// A FisheyeProjection determines how a fisheye camera maps angles from its
+// axis to distances from the center of the image.
+type FisheyeProjection int
+
+// Fisheye projections
+const (
+	// Equidistant fisheyes make the distance from the center proportional to
+	// the angle.
+	Equidistant FisheyeProjection = iota
+	// Equisolid fisheyes make every pixel cover the same solid angle.
+	Equisolid
+)
+
+// fisheye is a camera that sees a circle of directions around its look
+// direction.  The circle is as large as the image's shorter side allows.
+type fisheye struct {
+	resx, resy int
+	projection FisheyeProjection
+	thetaMax   float64 // thetaMax is half of the field of view
+	radius     float64 // radius is the size of the image circle in pixels
+	frame
+	motion
+}
+
+var _ goray.ShutterCamera = &fisheye{}
+
+// NewFisheye creates a fisheye camera with a field of view of fov degrees,
+// up to 360.  Pixels outside of the image circle are not rendered.
+func NewFisheye(pos, look, up vec64.Vector, resx, resy int, fov float64, projection FisheyeProjection) goray.Camera {
+	c := &fisheye{
+		resx:       resx,
+		resy:       resy,
+		projection: projection,
+		thetaMax:   fov * math.Pi / 360,
+		radius:     math.Min(float64(resx), float64(resy)) / 2,
+	}
+	c.start(c, pos, look, up)
+	return c
+}
+
+func (c *fisheye) setView(pos, look, up vec64.Vector) {
+	c.frame = newFrame(pos, look, up)
+}
+
+func (c *fisheye) frameAt(t float64) frame { return c.moveFrame(c.frame, t) }
+
+func (c *fisheye) ResolutionX() int { return c.resx }
+func (c *fisheye) ResolutionY() int { return c.resy }
+func (c *fisheye) SampleLens() bool { return false }
+
+// theta converts a distance from the center of the image circle (in [0, 1])
+// to an angle from the camera's axis.
+func (c *fisheye) theta(r float64) float64 {
+	if c.projection == Equisolid {
+		return 2 * math.Asin(r*math.Sin(c.thetaMax/2))
+	}
+	return r * c.thetaMax
+}
+
+// distance is the inverse of theta.
+func (c *fisheye) distance(theta float64) float64 {
+	if c.projection == Equisolid {
+		return math.Sin(theta/2) / math.Sin(c.thetaMax/2)
+	}
+	return theta / c.thetaMax
+}
+
+func (c *fisheye) ShootRayAt(x, y, u, v, time float64) (r goray.Ray, wt float64) {
+	f := c.frameAt(time)
+	r = goray.Ray{
+		From: f.eye,
+		Dir:  f.forward,
+		TMax: -1.0,
+		Time: time,
+	}
+	px := (x - float64(c.resx)/2) / c.radius
+	py := (float64(c.resy)/2 - y) / c.radius
+	dist := math.Hypot(px, py)
+	if dist > 1 {
+		return r, 0
+	}
+	theta, phi := c.theta(dist), math.Atan2(py, px)
+	d := vec64.Vector{math.Sin(theta) * math.Cos(phi), math.Sin(theta) * math.Sin(phi), math.Cos(theta)}
+	r.Dir = f.toWorld(d)
+	return r, 1
+}
+
+// Project finds the fragment that a ray leaving the eye would have been shot
+// through.
+func (c *fisheye) Project(wo goray.Ray, lu, lv *float64) (pdf float64, changed bool) {
+	f := c.frameAt(wo.Time)
+	d := f.toCamera(wo.Dir)
+	sinTheta := math.Hypot(d[0], d[1])
+	theta := math.Atan2(sinTheta, d[2])
+	if theta > c.thetaMax {
+		return
+	}
+	dist, phi := c.distance(theta), math.Atan2(d[1], d[0])
+	*lu = float64(c.resx)/2 + c.radius*dist*math.Cos(phi)
+	*lv = float64(c.resy)/2 - c.radius*dist*math.Sin(phi)
+
+	// A ring of the image circle dr wide covers 2*pi*r*dr pixels and
+	// 2*pi*sin(theta)*dtheta steradians.
+	area := float64(c.resx * c.resy)
+	switch {
+	case c.projection == Equisolid:
+		s := math.Sin(c.thetaMax / 2)
+		pdf = c.radius * c.radius / (4 * s * s * area)
+	case theta == 0:
+		pdf = c.radius * c.radius / (c.thetaMax * c.thetaMax * area)
+	default:
+		pdf = c.radius * c.radius * theta / (c.thetaMax * c.thetaMax * sinTheta * area)
+	}
+	return pdf, true
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"cameras/fisheye"] = yamlscene.MapConstruct(constructFisheye)
+}
+
+func constructFisheye(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	pos, look, up, err := constructView(m)
+	if err != nil {
+		return nil, err
+	}
+	width, widthOk := yamldata.AsInt(m["width"])
+	height, heightOk := yamldata.AsInt(m["height"])
+	if !widthOk || !heightOk {
+		return nil, errors.New("Fisheye camera must have width and height")
+	}
+	fov, _ := yamldata.AsFloat(m.SetDefault("fov", 180.0))
+	if fov <= 0 || fov > 360 {
+		return nil, errors.New("Fisheye field of view must be between 0 and 360 degrees")
+	}
+
+	var projection FisheyeProjection
+	switch m.SetDefault("projection", "equidistant") {
+	case "equidistant":
+		projection = Equidistant
+	case "equisolid":
+		projection = Equisolid
+	default:
+		return nil, errors.New("Unknown fisheye projection")
+	}
+
+	open, close, keys, err := constructMotion(m, pos, look, up)
+	if err != nil {
+		return nil, err
+	}
+
+	cam := NewFisheye(pos, look, up, width, height, fov, projection)
+	SetMotion(cam, open, close, keys)
+	return cam, nil
+}
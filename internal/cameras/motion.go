@@ -0,0 +1,191 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cameras
+
+import (
+	"errors"
+	"sort"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+)
+
+// A Keyframe is the view of a moving camera at a point in time.  Like the
+// arguments to the camera constructors, Up is a point above the camera, not a
+// direction.
+type Keyframe struct {
+	Time               float64
+	Position, Look, Up vec64.Vector
+}
+
+// motion is the shutter interval of a camera, along with the keyframes that it
+// moves through while the shutter is open.  Cameras embed it to implement
+// goray.ShutterCamera, and call start when they are created.
+type motion struct {
+	cam         movable
+	open, close float64
+	keys        []Keyframe
+}
+
+// movable is a camera that embeds motion.  setView points the camera from pos
+// toward look, like the camera's constructor.
+type movable interface {
+	goray.ShutterCamera
+	setView(pos, look, up vec64.Vector)
+	setMotion(open, close float64, keys []Keyframe)
+}
+
+// start records the camera that embeds m and points it at its initial view.
+func (m *motion) start(cam movable, pos, look, up vec64.Vector) {
+	m.cam = cam
+	cam.setView(pos, look, up)
+}
+
+func (m *motion) Shutter() (open, close float64) {
+	return m.open, m.close
+}
+
+// ShootRay shoots a ray at the time that the shutter opens.
+func (m *motion) ShootRay(x, y, u, v float64) (goray.Ray, float64) {
+	return m.cam.ShootRayAt(x, y, u, v, m.open)
+}
+
+// keyframe returns the view of the camera at time t.  ok is false if the
+// camera does not move, in which case it keeps the view it was created with.
+func (m *motion) keyframe(t float64) (k Keyframe, ok bool) {
+	if !m.moving() {
+		return
+	}
+	return m.view(t), true
+}
+
+// moveFrame returns f, the frame of the camera at rest, as it is at time t.
+func (m *motion) moveFrame(f frame, t float64) frame {
+	if k, ok := m.keyframe(t); ok {
+		return newFrame(k.Position, k.Look, k.Up)
+	}
+	return f
+}
+
+func (m *motion) setMotion(open, close float64, keys []Keyframe) {
+	m.open, m.close = open, close
+	m.keys = append([]Keyframe(nil), keys...)
+	sort.Slice(m.keys, func(i, j int) bool { return m.keys[i].Time < m.keys[j].Time })
+}
+
+// moving reports whether the camera has any keyframes.
+func (m *motion) moving() bool {
+	return len(m.keys) > 0
+}
+
+// view interpolates the keyframes at time t.  Before the first keyframe and
+// after the last, the camera holds still.
+func (m *motion) view(t float64) Keyframe {
+	i := sort.Search(len(m.keys), func(i int) bool { return m.keys[i].Time > t })
+	switch {
+	case i == 0:
+		return m.keys[0]
+	case i == len(m.keys):
+		return m.keys[len(m.keys)-1]
+	}
+	k0, k1 := m.keys[i-1], m.keys[i]
+	f := (t - k0.Time) / (k1.Time - k0.Time)
+	lerp := func(a, b vec64.Vector) vec64.Vector {
+		return vec64.Add(a.Scale(1-f), b.Scale(f))
+	}
+	return Keyframe{
+		Time:     t,
+		Position: lerp(k0.Position, k1.Position),
+		Look:     lerp(k0.Look, k1.Look),
+		Up:       lerp(k0.Up, k1.Up),
+	}
+}
+
+// SetMotion opens the shutter of a camera created by this package from time
+// open to time close.  If any keyframes are given, the camera moves through
+// them instead of staying at the view it was created with.  SetMotion reports
+// whether the camera supports motion.
+func SetMotion(cam goray.Camera, open, close float64, keys []Keyframe) bool {
+	mc, ok := cam.(movable)
+	if ok {
+		mc.setMotion(open, close, keys)
+	}
+	return ok
+}
+
+// constructMotion reads the shutter and keyframes keys of a camera.
+// Keyframes that leave out a view vector use the camera's.
+func constructMotion(m yamldata.Map, pos, look, up vec64.Vector) (open, close float64, keys []Keyframe, err error) {
+	if shutter, ok := m["shutter"]; ok {
+		seq, ok := yamldata.AsSequence(shutter)
+		if !ok || len(seq) != 2 {
+			err = errors.New("Shutter must be a sequence of 2 floats")
+			return
+		}
+		var ok1, ok2 bool
+		open, ok1 = yamldata.AsFloat(seq[0])
+		close, ok2 = yamldata.AsFloat(seq[1])
+		if !ok1 || !ok2 || close < open {
+			err = errors.New("Shutter must open before it closes")
+			return
+		}
+	}
+
+	if _, ok := m["keyframes"]; !ok {
+		return
+	}
+	seq, ok := yamldata.AsSequence(m["keyframes"])
+	if !ok {
+		err = errors.New("Keyframes must be a sequence")
+		return
+	}
+	keys = make([]Keyframe, len(seq))
+	for i := range seq {
+		km, ok := yamldata.AsMap(seq[i])
+		if !ok {
+			err = errors.New("Keyframe must be a mapping")
+			return
+		}
+		km = km.Copy()
+		keys[i].Time, ok = yamldata.AsFloat(km["time"])
+		if !ok {
+			err = errors.New("Keyframe must have a time")
+			return
+		}
+		keys[i].Position, ok = km.SetDefault("position", pos).(vec64.Vector)
+		if !ok {
+			err = errors.New("Keyframe position must be a vector")
+			return
+		}
+		keys[i].Look, ok = km.SetDefault("look", look).(vec64.Vector)
+		if !ok {
+			err = errors.New("Keyframe look must be a vector")
+			return
+		}
+		keys[i].Up, ok = km.SetDefault("up", up).(vec64.Vector)
+		if !ok {
+			err = errors.New("Keyframe up must be a vector")
+			return
+		}
+	}
+	return
+}
@@ -23,42 +23,78 @@ package cameras
 import (
 	"errors"
 
-	"bitbucket.org/zombiezen/goray"
 	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
 	yamldata "zombiezen.com/go/goray/internal/yaml/data"
 	"zombiezen.com/go/goray/internal/yamlscene"
 )
 
 // orthographic is a simple orthographic camera.
 type orthographic struct {
-	resx, resy         int
+	resx, resy    int
+	aspect, scale float64
+	orthoView
+
+	motion
+}
+
+var _ goray.ShutterCamera = &orthographic{}
+
+// orthoView is the part of an orthographic camera that changes as the camera
+// moves.  position is the corner of the image.
+type orthoView struct {
 	position           vec64.Vector
 	vlook, vup, vright vec64.Vector
 }
 
-var _ goray.Camera = &orthographic{}
-
 // NewOrthographic creates a new orthographic camera.
 func NewOrthographic(pos, look, up vec64.Vector, resx, resy int, aspect, scale float64) goray.Camera {
 	c := new(orthographic)
 	c.resx, c.resy = resx, resy
-	c.vup = vec64.Sub(up, pos)
-	c.vlook = vec64.Sub(look, pos).Normalize()
-	c.vright = vec64.Cross(c.vup, c.vlook)
-	c.vup = vec64.Cross(c.vright, c.vlook)
+	c.aspect, c.scale = aspect, scale
+	c.start(c, pos, look, up)
+	return c
+}
+
+// setView points the camera from pos toward look.
+func (c *orthographic) setView(pos, look, up vec64.Vector) {
+	c.orthoView = c.lookFrom(pos, look, up)
+}
+
+// lookFrom returns the view of the camera from pos toward look.
+func (c *orthographic) lookFrom(pos, look, up vec64.Vector) (v orthoView) {
+	v.vup = vec64.Sub(up, pos)
+	v.vlook = vec64.Sub(look, pos).Normalize()
+	v.vright = vec64.Cross(v.vup, v.vlook)
+	v.vup = vec64.Cross(v.vright, v.vlook)
 
 	// Normalize separately
-	c.vup = c.vup.Normalize()
-	c.vright = c.vright.Normalize()
+	v.vup = v.vup.Normalize()
+	v.vright = v.vright.Normalize()
 
-	c.vright = c.vright.Negate()
-	c.vup = c.vup.Scale(aspect * float64(resy) / float64(resx))
+	v.vright = v.vright.Negate()
+	v.vup = v.vup.Scale(c.aspect * float64(c.resy) / float64(c.resx))
 
-	c.position = vec64.Sub(pos, vec64.Add(c.vup, c.vright).Scale(0.5*scale))
+	v.position = vec64.Sub(pos, vec64.Add(v.vup, v.vright).Scale(0.5*c.scale))
 
-	c.vup = c.vup.Scale(scale / float64(resy))
-	c.vright = c.vright.Scale(scale / float64(resx))
-	return c
+	v.vup = v.vup.Scale(c.scale / float64(c.resy))
+	v.vright = v.vright.Scale(c.scale / float64(c.resx))
+	return
+}
+
+// viewAt returns the view of the camera at time t.
+func (c *orthographic) viewAt(t float64) orthoView {
+	if k, ok := c.keyframe(t); ok {
+		return c.lookFrom(k.Position, k.Look, k.Up)
+	}
+	return c.orthoView
+}
+
+func (c *orthographic) frameAt(t float64) frame {
+	v := c.viewAt(t)
+	center := vec64.Sum(v.position, v.vright.Scale(float64(c.resx)/2), v.vup.Scale(float64(c.resy)/2))
+	// vup points down the image.
+	return frame{eye: center, right: v.vright.Normalize(), up: v.vup.Normalize().Negate(), forward: v.vlook}
 }
 
 func (c *orthographic) SampleLens() bool {
@@ -73,12 +109,14 @@ func (c *orthographic) ResolutionY() int {
 	return c.resy
 }
 
-func (c *orthographic) ShootRay(x, y, u, v float64) (r goray.Ray, wt float64) {
+func (c *orthographic) ShootRayAt(x, y, u, v, time float64) (r goray.Ray, wt float64) {
+	ov := c.viewAt(time)
 	wt = 1
 	r = goray.Ray{
-		From: vec64.Sum(c.position, c.vright.Scale(x), c.vup.Scale(y)),
-		Dir:  c.vlook,
+		From: vec64.Sum(ov.position, ov.vright.Scale(x), ov.vup.Scale(y)),
+		Dir:  ov.vlook,
 		TMax: -1.0,
+		Time: time,
 	}
 	return
 }
@@ -117,6 +155,13 @@ func constructOrthographic(m yamldata.Map) (interface{}, error) {
 		scale = 1.0
 	}
 
+	open, close, keys, err := constructMotion(m, pos, look, up)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create camera (finally!)
-	return NewOrthographic(pos, look, up, width, height, aspect, scale), nil
+	cam := NewOrthographic(pos, look, up, width, height, aspect, scale)
+	SetMotion(cam, open, close, keys)
+	return cam, nil
 }
@@ -0,0 +1,156 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cameras
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// frame is an orthonormal basis for a camera that looks from eye toward a
+// point.  In camera space, X points right, Y points up, and Z points forward.
+type frame struct {
+	eye                vec64.Vector
+	right, up, forward vec64.Vector
+}
+
+func newFrame(pos, look, up vec64.Vector) (f frame) {
+	f.eye = pos
+	f.forward = vec64.Sub(look, pos).Normalize()
+	f.right = vec64.Cross(f.forward, vec64.Sub(up, pos)).Normalize()
+	f.up = vec64.Cross(f.right, f.forward)
+	return
+}
+
+// toWorld converts a direction in camera space to world space.
+func (f *frame) toWorld(d vec64.Vector) vec64.Vector {
+	return vec64.Sum(f.right.Scale(d[0]), f.up.Scale(d[1]), f.forward.Scale(d[2]))
+}
+
+// toCamera converts a direction in world space to camera space.
+func (f *frame) toCamera(d vec64.Vector) vec64.Vector {
+	return vec64.Vector{vec64.Dot(f.right, d), vec64.Dot(f.up, d), vec64.Dot(f.forward, d)}
+}
+
+// equirectangular is a camera that sees in every direction.  The image is a
+// latitude-longitude map with the look direction in the center.
+type equirectangular struct {
+	resx, resy int
+	frame
+	motion
+}
+
+var _ goray.ShutterCamera = &equirectangular{}
+
+// NewEquirectangular creates a camera that renders the full sphere of
+// directions around pos.  Longitude spans the width of the image and latitude
+// spans the height.
+func NewEquirectangular(pos, look, up vec64.Vector, resx, resy int) goray.Camera {
+	c := &equirectangular{resx: resx, resy: resy}
+	c.start(c, pos, look, up)
+	return c
+}
+
+func (c *equirectangular) setView(pos, look, up vec64.Vector) {
+	c.frame = newFrame(pos, look, up)
+}
+
+func (c *equirectangular) frameAt(t float64) frame { return c.moveFrame(c.frame, t) }
+
+func (c *equirectangular) ResolutionX() int { return c.resx }
+func (c *equirectangular) ResolutionY() int { return c.resy }
+func (c *equirectangular) SampleLens() bool { return false }
+
+func (c *equirectangular) ShootRayAt(x, y, u, v, time float64) (r goray.Ray, wt float64) {
+	f := c.frameAt(time)
+	phi := 2 * math.Pi * (x/float64(c.resx) - 0.5)
+	theta := math.Pi * (0.5 - y/float64(c.resy))
+	d := vec64.Vector{math.Cos(theta) * math.Sin(phi), math.Sin(theta), math.Cos(theta) * math.Cos(phi)}
+	r = goray.Ray{
+		From: f.eye,
+		Dir:  f.toWorld(d),
+		TMax: -1.0,
+		Time: time,
+	}
+	return r, 1
+}
+
+// Project finds the fragment that a ray leaving the eye would have been shot
+// through.  Every pixel covers the same range of angles, so pixels near the
+// poles cover less of the sphere.
+func (c *equirectangular) Project(wo goray.Ray, lu, lv *float64) (pdf float64, changed bool) {
+	f := c.frameAt(wo.Time)
+	d := f.toCamera(wo.Dir)
+	cosTheta := math.Hypot(d[0], d[2])
+	if cosTheta == 0 {
+		return
+	}
+	phi := math.Atan2(d[0], d[2])
+	theta := math.Atan2(d[1], cosTheta)
+	*lu = (phi/(2*math.Pi) + 0.5) * float64(c.resx)
+	*lv = (0.5 - theta/math.Pi) * float64(c.resy)
+
+	// The image covers 2*pi*pi square radians of latitude and longitude, and
+	// a solid angle of cos(theta) per square radian.
+	return 1 / (2 * math.Pi * math.Pi * cosTheta), true
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"cameras/equirectangular"] = yamlscene.MapConstruct(constructEquirectangular)
+}
+
+// constructView reads the keys that every camera in this package uses to
+// place itself.
+func constructView(m yamldata.Map) (pos, look, up vec64.Vector, err error) {
+	var posOk, lookOk, upOk bool
+	pos, posOk = m["position"].(vec64.Vector)
+	look, lookOk = m["look"].(vec64.Vector)
+	up, upOk = m["up"].(vec64.Vector)
+	if !posOk || !lookOk || !upOk {
+		err = errors.New("Camera must have position, look, and up vectors")
+	}
+	return
+}
+
+func constructEquirectangular(m yamldata.Map) (interface{}, error) {
+	pos, look, up, err := constructView(m)
+	if err != nil {
+		return nil, err
+	}
+	width, widthOk := yamldata.AsInt(m["width"])
+	height, heightOk := yamldata.AsInt(m["height"])
+	if !widthOk || !heightOk {
+		return nil, errors.New("Equirectangular camera must have width and height")
+	}
+	open, close, keys, err := constructMotion(m, pos, look, up)
+	if err != nil {
+		return nil, err
+	}
+
+	cam := NewEquirectangular(pos, look, up, width, height)
+	SetMotion(cam, open, close, keys)
+	return cam, nil
+}
@@ -24,8 +24,9 @@ import (
 	"errors"
 	"math"
 
-	"bitbucket.org/zombiezen/goray"
 	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
 	yamldata "zombiezen.com/go/goray/internal/yaml/data"
 	"zombiezen.com/go/goray/internal/yamlscene"
 )
@@ -62,53 +63,37 @@ func biasDist(bias BokehBias, r float64) float64 {
 	return math.Sqrt(r)
 }
 
-// shirleyDisk maps a square to a disk using P. Shirley's concentric disk algorithm.
-func shirleyDisk(r1, r2 float64) (u, v float64) {
-	var phi, r float64
-	a, b := 2*r1-1, 2*r2-1
-
-	switch {
-	case a > -b && a > b:
-		r = a
-		phi = math.Pi / 4 * (b / a)
-	case a > -b && a <= b:
-		r = b
-		phi = math.Pi / 4 * (2 - a/b)
-	case a <= -b && a < b:
-		r = -a
-		phi = math.Pi / 4 * (4 + b/a)
-	default:
-		r = -b
-		if b != 0 {
-			phi = math.Pi / 4 * (6 - a/b)
-		} else {
-			phi = 0
-		}
-	}
-
-	return r * math.Cos(phi), r * math.Sin(phi)
-}
-
 // perspective is a conventional perspective camera.
 type perspective struct {
 	resx, resy    int
-	eye           vec64.Vector // eye is the camera position
 	focalDistance float64
 	dofDistance   float64
 	aspectRatio   float64 // aspectRatio is the aspect of the camera (not the image)
 
-	look, up, right vec64.Vector
-	dofUp, dofRight vec64.Vector
-	x, y, z         vec64.Vector
+	perspectiveView
 
 	aperture  float64
 	aPix      float64
 	bokeh     Bokeh
 	bokehBias BokehBias
 	lens      []float64
+
+	exposure float64
+
+	motion
 }
 
-var _ goray.Camera = &perspective{}
+var _ goray.ShutterCamera = &perspective{}
+var _ goray.ExposedCamera = &perspective{}
+
+// perspectiveView is the part of a perspective camera that changes as the
+// camera moves.
+type perspectiveView struct {
+	eye             vec64.Vector // eye is the camera position
+	look, up, right vec64.Vector
+	dofUp, dofRight vec64.Vector
+	x, y, z         vec64.Vector
+}
 
 // NewPerspective creates a perspective camera.
 // It will not lead you to enlightenment.
@@ -117,37 +102,14 @@ func NewPerspective(pos, look, up vec64.Vector,
 	aspect, focalDist, aperture float64,
 	bokeh Bokeh, bias BokehBias, bokehRot float64) goray.Camera {
 	cam := new(perspective)
-	cam.eye = pos
 	cam.aperture = aperture
 	cam.dofDistance = 0
+	cam.exposure = 1
 	cam.resx, cam.resy = resx, resy
-
-	cam.up = vec64.Sub(up, pos)
-	cam.look = vec64.Sub(look, pos)
-	cam.right = vec64.Cross(cam.up, cam.look)
-	cam.up = vec64.Cross(cam.right, cam.look)
-
-	cam.up = cam.up.Normalize()
-	cam.right = cam.right.Normalize()
-	cam.right = cam.right.Negate() // Due to the order of vectors, we need to flip the vector to get "right"
-
-	cam.look = cam.look.Normalize()
-	cam.x = cam.right
-	cam.y = cam.up
-	cam.z = cam.look
-
-	// For DOF, premultiply values with aperture
-	cam.dofRight = cam.right.Scale(cam.aperture)
-	cam.dofUp = cam.up.Scale(cam.aperture)
-
 	cam.aspectRatio = aspect * float64(resy) / float64(resx)
-	cam.up = cam.up.Scale(cam.aspectRatio)
-
 	cam.focalDistance = focalDist
-	cam.look = vec64.Sub(cam.look.Scale(cam.focalDistance), vec64.Add(cam.up, cam.right).Scale(0.5))
-	cam.up = cam.up.Scale(1.0 / float64(resy))
-	cam.right = cam.right.Scale(1.0 / float64(resx))
 	cam.aPix = cam.aspectRatio / (cam.focalDistance * cam.focalDistance)
+	cam.start(cam, pos, look, up)
 
 	// Set up bokeh
 	cam.bokeh = bokeh
@@ -165,6 +127,54 @@ func NewPerspective(pos, look, up vec64.Vector,
 	return cam
 }
 
+// setView points the camera from pos toward look.
+func (cam *perspective) setView(pos, look, up vec64.Vector) {
+	cam.perspectiveView = cam.lookFrom(pos, look, up)
+}
+
+// lookFrom returns the view of the camera from pos toward look.
+func (cam *perspective) lookFrom(pos, look, up vec64.Vector) (v perspectiveView) {
+	v.eye = pos
+	v.up = vec64.Sub(up, pos)
+	v.look = vec64.Sub(look, pos)
+	v.right = vec64.Cross(v.up, v.look)
+	v.up = vec64.Cross(v.right, v.look)
+
+	v.up = v.up.Normalize()
+	v.right = v.right.Normalize()
+	v.right = v.right.Negate() // Due to the order of vectors, we need to flip the vector to get "right"
+
+	v.look = v.look.Normalize()
+	v.x = v.right
+	v.y = v.up
+	v.z = v.look
+
+	// For DOF, premultiply values with aperture
+	v.dofRight = v.right.Scale(cam.aperture)
+	v.dofUp = v.up.Scale(cam.aperture)
+
+	v.up = v.up.Scale(cam.aspectRatio)
+
+	v.look = vec64.Sub(v.look.Scale(cam.focalDistance), vec64.Add(v.up, v.right).Scale(0.5))
+	v.up = v.up.Scale(1.0 / float64(cam.resy))
+	v.right = v.right.Scale(1.0 / float64(cam.resx))
+	return
+}
+
+// viewAt returns the view of the camera at time t.
+func (cam *perspective) viewAt(t float64) perspectiveView {
+	if k, ok := cam.keyframe(t); ok {
+		return cam.lookFrom(k.Position, k.Look, k.Up)
+	}
+	return cam.perspectiveView
+}
+
+func (cam *perspective) frameAt(t float64) frame {
+	v := cam.viewAt(t)
+	// The camera's y axis points down the image.
+	return frame{eye: v.eye, right: v.x, up: v.y.Negate(), forward: v.z}
+}
+
 func (cam *perspective) ResolutionX() int {
 	return cam.resx
 }
@@ -200,36 +210,102 @@ func (cam *perspective) getLensUV(r1, r2 float64) (u, v float64) {
 		return
 	}
 
-	return shirleyDisk(r1, r2)
+	return sampleutil.ShirleyDisk(r1, r2)
+}
+
+func (cam *perspective) ShootRayAt(x, y, u, v, time float64) (r goray.Ray, wt float64) {
+	pv := cam.viewAt(time)
+	r, wt = cam.shootRay(&pv, x, y, u, v)
+	r.Time = time
+	return
 }
 
-func (cam *perspective) ShootRay(x, y, u, v float64) (r goray.Ray, wt float64) {
+func (cam *perspective) shootRay(pv *perspectiveView, x, y, u, v float64) (r goray.Ray, wt float64) {
 	wt = 1.0 // for now, always 1, except 0 for probe when outside sphere
 
 	r = goray.Ray{
-		From: cam.eye,
-		Dir:  vec64.Sum(cam.right.Scale(x), cam.up.Scale(y), cam.look).Normalize(),
+		From: pv.eye,
+		Dir:  vec64.Sum(pv.right.Scale(x), pv.up.Scale(y), pv.look).Normalize(),
 		TMax: -1.0,
 	}
 
 	if cam.SampleLens() {
 		u, v = cam.getLensUV(u, v)
-		li := vec64.Add(cam.dofRight.Scale(u), cam.dofUp.Scale(v))
+		li := vec64.Add(pv.dofRight.Scale(u), pv.dofUp.Scale(v))
 		r.From = vec64.Add(r.From, li)
 		r.Dir = vec64.Sub(r.Dir.Scale(cam.dofDistance), li).Normalize()
 	}
 	return
 }
 
+// Project finds the fragment that a ray leaving the lens would have been shot
+// through.  The ray must start on the lens; for a camera without depth of
+// field, this is the eye.  On success, lu and lv are set to the fragment
+// position and the returned PDF is the solid angle density of ShootRay
+// picking the ray's direction when sampling the whole image uniformly.
+// A moving camera is projected onto as it is at the ray's time.
 func (cam *perspective) Project(wo goray.Ray, lu, lv *float64) (pdf float64, changed bool) {
-	// TODO
-	return
+	pv := cam.viewAt(wo.Time)
+	return cam.project(&pv, wo, lu, lv)
+}
+
+func (cam *perspective) project(pv *perspectiveView, wo goray.Ray, lu, lv *float64) (pdf float64, changed bool) {
+	dir := wo.Dir
+	if cam.SampleLens() {
+		// ShootRay aims every lens ray at a point dofDistance away from the eye
+		// along the pinhole direction, so find where the ray crosses that sphere.
+		li := vec64.Sub(wo.From, pv.eye)
+		b := vec64.Dot(li, wo.Dir)
+		disc := b*b - li.LengthSqr() + cam.dofDistance*cam.dofDistance
+		if disc < 0 {
+			return
+		}
+		t := -b + math.Sqrt(disc)
+		dir = vec64.Add(li, wo.Dir.Scale(t)).Normalize()
+	}
+
+	dx, dy, dz := vec64.Dot(pv.x, dir), vec64.Dot(pv.y, dir), vec64.Dot(pv.z, dir)
+	if dz <= 0 {
+		return
+	}
+	u := dx * cam.focalDistance / dz
+	if u < -0.5 || u > 0.5 {
+		return
+	}
+	v := dy * cam.focalDistance / (dz * cam.aspectRatio)
+	if v < -0.5 || v > 0.5 {
+		return
+	}
+	*lu = (u + 0.5) * float64(cam.resx)
+	*lv = (v + 0.5) * float64(cam.resy)
+
+	// The image covers an area of aPix on the plane one unit in front of the
+	// eye.  Converting that to solid angle gives a factor of 1/cos^3.
+	pdf = 1.0 / (cam.aPix * dz * dz * dz)
+	return pdf, true
 }
 
 func (cam *perspective) SampleLens() bool {
 	return cam.aperture != 0
 }
 
+func (cam *perspective) Exposure() float64 {
+	return cam.exposure
+}
+
+// SetExposure sets the exposure of a perspective camera from the settings of
+// a physical camera: the f-number of the lens, the time that the shutter is
+// open in seconds and the ISO speed of the film.  A scene luminance of
+// 1.2 * 100 * fStop^2 / (shutterTime * iso) fills the film, as it does for a
+// light meter calibrated to the ISO standard.
+func SetExposure(cam goray.Camera, fStop, shutterTime, iso float64) bool {
+	pc, ok := cam.(*perspective)
+	if ok {
+		pc.exposure = shutterTime * iso / (1.2 * 100 * fStop * fStop)
+	}
+	return ok
+}
+
 func init() {
 	yamlscene.Constructor[yamlscene.StdPrefix+"cameras/perspective"] = yamlscene.MapConstruct(constructPerspective)
 }
@@ -248,9 +324,18 @@ func constructPerspective(m yamldata.Map) (interface{}, error) {
 	height, _ := yamldata.AsInt(m["height"])
 
 	aspect, _ := yamldata.AsFloat(m.SetDefault("aspect", 1.0))
-	focalDistance, _ := yamldata.AsFloat(m.SetDefault("focalDistance", 1.0))
-	dofDistance, _ := yamldata.AsFloat(m.SetDefault("dofDistance", 0.0))
-	aperture, _ := yamldata.AsFloat(m.SetDefault("aperture", 0.0))
+	focalDistance, err := constructFocalDistance(m)
+	if err != nil {
+		return nil, err
+	}
+	dofDistance, err := constructDOFDistance(m, pos)
+	if err != nil {
+		return nil, err
+	}
+	aperture, err := constructAperture(m, focalDistance, dofDistance)
+	if err != nil {
+		return nil, err
+	}
 	btype := m.SetDefault("bokehType", "disk1").(string)
 	bbias, _ := m.SetDefault("bokehBias", "uniform").(string)
 	bokehRot, _ := yamldata.AsFloat(m.SetDefault("bokehRotation", 1.0))
@@ -279,7 +364,102 @@ func constructPerspective(m yamldata.Map) (interface{}, error) {
 		bokehBias = EdgeBias
 	}
 
+	open, close, keys, err := constructMotion(m, pos, look, up)
+	if err != nil {
+		return nil, err
+	}
+
 	cam := NewPerspective(pos, look, up, width, height, aspect, focalDistance, aperture, bokehType, bokehBias, bokehRot)
 	cam.(*perspective).dofDistance = dofDistance
+	SetMotion(cam, open, close, keys)
+	if m.HasKeys("shutterTime") || m.HasKeys("iso") {
+		if !m.HasKeys("fStop", "shutterTime", "iso") {
+			return nil, errors.New("Camera exposure needs fStop, shutterTime and iso")
+		}
+		fStop, _ := yamldata.AsFloat(m["fStop"])
+		shutterTime, ok1 := yamldata.AsFloat(m["shutterTime"])
+		iso, ok2 := yamldata.AsFloat(m["iso"])
+		if !ok1 || !ok2 || shutterTime <= 0 || iso <= 0 {
+			return nil, errors.New("Camera shutterTime and iso must be positive")
+		}
+		SetExposure(cam, fStop, shutterTime, iso)
+	}
 	return cam, nil
 }
+
+// constructFocalDistance finds the distance from the eye to an image plane one
+// unit wide.  It can be given directly, by a horizontal field of view in
+// degrees or by the focal length of a lens and the width of the film (both in
+// millimeters).
+func constructFocalDistance(m yamldata.Map) (float64, error) {
+	n := 0
+	for _, k := range []string{"focalDistance", "fov", "focalLength"} {
+		if m.HasKeys(k) {
+			n++
+		}
+	}
+	if n > 1 {
+		return 0, errors.New("Camera must have only one of focalDistance, fov and focalLength")
+	}
+
+	switch {
+	case m.HasKeys("fov"):
+		fov, ok := yamldata.AsFloat(m["fov"])
+		if !ok || fov <= 0 || fov >= 180 {
+			return 0, errors.New("Camera fov must be between 0 and 180 degrees")
+		}
+		return 0.5 / math.Tan(fov*math.Pi/360), nil
+	case m.HasKeys("focalLength"):
+		focalLength, ok1 := yamldata.AsFloat(m["focalLength"])
+		sensorWidth, ok2 := yamldata.AsFloat(m.SetDefault("sensorWidth", 36.0))
+		if !ok1 || !ok2 || focalLength <= 0 || sensorWidth <= 0 {
+			return 0, errors.New("Camera focalLength and sensorWidth must be positive")
+		}
+		return focalLength / sensorWidth, nil
+	}
+	focalDistance, _ := yamldata.AsFloat(m.SetDefault("focalDistance", 1.0))
+	return focalDistance, nil
+}
+
+// constructDOFDistance finds the distance that the camera is focused at.  It
+// can be given directly or by a point to focus on.
+func constructDOFDistance(m yamldata.Map, pos vec64.Vector) (float64, error) {
+	if m.HasKeys("focusOn") {
+		if m.HasKeys("dofDistance") {
+			return 0, errors.New("Camera must not have both dofDistance and focusOn")
+		}
+		p, ok := m["focusOn"].(vec64.Vector)
+		if !ok {
+			return 0, errors.New("Camera focusOn must be a vector")
+		}
+		return vec64.Sub(p, pos).Length(), nil
+	}
+	dofDistance, _ := yamldata.AsFloat(m.SetDefault("dofDistance", 0.0))
+	return dofDistance, nil
+}
+
+// constructAperture finds the radius of the lens.  It can be given directly or
+// by an f-number, which divides the focal length of the lens (the film width
+// times focalDistance) to get the diameter.  metersPerUnit converts the
+// diameter from millimeters to scene units.  A camera with an f-number but
+// nothing to focus on stays a pinhole camera.
+func constructAperture(m yamldata.Map, focalDistance, dofDistance float64) (float64, error) {
+	if !m.HasKeys("fStop") {
+		aperture, _ := yamldata.AsFloat(m.SetDefault("aperture", 0.0))
+		return aperture, nil
+	}
+	if m.HasKeys("aperture") {
+		return 0, errors.New("Camera must not have both aperture and fStop")
+	}
+	fStop, ok1 := yamldata.AsFloat(m["fStop"])
+	sensorWidth, ok2 := yamldata.AsFloat(m.SetDefault("sensorWidth", 36.0))
+	metersPerUnit, ok3 := yamldata.AsFloat(m.SetDefault("metersPerUnit", 1.0))
+	if !ok1 || !ok2 || !ok3 || fStop <= 0 || sensorWidth <= 0 || metersPerUnit <= 0 {
+		return 0, errors.New("Camera fStop, sensorWidth and metersPerUnit must be positive")
+	}
+	if dofDistance == 0 {
+		return 0, nil
+	}
+	focalLength := focalDistance * sensorWidth
+	return focalLength / (2 * fStop) / 1000 / metersPerUnit, nil
+}
@@ -0,0 +1,317 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cameras
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// A StereoMode determines how the eyes of a stereo camera are set apart.
+type StereoMode int
+
+// Stereo modes
+const (
+	// OffAxis moves the eyes apart along the camera's right vector and skews
+	// their views so that they meet at the convergence distance.
+	OffAxis StereoMode = iota
+	// Parallel moves the eyes apart without changing where they look.
+	Parallel
+	// OmniDirectional (ODS) moves the eyes apart perpendicular to the
+	// horizontal direction of each ray instead of the camera's right vector,
+	// so that every direction of a panoramic camera is seen in stereo.  The
+	// rays of the two eyes meet at the convergence distance.
+	OmniDirectional
+)
+
+// A StereoLayout determines where the eyes of a stereo camera are placed in
+// the image.
+type StereoLayout int
+
+// Stereo layouts
+const (
+	// SideBySide places the left eye on the left half of the image.
+	SideBySide StereoLayout = iota
+	// OverUnder places the left eye on the top half of the image.
+	OverUnder
+)
+
+// framedCamera is a camera from this package, which can say where it is
+// looking.
+type framedCamera interface {
+	goray.ShutterCamera
+	frameAt(t float64) frame
+}
+
+// stereo renders the view of a camera from two eyes.
+type stereo struct {
+	cam              framedCamera
+	iod, convergence float64
+	mode             StereoMode
+	layout           StereoLayout
+}
+
+var _ goray.StereoCamera = &stereo{}
+var _ goray.ShutterCamera = &stereo{}
+var _ goray.ExposedCamera = &stereo{}
+
+// NewStereo creates a camera that renders cam from a left and right eye that
+// are iod apart.  The views are converged at a distance of convergence, or
+// at infinity if convergence is zero.  cam must be a camera from this package.
+func NewStereo(cam goray.Camera, iod, convergence float64, mode StereoMode, layout StereoLayout) (goray.Camera, error) {
+	fc, ok := cam.(framedCamera)
+	if !ok {
+		return nil, errors.New("Stereo camera must wrap a camera from the cameras package")
+	}
+	return &stereo{
+		cam:         fc,
+		iod:         iod,
+		convergence: convergence,
+		mode:        mode,
+		layout:      layout,
+	}, nil
+}
+
+func (s *stereo) ResolutionX() int {
+	if s.layout == SideBySide {
+		return 2 * s.cam.ResolutionX()
+	}
+	return s.cam.ResolutionX()
+}
+
+func (s *stereo) ResolutionY() int {
+	if s.layout == OverUnder {
+		return 2 * s.cam.ResolutionY()
+	}
+	return s.cam.ResolutionY()
+}
+
+func (s *stereo) EyeBounds(e goray.Eye) image.Rectangle {
+	w, h := s.cam.ResolutionX(), s.cam.ResolutionY()
+	r := image.Rect(0, 0, w, h)
+	if e == goray.RightEye {
+		if s.layout == SideBySide {
+			r = r.Add(image.Pt(w, 0))
+		} else {
+			r = r.Add(image.Pt(0, h))
+		}
+	}
+	return r
+}
+
+func (s *stereo) SampleLens() bool               { return s.cam.SampleLens() }
+func (s *stereo) Shutter() (open, close float64) { return s.cam.Shutter() }
+
+func (s *stereo) Exposure() float64 {
+	if ec, ok := s.cam.(goray.ExposedCamera); ok {
+		return ec.Exposure()
+	}
+	return 1
+}
+
+// ShootRay shoots a ray at the time that the shutter opens.
+func (s *stereo) ShootRay(x, y, u, v float64) (goray.Ray, float64) {
+	open, _ := s.cam.Shutter()
+	return s.ShootRayAt(x, y, u, v, open)
+}
+
+func (s *stereo) ShootRayAt(x, y, u, v, time float64) (r goray.Ray, wt float64) {
+	eye := goray.LeftEye
+	switch w, h := float64(s.cam.ResolutionX()), float64(s.cam.ResolutionY()); {
+	case s.layout == SideBySide && x >= w:
+		eye, x = goray.RightEye, x-w
+	case s.layout == OverUnder && y >= h:
+		eye, y = goray.RightEye, y-h
+	}
+	r, wt = s.cam.ShootRayAt(x, y, u, v, time)
+	if wt == 0 {
+		return
+	}
+
+	f := s.cam.frameAt(time)
+	o := s.eyeOffset(f, eye, r.Dir)
+	from := vec64.Add(r.From, o)
+	if s.convergence > 0 {
+		if p, ok := s.convergencePoint(f, r); ok {
+			r.Dir = vec64.Sub(p, from).Normalize()
+		}
+	}
+	r.From = from
+	return r, wt
+}
+
+// eyeOffset returns how far an eye is from the center of the camera for a
+// ray going in the direction dir.
+func (s *stereo) eyeOffset(f frame, eye goray.Eye, dir vec64.Vector) vec64.Vector {
+	d := s.iod / 2
+	if eye == goray.LeftEye {
+		d = -d
+	}
+	if s.mode != OmniDirectional {
+		return f.right.Scale(d)
+	}
+	h := vec64.Sub(dir, f.up.Scale(vec64.Dot(dir, f.up)))
+	if h.LengthSqr() == 0 {
+		// Straight up or down, both eyes are at the center.
+		return vec64.Vector{}
+	}
+	return vec64.Cross(h, f.up).Normalize().Scale(d)
+}
+
+// convergencePoint finds where a ray from the center of the camera meets the
+// surface at the convergence distance, which both eyes look at.  For ODS, the
+// surface is a sphere around the camera; otherwise, it is a plane in front of
+// the camera.
+func (s *stereo) convergencePoint(f frame, r goray.Ray) (p vec64.Vector, ok bool) {
+	switch s.mode {
+	case Parallel:
+		return
+	case OmniDirectional:
+		return vec64.Add(r.From, r.Dir.Scale(s.convergence)), true
+	}
+	cos := vec64.Dot(r.Dir, f.forward)
+	if cos <= 0 {
+		return
+	}
+	dist := (s.convergence - vec64.Dot(vec64.Sub(r.From, f.eye), f.forward)) / cos
+	return vec64.Add(r.From, r.Dir.Scale(dist)), true
+}
+
+// Project finds the fragment that a ray leaving one of the eyes would have
+// been shot through.  The ray is moved back to the center of the camera and
+// projected with the wrapped camera.
+//
+// An ODS camera has a different viewpoint for every direction, so its
+// projection treats the eye as a single point.  This is close as long as the
+// interocular distance is small compared to the scene.
+func (s *stereo) Project(wo goray.Ray, lu, lv *float64) (pdf float64, changed bool) {
+	f := s.cam.frameAt(wo.Time)
+	q := vec64.Sub(wo.From, f.eye)
+
+	// Find the center ray that this ray was made from.  jac converts the
+	// wrapped camera's density to the density of this ray's direction.
+	dir, jac := wo.Dir, 1.0
+	if s.convergence > 0 && s.mode != Parallel {
+		var p vec64.Vector
+		switch s.mode {
+		case OmniDirectional:
+			b := vec64.Dot(q, wo.Dir)
+			disc := b*b - q.LengthSqr() + s.convergence*s.convergence
+			if disc < 0 {
+				return
+			}
+			p = vec64.Add(wo.From, wo.Dir.Scale(-b+math.Sqrt(disc)))
+		default:
+			cos := vec64.Dot(wo.Dir, f.forward)
+			if cos <= 0 {
+				break
+			}
+			p = vec64.Add(wo.From, wo.Dir.Scale((s.convergence-vec64.Dot(q, f.forward))/cos))
+		}
+		if !p.IsZero() {
+			dir = vec64.Sub(p, f.eye).Normalize()
+			// Both rays pass through the same patch of the convergence
+			// surface, so the ratio of their solid angles is the ratio of
+			// cos/dist^2 from each one's origin.
+			normal := f.forward
+			if s.mode == OmniDirectional {
+				normal = dir
+			}
+			dIn, dOut := vec64.Sub(p, f.eye), vec64.Sub(p, wo.From)
+			cosIn := math.Abs(vec64.Dot(dIn.Normalize(), normal))
+			cosOut := math.Abs(vec64.Dot(wo.Dir, normal))
+			if cosOut == 0 {
+				return
+			}
+			jac = (cosIn / dIn.LengthSqr()) / (cosOut / dOut.LengthSqr())
+		}
+	}
+
+	eye := goray.LeftEye
+	if s.mode == OmniDirectional {
+		if vec64.Dot(q, s.eyeOffset(f, goray.RightEye, dir)) > 0 {
+			eye = goray.RightEye
+		}
+	} else if vec64.Dot(q, f.right) > 0 {
+		eye = goray.RightEye
+	}
+	from := vec64.Sub(wo.From, s.eyeOffset(f, eye, dir))
+
+	pdf, changed = s.cam.Project(goray.Ray{From: from, Dir: dir, TMax: -1.0, Time: wo.Time}, lu, lv)
+	if !changed {
+		return
+	}
+	if eye == goray.RightEye {
+		b := s.EyeBounds(goray.RightEye)
+		*lu += float64(b.Min.X)
+		*lv += float64(b.Min.Y)
+	}
+	// The wrapped camera's density is over one eye's image, which is half
+	// of the whole image.
+	return pdf * jac / 2, true
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"cameras/stereo"] = yamlscene.MapConstruct(constructStereo)
+}
+
+func constructStereo(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	cam, ok := m["camera"].(goray.Camera)
+	if !ok {
+		return nil, errors.New("Stereo camera must have a camera")
+	}
+	iod, _ := yamldata.AsFloat(m.SetDefault("interocularDistance", 0.065))
+	convergence, _ := yamldata.AsFloat(m.SetDefault("convergence", 0.0))
+	if iod < 0 || convergence < 0 {
+		return nil, errors.New("Stereo distances must not be negative")
+	}
+
+	var mode StereoMode
+	switch m.SetDefault("mode", "offAxis") {
+	case "offAxis":
+		mode = OffAxis
+	case "parallel":
+		mode = Parallel
+	case "ods":
+		mode = OmniDirectional
+	default:
+		return nil, errors.New("Unknown stereo mode")
+	}
+
+	var layout StereoLayout
+	switch m.SetDefault("layout", "sideBySide") {
+	case "sideBySide":
+		layout = SideBySide
+	case "overUnder":
+		layout = OverUnder
+	default:
+		return nil, errors.New("Unknown stereo layout")
+	}
+
+	return NewStereo(cam, iod, convergence, mode, layout)
+}
@@ -0,0 +1,387 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package distrib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/log"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// Coordinator describes how to render scenes on worker processes.
+type Coordinator struct {
+	// Workers are the TCP addresses of the worker processes.
+	Workers []string
+
+	// TextureDir is the directory that relative texture and voxel grid names
+	// are loaded from.
+	TextureDir string
+
+	// TileTimeout, if positive, is how long a worker may take to render a
+	// tile before the worker is dropped and its tile given to another worker.
+	TileTimeout time.Duration
+}
+
+// Session is a scene that has been loaded by a coordinator and its workers.
+type Session struct {
+	scene       *goray.Scene
+	integ       goray.Integrator
+	splats      *goray.SplatBuffer
+	tileTimeout time.Duration
+
+	lock    sync.Mutex
+	workers []*workerConn
+	err     error
+	cancel  context.CancelFunc
+}
+
+// workerConn is a connection to a worker that has loaded a session's scene.
+type workerConn struct {
+	addr   string
+	client *rpc.Client
+	id     int
+}
+
+// call calls a worker method, giving up once the context is done.
+func (wc *workerConn) call(ctx context.Context, method string, args, reply interface{}) error {
+	call := wc.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Load reads a scene into sc and loads it on each of the coordinator's
+// workers.  The workers that fail to load the scene are left out of the
+// session, but at least one worker must succeed.  The workers record the
+// scene's AOVs, including any that were added to sc before it was loaded.
+func (c *Coordinator) Load(ctx context.Context, r io.Reader, sc *goray.Scene, l log.Logger) (*Session, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	files := newFileRecorder(c.TextureDir)
+	integ, err := yamlscene.Load(bytes.NewReader(src), sc, fileParams(files.read))
+	if err != nil {
+		return nil, err
+	}
+	if sc.Camera() == nil {
+		return nil, errors.New("Scene has no camera")
+	}
+
+	sess := &Session{
+		scene:       sc,
+		integ:       integ,
+		tileTimeout: c.TileTimeout,
+	}
+	if _, ok := integ.(goray.SplatIntegrator); ok {
+		sess.splats = goray.NewSplatBuffer(sc.Camera().ResolutionX(), sc.Camera().ResolutionY())
+	}
+	args := LoadArgs{Source: src, Files: files.files, AOVs: sc.AOVs()}
+	var wg sync.WaitGroup
+	for _, addr := range c.Workers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			wc, err := loadWorker(ctx, addr, &args)
+			if err != nil {
+				l.Warningf("Worker %s: %v", addr, err)
+				return
+			}
+			sess.lock.Lock()
+			sess.workers = append(sess.workers, wc)
+			sess.lock.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	if len(sess.workers) == 0 {
+		return nil, errors.New("No workers loaded the scene")
+	}
+	l.Infof("Loaded scene on %d worker(s)", len(sess.workers))
+	return sess, nil
+}
+
+// loadWorker connects to a worker and loads a scene on it.
+func loadWorker(ctx context.Context, addr string, args *LoadArgs) (*workerConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	wc := &workerConn{addr: addr, client: rpc.NewClient(conn)}
+	var reply LoadReply
+	if err := wc.call(ctx, "Worker.Load", args, &reply); err != nil {
+		wc.client.Close()
+		return nil, err
+	}
+	wc.id = reply.ID
+	return wc, nil
+}
+
+// Integrator returns the session's integrator.  Its splats are the ones that
+// the workers have sent back.
+func (sess *Session) Integrator() goray.Integrator {
+	if sess.splats != nil {
+		return splatIntegrator{sess.integ, sess.splats}
+	}
+	return sess.integ
+}
+
+// splatIntegrator replaces the splats of an integrator.
+type splatIntegrator struct {
+	goray.Integrator
+	splats *goray.SplatBuffer
+}
+
+func (si splatIntegrator) Splats() *goray.SplatBuffer { return si.splats }
+
+// Render renders the scene on the workers like goray.RenderContext.
+func (sess *Session) Render(ctx context.Context, l log.Logger) (*goray.Image, error) {
+	ctx = sess.start(ctx)
+	img, err := goray.RenderWith(ctx, sess.scene, sess.Integrator(), sess.integrate, l)
+	return sess.finish(img, err)
+}
+
+// RenderProgressive renders the scene on the workers like
+// goray.RenderProgressiveContext.
+func (sess *Session) RenderProgressive(ctx context.Context, prog goray.Progressive, snapshot func(*goray.Image, goray.Progress), l log.Logger) (*goray.Image, error) {
+	ctx = sess.start(ctx)
+	img, err := goray.RenderProgressiveWith(ctx, sess.scene, sess.Integrator(), sess.integrate, prog, snapshot, l)
+	return sess.finish(img, err)
+}
+
+// start returns a context for a render that is canceled if the session fails.
+func (sess *Session) start(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	sess.lock.Lock()
+	sess.err, sess.cancel = nil, cancel
+	sess.lock.Unlock()
+	return ctx
+}
+
+// finish returns the session's error in place of the render's error if the
+// session failed.
+func (sess *Session) finish(img *goray.Image, err error) (*goray.Image, error) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	sess.cancel()
+	if sess.err != nil {
+		return nil, sess.err
+	}
+	return img, err
+}
+
+// fail stops the current render with an error.
+func (sess *Session) fail(err error) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.err == nil {
+		sess.err = err
+	}
+	sess.cancel()
+}
+
+// drop removes a worker from the session and returns the number of workers
+// left.
+func (sess *Session) drop(wc *workerConn) int {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	for i := range sess.workers {
+		if sess.workers[i] == wc {
+			sess.workers = append(sess.workers[:i], sess.workers[i+1:]...)
+			wc.client.Close()
+			break
+		}
+	}
+	return len(sess.workers)
+}
+
+// integrate hands out the tiles of a pass to the session's workers.  It is a
+// goray.PassFunc.
+func (sess *Session) integrate(ctx context.Context, s *goray.Scene, in goray.Integrator, p goray.Pass, l log.Logger) <-chan goray.Fragment {
+	w, h := s.Camera().ResolutionX(), s.Camera().ResolutionY()
+	area := image.Rect(0, 0, w, h)
+	if !p.Region.Empty() {
+		area = p.Region.Intersect(area)
+	}
+	size, order := s.Tiles()
+	tiles := goray.Tiles(area, size, order)
+	tileFunc := s.TileFunc()
+	wire := p
+	wire.Region, wire.Mask = image.Rectangle{}, nil
+
+	// Tiles are numbered by their position in tiles so that their
+	// fragments can be sent in order.
+	type tileJob struct {
+		index int
+		args  TileArgs
+	}
+	queue := make(chan tileJob, len(tiles))
+	ch := make(chan goray.Fragment, 1024)
+	seq := goray.NewTileSequencer(ch)
+	var (
+		doneLock sync.Mutex
+		done     int
+	)
+	finishTile := func(t image.Rectangle) {
+		doneLock.Lock()
+		defer doneLock.Unlock()
+		done++
+		l.Debugf("Tile %v (%d/%d)", t, done, len(tiles))
+		if tileFunc != nil {
+			tileFunc(goray.TileProgress{Tile: t, Pass: p.Number, Passes: p.Passes, Done: done, Total: len(tiles)})
+		}
+		if done == len(tiles) {
+			close(queue)
+		}
+	}
+	if len(tiles) == 0 {
+		close(queue)
+	}
+	for i, t := range tiles {
+		args := TileArgs{Pass: wire, Tile: t}
+		if p.Mask != nil {
+			args.Mask = tileMask(p.Mask, w, t)
+			if args.Mask == nil {
+				seq.Finish(i, nil)
+				finishTile(t)
+				continue
+			}
+		}
+		queue <- tileJob{i, args}
+	}
+
+	sess.lock.Lock()
+	workers := append([]*workerConn(nil), sess.workers...)
+	sess.lock.Unlock()
+	go func() {
+		defer close(ch)
+		var wg sync.WaitGroup
+		for _, wc := range workers {
+			wg.Add(1)
+			go func(wc *workerConn) {
+				defer wg.Done()
+				for {
+					var job tileJob
+					select {
+					case j, ok := <-queue:
+						if !ok {
+							return
+						}
+						job = j
+					case <-ctx.Done():
+						return
+					}
+					args := job.args
+					args.ID = wc.id
+					reply, err := sess.renderTile(ctx, wc, args)
+					if ctx.Err() != nil {
+						return
+					}
+					if err != nil {
+						queue <- job
+						left := sess.drop(wc)
+						l.Warningf("Worker %s failed, reassigning tile %v: %v", wc.addr, args.Tile, err)
+						if left == 0 {
+							sess.fail(errors.New("All workers failed"))
+						}
+						return
+					}
+					if sess.splats != nil {
+						sess.splats.MergeSplats(reply.Splats)
+					}
+					frags := make([]goray.Fragment, len(reply.Fragments))
+					for i, f := range reply.Fragments {
+						frags[i] = f.Fragment()
+					}
+					finishTile(args.Tile)
+					seq.Finish(job.index, frags)
+				}
+			}(wc)
+		}
+		wg.Wait()
+	}()
+	return ch
+}
+
+// renderTile asks a worker to render a tile, giving up after the session's
+// tile timeout.
+func (sess *Session) renderTile(ctx context.Context, wc *workerConn, args TileArgs) (*TileReply, error) {
+	if sess.tileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sess.tileTimeout)
+		defer cancel()
+	}
+	args.Timeout = sess.tileTimeout
+	reply := new(TileReply)
+	if err := wc.call(ctx, "Worker.RenderTile", &args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// tileMask returns the part of an image's pass mask that covers a tile, or nil
+// if none of the tile's pixels are rendered.
+func tileMask(mask []bool, w int, t image.Rectangle) []bool {
+	m := make([]bool, 0, t.Dx()*t.Dy())
+	used := false
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		row := mask[y*w+t.Min.X : y*w+t.Max.X]
+		for _, b := range row {
+			used = used || b
+		}
+		m = append(m, row...)
+	}
+	if !used {
+		return nil
+	}
+	return m
+}
+
+// Close unloads the scene from the workers and closes their connections.
+func (sess *Session) Close() error {
+	sess.lock.Lock()
+	workers := sess.workers
+	sess.workers = nil
+	sess.lock.Unlock()
+	for _, wc := range workers {
+		wc.call(context.Background(), "Worker.Unload", wc.id, new(struct{}))
+		wc.client.Close()
+	}
+	return nil
+}
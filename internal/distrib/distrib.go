@@ -0,0 +1,177 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package distrib renders scenes across several worker processes.
+//
+// A coordinator loads a scene, sends the scene file and the files it refers to
+// (like textures) to each of its workers over net/rpc, and then hands out the
+// tiles of each pass to whichever worker is free.  The fragments that come back
+// are assembled into the image just like a local render.  If a worker fails,
+// the tile it was working on is given to another worker.
+package distrib
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/lights"
+	"zombiezen.com/go/goray/internal/textures"
+	"zombiezen.com/go/goray/internal/volumes"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// LoadArgs are the arguments of Worker.Load.
+type LoadArgs struct {
+	// Source is the scene file.
+	Source []byte
+
+	// Files holds the files that the scene loads, keyed by the name that the
+	// scene uses for them.
+	Files map[string][]byte
+
+	// AOVs lists the arbitrary output variables to record, including the
+	// ones that were not asked for by the scene file.
+	AOVs []goray.AOV
+}
+
+// LoadReply is the result of Worker.Load.
+type LoadReply struct {
+	// ID identifies the scene in later calls.
+	ID int
+}
+
+// TileArgs are the arguments of Worker.RenderTile.
+type TileArgs struct {
+	// ID is the scene to render, as returned by Worker.Load.
+	ID int
+
+	// Pass is the pass that the tile is part of.  Its Region and Mask are
+	// ignored.
+	Pass goray.Pass
+
+	// Tile is the rectangle of pixels to render.
+	Tile image.Rectangle
+
+	// Mask reports which pixels of the tile (in row-major order) are
+	// rendered.  A nil mask renders the whole tile.
+	Mask []bool
+
+	// Timeout, if positive, is how long the worker may spend on the tile.
+	// The coordinator gives up on the tile after this long, so the worker
+	// stops rendering it too.
+	Timeout time.Duration
+}
+
+// TileReply is the result of Worker.RenderTile.
+type TileReply struct {
+	Fragments []Fragment
+
+	// Splats holds the pixels that the integrator splatted light onto while
+	// rendering the tile.
+	Splats []goray.Splat
+}
+
+// Fragment is a goray.Fragment that can be sent with gob.
+type Fragment struct {
+	X, Y   int
+	DX, DY float64
+	Color  color.RGBA
+	AOVs   *goray.AOVValues
+}
+
+func newFragment(frag goray.Fragment) Fragment {
+	f := Fragment{X: frag.X, Y: frag.Y, DX: frag.DX, DY: frag.DY, AOVs: frag.AOVs}
+	f.Color.Copy(frag.Color)
+	return f
+}
+
+// Fragment converts the fragment back to a goray.Fragment.
+func (f Fragment) Fragment() goray.Fragment {
+	return goray.Fragment{X: f.X, Y: f.Y, DX: f.DX, DY: f.DY, Color: f.Color, AOVs: f.AOVs}
+}
+
+// fileParams returns scene parameters that load textures, voxel grids and
+// photometric profiles with a function that reads the contents of a named file.
+func fileParams(read func(name string) ([]byte, error)) yamlscene.Params {
+	return yamlscene.Params{
+		"ImageLoader": textures.ImageLoaderFunc(func(name string) (*goray.Image, error) {
+			data, err := read(name)
+			if err != nil {
+				return nil, err
+			}
+			i, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return goray.NewGoImage(i), nil
+		}),
+		"GridLoader": volumes.GridLoaderFunc(func(name string) (*volumes.Grid, error) {
+			data, err := read(name)
+			if err != nil {
+				return nil, err
+			}
+			return volumes.ReadGrid(bytes.NewReader(data))
+		}),
+		"IESLoader": lights.IESLoaderFunc(func(name string) (*lights.IESProfile, error) {
+			data, err := read(name)
+			if err != nil {
+				return nil, err
+			}
+			return lights.ReadIES(bytes.NewReader(data))
+		}),
+	}
+}
+
+// A fileRecorder reads files relative to a directory and keeps a copy of each
+// file that it reads.
+type fileRecorder struct {
+	base  string
+	lock  sync.Mutex
+	files map[string][]byte
+}
+
+func newFileRecorder(base string) *fileRecorder {
+	return &fileRecorder{base: base, files: make(map[string][]byte)}
+}
+
+func (fr *fileRecorder) read(name string) ([]byte, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+	path := filepath.FromSlash(name)
+	if name[0] != '/' {
+		path = filepath.Join(fr.base, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.files[name] = data
+	return data, nil
+}
@@ -0,0 +1,250 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package distrib
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/intersect"
+	"zombiezen.com/go/goray/internal/log"
+	"zombiezen.com/go/goray/internal/yamlscene"
+
+	_ "zombiezen.com/go/goray/internal/cameras"
+	_ "zombiezen.com/go/goray/internal/integrators"
+	_ "zombiezen.com/go/goray/internal/lights"
+	_ "zombiezen.com/go/goray/internal/materials"
+)
+
+const testScene = `%YAML 1.2
+%TAG !goray! tag:goray/
+%TAG !std! tag:goray/std/
+---
+objects:
+   -  !std!objects/mesh
+      vertices:
+         -  [-2.0, 0.0, -2.0]
+         -  [2.0, 0.0, -2.0]
+         -  [2.0, 0.0, 2.0]
+         -  [-2.0, 0.0, 2.0]
+         -  [-2.0, 3.0, -2.0]
+         -  [2.0, 3.0, -2.0]
+      faces:
+         -  vertices: [2, 1, 0]
+            material: &white !std!materials/shinydiffuse
+               color: !goray!rgb [0.8, 0.8, 0.8]
+               mirrorColor: !goray!rgb [1.0, 1.0, 1.0]
+               diffuseReflect: 1.0
+         -  vertices: [0, 3, 2]
+            material: *white
+         -  vertices: [0, 1, 5]
+            material: *white
+         -  vertices: [0, 5, 4]
+            material: *white
+camera: !std!cameras/perspective
+   position: !goray!vec [0.0, 1.5, 5.5]
+   look: !goray!vec [0.0, 1.5, 0.0]
+   up: !goray!vec [0.0, 2.5, 5.5]
+   width: 40
+   height: 30
+   focalDistance: 1.0
+lights:
+   -  !std!lights/point
+      position: !goray!vec [0.0, 2.5, 0.0]
+      color: !goray!rgb [1.0, 1.0, 1.0]
+      intensity: 3.0
+integrator: !std!integrators/directlight
+   maxDepth: 3
+antialiasing:
+   samples: 2
+   passes: 2
+   threshold: 0.05
+tiles:
+   size: 8
+   order: spiral
+...
+`
+
+var testLog = log.New(ioutil.Discard)
+
+// closingListener is a listener that can close the connections it accepted,
+// which looks like the worker died to its coordinator.
+type closingListener struct {
+	net.Listener
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+func (l *closingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.lock.Lock()
+		l.conns = append(l.conns, c)
+		l.lock.Unlock()
+	}
+	return c, err
+}
+
+func (l *closingListener) closeConns() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, c := range l.conns {
+		c.Close()
+	}
+}
+
+func startWorker(t *testing.T) *closingListener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &closingListener{Listener: ln}
+	go Serve(l, NewWorker(testLog))
+	return l
+}
+
+func renderLocal(t *testing.T) *goray.Image {
+	sc := goray.NewScene(goray.IntersecterBuilder(intersect.NewKD), testLog)
+	integ, err := yamlscene.Load(strings.NewReader(testScene), sc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return goray.Render(sc, integ, testLog)
+}
+
+func renderDistributed(t *testing.T, c *Coordinator, beforeRender func()) (*goray.Image, error) {
+	sc := goray.NewScene(goray.IntersecterBuilder(intersect.NewKD), testLog)
+	var tiles int
+	sc.SetTileFunc(func(goray.TileProgress) { tiles++ })
+	sess, err := c.Load(context.Background(), bytes.NewReader([]byte(testScene)), sc, testLog)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	if beforeRender != nil {
+		beforeRender()
+	}
+	img, err := sess.Render(context.Background(), testLog)
+	if err == nil && tiles < 20 {
+		t.Errorf("%d tiles reported; want at least 20", tiles)
+	}
+	return img, err
+}
+
+func compareImages(t *testing.T, got, want *goray.Image) {
+	if got.Width != want.Width || got.Height != want.Height {
+		t.Fatalf("image is %dx%d; want %dx%d", got.Width, got.Height, want.Width, want.Height)
+	}
+	for j := range want.Pix {
+		if got.Pix[j] != want.Pix[j] {
+			t.Errorf("pixel (%d, %d) = %v; want %v", j%want.Width, j/want.Width, got.Pix[j], want.Pix[j])
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		l := startWorker(t)
+		defer l.Close()
+		addrs = append(addrs, l.Addr().String())
+	}
+	img, err := renderDistributed(t, &Coordinator{Workers: addrs}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareImages(t, img, renderLocal(t))
+}
+
+func TestRenderWorkerDies(t *testing.T) {
+	good, bad := startWorker(t), startWorker(t)
+	defer good.Close()
+	defer bad.Close()
+	c := &Coordinator{Workers: []string{bad.Addr().String(), good.Addr().String()}}
+	img, err := renderDistributed(t, c, func() {
+		bad.Close()
+		bad.closeConns()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareImages(t, img, renderLocal(t))
+}
+
+func TestRenderAllWorkersDie(t *testing.T) {
+	l := startWorker(t)
+	defer l.Close()
+	_, err := renderDistributed(t, &Coordinator{Workers: []string{l.Addr().String()}}, func() {
+		l.Close()
+		l.closeConns()
+	})
+	if err == nil {
+		t.Error("Render succeeded after all workers died")
+	}
+}
+
+func TestLoadNoWorkers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = renderDistributed(t, &Coordinator{Workers: []string{addr}}, nil)
+	if err == nil {
+		t.Error("Load succeeded without any workers")
+	}
+}
+
+func TestRenderTileTimeout(t *testing.T) {
+	w := NewWorker(testLog)
+	var lr LoadReply
+	if err := w.Load(LoadArgs{Source: []byte(testScene)}, &lr); err != nil {
+		t.Fatal("Load:", err)
+	}
+	args := TileArgs{
+		ID:      lr.ID,
+		Pass:    goray.Pass{Samples: 1},
+		Tile:    image.Rect(0, 0, 40, 30),
+		Timeout: time.Nanosecond,
+	}
+	if err := w.RenderTile(args, new(TileReply)); err == nil {
+		t.Error("RenderTile succeeded after its timeout")
+	}
+
+	// The worker must still render tiles afterward.
+	args.Timeout = 0
+	var reply TileReply
+	if err := w.RenderTile(args, &reply); err != nil {
+		t.Fatal("RenderTile:", err)
+	}
+	if len(reply.Fragments) != 40*30 {
+		t.Errorf("len(reply.Fragments) = %d; want %d", len(reply.Fragments), 40*30)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package distrib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/intersect"
+	"zombiezen.com/go/goray/internal/log"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// workerTileSize is the size of the tiles that a worker splits each of its
+// tiles into, so that a tile is rendered on all of the worker's processors.
+const workerTileSize = 8
+
+// Worker renders tiles of scenes for a coordinator.  Its methods are served
+// over net/rpc under the name "Worker".  The scene constructors that the
+// scenes use must be registered in the worker's process.
+type Worker struct {
+	log    log.Logger
+	lock   sync.Mutex
+	nextID int
+	scenes map[int]*workerScene
+}
+
+// workerScene is a scene that has been loaded by a worker.  Tiles of a scene
+// are rendered one at a time, so that splats can be attributed to tiles.
+type workerScene struct {
+	lock  sync.Mutex
+	scene *goray.Scene
+	integ goray.Integrator
+}
+
+// NewWorker creates a worker with no scenes loaded.
+func NewWorker(l log.Logger) *Worker {
+	return &Worker{log: l, scenes: make(map[int]*workerScene)}
+}
+
+// Load parses a scene and prepares it for rendering.
+func (w *Worker) Load(args LoadArgs, reply *LoadReply) error {
+	read := func(name string) ([]byte, error) {
+		data, ok := args.Files[name]
+		if !ok {
+			return nil, errors.New("File was not sent by the coordinator: " + name)
+		}
+		return data, nil
+	}
+	sc := goray.NewScene(goray.IntersecterBuilder(intersect.NewKD), w.log)
+	integ, err := yamlscene.Load(bytes.NewReader(args.Source), sc, fileParams(read))
+	if err != nil {
+		return err
+	}
+	if sc.Camera() == nil {
+		return errors.New("Scene has no camera")
+	}
+	for _, a := range args.AOVs {
+		sc.AddAOV(a)
+	}
+	sc.SetTiles(workerTileSize, goray.TileScanline)
+	goray.Prepare(sc, integ)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.nextID++
+	w.scenes[w.nextID] = &workerScene{scene: sc, integ: integ}
+	reply.ID = w.nextID
+	w.log.Infof("Loaded scene %d", reply.ID)
+	return nil
+}
+
+// RenderTile renders a tile of a loaded scene.
+func (w *Worker) RenderTile(args TileArgs, reply *TileReply) error {
+	w.lock.Lock()
+	ws := w.scenes[args.ID]
+	w.lock.Unlock()
+	if ws == nil {
+		return fmt.Errorf("No scene with ID %d", args.ID)
+	}
+
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	cam := ws.scene.Camera()
+	width, height := cam.ResolutionX(), cam.ResolutionY()
+	tile := args.Tile.Intersect(image.Rect(0, 0, width, height))
+	if tile.Empty() {
+		return nil
+	}
+	p := args.Pass
+	p.Region, p.Mask = tile, nil
+	if args.Mask != nil {
+		if len(args.Mask) != args.Tile.Dx()*args.Tile.Dy() {
+			return errors.New("Mask does not match tile")
+		}
+		p.Mask = make([]bool, width*height)
+		for y := tile.Min.Y; y < tile.Max.Y; y++ {
+			for x := tile.Min.X; x < tile.Max.X; x++ {
+				p.Mask[y*width+x] = args.Mask[(y-args.Tile.Min.Y)*args.Tile.Dx()+x-args.Tile.Min.X]
+			}
+		}
+	}
+
+	ctx := context.Background()
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
+	for frag := range goray.BlockIntegrateContext(ctx, ws.scene, ws.integ, p, w.log) {
+		reply.Fragments = append(reply.Fragments, newFragment(frag))
+	}
+	var splats []goray.Splat
+	if si, ok := ws.integ.(goray.SplatIntegrator); ok {
+		if buf := si.Splats(); buf != nil {
+			splats = buf.Take()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// The coordinator has given the tile to another worker.
+		return err
+	}
+	reply.Splats = splats
+	return nil
+}
+
+// Unload forgets a loaded scene.
+func (w *Worker) Unload(id int, _ *struct{}) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.scenes[id] == nil {
+		return fmt.Errorf("No scene with ID %d", id)
+	}
+	delete(w.scenes, id)
+	w.log.Infof("Unloaded scene %d", id)
+	return nil
+}
+
+// Serve accepts connections on a listener and serves the worker's methods on
+// each of them.  Serve returns once the listener fails, e.g. because it was
+// closed.
+func Serve(l net.Listener, w *Worker) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Worker", w); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package exr writes OpenEXR images.
+//
+// Images are written as single-part scan line files with uncompressed 32-bit
+// float channels, which every OpenEXR reader understands.  Channels whose
+// names contain a dot, like "depth.Z", are grouped into layers by most
+// compositing applications.
+package exr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// A Channel is a named plane of samples, stored in row-major order.
+type Channel struct {
+	Name string
+	Pix  []float32
+}
+
+const (
+	magic   = 20000630
+	version = 2
+
+	pixelTypeFloat = 2
+	noCompression  = 0
+	increasingY    = 0
+
+	// maxNameLength is the longest attribute or channel name that a file
+	// without the long names flag may have.
+	maxNameLength = 31
+)
+
+// Encode writes channels as an image with the given width and height.  Every
+// channel must have width*height samples and a unique, non-empty name.
+func Encode(w io.Writer, width, height int, channels []Channel) error {
+	if width < 1 || height < 1 {
+		return errors.New("EXR image must not be empty")
+	}
+	chans := make([]Channel, len(channels))
+	copy(chans, channels)
+	// Channels are stored in alphabetical order.
+	sort.Slice(chans, func(i, j int) bool { return chans[i].Name < chans[j].Name })
+	for i, c := range chans {
+		if c.Name == "" || len(c.Name) > maxNameLength {
+			return errors.New("EXR channel name must have between 1 and 31 bytes")
+		}
+		if i > 0 && chans[i-1].Name == c.Name {
+			return errors.New("EXR channel name is not unique: " + c.Name)
+		}
+		if len(c.Pix) != width*height {
+			return errors.New("EXR channel has wrong number of samples: " + c.Name)
+		}
+	}
+
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.int32(magic)
+	e.int32(version)
+	e.header(width, height, chans)
+
+	// Offset table: each block holds one uncompressed scan line.
+	lineSize := 4 * width * len(chans)
+	offset := uint64(e.n) + 8*uint64(height)
+	for y := 0; y < height; y++ {
+		e.uint64(offset)
+		offset += 8 + uint64(lineSize)
+	}
+
+	for y := 0; y < height; y++ {
+		e.int32(int32(y))
+		e.int32(int32(lineSize))
+		for _, c := range chans {
+			for _, f := range c.Pix[y*width : (y+1)*width] {
+				e.uint32(math.Float32bits(f))
+			}
+		}
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// encoder writes little-endian values and remembers the first error.
+type encoder struct {
+	w   *bufio.Writer
+	n   int
+	err error
+	buf [8]byte
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	var n int
+	n, e.err = e.w.Write(p)
+	e.n += n
+}
+
+func (e *encoder) uint8(x uint8) { e.write([]byte{x}) }
+
+func (e *encoder) uint32(x uint32) {
+	binary.LittleEndian.PutUint32(e.buf[:4], x)
+	e.write(e.buf[:4])
+}
+
+func (e *encoder) int32(x int32) { e.uint32(uint32(x)) }
+
+func (e *encoder) float32(x float32) { e.uint32(math.Float32bits(x)) }
+
+func (e *encoder) uint64(x uint64) {
+	binary.LittleEndian.PutUint64(e.buf[:], x)
+	e.write(e.buf[:])
+}
+
+func (e *encoder) string(s string) {
+	e.write([]byte(s))
+	e.uint8(0)
+}
+
+// attr writes the name, type and size of an attribute.  The value follows.
+func (e *encoder) attr(name, typ string, size int) {
+	e.string(name)
+	e.string(typ)
+	e.int32(int32(size))
+}
+
+func (e *encoder) box2i(xMin, yMin, xMax, yMax int) {
+	e.int32(int32(xMin))
+	e.int32(int32(yMin))
+	e.int32(int32(xMax))
+	e.int32(int32(yMax))
+}
+
+// header writes the required attributes of a scan line image.
+func (e *encoder) header(width, height int, chans []Channel) {
+	size := 1
+	for _, c := range chans {
+		size += len(c.Name) + 1 + 16
+	}
+	e.attr("channels", "chlist", size)
+	for _, c := range chans {
+		e.string(c.Name)
+		e.int32(pixelTypeFloat)
+		e.write([]byte{0, 0, 0, 0}) // pLinear and reserved
+		e.int32(1)                  // xSampling
+		e.int32(1)                  // ySampling
+	}
+	e.uint8(0)
+
+	e.attr("compression", "compression", 1)
+	e.uint8(noCompression)
+	e.attr("dataWindow", "box2i", 16)
+	e.box2i(0, 0, width-1, height-1)
+	e.attr("displayWindow", "box2i", 16)
+	e.box2i(0, 0, width-1, height-1)
+	e.attr("lineOrder", "lineOrder", 1)
+	e.uint8(increasingY)
+	e.attr("pixelAspectRatio", "float", 4)
+	e.float32(1)
+	e.attr("screenWindowCenter", "v2f", 8)
+	e.float32(0)
+	e.float32(0)
+	e.attr("screenWindowWidth", "float", 4)
+	e.float32(1)
+	e.uint8(0)
+}
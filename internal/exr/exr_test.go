@@ -0,0 +1,125 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package exr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// readString reads a null-terminated string from the start of b.
+func readString(b []byte) (string, []byte) {
+	i := bytes.IndexByte(b, 0)
+	return string(b[:i]), b[i+1:]
+}
+
+func TestEncode(t *testing.T) {
+	const w, h = 3, 2
+	chans := []Channel{
+		{Name: "R", Pix: []float32{0, 1, 2, 3, 4, 5}},
+		{Name: "depth.Z", Pix: []float32{10, 11, 12, 13, 14, 15}},
+		{Name: "A", Pix: []float32{1, 1, 1, 0, 0, 0}},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, w, h, chans); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if m := binary.LittleEndian.Uint32(data); m != magic {
+		t.Fatalf("magic = %d; want %d", m, magic)
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != version {
+		t.Errorf("version = %d; want %d", v, version)
+	}
+
+	// Read the attributes, checking the channel list.
+	b := data[8:]
+	attrs := make(map[string][]byte)
+	for b[0] != 0 {
+		var name, typ string
+		name, b = readString(b)
+		typ, b = readString(b)
+		size := int(binary.LittleEndian.Uint32(b))
+		attrs[name+" "+typ] = b[4 : 4+size]
+		b = b[4+size:]
+	}
+	b = b[1:]
+	for _, name := range []string{"channels chlist", "compression compression", "dataWindow box2i", "displayWindow box2i", "lineOrder lineOrder", "pixelAspectRatio float", "screenWindowCenter v2f", "screenWindowWidth float"} {
+		if _, ok := attrs[name]; !ok {
+			t.Errorf("missing attribute %s", name)
+		}
+	}
+	var names []string
+	for cl := attrs["channels chlist"]; cl[0] != 0; cl = cl[16:] {
+		var name string
+		name, cl = readString(cl)
+		names = append(names, name)
+		if pt := binary.LittleEndian.Uint32(cl); pt != pixelTypeFloat {
+			t.Errorf("channel %s pixel type = %d; want %d", name, pt, pixelTypeFloat)
+		}
+	}
+	if want := []string{"A", "R", "depth.Z"}; len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Fatalf("channels = %q; want %q", names, want)
+	}
+
+	// Follow the offset table to each scan line.
+	for y := 0; y < h; y++ {
+		off := binary.LittleEndian.Uint64(b[8*y:])
+		line := data[off:]
+		if ly := int32(binary.LittleEndian.Uint32(line)); ly != int32(y) {
+			t.Errorf("line %d has y = %d", y, ly)
+		}
+		if size := binary.LittleEndian.Uint32(line[4:]); size != 4*w*3 {
+			t.Errorf("line %d size = %d; want %d", y, size, 4*w*3)
+		}
+		for ci, c := range []Channel{chans[2], chans[0], chans[1]} {
+			for x := 0; x < w; x++ {
+				f := math.Float32frombits(binary.LittleEndian.Uint32(line[8+4*(ci*w+x):]))
+				if want := c.Pix[y*w+x]; f != want {
+					t.Errorf("%s(%d, %d) = %v; want %v", c.Name, x, y, f, want)
+				}
+			}
+		}
+	}
+	if last := binary.LittleEndian.Uint64(b[8*(h-1):]); int(last)+8+4*w*3 != len(data) {
+		t.Errorf("file has %d bytes after the last line", len(data)-int(last)-8-4*w*3)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		Name     string
+		W, H     int
+		Channels []Channel
+	}{
+		{"empty", 0, 1, []Channel{{"R", nil}}},
+		{"short", 2, 2, []Channel{{"R", make([]float32, 3)}}},
+		{"duplicate", 1, 1, []Channel{{"R", make([]float32, 1)}, {"R", make([]float32, 1)}}},
+		{"unnamed", 1, 1, []Channel{{"", make([]float32, 1)}}},
+	}
+	for _, test := range tests {
+		if err := Encode(new(bytes.Buffer), test.W, test.H, test.Channels); err == nil {
+			t.Errorf("%s: Encode succeeded; want error", test.Name)
+		}
+	}
+}
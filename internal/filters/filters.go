@@ -0,0 +1,231 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filters
+
+import (
+	"errors"
+	"math"
+
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+type box struct {
+	radius float64
+}
+
+var _ goray.Filter = box{}
+
+// NewBox creates a filter that weights every sample within radius equally.
+// A radius of 0.5 averages the samples inside each pixel.
+func NewBox(radius float64) goray.Filter {
+	return box{radius}
+}
+
+func (f box) Radius() float64             { return f.radius }
+func (f box) Eval(dx, dy float64) float64 { return 1 }
+
+type tent struct {
+	radius float64
+}
+
+var _ goray.Filter = tent{}
+
+// NewTent creates a filter whose weight falls off linearly from its center
+// to zero at radius.
+func NewTent(radius float64) goray.Filter {
+	return tent{radius}
+}
+
+func (f tent) Radius() float64 { return f.radius }
+
+func (f tent) Eval(dx, dy float64) float64 {
+	return math.Max(0, f.radius-math.Abs(dx)) * math.Max(0, f.radius-math.Abs(dy))
+}
+
+type gaussian struct {
+	radius float64
+	alpha  float64
+	edge   float64
+}
+
+var _ goray.Filter = gaussian{}
+
+// NewGaussian creates a Gaussian filter, exp(-alpha·x²), that is shifted down
+// so that it reaches zero at radius.
+func NewGaussian(radius, alpha float64) goray.Filter {
+	return gaussian{
+		radius: radius,
+		alpha:  alpha,
+		edge:   math.Exp(-alpha * radius * radius),
+	}
+}
+
+func (f gaussian) Radius() float64 { return f.radius }
+
+func (f gaussian) Eval(dx, dy float64) float64 {
+	return f.gaussian(dx) * f.gaussian(dy)
+}
+
+func (f gaussian) gaussian(d float64) float64 {
+	return math.Max(0, math.Exp(-f.alpha*d*d)-f.edge)
+}
+
+type mitchell struct {
+	radius float64
+	b, c   float64
+}
+
+var _ goray.Filter = mitchell{}
+
+// NewMitchell creates a Mitchell-Netravali filter with the given B and C
+// parameters.  Mitchell and Netravali recommend B = C = 1/3.
+func NewMitchell(radius, b, c float64) goray.Filter {
+	return mitchell{radius, b, c}
+}
+
+func (f mitchell) Radius() float64 { return f.radius }
+
+func (f mitchell) Eval(dx, dy float64) float64 {
+	return f.mitchell(2*dx/f.radius) * f.mitchell(2*dy/f.radius)
+}
+
+// mitchell evaluates the one-dimensional filter, which extends to |x| = 2.
+func (f mitchell) mitchell(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*f.b-6*f.c)*x*x*x + (-18+12*f.b+6*f.c)*x*x + (6 - 2*f.b)) / 6
+	case x < 2:
+		return ((-f.b-6*f.c)*x*x*x + (6*f.b+30*f.c)*x*x + (-12*f.b-48*f.c)*x + (8*f.b + 24*f.c)) / 6
+	}
+	return 0
+}
+
+type lanczos struct {
+	radius float64
+}
+
+var _ goray.Filter = lanczos{}
+
+// NewLanczos creates a Lanczos filter: a sinc function windowed by a wider
+// sinc that reaches zero at radius.
+func NewLanczos(radius float64) goray.Filter {
+	return lanczos{radius}
+}
+
+func (f lanczos) Radius() float64 { return f.radius }
+
+func (f lanczos) Eval(dx, dy float64) float64 {
+	return f.lanczos(dx) * f.lanczos(dy)
+}
+
+func (f lanczos) lanczos(x float64) float64 {
+	if math.Abs(x) >= f.radius {
+		return 0
+	}
+	return sinc(x) * sinc(x/f.radius)
+}
+
+func sinc(x float64) float64 {
+	if math.Abs(x) < 1e-5 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"filters/box"] = yamlscene.MapConstruct(constructBox)
+	yamlscene.Constructor[yamlscene.StdPrefix+"filters/tent"] = yamlscene.MapConstruct(constructTent)
+	yamlscene.Constructor[yamlscene.StdPrefix+"filters/gaussian"] = yamlscene.MapConstruct(constructGaussian)
+	yamlscene.Constructor[yamlscene.StdPrefix+"filters/mitchell"] = yamlscene.MapConstruct(constructMitchell)
+	yamlscene.Constructor[yamlscene.StdPrefix+"filters/lanczos"] = yamlscene.MapConstruct(constructLanczos)
+}
+
+// radius reads the radius of a filter from a mapping.
+func radius(m yamldata.Map, def float64) (float64, error) {
+	m = m.Copy()
+	m.SetDefault("radius", def)
+	r, ok := yamldata.AsFloat(m["radius"])
+	if !ok || r <= 0 {
+		return 0, errors.New("Radius must be a positive float")
+	}
+	return r, nil
+}
+
+func constructBox(m yamldata.Map) (interface{}, error) {
+	r, err := radius(m, 0.5)
+	if err != nil {
+		return nil, err
+	}
+	return NewBox(r), nil
+}
+
+func constructTent(m yamldata.Map) (interface{}, error) {
+	r, err := radius(m, 1.0)
+	if err != nil {
+		return nil, err
+	}
+	return NewTent(r), nil
+}
+
+func constructGaussian(m yamldata.Map) (interface{}, error) {
+	r, err := radius(m, 1.5)
+	if err != nil {
+		return nil, err
+	}
+	m = m.Copy()
+	m.SetDefault("alpha", 2.0)
+	alpha, ok := yamldata.AsFloat(m["alpha"])
+	if !ok || alpha <= 0 {
+		return nil, errors.New("Alpha must be a positive float")
+	}
+	return NewGaussian(r, alpha), nil
+}
+
+func constructMitchell(m yamldata.Map) (interface{}, error) {
+	r, err := radius(m, 2.0)
+	if err != nil {
+		return nil, err
+	}
+	m = m.Copy()
+	m.SetDefault("b", 1.0/3.0)
+	m.SetDefault("c", 1.0/3.0)
+	b, ok := yamldata.AsFloat(m["b"])
+	if !ok {
+		return nil, errors.New("B must be a float")
+	}
+	c, ok := yamldata.AsFloat(m["c"])
+	if !ok {
+		return nil, errors.New("C must be a float")
+	}
+	return NewMitchell(r, b, c), nil
+}
+
+func constructLanczos(m yamldata.Map) (interface{}, error) {
+	r, err := radius(m, 3.0)
+	if err != nil {
+		return nil, err
+	}
+	return NewLanczos(r), nil
+}
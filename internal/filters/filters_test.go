@@ -0,0 +1,85 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filters
+
+import (
+	"math"
+	"testing"
+
+	"zombiezen.com/go/goray/internal/goray"
+)
+
+var testFilters = []struct {
+	Name   string
+	Filter goray.Filter
+}{
+	{"box", NewBox(0.5)},
+	{"tent", NewTent(1.0)},
+	{"gaussian", NewGaussian(1.5, 2.0)},
+	{"mitchell", NewMitchell(2.0, 1.0/3.0, 1.0/3.0)},
+	{"lanczos", NewLanczos(3.0)},
+}
+
+func TestFilterPeak(t *testing.T) {
+	for _, test := range testFilters {
+		peak := test.Filter.Eval(0, 0)
+		if peak <= 0 {
+			t.Errorf("%s: Eval(0, 0) = %v; want > 0", test.Name, peak)
+			continue
+		}
+		r := test.Filter.Radius()
+		for _, d := range []float64{0.25, 0.5, 1.0} {
+			if w := test.Filter.Eval(d*r, 0); w > peak {
+				t.Errorf("%s: Eval(%v, 0) = %v > Eval(0, 0) = %v", test.Name, d*r, w, peak)
+			}
+		}
+	}
+}
+
+func TestFilterEdge(t *testing.T) {
+	for _, test := range testFilters {
+		if test.Name == "box" {
+			continue
+		}
+		r := test.Filter.Radius()
+		if w := test.Filter.Eval(r, 0); math.Abs(w) > 1e-9 {
+			t.Errorf("%s: Eval(%v, 0) = %v; want 0", test.Name, r, w)
+		}
+		if w := test.Filter.Eval(0, -r); math.Abs(w) > 1e-9 {
+			t.Errorf("%s: Eval(0, %v) = %v; want 0", test.Name, -r, w)
+		}
+	}
+}
+
+// A filter reproduces a flat image if the weights of the pixel centers around
+// any sample position add up to the same value.
+func TestMitchellPartitionOfUnity(t *testing.T) {
+	f := NewMitchell(2.0, 1.0/3.0, 1.0/3.0).(mitchell)
+	for _, x := range []float64{0, 0.1, 0.25, 0.5, 0.9} {
+		sum := 0.0
+		for i := -3; i <= 3; i++ {
+			sum += f.mitchell(x + float64(i))
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("weights at offset %v sum to %v; want 1", x, sum)
+		}
+	}
+}
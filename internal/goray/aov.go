@@ -0,0 +1,167 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"errors"
+
+	"zombiezen.com/go/goray/internal/color"
+)
+
+// An AOV is an arbitrary output variable: a value besides the color that is
+// computed for every sample of an image, like the depth or the normal of the
+// surface that the sample sees.  Renders develop each of the scene's AOVs
+// into its own image.
+type AOV int
+
+// Arbitrary output variables
+const (
+	// AOVDepth is the distance from the camera to the surface, in every
+	// channel.
+	AOVDepth AOV = iota
+	// AOVNormal is the geometric normal of the surface in world space.
+	AOVNormal
+	// AOVShadingNormal is the normal that the surface is shaded with, in
+	// world space.
+	AOVShadingNormal
+	// AOVAlbedo is the diffuse reflectivity of the surface.
+	AOVAlbedo
+	// AOVUV is the texture coordinates of the surface, in red and green.
+	AOVUV
+	// AOVObjectID is the ID of the object that the surface belongs to, in
+	// every channel.
+	AOVObjectID
+	// AOVPrimitiveID is the number of the primitive in the scene, in every
+	// channel.
+	AOVPrimitiveID
+	// AOVDirect is the light that reaches the camera after at most one
+	// bounce, including light from emitting surfaces and the background.
+	AOVDirect
+	// AOVIndirect is the light that reaches the camera after more than one
+	// bounce.  Light that a SplatIntegrator traces to the camera is in
+	// neither AOVDirect nor AOVIndirect.
+	AOVIndirect
+
+	numAOVs
+)
+
+var aovNames = [numAOVs]string{
+	AOVDepth:         "depth",
+	AOVNormal:        "normal",
+	AOVShadingNormal: "shadingNormal",
+	AOVAlbedo:        "albedo",
+	AOVUV:            "uv",
+	AOVObjectID:      "objectID",
+	AOVPrimitiveID:   "primitiveID",
+	AOVDirect:        "direct",
+	AOVIndirect:      "indirect",
+}
+
+func (a AOV) String() string {
+	if a >= 0 && a < numAOVs {
+		return aovNames[a]
+	}
+	return "unknown"
+}
+
+// ParseAOV returns the AOV with the given name.
+func ParseAOV(name string) (AOV, error) {
+	for i, n := range aovNames {
+		if n == name {
+			return AOV(i), nil
+		}
+	}
+	return 0, errors.New("Unknown AOV: " + name)
+}
+
+// isLight reports whether the AOV holds light, as opposed to a property of
+// the surface.  Light AOVs are reconstructed with the scene's filter like the
+// image itself, so that they add up to the image.
+func (a AOV) isLight() bool {
+	return a == AOVDirect || a == AOVIndirect
+}
+
+// isID reports whether the AOV holds an identifier.  Averaging identifiers
+// does not make sense, so each pixel keeps the identifier of the sample
+// closest to its center.
+func (a AOV) isID() bool {
+	return a == AOVObjectID || a == AOVPrimitiveID
+}
+
+// AOVValues holds the values of every AOV for a single sample.  The alpha of
+// a value is 1 if the value was recorded and 0 otherwise.
+type AOVValues [numAOVs]color.RGBA
+
+// Set records the value of an AOV.
+func (v *AOVValues) Set(a AOV, col color.Color) {
+	v[a] = color.NewRGBAFromColor(col, 1)
+}
+
+// Add adds a color to the value of an AOV.
+func (v *AOVValues) Add(a AOV, col color.Color) {
+	v[a] = color.RGBA{R: v[a].R + col.Red(), G: v[a].G + col.Green(), B: v[a].B + col.Blue(), A: 1}
+}
+
+// RecordSurface records the AOVs that describe the surface that a camera ray
+// hit.  Integrators call it with the first surface of every sample; it does
+// nothing for other rays or when the scene has no AOVs.  The material's BSDF
+// must already be initialized.
+func RecordSurface(s *Scene, state *RenderState, coll Collision, sp SurfacePoint) {
+	v := state.AOVs
+	if v == nil || state.RayLevel != 0 || v[AOVDepth].A != 0 {
+		return
+	}
+	v.Set(AOVDepth, color.Gray(coll.RayDepth))
+	v.Set(AOVNormal, color.RGB{sp.GeometricNormal[0], sp.GeometricNormal[1], sp.GeometricNormal[2]})
+	v.Set(AOVShadingNormal, color.RGB{sp.Normal[0], sp.Normal[1], sp.Normal[2]})
+	if s.HasAOV(AOVAlbedo) {
+		if mat, ok := sp.Material.(Material); ok {
+			v.Set(AOVAlbedo, mat.Reflectivity(state, sp, BSDFDiffuse|BSDFReflect))
+		}
+	}
+	if sp.HasUV {
+		v.Set(AOVUV, color.RGB{sp.U, sp.V, 0})
+	}
+	if s.HasAOV(AOVObjectID) || s.HasAOV(AOVPrimitiveID) {
+		info := s.primitiveInfo(sp.Primitive)
+		v.Set(AOVObjectID, color.Gray(float64(info.object)))
+		v.Set(AOVPrimitiveID, color.Gray(float64(info.index)))
+	}
+}
+
+// AddLight adds light that reached the camera to the direct or indirect AOV
+// of the sample.  The number of bounces counts the surfaces that the light
+// was scattered from, so light from a light source that is reflected by the
+// first surface has one bounce.
+//
+// Only light added while tracing the camera ray (at ray level 0) is recorded,
+// so recursive integrators add the light that deeper rays bring back to the
+// first surface.  AddLight does nothing when the scene has no AOVs.
+func (st *RenderState) AddLight(bounces int, col color.Color) {
+	if st.AOVs == nil || st.RayLevel != 0 {
+		return
+	}
+	if bounces <= 1 {
+		st.AOVs.Add(AOVDirect, col)
+	} else {
+		st.AOVs.Add(AOVIndirect, col)
+	}
+}
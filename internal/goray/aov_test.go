@@ -0,0 +1,50 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"testing"
+)
+
+func TestParseAOV(t *testing.T) {
+	for a := AOV(0); a < numAOVs; a++ {
+		parsed, err := ParseAOV(a.String())
+		if err != nil || parsed != a {
+			t.Errorf("ParseAOV(%q) = %v, %v; want %v, nil", a.String(), parsed, err, a)
+		}
+	}
+	if _, err := ParseAOV("beauty"); err == nil {
+		t.Error("ParseAOV(\"beauty\") succeeded; want error")
+	}
+}
+
+func TestSceneAddAOV(t *testing.T) {
+	s := NewScene(nil, nil)
+	s.AddAOV(AOVNormal)
+	s.AddAOV(AOVDepth)
+	s.AddAOV(AOVNormal)
+	if aovs := s.AOVs(); len(aovs) != 2 || aovs[0] != AOVNormal || aovs[1] != AOVDepth {
+		t.Errorf("s.AOVs() = %v; want [normal depth]", aovs)
+	}
+	if !s.DoDepth() {
+		t.Error("s.DoDepth() = false; want true")
+	}
+}
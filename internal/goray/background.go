@@ -33,3 +33,27 @@ type Background interface {
 	// This may be nil if the background should only be sampled from BSDFs.
 	Light() Light
 }
+
+// LightsBackground is a Background that lights the scene with more than one
+// light, like a sky with a sun.
+type LightsBackground interface {
+	Background
+
+	// Lights returns all of the light sources of the background, including
+	// the one returned by Light.
+	Lights() []Light
+}
+
+// BackgroundLights returns the light sources of a background.
+func BackgroundLights(bg Background) []Light {
+	if bg == nil {
+		return nil
+	}
+	if lb, ok := bg.(LightsBackground); ok {
+		return lb.Lights()
+	}
+	if l := bg.Light(); l != nil {
+		return []Light{l}
+	}
+	return nil
+}
@@ -20,6 +20,10 @@
 
 package goray
 
+import (
+	"image"
+)
+
 // Camera is a viewpoint of a scene.
 type Camera interface {
 	// ShootRay calculates the initial ray used for computing a fragment of the
@@ -41,3 +45,74 @@ type Camera interface {
 	// returns false, no lens samples need to be computed.
 	SampleLens() bool
 }
+
+// A ShutterCamera is a camera whose shutter stays open over an interval of
+// time.  Each ray shot by the camera is given a time inside of the interval,
+// so objects (and the camera itself) can move while the image is exposed.
+type ShutterCamera interface {
+	Camera
+
+	// Shutter returns the times that the shutter opens and closes.
+	Shutter() (open, close float64)
+
+	// ShootRayAt is the same as ShootRay, but shoots the ray at the given
+	// time.  Project uses the time of the ray it is given.
+	ShootRayAt(x, y, u, v, time float64) (Ray, float64)
+}
+
+// An ExposedCamera is a camera that scales the light that reaches its film,
+// like the exposure settings of a physical camera.
+type ExposedCamera interface {
+	Camera
+
+	// Exposure returns the factor that light reaching the film is
+	// multiplied by.
+	Exposure() float64
+}
+
+// cameraExposure returns the exposure of a camera, which is 1 for cameras that
+// are not ExposedCameras.
+func cameraExposure(cam Camera) float64 {
+	if ec, ok := cam.(ExposedCamera); ok {
+		return ec.Exposure()
+	}
+	return 1
+}
+
+// An Eye is one of the views of a stereo camera.
+type Eye int
+
+// Eyes
+const (
+	LeftEye Eye = iota
+	RightEye
+)
+
+func (e Eye) String() string {
+	switch e {
+	case LeftEye:
+		return "left"
+	case RightEye:
+		return "right"
+	}
+	return "unknown"
+}
+
+// A StereoCamera renders the view of each eye into a different part of one
+// image.
+type StereoCamera interface {
+	Camera
+
+	// EyeBounds returns the part of the image that holds an eye's view.
+	EyeBounds(e Eye) image.Rectangle
+}
+
+// shootRay shoots a camera ray at a given time.
+func shootRay(cam Camera, x, y, u, v, time float64) (Ray, float64) {
+	if sc, ok := cam.(ShutterCamera); ok {
+		return sc.ShootRayAt(x, y, u, v, time)
+	}
+	r, wt := cam.ShootRay(x, y, u, v)
+	r.Time = time
+	return r, wt
+}
@@ -0,0 +1,255 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"math"
+
+	"zombiezen.com/go/goray/internal/color"
+)
+
+// Filter is a pixel reconstruction filter.  It determines how much a sample
+// contributes to the pixels around it.
+type Filter interface {
+	// Radius returns the distance, in pixels, that the filter extends from its
+	// center along each axis.
+	Radius() float64
+
+	// Eval returns the weight of a sample that is (dx, dy) pixels away from
+	// the center of a pixel.  The offsets are never larger than the radius.
+	Eval(dx, dy float64) float64
+}
+
+const filterTableSize = 16
+
+// Film accumulates the samples of an image, weighting each sample by the
+// scene's filter for every pixel that it lands near.  Since a film collects
+// the samples of the whole image, samples at the edge of a block still
+// contribute to the pixels of the neighboring blocks.
+//
+// Arbitrary output variables are developed into the AOV images of the image.
+// The direct and indirect light AOVs are weighted like the image, so they add
+// up to it.  The other AOVs describe the surface that a sample sees, so they
+// are averaged over the samples inside of each pixel instead, except for IDs,
+// which are taken from the sample closest to the pixel's center.
+//
+// A film is not safe to use from multiple goroutines.
+type Film struct {
+	Width, Height int
+
+	filter  Filter
+	radius  float64
+	table   [filterTableSize * filterTableSize]float64
+	pix     []filmPixel
+	aovPix  []filmAOVPixel
+	samples int
+}
+
+type filmPixel struct {
+	sum    color.RGBA
+	weight float64
+}
+
+type filmAOVPixel struct {
+	sum    AOVValues
+	count  int
+	idDist float64
+}
+
+// NewFilm creates a new, empty film with the given width and height.  If the
+// filter is nil, each sample only contributes to the pixel that contains it.
+func NewFilm(w, h int, f Filter) *Film {
+	film := &Film{
+		Width:  w,
+		Height: h,
+		filter: f,
+		pix:    make([]filmPixel, w*h),
+	}
+	if f != nil {
+		film.radius = f.Radius()
+		for j := range film.table {
+			dx := (float64(j%filterTableSize) + 0.5) / filterTableSize * film.radius
+			dy := (float64(j/filterTableSize) + 0.5) / filterTableSize * film.radius
+			film.table[j] = f.Eval(dx, dy)
+		}
+	}
+	return film
+}
+
+// weight looks up the filter's weight for an offset from a pixel center.
+func (film *Film) weight(dx, dy float64) float64 {
+	ix := int(math.Abs(dx) / film.radius * filterTableSize)
+	iy := int(math.Abs(dy) / film.radius * filterTableSize)
+	if ix >= filterTableSize {
+		ix = filterTableSize - 1
+	}
+	if iy >= filterTableSize {
+		iy = filterTableSize - 1
+	}
+	return film.table[iy*filterTableSize+ix]
+}
+
+// Add adds a sample to the film.
+func (film *Film) Add(frag Fragment) {
+	film.samples++
+	inside := frag.X >= 0 && frag.Y >= 0 && frag.X < film.Width && frag.Y < film.Height
+	if frag.AOVs != nil {
+		if film.aovPix == nil {
+			film.aovPix = make([]filmAOVPixel, len(film.pix))
+		}
+		if inside {
+			film.addAOVs(frag)
+		}
+	}
+	if film.filter == nil || film.radius <= 0 {
+		if inside {
+			film.addWeighted(frag.Y*film.Width+frag.X, frag, 1)
+		}
+		return
+	}
+
+	// Visit every pixel center that is closer than the filter's radius.
+	sx, sy := float64(frag.X)+frag.DX-0.5, float64(frag.Y)+frag.DY-0.5
+	x0, x1 := int(math.Floor(sx-film.radius))+1, int(math.Ceil(sx+film.radius))-1
+	y0, y1 := int(math.Floor(sy-film.radius))+1, int(math.Ceil(sy+film.radius))-1
+	if x0 < 0 {
+		x0 = 0
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	if x1 >= film.Width {
+		x1 = film.Width - 1
+	}
+	if y1 >= film.Height {
+		y1 = film.Height - 1
+	}
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			if w := film.weight(float64(x)-sx, float64(y)-sy); w != 0 {
+				film.addWeighted(y*film.Width+x, frag, w)
+			}
+		}
+	}
+}
+
+func (film *Film) addWeighted(j int, frag Fragment, w float64) {
+	p := &film.pix[j]
+	col := frag.Color
+	p.sum.R += col.Red() * w
+	p.sum.G += col.Green() * w
+	p.sum.B += col.Blue() * w
+	p.sum.A += col.Alpha() * w
+	p.weight += w
+
+	if frag.AOVs == nil {
+		return
+	}
+	ap := &film.aovPix[j]
+	for _, a := range [...]AOV{AOVDirect, AOVIndirect} {
+		v, sum := frag.AOVs[a], &ap.sum[a]
+		sum.R += v.R * w
+		sum.G += v.G * w
+		sum.B += v.B * w
+		sum.A += v.A * w
+	}
+}
+
+// addAOVs adds the AOVs of a sample that do not hold light to the pixel that
+// contains the sample.
+func (film *Film) addAOVs(frag Fragment) {
+	ap := &film.aovPix[frag.Y*film.Width+frag.X]
+	ap.count++
+	dist := (frag.DX-0.5)*(frag.DX-0.5) + (frag.DY-0.5)*(frag.DY-0.5)
+	keepID := ap.count == 1 || dist < ap.idDist
+	if keepID {
+		ap.idDist = dist
+	}
+	for a, v := range frag.AOVs {
+		switch a := AOV(a); {
+		case a.isLight():
+		case a.isID():
+			if keepID {
+				ap.sum[a] = v
+			}
+		default:
+			sum := &ap.sum[a]
+			sum.R += v.R
+			sum.G += v.G
+			sum.B += v.B
+			sum.A += v.A
+		}
+	}
+}
+
+// Acquire adds samples from a channel until the channel is closed.  The number
+// of samples received is returned.
+func (film *Film) Acquire(ch <-chan Fragment) (n int) {
+	for frag := range ch {
+		film.Add(frag)
+		n++
+	}
+	return
+}
+
+// Samples returns the number of samples that have been added to the film.
+func (film *Film) Samples() int {
+	return film.samples
+}
+
+// Develop writes the weighted average of the samples for each pixel into an
+// image with the same dimensions as the film, along with the AOV images that
+// the image has.  Pixels without any samples are left untouched.
+func (film *Film) Develop(img *Image) {
+	for j := range film.pix {
+		p := &film.pix[j]
+		if p.weight != 0 {
+			img.Pix[j] = scaleRGBA(p.sum, 1/p.weight)
+		}
+	}
+	if film.aovPix == nil {
+		return
+	}
+	for a, layer := range img.AOVs {
+		film.developAOV(a, layer)
+	}
+}
+
+func (film *Film) developAOV(a AOV, img *Image) {
+	for j := range film.aovPix {
+		ap := &film.aovPix[j]
+		switch {
+		case a.isLight():
+			if w := film.pix[j].weight; w != 0 {
+				img.Pix[j] = scaleRGBA(ap.sum[a], 1/w)
+			}
+		case ap.count == 0:
+		case a.isID():
+			img.Pix[j] = ap.sum[a]
+		default:
+			img.Pix[j] = scaleRGBA(ap.sum[a], 1/float64(ap.count))
+		}
+	}
+}
+
+func scaleRGBA(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{R: c.R * f, G: c.G * f, B: c.B * f, A: c.A * f}
+}
@@ -0,0 +1,115 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"math"
+	"testing"
+
+	"zombiezen.com/go/goray/internal/color"
+)
+
+func checkPixels(t *testing.T, img *Image, expected []color.RGBA) {
+	for j, want := range expected {
+		if p := img.Pix[j]; math.Abs(p.R-want.R) > 1e-9 || math.Abs(p.G-want.G) > 1e-9 || math.Abs(p.B-want.B) > 1e-9 || math.Abs(p.A-want.A) > 1e-9 {
+			t.Errorf("img.Pix[%d] = %v; want %v", j, p, want)
+		}
+	}
+}
+
+func TestFilmNoFilter(t *testing.T) {
+	film := NewFilm(2, 1, nil)
+	film.Add(Fragment{X: 0, Y: 0, DX: 0.9, DY: 0.5, Color: color.RGBA{1, 1, 1, 1}})
+	film.Add(Fragment{X: 1, Y: 0, DX: 0.1, DY: 0.5, Color: color.RGBA{0, 0, 0, 1}})
+	film.Add(Fragment{X: 1, Y: 0, DX: 0.5, DY: 0.5, Color: color.RGBA{1, 0.5, 0, 0}})
+	if n := film.Samples(); n != 3 {
+		t.Errorf("film.Samples() = %d; want 3", n)
+	}
+
+	img := NewImage(2, 1)
+	film.Develop(img)
+	checkPixels(t, img, []color.RGBA{{1, 1, 1, 1}, {0.5, 0.25, 0, 0.5}})
+}
+
+// tentFilter is a separable triangle filter with a radius of one pixel.
+type tentFilter struct{}
+
+func (tentFilter) Radius() float64 { return 1 }
+
+func (tentFilter) Eval(dx, dy float64) float64 {
+	return math.Max(0, 1-math.Abs(dx)) * math.Max(0, 1-math.Abs(dy))
+}
+
+func TestFilmFilter(t *testing.T) {
+	film := NewFilm(3, 1, tentFilter{})
+	// A sample near the edge of the middle pixel also contributes to the
+	// pixel on its left, but the sample at the center of the right pixel is
+	// a whole radius away from the middle pixel.
+	film.Add(Fragment{X: 1, Y: 0, DX: 1.0 / 32, DY: 0.5, Color: color.RGBA{1, 0, 0, 1}})
+	film.Add(Fragment{X: 2, Y: 0, DX: 0.5, DY: 0.5, Color: color.RGBA{0, 0, 1, 1}})
+
+	img := NewImage(3, 1)
+	img.Clear(color.RGBA{0.5, 0.5, 0.5, 0.5})
+	film.Develop(img)
+
+	// The table lookup rounds offsets to the middle of a cell.
+	wl, wm := film.weight(1.0/32+0.5, 0), film.weight(0.5-1.0/32, 0)
+	if math.Abs(wl-0.5) > 0.05 || math.Abs(wm-0.5) > 0.05 {
+		t.Errorf("weights = %v, %v; want about 0.5", wl, wm)
+	}
+	checkPixels(t, img, []color.RGBA{
+		{1, 0, 0, 1},
+		{1, 0, 0, 1},
+		{0, 0, 1, 1},
+	})
+}
+
+func TestFilmAOVs(t *testing.T) {
+	film := NewFilm(2, 1, tentFilter{})
+	add := func(x int, dx float64, col color.RGBA, depth, id float64) {
+		v := new(AOVValues)
+		v.Set(AOVDepth, color.Gray(depth))
+		v.Set(AOVObjectID, color.Gray(id))
+		v.Set(AOVDirect, col)
+		film.Add(Fragment{X: x, Y: 0, DX: dx, DY: 0.5, Color: col, AOVs: v})
+	}
+	add(0, 0.25, color.RGBA{1, 0, 0, 1}, 2, 7)
+	add(0, 0.75, color.RGBA{0, 1, 0, 1}, 4, 8)
+	add(1, 0.5, color.RGBA{0, 0, 1, 1}, 6, 9)
+
+	img := NewImage(2, 1)
+	img.AOVs = map[AOV]*Image{
+		AOVDepth:    NewImage(2, 1),
+		AOVObjectID: NewImage(2, 1),
+		AOVDirect:   NewImage(2, 1),
+		AOVIndirect: NewImage(2, 1),
+	}
+	film.Develop(img)
+
+	// Light AOVs are filtered like the image.
+	checkPixels(t, img.AOVs[AOVDirect], img.Pix)
+	// Surface AOVs are averaged over the samples inside of the pixel.
+	checkPixels(t, img.AOVs[AOVDepth], []color.RGBA{{3, 3, 3, 1}, {6, 6, 6, 1}})
+	// The first of the samples that are equally close to the center wins.
+	checkPixels(t, img.AOVs[AOVObjectID], []color.RGBA{{7, 7, 7, 1}, {9, 9, 9, 1}})
+	// AOVs that were never recorded have no coverage.
+	checkPixels(t, img.AOVs[AOVIndirect], []color.RGBA{{0, 0, 0, 0}, {0, 0, 0, 0}})
+}
@@ -21,6 +21,11 @@
 package goray
 
 import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"image"
+	"math"
 	"runtime"
 	"sync"
 
@@ -35,112 +40,497 @@ type Integrator interface {
 	Integrate(scene *Scene, state *RenderState, r DifferentialRay) color.AlphaColor
 }
 
-// A SurfaceIntegrator renders rays by casting onto solid objects.
+// A SurfaceIntegrator renders rays by casting onto solid objects.  It also
+// passes the rays that it traces through the scene's volume integrator, using
+// IntegrateVolume or TraceVolume, since it knows where the rays end.
 type SurfaceIntegrator interface {
 	Integrator
 	SurfaceIntegrator()
 }
 
 // A VolumeIntegrator renders rays by casting onto volumetric regions.
+//
+// Integrate returns the light scattered or emitted toward the ray's origin by
+// the volumes between the origin and TMax, with an alpha of the volumes'
+// opacity.  Transmittance returns the fraction of light that passes through
+// the same span, with an alpha of the fraction of the surface's alpha that
+// remains visible.
 type VolumeIntegrator interface {
 	Integrator
 	VolumeIntegrator()
 	Transmittance(scene *Scene, state *RenderState, r Ray) color.AlphaColor
 }
 
+// A SplatIntegrator is an integrator that also contributes light to
+// fragments other than the one it is integrating.  Bidirectional methods use
+// this to add light that is traced from the lights to the camera.
+type SplatIntegrator interface {
+	Integrator
+	// Splats returns the light that was added outside of Integrate, assuming
+	// that Integrate is called once for every pixel.  Render scales the buffer
+	// by the number of samples taken and adds it to the image after every
+	// pass has been integrated.
+	Splats() *SplatBuffer
+}
+
+// A Pass is one round of antialiasing samples over an image.
+type Pass struct {
+	// Number is the index of the pass, starting from zero.  Passes is the
+	// most passes that the render takes, or zero if it is not known ahead of
+	// time.
+	Number, Passes int
+
+	// FirstSample is the index of the first sample taken in each pixel during
+	// the pass.  Samples is the number of samples taken in each pixel.
+	FirstSample, Samples int
+
+	// Jitter is whether sample positions are randomized within the pixel.
+	// Otherwise, the pixel is sampled at its center.
+	Jitter bool
+
+	// Region is the rectangle of pixels that the pass covers.  An empty
+	// region covers the whole image.
+	Region image.Rectangle
+
+	// Mask reports which pixels (in row-major order) are rendered in the pass.
+	// A nil mask renders every pixel in the region.
+	Mask []bool
+}
+
+// area returns the part of a w×h image that the pass covers.
+func (p Pass) area(w, h int) image.Rectangle {
+	full := image.Rect(0, 0, w, h)
+	if p.Region.Empty() {
+		return full
+	}
+	return p.Region.Intersect(full)
+}
+
+// includes reports whether the pass renders the pixel (x, y) of an image that
+// is w pixels wide.
+func (p Pass) includes(x, y, w int) bool {
+	if !p.Region.Empty() && !image.Pt(x, y).In(p.Region) {
+		return false
+	}
+	return p.Mask == nil || p.Mask[y*w+x]
+}
+
 // Render is an easy way of creating an image from a scene.
 //
 // Render will update the scene, create a new image, and then use one of the
-// integration functions to write to the image.
+// integration functions to write to the image.  If the scene has a volume
+// integrator, it is combined with the given integrator.
+//
+// The image is rendered in the number of passes set by the scene's
+// antialiasing parameters.  The first pass samples every pixel and each of
+// the later passes adds samples to the pixels that differ from one of their
+// neighbors by more than the threshold.
+//
+// If the scene has a render region, only the pixels inside of it are filled
+// in.  The first pass takes the same samples that a full render would take
+// for those pixels, so the region can be pasted into a full render.  Later
+// passes only compare pixels inside of the region (and its filter border), so
+// they may resample the edge of the region differently.
 func Render(s *Scene, i Integrator, log log.Logger) (img *Image) {
+	img, _ = RenderContext(context.Background(), s, i, log)
+	return
+}
+
+// RenderContext is like Render, but stops rendering once the context is
+// done.  If the render is stopped, the image is incomplete and the context's
+// error is returned.
+func RenderContext(ctx context.Context, s *Scene, i Integrator, log log.Logger) (img *Image, err error) {
+	Prepare(s, i)
+	return RenderWith(ctx, s, i, BlockIntegrateContext, log)
+}
+
+// A PassFunc integrates the pixels of a pass and sends their fragments on the
+// returned channel, like BlockIntegrateContext.  The channel is closed once the
+// pass is done or the context is done.
+type PassFunc func(ctx context.Context, s *Scene, i Integrator, p Pass, log log.Logger) <-chan Fragment
+
+// RenderWith renders an image in passes like RenderContext, but integrates
+// each pass with the given function.  The scene is not updated and the
+// integrators are not preprocessed, so integrate must take care of that if it
+// needs to.
+func RenderWith(ctx context.Context, s *Scene, i Integrator, integrate PassFunc, log log.Logger) (img *Image, err error) {
+	w, h := s.Camera().ResolutionX(), s.Camera().ResolutionY()
+	img = newSceneImage(s, w, h)
+	film := NewFilm(w, h, s.Filter())
+	p := Pass{
+		Passes:  s.aaPasses,
+		Samples: s.aaSamples,
+		Jitter:  s.aaSamples > 1 || s.aaPasses > 1,
+		Region:  renderArea(s),
+	}
+	defer func() {
+		clearOutside(img, s.Region())
+	}()
+	for p.Number = 0; p.Number < s.aaPasses; p.Number++ {
+		if p.Number > 0 {
+			var n int
+			p.FirstSample += p.Samples
+			p.Samples = s.aaIncSamples
+			p.Mask, n = edgeMask(img, p.area(w, h), s.aaThreshold)
+			if n == 0 {
+				break
+			}
+			log.Debugf("Pass %d: resampling %d pixel(s)", p.Number+1, n)
+		}
+		film.Acquire(integrate(ctx, s, i, p, log))
+		film.Develop(img)
+		if err = ctx.Err(); err != nil {
+			return
+		}
+	}
+
+	addSplats(img, s, i, film)
+	return
+}
+
+// renderArea returns the pixels that need to be sampled to render the scene's
+// region.  The region is grown by the radius of the scene's filter so that the
+// pixels at the edge of the region receive every sample that they would in a
+// full render.
+func renderArea(s *Scene) image.Rectangle {
+	r := s.Region()
+	if r.Empty() {
+		return r
+	}
+	if f := s.Filter(); f != nil {
+		r = r.Inset(-int(math.Ceil(f.Radius())))
+	}
+	return r.Intersect(image.Rect(0, 0, s.Camera().ResolutionX(), s.Camera().ResolutionY()))
+}
+
+// newSceneImage creates a blank image with an AOV image for each of the
+// scene's AOVs.
+func newSceneImage(s *Scene, w, h int) *Image {
+	img := NewImage(w, h)
+	if len(s.aovs) > 0 {
+		img.AOVs = make(map[AOV]*Image, len(s.aovs))
+		for _, a := range s.aovs {
+			img.AOVs[a] = NewImage(w, h)
+		}
+	}
+	return img
+}
+
+// clearOutside clears the pixels of an image (and its AOV images) that are
+// outside of a region.  If the region is empty, the image is left untouched.
+func clearOutside(img *Image, r image.Rectangle) {
+	if r.Empty() {
+		return
+	}
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if !image.Pt(x, y).In(r) {
+				img.Pix[y*img.Width+x] = color.RGBA{}
+			}
+		}
+	}
+	for _, layer := range img.AOVs {
+		clearOutside(layer, r)
+	}
+}
+
+// Prepare updates the scene and preprocesses its integrators so that the
+// scene is ready to be integrated.
+func Prepare(s *Scene, i Integrator) {
 	s.Update()
-	img = NewImage(s.Camera().ResolutionX(), s.Camera().ResolutionY())
 	i.Preprocess(s)
-	ch := BlockIntegrate(s, i, log)
-	img.Acquire(ch)
+	if vi := s.VolumeIntegrator(); vi != nil {
+		vi.Preprocess(s)
+	}
+}
+
+// addSplats adds the light that a splat integrator traced to the camera,
+// scaled by the number of samples in the film and the camera's exposure.
+func addSplats(img *Image, s *Scene, i Integrator, film *Film) {
+	si, ok := i.(SplatIntegrator)
+	if !ok || film.Samples() == 0 {
+		return
+	}
+	if buf := si.Splats(); buf != nil {
+		img.AddSplats(buf, cameraExposure(s.Camera())*float64(img.Width*img.Height)/float64(film.Samples()))
+	}
+}
+
+// edgeMask finds the pixels inside of an area of an image that differ from one
+// of their neighbors in the area by more than threshold in any channel.  The
+// number of pixels found is also returned.
+func edgeMask(img *Image, area image.Rectangle, threshold float64) (mask []bool, n int) {
+	mask = make([]bool, len(img.Pix))
+	mark := func(j int) {
+		if !mask[j] {
+			mask[j] = true
+			n++
+		}
+	}
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			j := y*img.Width + x
+			if x+1 < area.Max.X && colorDiff(img.Pix[j], img.Pix[j+1]) > threshold {
+				mark(j)
+				mark(j + 1)
+			}
+			if y+1 < area.Max.Y && colorDiff(img.Pix[j], img.Pix[j+img.Width]) > threshold {
+				mark(j)
+				mark(j + img.Width)
+			}
+		}
+	}
 	return
 }
 
-// RenderPixel creates a fragment for a position in the image.
-func RenderPixel(s *Scene, i Integrator, x, y int) Fragment {
+// colorDiff returns the largest difference between the channels of two colors.
+func colorDiff(c1, c2 color.RGBA) float64 {
+	return math.Max(math.Abs(c1.R-c2.R), math.Max(math.Abs(c1.G-c2.G), math.Max(math.Abs(c1.B-c2.B), math.Abs(c1.A-c2.A))))
+}
+
+// RenderPixel takes the samples of a pass in a pixel.  Each sample is
+// returned as a fragment, except for samples that the camera gives no weight
+// (such as the corners of a fisheye image), which are dropped.
+func RenderPixel(s *Scene, i Integrator, p Pass, x, y int) []Fragment {
 	cam := s.Camera()
 	w, h := cam.ResolutionX(), cam.ResolutionY()
+	pixel := y*w + x
+	offset := samplingOffset(s.seed, pixel)
+	smp := s.Sampler().Clone(s.seed)
 
 	// Set up state
 	state := new(RenderState)
 	state.Init()
-	state.PixelNumber = y*w + x
-	state.ScreenPos = vec64.Vector{2.0*float64(x)/float64(w) - 1.0, -2.0*float64(y)/float64(h) + 1.0, 0.0}
-	state.Time = 0.0
+	state.CurrentPass = p.Number
+	state.PixelNumber = pixel
+	state.Sampler = smp
+	open, close := s.Shutter()
+	exposure := cameraExposure(cam)
 
-	// Shoot ray
-	r, _ := cam.ShootRay(float64(x), float64(y), 0, 0)
+	frags := make([]Fragment, 0, p.Samples)
+	for k := 0; k < p.Samples; k++ {
+		state.SetDefaults()
+		state.PixelSample = p.FirstSample + k
+		state.SamplingOffset = offset + uint(state.PixelSample)
+		smp.StartSample(pixel, state.PixelSample)
+		if len(s.aovs) > 0 {
+			state.AOVs = new(AOVValues)
+		}
+
+		dx, dy := 0.5, 0.5
+		if p.Jitter {
+			dx, dy = smp.Get2D()
+		}
+		var lu, lv float64
+		if cam.SampleLens() {
+			lu, lv = smp.Get2D()
+		}
+		state.Time = open
+		if close > open {
+			state.Time += (close - open) * smp.Get1D()
+		}
+		sx, sy := float64(x)+dx, float64(y)+dy
+		state.ScreenPos = vec64.Vector{2.0*sx/float64(w) - 1.0, -2.0*sy/float64(h) + 1.0, 0.0}
+
+		// Shoot ray.  Cameras that don't cover the whole image give the
+		// pixels outside of their view no weight.  Leaving those samples off
+		// of the film also keeps them from diluting splats.
+		r, wt := shootRay(cam, sx, sy, lu, lv, state.Time)
+		if wt == 0 {
+			continue
+		}
 
-	// Set up differentials
-	cRay := DifferentialRay{Ray: r}
-	r, _ = cam.ShootRay(float64(x+1), float64(y), 0, 0)
-	cRay.FromX = r.From
-	cRay.DirX = r.Dir
-	r, _ = cam.ShootRay(float64(x), float64(y+1), 0, 0)
-	cRay.FromY = r.From
-	cRay.DirY = r.Dir
+		// Set up differentials
+		cRay := DifferentialRay{Ray: r}
+		r, _ = shootRay(cam, sx+1, sy, lu, lv, state.Time)
+		cRay.FromX = r.From
+		cRay.DirX = r.Dir
+		r, _ = shootRay(cam, sx, sy+1, lu, lv, state.Time)
+		cRay.FromY = r.From
+		cRay.DirY = r.Dir
+
+		// Integrate
+		col := i.Integrate(s, state, cRay)
+		if exposure != 1 {
+			col = exposeColor(col, exposure)
+			if state.AOVs != nil {
+				for _, a := range [...]AOV{AOVDirect, AOVIndirect} {
+					state.AOVs[a] = exposeColor(state.AOVs[a], exposure)
+				}
+			}
+		}
+		frags = append(frags, Fragment{
+			X:     x,
+			Y:     y,
+			DX:    dx,
+			DY:    dy,
+			Color: col,
+			AOVs:  state.AOVs,
+		})
+	}
+	return frags
+}
 
-	// Integrate
-	color := i.Integrate(s, state, cRay)
-	return Fragment{X: x, Y: y, Color: color}
+// exposeColor scales the light of a color by a camera's exposure.  Alpha is
+// left unchanged.
+func exposeColor(col color.AlphaColor, exposure float64) color.RGBA {
+	return color.NewRGBAFromColor(color.ScalarMul(col, exposure), col.Alpha())
+}
+
+// samplingOffset scrambles a pixel number and the scene's seed so that
+// neighboring pixels (and renders with different seeds) use different parts of
+// the sampling sequences.
+func samplingOffset(seed int64, pixel int) uint {
+	var buf [12]byte
+	binary.LittleEndian.PutUint32(buf[:4], uint32(pixel))
+	binary.LittleEndian.PutUint64(buf[4:], uint64(seed))
+	h := fnv.New32a()
+	h.Write(buf[:])
+	return uint(h.Sum32())
+}
+
+// IntegrateVolume combines the color that a surface integrator computed for a
+// ray with the scene's volume integrator.  Light from the surface is
+// attenuated by the volumes in front of it, and the light that the volumes
+// scatter toward the ray's origin is added.  r.TMax must be the distance to
+// the surface that the ray hit, or negative if the ray left the scene.  If the
+// scene has no volume integrator, col is returned unchanged.
+func IntegrateVolume(s *Scene, state *RenderState, r Ray, col color.AlphaColor) color.AlphaColor {
+	vi := s.VolumeIntegrator()
+	if vi == nil {
+		return col
+	}
+	tr := vi.Transmittance(s, state, r)
+	vol := vi.Integrate(s, state, DifferentialRay{Ray: r})
+	return color.RGBA{
+		R: col.Red()*tr.Red() + vol.Red(),
+		G: col.Green()*tr.Green() + vol.Green(),
+		B: col.Blue()*tr.Blue() + vol.Blue(),
+		A: col.Alpha()*tr.Alpha() + vol.Alpha(),
+	}
+}
+
+// TraceVolume returns the light that the scene's volumes scatter toward the
+// origin of a ray and the fraction of the light from the end of the ray that
+// passes through them.  Integrators that follow paths use it for each
+// segment.  r.TMax must be the distance to the surface that the ray hit, or
+// negative if the ray left the scene.  If the scene has no volume integrator,
+// no light is scattered and all of it passes through.
+func TraceVolume(s *Scene, state *RenderState, r Ray) (col, tr color.Color) {
+	vi := s.VolumeIntegrator()
+	if vi == nil {
+		return color.Black, color.White
+	}
+	tr = vi.Transmittance(s, state, r)
+	col = vi.Integrate(s, state, DifferentialRay{Ray: r})
+	return
 }
 
 const fragBufferSize = 100
 
-// SimpleIntegrate integrates an image one pixel at a time.
-func SimpleIntegrate(s *Scene, in Integrator, log log.Logger) <-chan Fragment {
+func sendFragments(ch chan<- Fragment, frags []Fragment) {
+	for _, f := range frags {
+		ch <- f
+	}
+}
+
+// SimpleIntegrate integrates the pixels of a pass one at a time.
+func SimpleIntegrate(s *Scene, in Integrator, p Pass, log log.Logger) <-chan Fragment {
 	ch := make(chan Fragment, fragBufferSize)
 	go func() {
 		defer close(ch)
 		w, h := s.Camera().ResolutionX(), s.Camera().ResolutionY()
 		for x := 0; x < w; x++ {
 			for y := 0; y < h; y++ {
-				ch <- RenderPixel(s, in, x, y)
+				if p.includes(x, y, w) {
+					sendFragments(ch, RenderPixel(s, in, p, x, y))
+				}
 			}
 		}
 	}()
 	return ch
 }
 
-// BlockIntegrate integrates an image in small batches.
-func BlockIntegrate(s *Scene, in Integrator, log log.Logger) <-chan Fragment {
-	const blockDim = 32
+// BlockIntegrate integrates the pixels of a pass in tiles.
+func BlockIntegrate(s *Scene, in Integrator, p Pass, log log.Logger) <-chan Fragment {
+	return BlockIntegrateContext(context.Background(), s, in, p, log)
+}
+
+// BlockIntegrateContext integrates the pixels of a pass in tiles, using the
+// scene's tile size and order, until the context is done.  Once the context
+// is done, no more tiles are started and the channel is closed after the
+// workers finish the rows they are on.  The scene's tile function is called
+// after each tile that finishes.
+//
+// Fragments are sent in tile order, no matter which tiles finish first, so the
+// image does not depend on the number of goroutines that render it.
+func BlockIntegrateContext(ctx context.Context, s *Scene, in Integrator, p Pass, log log.Logger) <-chan Fragment {
 	numWorkers := runtime.GOMAXPROCS(0)
 	cam := s.Camera()
-	w, h := cam.ResolutionX(), cam.ResolutionY()
+	w := cam.ResolutionX()
+	size, order := s.Tiles()
+	tiles := Tiles(p.area(w, cam.ResolutionY()), size, order)
 	ch := make(chan Fragment, fragBufferSize)
 
-	// Separate goroutine manages block locations
-	locCh := make(chan [2]int)
+	// Separate goroutine manages tiles
+	tileCh := make(chan int)
 	go func() {
-		defer close(locCh)
-		for y := 0; y < h; y += blockDim {
-			for x := 0; x < w; x += blockDim {
-				locCh <- [2]int{x, y}
+		defer close(tileCh)
+		for i := range tiles {
+			select {
+			case tileCh <- i:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
+	var (
+		doneMu sync.Mutex
+		done   int
+	)
+	finishTile := func(t image.Rectangle) {
+		doneMu.Lock()
+		defer doneMu.Unlock()
+		done++
+		log.Debugf("Tile %v (%d/%d)", t, done, len(tiles))
+		if s.tileFunc != nil {
+			s.tileFunc(TileProgress{
+				Tile:   t,
+				Pass:   p.Number,
+				Passes: p.Passes,
+				Done:   done,
+				Total:  len(tiles),
+			})
+		}
+	}
+
 	go func() {
 		defer close(ch)
 		wg := new(sync.WaitGroup)
+		seq := NewTileSequencer(ch)
 		for i := 0; i < numWorkers; i++ {
 			wg.Add(1)
 			go func() {
-				for loc := range locCh {
-					log.Debugf("Block (%3d, %3d)", loc[0], loc[1])
-					for y := loc[1]; y < loc[1]+blockDim && y < h; y++ {
-						for x := loc[0]; x < loc[0]+blockDim && x < w; x++ {
-							ch <- RenderPixel(s, in, x, y)
+				defer wg.Done()
+				for i := range tileCh {
+					t := tiles[i]
+					var frags []Fragment
+					for y := t.Min.Y; y < t.Max.Y; y++ {
+						if ctx.Err() != nil {
+							break
+						}
+						for x := t.Min.X; x < t.Max.X; x++ {
+							if p.includes(x, y, w) {
+								frags = append(frags, RenderPixel(s, in, p, x, y)...)
+							}
 						}
 					}
+					if ctx.Err() == nil {
+						finishTile(t)
+						seq.Finish(i, frags)
+					}
 				}
-				wg.Done()
 			}()
 		}
 		wg.Wait()
@@ -148,13 +538,13 @@ func BlockIntegrate(s *Scene, in Integrator, log log.Logger) <-chan Fragment {
 	return ch
 }
 
-// WorkerIntegrate integrates an image using a set number of jobs.
-func WorkerIntegrate(s *Scene, in Integrator, log log.Logger) <-chan Fragment {
+// WorkerIntegrate integrates the pixels of a pass using a set number of jobs.
+func WorkerIntegrate(s *Scene, in Integrator, p Pass, log log.Logger) <-chan Fragment {
 	numWorkers := runtime.GOMAXPROCS(0)
 	cam := s.Camera()
 	w, h := cam.ResolutionX(), cam.ResolutionY()
 	if h < numWorkers {
-		return SimpleIntegrate(s, in, log)
+		return SimpleIntegrate(s, in, p, log)
 	}
 
 	ch := make(chan Fragment, fragBufferSize)
@@ -170,7 +560,9 @@ func WorkerIntegrate(s *Scene, in Integrator, log log.Logger) <-chan Fragment {
 			go func(baseY int) {
 				for y := baseY; y < baseY+rowsPerWorker && y < h; y++ {
 					for x := 0; x < w; x++ {
-						ch <- RenderPixel(s, in, x, y)
+						if p.includes(x, y, w) {
+							sendFragments(ch, RenderPixel(s, in, p, x, y))
+						}
 					}
 				}
 				wg.Done()
@@ -0,0 +1,67 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"image"
+	"testing"
+
+	"zombiezen.com/go/goray/internal/color"
+)
+
+func TestEdgeMask(t *testing.T) {
+	img := NewImage(4, 3)
+	img.Clear(color.RGBA{0.5, 0.5, 0.5, 1})
+	img.Pix[1*4+1] = color.RGBA{0.5, 0.6, 0.5, 1}
+	img.Pix[2*4+3] = color.RGBA{0.5, 0.52, 0.5, 1}
+
+	mask, n := edgeMask(img, img.Bounds(), 0.05)
+	expected := []bool{
+		false, true, false, false,
+		true, true, true, false,
+		false, true, false, false,
+	}
+	if n != 5 {
+		t.Errorf("edgeMask found %d pixels; want 5", n)
+	}
+	for j := range expected {
+		if mask[j] != expected[j] {
+			t.Errorf("mask[%d, %d] = %t; want %t", j%4, j/4, mask[j], expected[j])
+		}
+	}
+}
+
+func TestEdgeMaskArea(t *testing.T) {
+	img := NewImage(4, 3)
+	img.Clear(color.RGBA{0.5, 0.5, 0.5, 1})
+	img.Pix[1*4+1] = color.RGBA{0.5, 0.6, 0.5, 1}
+
+	// Only the pixels in the right two columns are compared.
+	mask, n := edgeMask(img, image.Rect(2, 0, 4, 3), 0.05)
+	if n != 0 {
+		t.Errorf("edgeMask found %d pixels; want 0", n)
+	}
+	for j, m := range mask {
+		if m {
+			t.Errorf("mask[%d, %d] = true; want false", j%4, j/4)
+		}
+	}
+}
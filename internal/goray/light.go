@@ -29,6 +29,7 @@ import (
 const (
 	LightTypeDiracDir = 1 << iota // A light with TypeDiracDir has a Dirac delta distribution
 	LightTypeSingular
+	LightTypeInfinite // A light with TypeInfinite is infinitely far away from the scene
 
 	LightTypeNone = 0
 )
@@ -43,6 +44,7 @@ type LightSample struct {
 	Color   color.Color  // Color of the generated sample
 	Flags   uint         // Flags of the sampled light source
 	Point   SurfacePoint // Surface point on the light source.  This may only be complete enough to call other light methods with it!
+	Time    float64      // Time that the light is sampled at, for lights that move (EmitSample)
 }
 
 // Light is an entity that illuminates a scene.
@@ -74,7 +76,8 @@ type Light interface {
 	// IlluminateSample samples the illumination at a given point.
 	//
 	// The Sample passed in will be filled with the proper sample values.
-	// The returned ray will be the ray that casted the light.
+	// The returned ray will be the ray that casted the light.  Lights that
+	// move are sampled at the time of the ray passed in.
 	IlluminateSample(sp SurfacePoint, wi *Ray, s *LightSample) (illuminated bool)
 
 	// IlluminatePdf returns the PDF for sampling with IllumSample.
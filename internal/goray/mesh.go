@@ -21,7 +21,11 @@
 package goray
 
 import (
+	"math"
+
+	"bitbucket.org/zombiezen/math3/mat64"
 	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/sampleutil"
 )
 
 // UV holds a set of texture coordinates.
@@ -37,9 +41,19 @@ type Mesh struct {
 	hasOrco   bool
 	light     Light
 	hidden    bool
+
+	// areaDist picks triangles in proportion to their area and area is
+	// the mesh's total area.  They are set by EnableSampling.
+	areaDist sampleutil.Pdf1D
+	area     float64
+
+	// endVertices and endNormals are where the vertices and normals are at
+	// time one.  They are nil if the mesh doesn't move.
+	endVertices []vec64.Vector
+	endNormals  []vec64.Vector
 }
 
-var _ Object3D = &Mesh{}
+var _ SamplableObject3D = &Mesh{}
 
 // NewMesh creates an empty mesh.
 func NewMesh(ntris int, hasOrco bool) (mesh *Mesh) {
@@ -64,10 +78,59 @@ func (mesh *Mesh) Visible() bool     { return !mesh.hidden }
 func (mesh *Mesh) SetVisible(v bool) { mesh.hidden = !v }
 
 //func (mesh *Mesh) EvalVmap(sp surface.Point, id uint, val []float) int { return 0 }
+
+// SetLight makes the mesh's surfaces belong to a light.  Surface points on the
+// mesh will report the light, so integrators know that the light they emit is
+// also found by sampling the light.
 func (mesh *Mesh) SetLight(l Light) { mesh.light = l }
 
-//func (mesh *Mesh) EnableSampling() bool {}
-//func (mesh *Mesh) Sample(s1, s2 float) (p, n vec64.Vector) {}
+// EnableSampling prepares the mesh for Sample.  It returns false if the mesh
+// has no area.
+func (mesh *Mesh) EnableSampling() bool {
+	areas := make([]float64, len(mesh.triangles))
+	mesh.area = 0
+	for i, tri := range mesh.triangles {
+		areas[i] = tri.SurfaceArea()
+		mesh.area += areas[i]
+	}
+	if mesh.area <= 0 {
+		return false
+	}
+	mesh.areaDist = sampleutil.NewPdf1D(areas)
+	return true
+}
+
+// SurfaceArea returns the total area of the mesh's triangles.  It is only
+// valid after EnableSampling.
+func (mesh *Mesh) SurfaceArea() float64 { return mesh.area }
+
+// Sample picks a point on the mesh uniformly by area and returns its position
+// and geometric normal.  EnableSampling must be called first.
+func (mesh *Mesh) Sample(s1, s2 float64) (p, n vec64.Vector) {
+	sp := mesh.SampleSurface(s1, s2, 0)
+	return sp.Position, sp.GeometricNormal
+}
+
+// SampleSurface picks a point on the mesh uniformly by area, as it is at time
+// t.  EnableSampling must be called first.
+func (mesh *Mesh) SampleSurface(s1, s2, t float64) SurfacePoint {
+	offset, _ := mesh.areaDist.Sample(s1)
+	i := int(offset)
+	if i >= len(mesh.triangles) {
+		i = len(mesh.triangles) - 1
+	}
+	// The rest of s1 is still uniform, so it picks the point in the triangle.
+	su := math.Sqrt(offset - float64(i))
+	v, w := s2*su, 1-su
+	tri := mesh.triangles[i]
+	vert := tri.verticesAt(t)
+	p := vec64.Sum(vert[0].Scale(1-v-w), vert[1].Scale(v), vert[2].Scale(w))
+	return tri.Surface(Collision{
+		Primitive: tri,
+		Ray:       Ray{From: p, Time: t},
+		UserData:  [2]float64{v, w},
+	})
+}
 
 // SetData changes the mesh's data.
 //
@@ -90,3 +153,45 @@ func (mesh *Mesh) AddTriangle(t *Triangle) {
 	t.index = len(mesh.triangles)
 	mesh.triangles = append(mesh.triangles, t)
 }
+
+// SetMotion makes the mesh move while the camera's shutter is open.  The
+// mesh's data is transformed by start at time zero and by end at time one, and
+// each vertex moves along a straight line in between.  SetMotion must be
+// called after SetData, and the transforms should not scale non-uniformly
+// (normals are transformed like directions).
+func (mesh *Mesh) SetMotion(start, end mat64.Matrix) {
+	vertices := mesh.vertices
+	mesh.vertices = transformPoints(start, vertices)
+	mesh.endVertices = transformPoints(end, vertices)
+	if mesh.normals != nil {
+		normals := mesh.normals
+		mesh.normals = transformNormals(start, normals)
+		mesh.endNormals = transformNormals(end, normals)
+	}
+}
+
+// Moving reports whether the mesh's vertices change over time.
+func (mesh *Mesh) Moving() bool {
+	return mesh.endVertices != nil
+}
+
+func transformPoints(m mat64.Matrix, points []vec64.Vector) []vec64.Vector {
+	result := make([]vec64.Vector, len(points))
+	for i, p := range points {
+		result[i] = m.Transform(vec64.Vector{p[0], p[1], p[2], 1}).Vec3()
+	}
+	return result
+}
+
+func transformNormals(m mat64.Matrix, normals []vec64.Vector) []vec64.Vector {
+	result := make([]vec64.Vector, len(normals))
+	for i, n := range normals {
+		result[i] = m.Transform(vec64.Vector{n[0], n[1], n[2], 0}).Vec3().Normalize()
+	}
+	return result
+}
+
+// lerpVector linearly interpolates between two vectors.
+func lerpVector(a, b vec64.Vector, t float64) vec64.Vector {
+	return vec64.Add(a.Scale(1-t), b.Scale(t))
+}
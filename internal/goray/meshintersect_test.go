@@ -23,7 +23,9 @@ package goray
 import (
 	"testing"
 
+	"bitbucket.org/zombiezen/math3/mat64"
 	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
 )
 
 var meshIntersectTests = []struct {
@@ -114,6 +116,54 @@ func BenchmarkMeshIntersect(b *testing.B) {
 	}
 }
 
+func TestMovingTriangle(t *testing.T) {
+	mesh := NewMesh(1, false)
+	mesh.SetData([]vec64.Vector{
+		{0.0, 0.0, 1.0},
+		{1.0, 0.0, 1.0},
+		{0.0, 1.0, 1.0},
+	}, nil, nil)
+	// Slide the triangle two units along X over the shutter.
+	start := mat64.Identity
+	end := mat64.Identity
+	end[0][3] = 2.0
+	mesh.SetMotion(start, end)
+	tri := NewTriangle(0, 1, 2, mesh)
+	mesh.AddTriangle(tri)
+
+	wantBound := bound.Bound{Min: vec64.Vector{0.0, 0.0, 1.0}, Max: vec64.Vector{3.0, 1.0, 1.0}}
+	if bd := tri.Bound(); bd != wantBound {
+		t.Errorf("tri.Bound() = %v; want %v", bd, wantBound)
+	}
+
+	tests := []struct {
+		Time float64
+		Hit  bool
+	}{
+		{0.0, true},
+		{0.5, false},
+		{0.9, false},
+	}
+	for _, test := range tests {
+		r := Ray{
+			From: vec64.Vector{0.25, 0.25, 0.0},
+			Dir:  vec64.Vector{0.0, 0.0, 1.0},
+			Time: test.Time,
+		}
+		if coll := tri.Intersect(r); coll.Hit() != test.Hit {
+			t.Errorf("Intersect at time %.1f hit = %t; want %t", test.Time, coll.Hit(), test.Hit)
+		}
+	}
+	r := Ray{
+		From: vec64.Vector{2.25, 0.25, 0.0},
+		Dir:  vec64.Vector{0.0, 0.0, 1.0},
+		Time: 1.0,
+	}
+	if coll := tri.Intersect(r); !coll.Hit() {
+		t.Error("Intersect at time 1.0 missed the moved triangle")
+	}
+}
+
 func BenchmarkGoMeshIntersect(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		intersect_go(
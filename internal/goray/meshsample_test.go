@@ -0,0 +1,72 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"math"
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+)
+
+func TestMeshSample(t *testing.T) {
+	mesh := NewMesh(2, false)
+	mesh.SetData([]vec64.Vector{
+		{0.0, 0.0, 0.0},
+		{2.0, 0.0, 0.0},
+		{0.0, 1.0, 0.0},
+		{0.0, 0.0, 5.0},
+		{6.0, 0.0, 5.0},
+		{0.0, 1.0, 5.0},
+	}, nil, nil)
+	mesh.AddTriangle(NewTriangle(0, 1, 2, mesh))
+	mesh.AddTriangle(NewTriangle(3, 4, 5, mesh))
+	if !mesh.EnableSampling() {
+		t.Fatal("EnableSampling() = false")
+	}
+	if a := mesh.SurfaceArea(); math.Abs(a-4.0) > 1e-9 {
+		t.Errorf("SurfaceArea() = %v; want 4", a)
+	}
+
+	// The second triangle is three times as large, so it should get three
+	// quarters of the samples.
+	const n = 64
+	var big int
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			p, norm := mesh.Sample((float64(i)+0.5)/n, (float64(j)+0.5)/n)
+			if norm != (vec64.Vector{0.0, 0.0, 1.0}) {
+				t.Errorf("Sample normal = %v; want [0 0 1]", norm)
+			}
+			width := 2.0
+			if p[2] > 2.5 {
+				big++
+				width = 6.0
+			}
+			if p[0] < 0 || p[1] < 0 || p[0]/width+p[1] > 1+1e-9 {
+				t.Errorf("Sample point %v is not on a triangle", p)
+			}
+		}
+	}
+	if frac := float64(big) / (n * n); math.Abs(frac-0.75) > 0.01 {
+		t.Errorf("fraction of samples on large triangle = %.3f; want 0.75", frac)
+	}
+}
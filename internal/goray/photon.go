@@ -124,6 +124,8 @@ func (h *gatherHeap) Pop() (val interface{}) {
 	return
 }
 
+// Gather returns up to nLookup photons that are closest to p.  Only photons
+// whose squared distance from p is less than maxDist are considered.
 func (pm *PhotonMap) Gather(p vec64.Vector, nLookup int, maxDist float64) []GatherResult {
 	resultHeap := make(gatherHeap, 0, nLookup)
 
@@ -133,6 +135,12 @@ func (pm *PhotonMap) Gather(p vec64.Vector, nLookup int, maxDist float64) []Gath
 
 	for gresult := range ch {
 		resultHeap.Add(gresult)
+		if len(resultHeap) == cap(resultHeap) {
+			// Only photons closer than the farthest one found can improve the result.
+			distCh <- resultHeap[0].Distance
+		} else {
+			distCh <- maxDist
+		}
 	}
 	return resultHeap
 }
@@ -168,12 +176,14 @@ func lookup(p vec64.Vector, ch chan<- GatherResult, distCh <-chan float64, photo
 
 	for currNode, empty := next(); !empty; currNode, empty = next() {
 		if currNode.IsLeaf() {
-			phot := photons[currNode.Indices()[0]]
-			v := vec64.Sub(phot.Position, p)
-			distSqr := v.LengthSqr()
-			if distSqr < maxDistSqr {
-				ch <- GatherResult{phot, distSqr}
-				maxDistSqr = <-distCh
+			for _, i := range currNode.Indices() {
+				phot := photons[i]
+				v := vec64.Sub(phot.Position, p)
+				distSqr := v.LengthSqr()
+				if distSqr < maxDistSqr {
+					ch <- GatherResult{phot, distSqr}
+					maxDistSqr = <-distCh
+				}
 			}
 			continue
 		}
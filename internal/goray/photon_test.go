@@ -0,0 +1,71 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+)
+
+func newLinePhotonMap(n int) *PhotonMap {
+	pm := NewMap()
+	for i := 0; i < n; i++ {
+		pm.AddPhoton(Photon{
+			Position:  vec64.Vector{float64(i), 0, 0},
+			Direction: vec64.Vector{0, 1, 0},
+			Color:     color.White,
+		})
+	}
+	pm.Update()
+	return pm
+}
+
+func TestGather(t *testing.T) {
+	pm := newLinePhotonMap(20)
+	results := pm.Gather(vec64.Vector{10.1, 0, 0}, 3, 100)
+	if len(results) != 3 {
+		t.Fatalf("len(Gather(...)) = %d (expected 3)", len(results))
+	}
+	found := make(map[float64]bool)
+	for _, r := range results {
+		found[r.Photon.Position[0]] = true
+	}
+	for _, x := range []float64{9, 10, 11} {
+		if !found[x] {
+			t.Errorf("Gather did not return photon at x=%v (got %v)", x, results)
+		}
+	}
+}
+
+func TestGatherMaxDist(t *testing.T) {
+	pm := newLinePhotonMap(20)
+	results := pm.Gather(vec64.Vector{5, 0, 0}, 10, 1.5)
+	if len(results) != 3 {
+		t.Errorf("len(Gather(...)) = %d (expected 3)", len(results))
+	}
+	for _, r := range results {
+		if r.Distance >= 1.5 {
+			t.Errorf("Gather returned photon at squared distance %v", r.Distance)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/log"
+)
+
+// Progressive controls a progressive render.  A progressive render takes
+// passes of samples over the whole image until one of its stopping conditions
+// is met.  Conditions with a zero value are ignored; if none are set, the
+// render stops after the first pass.
+type Progressive struct {
+	// PassSamples is the number of samples taken in each pixel per pass.
+	PassSamples int
+
+	// MaxSamples stops the render once each pixel has this many samples.
+	MaxSamples int
+
+	// MaxTime stops the render after the first pass that ends after this
+	// much time has been spent rendering.
+	MaxTime time.Duration
+
+	// NoiseTarget stops the render once the estimated noise of the image
+	// falls below this value.  See Progress.Noise.
+	NoiseTarget float64
+}
+
+// Progress describes a progressive render after one of its passes.
+type Progress struct {
+	// Pass is the number of passes that have finished.
+	Pass int
+
+	// Samples is the number of samples that have been taken in each pixel.
+	Samples int
+
+	// Elapsed is the time spent rendering so far.
+	Elapsed time.Duration
+
+	// Noise is the average over the image of each pixel's standard error,
+	// relative to the pixel's energy plus 0.1 so that dark pixels don't
+	// dominate.  It is estimated from how much the pixel varies between
+	// passes, so it is infinite until the second pass has finished.
+	Noise float64
+}
+
+// done reports whether the render should stop.
+func (prog Progressive) done(p Progress) bool {
+	switch {
+	case prog.MaxSamples <= 0 && prog.MaxTime <= 0 && prog.NoiseTarget <= 0:
+		return true
+	case prog.MaxSamples > 0 && p.Samples >= prog.MaxSamples:
+		return true
+	case prog.MaxTime > 0 && p.Elapsed >= prog.MaxTime:
+		return true
+	case prog.NoiseTarget > 0 && p.Noise <= prog.NoiseTarget:
+		return true
+	}
+	return false
+}
+
+// RenderProgressive creates an image from a scene like Render, but renders in
+// passes over the whole image (or the scene's region).  After each pass, snapshot (if not nil) is
+// called with the image rendered so far.  The snapshot image is not used by
+// the renderer afterward.  The scene's antialiasing parameters are ignored.
+func RenderProgressive(s *Scene, i Integrator, prog Progressive, snapshot func(*Image, Progress), log log.Logger) (img *Image) {
+	img, _ = RenderProgressiveContext(context.Background(), s, i, prog, snapshot, log)
+	return
+}
+
+// RenderProgressiveContext is like RenderProgressive, but stops rendering once
+// the context is done.  If the render is stopped, the image from the last
+// finished pass (or nil, if no pass finished) is returned along with the
+// context's error.
+func RenderProgressiveContext(ctx context.Context, s *Scene, i Integrator, prog Progressive, snapshot func(*Image, Progress), log log.Logger) (img *Image, err error) {
+	start := time.Now()
+	Prepare(s, i)
+	return renderProgressive(ctx, start, s, i, BlockIntegrateContext, prog, snapshot, log)
+}
+
+// RenderProgressiveWith renders an image in passes like
+// RenderProgressiveContext, but integrates each pass with the given function.
+// Like RenderWith, the scene is not updated and the integrators are not
+// preprocessed.
+func RenderProgressiveWith(ctx context.Context, s *Scene, i Integrator, integrate PassFunc, prog Progressive, snapshot func(*Image, Progress), log log.Logger) (img *Image, err error) {
+	return renderProgressive(ctx, time.Now(), s, i, integrate, prog, snapshot, log)
+}
+
+// renderProgressive renders passes of a progressive render that started at
+// start.
+func renderProgressive(ctx context.Context, start time.Time, s *Scene, i Integrator, integrate PassFunc, prog Progressive, snapshot func(*Image, Progress), log log.Logger) (img *Image, err error) {
+	w, h := s.Camera().ResolutionX(), s.Camera().ResolutionY()
+	film := NewFilm(w, h, s.Filter())
+	est := newNoiseEstimator(w, h)
+
+	p := Pass{Samples: prog.PassSamples, Jitter: true, Region: renderArea(s)}
+	if p.Samples < 1 {
+		p.Samples = 1
+	}
+	if prog.MaxSamples > 0 && p.Samples > prog.MaxSamples {
+		p.Samples = prog.MaxSamples
+	}
+	switch {
+	case prog.MaxSamples <= 0 && prog.MaxTime <= 0 && prog.NoiseTarget <= 0:
+		p.Passes = 1
+	case prog.MaxSamples > 0:
+		p.Passes = (prog.MaxSamples + p.Samples - 1) / p.Samples
+	}
+	for p.Number = 0; ; p.Number++ {
+		p.FirstSample = p.Number * p.Samples
+		for frag := range integrate(ctx, s, i, p, log) {
+			film.Add(frag)
+			est.add(frag)
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		est.endPass()
+
+		img = newSceneImage(s, w, h)
+		film.Develop(img)
+		addSplats(img, s, i, film)
+		clearOutside(img, s.Region())
+		progress := Progress{
+			Pass:    p.Number + 1,
+			Samples: p.FirstSample + p.Samples,
+			Elapsed: time.Since(start),
+			Noise:   est.noise(),
+		}
+		log.Debugf("Pass %d: %d samples, noise %.4f", progress.Pass, progress.Samples, progress.Noise)
+		if snapshot != nil {
+			snapshot(img, progress)
+		}
+		if prog.done(progress) {
+			return
+		}
+	}
+}
+
+// A noiseEstimator measures how much the average of each pixel varies between
+// passes.
+type noiseEstimator struct {
+	width  int
+	sum    []float64 // energy of the current pass's samples
+	count  []int     // number of the current pass's samples
+	passes []int
+	mean   []float64 // mean of the pass averages
+	m2     []float64 // sum of squared differences from the mean
+}
+
+func newNoiseEstimator(w, h int) *noiseEstimator {
+	n := w * h
+	return &noiseEstimator{
+		width:  w,
+		sum:    make([]float64, n),
+		count:  make([]int, n),
+		passes: make([]int, n),
+		mean:   make([]float64, n),
+		m2:     make([]float64, n),
+	}
+}
+
+func (est *noiseEstimator) add(frag Fragment) {
+	j := frag.Y*est.width + frag.X
+	est.sum[j] += color.Energy(frag.Color)
+	est.count[j]++
+}
+
+func (est *noiseEstimator) endPass() {
+	for j := range est.sum {
+		if est.count[j] == 0 {
+			continue
+		}
+		// Welford's algorithm
+		x := est.sum[j] / float64(est.count[j])
+		est.passes[j]++
+		d := x - est.mean[j]
+		est.mean[j] += d / float64(est.passes[j])
+		est.m2[j] += d * (x - est.mean[j])
+		est.sum[j], est.count[j] = 0, 0
+	}
+}
+
+func (est *noiseEstimator) noise() float64 {
+	total, n := 0.0, 0
+	for j, k := range est.passes {
+		switch k {
+		case 0:
+			// Outside of the render region
+			continue
+		case 1:
+			return math.Inf(1)
+		}
+		stdErr := math.Sqrt(est.m2[j] / float64(k-1) / float64(k))
+		total += stdErr / (math.Abs(est.mean[j]) + 0.1)
+		n++
+	}
+	if n == 0 {
+		return math.Inf(1)
+	}
+	return total / float64(n)
+}
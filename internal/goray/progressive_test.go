@@ -0,0 +1,74 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"zombiezen.com/go/goray/internal/color"
+)
+
+func TestProgressiveDone(t *testing.T) {
+	tests := []struct {
+		Prog     Progressive
+		Progress Progress
+		Done     bool
+	}{
+		{Progressive{}, Progress{Pass: 1, Samples: 1, Noise: math.Inf(1)}, true},
+		{Progressive{MaxSamples: 8}, Progress{Pass: 3, Samples: 6}, false},
+		{Progressive{MaxSamples: 8}, Progress{Pass: 4, Samples: 8}, true},
+		{Progressive{MaxTime: time.Minute}, Progress{Elapsed: 30 * time.Second}, false},
+		{Progressive{MaxTime: time.Minute}, Progress{Elapsed: 61 * time.Second}, true},
+		{Progressive{NoiseTarget: 0.01}, Progress{Noise: math.Inf(1)}, false},
+		{Progressive{NoiseTarget: 0.01}, Progress{Noise: 0.005}, true},
+		{Progressive{MaxSamples: 100, NoiseTarget: 0.01}, Progress{Samples: 10, Noise: 0.005}, true},
+	}
+	for _, test := range tests {
+		if done := test.Prog.done(test.Progress); done != test.Done {
+			t.Errorf("%+v.done(%+v) = %t; want %t", test.Prog, test.Progress, done, test.Done)
+		}
+	}
+}
+
+func TestNoiseEstimator(t *testing.T) {
+	est := newNoiseEstimator(2, 1)
+	passes := [][2]float64{{0.5, 1.0}, {0.5, 3.0}, {0.5, 2.0}}
+	for i, pass := range passes {
+		for x, e := range pass {
+			est.add(Fragment{X: x, Color: color.RGBA{e, e, e, 1}})
+		}
+		est.endPass()
+		if i == 0 {
+			if n := est.noise(); !math.IsInf(n, 1) {
+				t.Errorf("noise after one pass = %v; want +Inf", n)
+			}
+		}
+	}
+
+	// The first pixel never changes and the second has a standard error of
+	// 1/sqrt(3) around a mean of 2.
+	expected := (1 / math.Sqrt(3) / 2.1) / 2
+	if n := est.noise(); math.Abs(n-expected) > 1e-9 {
+		t.Errorf("noise = %v; want %v", n, expected)
+	}
+}
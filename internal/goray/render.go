@@ -23,6 +23,8 @@ package goray
 import (
 	"image"
 	"image/color"
+	"math"
+	"sync"
 
 	"bitbucket.org/zombiezen/math3/vec64"
 	color_ "zombiezen.com/go/goray/internal/color"
@@ -48,6 +50,13 @@ type RenderState struct {
 	WaveLength     float64
 	Time           float64
 	MaterialData   interface{}
+
+	// Sampler hands out the sample values for the current pixel sample.
+	Sampler Sampler
+
+	// AOVs collects the arbitrary output variables of the current pixel
+	// sample.  It is nil if the scene does not record any.
+	AOVs *AOVValues
 }
 
 // Init initializes the state.
@@ -69,17 +78,27 @@ func (st *RenderState) SetDefaults() {
 	st.Traveled = 0.0
 }
 
-// Fragment stores a single element of an image.
+// Fragment stores a single element of an image.  When a fragment is a
+// sample of a pixel, DX and DY give its position within the pixel.  AOVs holds
+// the sample's arbitrary output variables, or nil if the scene does not
+// record any.
 type Fragment struct {
-	Color color_.AlphaColor
-	X, Y  int
+	Color  color_.AlphaColor
+	X, Y   int
+	DX, DY float64
+	AOVs   *AOVValues
 }
 
 // Image stores a two-dimensional array of colors.
 // It implements the image.Image interface, so you can use it directly with the standard library.
+//
+// An image that was rendered with arbitrary output variables carries an image
+// for each of them in AOVs.  The AOV images have the same dimensions as the
+// image.
 type Image struct {
 	Width, Height int
 	Pix           []color_.RGBA
+	AOVs          map[AOV]*Image
 }
 
 // NewImage creates a new, blank image with the given width and height.
@@ -122,6 +141,23 @@ func (i *Image) Pixel(x, y int) color_.RGBA {
 	return i.Pix[y*i.Width+x]
 }
 
+// Crop returns a copy of the part of the image (and its AOV images) inside a
+// rectangle.
+func (i *Image) Crop(r image.Rectangle) *Image {
+	r = r.Intersect(i.Bounds())
+	crop := NewImage(r.Dx(), r.Dy())
+	for y := 0; y < crop.Height; y++ {
+		copy(crop.Pix[y*crop.Width:(y+1)*crop.Width], i.Pix[(r.Min.Y+y)*i.Width+r.Min.X:])
+	}
+	if i.AOVs != nil {
+		crop.AOVs = make(map[AOV]*Image, len(i.AOVs))
+		for a, layer := range i.AOVs {
+			crop.AOVs[a] = layer.Crop(r)
+		}
+	}
+	return crop
+}
+
 // Clear sets all of the pixels in the image to a given color.
 func (i *Image) Clear(clearColor color_.AlphaColor) {
 	for j, _ := range i.Pix {
@@ -135,3 +171,152 @@ func (i *Image) Acquire(ch <-chan Fragment) {
 		i.Pix[frag.Y*i.Width+frag.X].Copy(frag.Color)
 	}
 }
+
+// AddSplats adds the contents of a splat buffer, multiplied by scale, to the
+// image.  The buffer must have the same dimensions as the image.  The alpha of
+// the image is left untouched.
+func (i *Image) AddSplats(buf *SplatBuffer, scale float64) {
+	scale /= splatUnit
+	for j := range i.Pix {
+		p := &i.Pix[j]
+		col := buf.pix[j]
+		p.R += float64(col[0]) * scale
+		p.G += float64(col[1]) * scale
+		p.B += float64(col[2]) * scale
+	}
+}
+
+// splatUnit is the number of fixed-point units in one unit of splatted light.
+const splatUnit = 1 << 32
+
+// maxSplat is the most light that a single splat can add to a channel.  A
+// channel's fixed-point sum holds 128 splats this bright; sums that would be
+// any brighter (or darker) saturate instead of wrapping around.
+const maxSplat = 1 << 24
+
+// SplatBuffer accumulates light that lands on arbitrary positions of the
+// image plane, such as light traced from a light source back to the camera.
+// It is safe to call Add from multiple goroutines.  Light is summed in fixed
+// point, so the result does not depend on the order of the calls unless a sum
+// saturates.
+type SplatBuffer struct {
+	Width, Height int
+	pix           [][3]int64
+	rowLocks      []sync.Mutex
+}
+
+// NewSplatBuffer creates a new, empty splat buffer with the given width and height.
+func NewSplatBuffer(w, h int) *SplatBuffer {
+	return &SplatBuffer{
+		Width:    w,
+		Height:   h,
+		pix:      make([][3]int64, w*h),
+		rowLocks: make([]sync.Mutex, h),
+	}
+}
+
+// Add adds a color to the fragment that contains the image plane position
+// (x, y).  Positions outside of the buffer and colors that are not finite are
+// ignored, and channels brighter than maxSplat are clamped.
+func (buf *SplatBuffer) Add(x, y float64, col color_.Color) {
+	px, py := int(math.Floor(x)), int(math.Floor(y))
+	if px < 0 || py < 0 || px >= buf.Width || py >= buf.Height {
+		return
+	}
+	r, g, b := col.Red(), col.Green(), col.Blue()
+	if !isFinite(r) || !isFinite(g) || !isFinite(b) {
+		return
+	}
+	buf.rowLocks[py].Lock()
+	defer buf.rowLocks[py].Unlock()
+	addSplat(&buf.pix[py*buf.Width+px], [3]int64{toSplatUnits(r), toSplatUnits(g), toSplatUnits(b)})
+}
+
+// addSplat adds fixed-point light to a pixel, saturating each channel.
+func addSplat(p *[3]int64, col [3]int64) {
+	for i := range p {
+		sum := p[i] + col[i]
+		// The sum overflowed if both addends have a different sign than it.
+		switch {
+		case p[i] >= 0 && col[i] >= 0 && sum < 0:
+			sum = math.MaxInt64
+		case p[i] < 0 && col[i] < 0 && sum >= 0:
+			sum = math.MinInt64
+		}
+		p[i] = sum
+	}
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// toSplatUnits converts a finite channel value to fixed point.
+func toSplatUnits(f float64) int64 {
+	f = math.Max(-maxSplat, math.Min(f, maxSplat))
+	return int64(math.Floor(f*splatUnit + 0.5))
+}
+
+// Merge adds the contents of another splat buffer with the same dimensions to
+// the buffer.
+func (buf *SplatBuffer) Merge(other *SplatBuffer) {
+	for y := 0; y < buf.Height; y++ {
+		buf.rowLocks[y].Lock()
+		for x := 0; x < buf.Width; x++ {
+			j := y*buf.Width + x
+			addSplat(&buf.pix[j], other.pix[j])
+		}
+		buf.rowLocks[y].Unlock()
+	}
+}
+
+// Reset clears the buffer.
+func (buf *SplatBuffer) Reset() {
+	for y := 0; y < buf.Height; y++ {
+		buf.rowLocks[y].Lock()
+		row := buf.pix[y*buf.Width : (y+1)*buf.Width]
+		for x := range row {
+			row[x] = [3]int64{}
+		}
+		buf.rowLocks[y].Unlock()
+	}
+}
+
+// A Splat is the light that a splat buffer holds for one pixel, in the
+// buffer's fixed-point units.  Index is the position of the pixel in
+// row-major order.
+type Splat struct {
+	Index int
+	Color [3]int64
+}
+
+// Take clears the buffer and returns the pixels that held light, so that
+// splats can be sent to another process without sending the whole image.
+func (buf *SplatBuffer) Take() []Splat {
+	var splats []Splat
+	for y := 0; y < buf.Height; y++ {
+		buf.rowLocks[y].Lock()
+		for j := y * buf.Width; j < (y+1)*buf.Width; j++ {
+			if p := &buf.pix[j]; *p != ([3]int64{}) {
+				splats = append(splats, Splat{j, *p})
+				*p = [3]int64{}
+			}
+		}
+		buf.rowLocks[y].Unlock()
+	}
+	return splats
+}
+
+// MergeSplats adds splats taken from a buffer with the same dimensions to the
+// buffer.  Splats outside of the buffer are ignored.
+func (buf *SplatBuffer) MergeSplats(splats []Splat) {
+	for _, s := range splats {
+		if s.Index < 0 || s.Index >= len(buf.pix) {
+			continue
+		}
+		y := s.Index / buf.Width
+		buf.rowLocks[y].Lock()
+		addSplat(&buf.pix[s.Index], s.Color)
+		buf.rowLocks[y].Unlock()
+	}
+}
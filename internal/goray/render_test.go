@@ -21,6 +21,8 @@
 package goray
 
 import (
+	"image"
+	"math"
 	"testing"
 
 	"zombiezen.com/go/goray/internal/color"
@@ -34,7 +36,7 @@ func doAcquireBench(b *testing.B, queueSize int) {
 	go func() {
 		defer close(ch)
 		for i := 0; i < b.N; i++ {
-			ch <- Fragment{color.RGBA{0.1, 0.2, 0.3, 0.5}, i, 0}
+			ch <- Fragment{Color: color.RGBA{0.1, 0.2, 0.3, 0.5}, X: i}
 		}
 	}()
 
@@ -51,3 +53,139 @@ func BenchmarkAsyncAcquire(b *testing.B) {
 	b.StopTimer()
 	doAcquireBench(b, 100)
 }
+
+func TestSplatBuffer(t *testing.T) {
+	buf := NewSplatBuffer(3, 2)
+	buf.Add(1.5, 0.25, color.RGB{0.1, 0.2, 0.3})
+	buf.Add(1.0, 0.99, color.RGB{0.1, 0.2, 0.3})
+	buf.Add(-0.5, 1.0, color.White)
+	buf.Add(3.0, 1.0, color.White)
+
+	img := NewImage(3, 2)
+	img.Clear(color.RGBA{0.5, 0.5, 0.5, 0.75})
+	img.AddSplats(buf, 0.5)
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			want := color.RGBA{0.5, 0.5, 0.5, 0.75}
+			if x == 1 && y == 0 {
+				want = color.RGBA{0.6, 0.7, 0.8, 0.75}
+			}
+			if p := img.Pixel(x, y); math.Abs(p.R-want.R) > 1e-9 || math.Abs(p.G-want.G) > 1e-9 || math.Abs(p.B-want.B) > 1e-9 || p.A != want.A {
+				t.Errorf("img.Pixel(%d, %d) = %v; want %v", x, y, p, want)
+			}
+		}
+	}
+}
+
+func TestSplatBufferOrder(t *testing.T) {
+	cols := []color.RGB{{1e-9, 0.3, 7}, {0.1, 1e6, 0.2}, {0.7, 1e-3, 3}, {0.2, 0.1, 1e-7}}
+	forward, backward := NewSplatBuffer(1, 1), NewSplatBuffer(1, 1)
+	for i := range cols {
+		forward.Add(0.5, 0.5, cols[i])
+		backward.Add(0.5, 0.5, cols[len(cols)-1-i])
+	}
+	img1, img2 := NewImage(1, 1), NewImage(1, 1)
+	img1.AddSplats(forward, 1)
+	img2.AddSplats(backward, 1)
+	if img1.Pix[0] != img2.Pix[0] {
+		t.Errorf("splats added in reverse = %v; want %v", img2.Pix[0], img1.Pix[0])
+	}
+	if p := img1.Pix[0]; math.Abs(p.R-1.000000001) > 1e-9 || math.Abs(p.G-1000000.401) > 1e-6 || math.Abs(p.B-10.2000001) > 1e-9 {
+		t.Errorf("splat sum = %v", p)
+	}
+}
+
+func TestSplatBufferNonFinite(t *testing.T) {
+	buf := NewSplatBuffer(1, 1)
+	buf.Add(0.5, 0.5, color.RGB{1, 2, 3})
+	buf.Add(0.5, 0.5, color.RGB{math.Inf(1), 0, 0})
+	buf.Add(0.5, 0.5, color.RGB{0, math.NaN(), 0})
+	buf.Add(0.5, 0.5, color.RGB{0, 0, math.Inf(-1)})
+	img := NewImage(1, 1)
+	img.AddSplats(buf, 1)
+	if p := img.Pix[0]; p.R != 1 || p.G != 2 || p.B != 3 {
+		t.Errorf("splat sum = %v; want {1 2 3}", p)
+	}
+
+	buf.Reset()
+	buf.Add(0.5, 0.5, color.RGB{1e12, -1e12, 0})
+	buf.Add(0.5, 0.5, color.RGB{1e12, -1e12, 0})
+	img.Clear(color.RGBA{})
+	img.AddSplats(buf, 1)
+	if p := img.Pix[0]; p.R != 2*maxSplat || p.G != -2*maxSplat {
+		t.Errorf("clamped splat sum = %v; want {%v %v 0}", p, 2*maxSplat, -2*maxSplat)
+	}
+}
+
+func TestSplatBufferSaturate(t *testing.T) {
+	// The channels' sums hold 128 of the brightest splats.
+	const most = 128 * maxSplat
+	buf, other := NewSplatBuffer(1, 1), NewSplatBuffer(1, 1)
+	for i := 0; i < 200; i++ {
+		buf.Add(0.5, 0.5, color.RGB{maxSplat, -maxSplat, 1})
+		other.Add(0.5, 0.5, color.RGB{maxSplat, -maxSplat, 1})
+	}
+	img := NewImage(1, 1)
+	img.AddSplats(buf, 1)
+	if p := img.Pix[0]; p.R < 0.99*most || p.G > -0.99*most || p.B != 200 {
+		t.Errorf("saturated splat sum = %v; want {%v %v 200}", p, float64(most), float64(-most))
+	}
+
+	buf.Merge(other)
+	buf.MergeSplats(other.Take())
+	img.Clear(color.RGBA{})
+	img.AddSplats(buf, 1)
+	if p := img.Pix[0]; p.R < 0.99*most || p.G > -0.99*most || p.B != 600 {
+		t.Errorf("merged saturated splat sum = %v; want {%v %v 600}", p, float64(most), float64(-most))
+	}
+}
+
+func TestSplatBufferTake(t *testing.T) {
+	src := NewSplatBuffer(3, 2)
+	src.Add(1.5, 0.25, color.RGB{0.1, 0.2, 0.3})
+	src.Add(2.5, 1.5, color.RGB{0.4, 0.5, 0.6})
+	splats := src.Take()
+	if len(splats) != 2 {
+		t.Fatalf("len(Take()) = %d; want 2", len(splats))
+	}
+	if again := src.Take(); len(again) != 0 {
+		t.Errorf("Take() after Take() = %v; want none", again)
+	}
+
+	dst := NewSplatBuffer(3, 2)
+	dst.MergeSplats(splats)
+	dst.MergeSplats([]Splat{{Index: -1}, {Index: 6}})
+	img := NewImage(3, 2)
+	img.AddSplats(dst, 1)
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			var want color.RGBA
+			switch {
+			case x == 1 && y == 0:
+				want = color.RGBA{0.1, 0.2, 0.3, 0}
+			case x == 2 && y == 1:
+				want = color.RGBA{0.4, 0.5, 0.6, 0}
+			}
+			if p := img.Pixel(x, y); math.Abs(p.R-want.R) > 1e-9 || math.Abs(p.G-want.G) > 1e-9 || math.Abs(p.B-want.B) > 1e-9 {
+				t.Errorf("img.Pixel(%d, %d) = %v; want %v", x, y, p, want)
+			}
+		}
+	}
+}
+
+func TestImageCrop(t *testing.T) {
+	img := NewImage(4, 3)
+	for j := range img.Pix {
+		img.Pix[j] = color.RGBA{float64(j), 0, 0, 1}
+	}
+	crop := img.Crop(image.Rect(1, 1, 3, 3))
+	if crop.Width != 2 || crop.Height != 2 {
+		t.Fatalf("crop is %dx%d; want 2x2", crop.Width, crop.Height)
+	}
+	expected := []float64{5, 6, 9, 10}
+	for j, want := range expected {
+		if p := crop.Pix[j]; p.R != want {
+			t.Errorf("crop.Pix[%d].R = %v; want %v", j, p.R, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"zombiezen.com/go/goray/internal/montecarlo"
+)
+
+// A Sampler hands out the numbers that a render uses to pick its samples.
+//
+// Each sample is a point in a unit hypercube with as many dimensions as the
+// sample needs.  After StartSample, the sample's dimensions are handed out one
+// or two at a time, so users should ask for them in the same order for every
+// sample.  Samplers are not safe for concurrent use; Clone creates samplers
+// for other goroutines.
+type Sampler interface {
+	// StartSample starts the sample with the given index in a sequence of
+	// samples.  Renders use the pixel number as the sequence; other users
+	// (like photon shooting) use negative sequence numbers.
+	StartSample(seq, index int)
+
+	// Get1D returns the next dimension of the current sample in [0, 1).
+	Get1D() float64
+
+	// Get2D returns the next two dimensions of the current sample in [0, 1).
+	Get2D() (u, v float64)
+
+	// Clone returns a new sampler with the same settings whose samples are
+	// scrambled by seed.
+	Clone(seed int64) Sampler
+}
+
+type randomSampler struct {
+	seed int64
+	key  uint64
+	dim  uint64
+}
+
+var _ Sampler = &randomSampler{}
+
+// NewRandomSampler returns a sampler whose dimensions are independent random
+// numbers.  The numbers are derived from the seed, sequence, sample index and
+// dimension, so they are the same every time they are asked for.
+func NewRandomSampler(seed int64) Sampler {
+	return &randomSampler{seed: seed}
+}
+
+func (rs *randomSampler) StartSample(seq, index int) {
+	rs.key = montecarlo.Hash(uint64(rs.seed), uint64(seq), uint64(index))
+	rs.dim = 0
+}
+
+func (rs *randomSampler) Get1D() float64 {
+	rs.dim++
+	return montecarlo.Float64(montecarlo.Hash(rs.key, rs.dim))
+}
+
+func (rs *randomSampler) Get2D() (u, v float64) {
+	return rs.Get1D(), rs.Get1D()
+}
+
+func (rs *randomSampler) Clone(seed int64) Sampler {
+	return NewRandomSampler(seed)
+}
@@ -22,7 +22,9 @@ package goray
 
 import (
 	"errors"
+	"image"
 	"math"
+	"sort"
 
 	"bitbucket.org/zombiezen/math3/vec64"
 	"zombiezen.com/go/goray/internal/bound"
@@ -86,6 +88,15 @@ type Scene struct {
 	camera     Camera
 	background Background
 
+	volIntegrator VolumeIntegrator
+	filter        Filter
+	region        image.Rectangle
+	tileSize      int
+	tileOrder     TileOrder
+	tileFunc      func(TileProgress)
+	seed          int64
+	sampler       Sampler
+
 	intersecter        Intersecter
 	intersecterBuilder IntersecterBuilder
 	sceneBound         bound.Bound
@@ -93,7 +104,14 @@ type Scene struct {
 	aaSamples, aaPasses int
 	aaIncSamples        int
 	aaThreshold         float64
-	doDepth             bool
+	aovs                []AOV
+	primInfo            map[Primitive]primitiveInfo
+}
+
+// primitiveInfo identifies a primitive for the ID AOVs.
+type primitiveInfo struct {
+	object ObjectID
+	index  int
 }
 
 // NewScene creates a new scene.
@@ -105,6 +123,8 @@ func NewScene(ib IntersecterBuilder, l log.Logger) (s *Scene) {
 		aaPasses:    1,
 		aaThreshold: 0.05,
 
+		tileSize: DefaultTileSize,
+
 		objects:   make(map[ObjectID]Object3D),
 		materials: make(map[string]Material),
 		volumes:   make([]VolumeRegion, 0),
@@ -163,6 +183,20 @@ func (s *Scene) AddVolumeRegion(vr VolumeRegion) {
 	s.volumes = append(s.volumes, vr)
 }
 
+// VolumeRegions returns all of the volumetric effects added to the scene.
+func (s *Scene) VolumeRegions() []VolumeRegion { return s.volumes }
+
+// VolumeIntegrator returns the integrator used for the scene's volumes, or
+// nil if volumes are not rendered.
+func (s *Scene) VolumeIntegrator() VolumeIntegrator {
+	return s.volIntegrator
+}
+
+// SetVolumeIntegrator changes the integrator used for the scene's volumes.
+func (s *Scene) SetVolumeIntegrator(vi VolumeIntegrator) {
+	s.volIntegrator = vi
+}
+
 // Camera returns the scene's current camera.
 func (s *Scene) Camera() Camera {
 	return s.camera
@@ -173,6 +207,92 @@ func (s *Scene) SetCamera(cam Camera) {
 	s.camera = cam
 }
 
+// Shutter returns the interval of time that the scene's camera is exposed
+// for.  Cameras that are not ShutterCameras see the scene at time zero.
+func (s *Scene) Shutter() (open, close float64) {
+	if sc, ok := s.camera.(ShutterCamera); ok {
+		return sc.Shutter()
+	}
+	return 0, 0
+}
+
+// Filter returns the filter used to reconstruct pixels from their samples, or
+// nil if each sample only contributes to the pixel that contains it.
+func (s *Scene) Filter() Filter {
+	return s.filter
+}
+
+// SetFilter changes the filter used to reconstruct pixels from their samples.
+func (s *Scene) SetFilter(f Filter) {
+	s.filter = f
+}
+
+// Region returns the rectangle of pixels that renders fill in.  An empty
+// rectangle means the whole image.
+func (s *Scene) Region() image.Rectangle {
+	return s.region
+}
+
+// SetRegion limits renders to a rectangle of pixels.  An empty rectangle
+// renders the whole image.
+func (s *Scene) SetRegion(r image.Rectangle) {
+	s.region = r
+}
+
+// Seed returns the number that the scene's random sampling starts from.
+func (s *Scene) Seed() int64 {
+	return s.seed
+}
+
+// SetSeed changes the number that the scene's random sampling starts from.
+// Renders of the same scene with the same seed and number of samples come out
+// identical, no matter how many processors render them.
+func (s *Scene) SetSeed(seed int64) {
+	s.seed = seed
+}
+
+// Sampler returns the sampler that picks the scene's samples.  If no sampler
+// has been set, a random sampler is returned.
+func (s *Scene) Sampler() Sampler {
+	if s.sampler == nil {
+		return NewRandomSampler(s.seed)
+	}
+	return s.sampler
+}
+
+// SetSampler changes the sampler that picks the scene's samples.  Renders use
+// clones of the sampler that are scrambled by the scene's seed.
+func (s *Scene) SetSampler(smp Sampler) {
+	s.sampler = smp
+}
+
+// Tiles returns the size and order of the tiles that the image is rendered in.
+func (s *Scene) Tiles() (size int, order TileOrder) {
+	return s.tileSize, s.tileOrder
+}
+
+// SetTiles changes the size and order of the tiles that the image is rendered
+// in.  A size less than one uses DefaultTileSize.
+func (s *Scene) SetTiles(size int, order TileOrder) {
+	if size < 1 {
+		size = DefaultTileSize
+	}
+	s.tileSize, s.tileOrder = size, order
+}
+
+// TileFunc returns the function that is called each time a tile finishes
+// rendering, or nil if there is none.
+func (s *Scene) TileFunc() func(TileProgress) {
+	return s.tileFunc
+}
+
+// SetTileFunc sets a function that is called each time a tile finishes
+// rendering.  The function is called from the rendering goroutines, one call
+// at a time, so it should return quickly.
+func (s *Scene) SetTileFunc(f func(TileProgress)) {
+	s.tileFunc = f
+}
+
 // Background returns the scene's current background.
 func (s *Scene) Background() Background {
 	return s.background
@@ -183,11 +303,17 @@ func (s *Scene) SetBackground(bg Background) {
 	s.background = bg
 }
 
-// SetAntialiasing changes the parameters for antialiasing.
+// SetAntialiasing changes the parameters for antialiasing.  The first pass
+// takes numSamples samples in every pixel.  Each of the remaining passes takes
+// incSamples more samples (or numSamples, if incSamples is not positive) in
+// the pixels that differ from a neighbor by more than threshold.
 func (s *Scene) SetAntialiasing(numSamples, numPasses, incSamples int, threshold float64) {
 	if numSamples < 1 {
 		numSamples = 1
 	}
+	if numPasses < 1 {
+		numPasses = 1
+	}
 	s.aaSamples = numSamples
 	s.aaPasses = numPasses
 	if incSamples > 0 {
@@ -203,8 +329,44 @@ func (s *Scene) Bound() bound.Bound {
 	return s.sceneBound
 }
 
+// DoDepth returns whether the scene's renders record depth.
 func (s *Scene) DoDepth() bool {
-	return s.doDepth
+	return s.HasAOV(AOVDepth)
+}
+
+// AOVs returns the arbitrary output variables that the scene's renders record,
+// in the order that they were added.
+func (s *Scene) AOVs() []AOV {
+	return s.aovs
+}
+
+// AddAOV adds an arbitrary output variable to the ones that the scene's
+// renders record.  Adding an AOV more than once has no effect.
+func (s *Scene) AddAOV(a AOV) {
+	if s.HasAOV(a) {
+		return
+	}
+	s.aovs = append(s.aovs, a)
+	if a.isID() {
+		s.changes.Mark(sceneOtherChange)
+	}
+}
+
+// HasAOV returns whether the scene's renders record an arbitrary output
+// variable.
+func (s *Scene) HasAOV(a AOV) bool {
+	for _, aa := range s.aovs {
+		if aa == a {
+			return true
+		}
+	}
+	return false
+}
+
+// primitiveInfo returns the object and number of a primitive.  It is only
+// filled in by Update if the scene records one of the ID AOVs.
+func (s *Scene) primitiveInfo(p Primitive) primitiveInfo {
+	return s.primInfo[p]
 }
 
 // Intersect returns the surface point that intersects with the given ray.
@@ -268,15 +430,18 @@ func (s *Scene) Update() (err error) {
 		}
 	}
 
+	if s.HasAOV(AOVObjectID) || s.HasAOV(AOVPrimitiveID) {
+		if s.primInfo == nil || s.changes.Has(sceneObjectsChanged) {
+			s.numberPrimitives()
+		}
+	}
+
 	if s.changes.Has(sceneLightsChanged) {
 		for _, li := range s.lights {
 			li.SetScene(s)
 		}
-		if s.background != nil {
-			bgLight := s.background.Light()
-			if bgLight != nil {
-				bgLight.SetScene(s)
-			}
+		for _, li := range BackgroundLights(s.background) {
+			li.SetScene(s)
 		}
 		s.log.Debugf("Set up lights")
 	}
@@ -284,3 +449,22 @@ func (s *Scene) Update() (err error) {
 	s.changes.Clear()
 	return
 }
+
+// numberPrimitives assigns every primitive in the scene a number for the
+// primitive ID AOV.  Objects are numbered in the order of their IDs, so the
+// numbers do not change between renders of the same scene.
+func (s *Scene) numberPrimitives() {
+	ids := make([]int, 0, len(s.objects))
+	for id := range s.objects {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	s.primInfo = make(map[Primitive]primitiveInfo)
+	n := 0
+	for _, id := range ids {
+		for _, p := range s.objects[ObjectID(id)].Primitives() {
+			n++
+			s.primInfo[p] = primitiveInfo{object: ObjectID(id), index: n}
+		}
+	}
+}
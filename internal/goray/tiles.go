@@ -0,0 +1,236 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"errors"
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// DefaultTileSize is the width and height of tiles in pixels for scenes that
+// do not set a tile size.
+const DefaultTileSize = 32
+
+// TileOrder specifies the order in which the tiles of an image are rendered.
+type TileOrder int
+
+// Tile orders
+const (
+	// TileScanline renders tiles left to right, top to bottom.
+	TileScanline TileOrder = iota
+	// TileSpiral renders tiles in rings around the center of the image.
+	TileSpiral
+	// TileHilbert renders tiles along a Hilbert curve, so that consecutive
+	// tiles are usually neighbors.
+	TileHilbert
+	// TileRandom renders tiles in a shuffled (but repeatable) order.
+	TileRandom
+)
+
+var tileOrderNames = []string{
+	TileScanline: "scanline",
+	TileSpiral:   "spiral",
+	TileHilbert:  "hilbert",
+	TileRandom:   "random",
+}
+
+func (order TileOrder) String() string {
+	if order >= 0 && int(order) < len(tileOrderNames) {
+		return tileOrderNames[order]
+	}
+	return "unknown"
+}
+
+// ParseTileOrder returns the tile order with the given name.
+func ParseTileOrder(name string) (TileOrder, error) {
+	for i, n := range tileOrderNames {
+		if n == name {
+			return TileOrder(i), nil
+		}
+	}
+	return 0, errors.New("Unknown tile order: " + name)
+}
+
+// TileProgress reports that a tile of a render has finished.
+type TileProgress struct {
+	// Tile is the rectangle of pixels that finished.
+	Tile image.Rectangle
+
+	// Pass is the number of the pass that the tile belongs to, starting at
+	// zero.  Passes is the number of passes that the render will take at
+	// most, or zero if it is not known ahead of time.
+	Pass, Passes int
+
+	// Done is the number of tiles in the pass that have finished and Total
+	// is the number of tiles in the pass.
+	Done, Total int
+}
+
+// Fraction returns the fraction of the render that has finished, assuming
+// that every pass takes the same time.  If the number of passes is not known,
+// the fraction of the current pass is returned.
+func (tp TileProgress) Fraction() float64 {
+	if tp.Total == 0 {
+		return 0
+	}
+	f := float64(tp.Done) / float64(tp.Total)
+	if tp.Passes <= 0 {
+		return f
+	}
+	return (float64(tp.Pass) + f) / float64(tp.Passes)
+}
+
+// Tiles splits an area into size×size tiles and returns them in the given
+// order.  Tiles on the right and bottom edges may be smaller.
+func Tiles(area image.Rectangle, size int, order TileOrder) []image.Rectangle {
+	if size < 1 {
+		size = DefaultTileSize
+	}
+	if area.Empty() {
+		return nil
+	}
+	nx := (area.Dx() + size - 1) / size
+	ny := (area.Dy() + size - 1) / size
+	cells := make([]image.Point, 0, nx*ny)
+	for ty := 0; ty < ny; ty++ {
+		for tx := 0; tx < nx; tx++ {
+			cells = append(cells, image.Pt(tx, ty))
+		}
+	}
+
+	switch order {
+	case TileSpiral:
+		spiralOrder(cells, nx, ny)
+	case TileHilbert:
+		hilbertOrder(cells, nx, ny)
+	case TileRandom:
+		r := rand.New(rand.NewSource(int64(nx*ny) ^ int64(size)))
+		for i := len(cells) - 1; i > 0; i-- {
+			j := r.Intn(i + 1)
+			cells[i], cells[j] = cells[j], cells[i]
+		}
+	}
+
+	tiles := make([]image.Rectangle, len(cells))
+	for i, c := range cells {
+		min := area.Min.Add(c.Mul(size))
+		tiles[i] = image.Rectangle{min, min.Add(image.Pt(size, size))}.Intersect(area)
+	}
+	return tiles
+}
+
+// spiralOrder sorts tile cells by the square ring around the center that they
+// are in, and then by angle within each ring.
+func spiralOrder(cells []image.Point, nx, ny int) {
+	cx, cy := float64(nx-1)/2, float64(ny-1)/2
+	ring := func(p image.Point) float64 {
+		return math.Max(math.Abs(float64(p.X)-cx), math.Abs(float64(p.Y)-cy))
+	}
+	angle := func(p image.Point) float64 {
+		return math.Atan2(float64(p.Y)-cy, float64(p.X)-cx)
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		ri, rj := math.Ceil(ring(cells[i])), math.Ceil(ring(cells[j]))
+		if ri != rj {
+			return ri < rj
+		}
+		return angle(cells[i]) < angle(cells[j])
+	})
+}
+
+// hilbertOrder sorts tile cells by their distance along a Hilbert curve that
+// covers the grid of tiles.
+func hilbertOrder(cells []image.Point, nx, ny int) {
+	n := 1
+	for n < nx || n < ny {
+		n *= 2
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		return hilbertIndex(n, cells[i]) < hilbertIndex(n, cells[j])
+	})
+}
+
+// hilbertIndex returns the distance of a point along the Hilbert curve that
+// fills an n×n grid, where n is a power of two.
+func hilbertIndex(n int, p image.Point) int {
+	x, y := p.X, p.Y
+	d := 0
+	for s := n / 2; s > 0; s /= 2 {
+		rx, ry := 0, 0
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		// Rotate the quadrant so that the curve stays continuous.
+		if ry == 0 {
+			if rx == 1 {
+				x, y = n-1-x, n-1-y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+// A TileSequencer sends the fragments of numbered tiles on a channel in the
+// order of their numbers, no matter what order the tiles finish in.  Films add
+// samples in the order that they arrive, so sequencing the tiles makes a render
+// come out the same regardless of how many goroutines (or processes) render
+// it.
+type TileSequencer struct {
+	lock    sync.Mutex
+	ch      chan<- Fragment
+	next    int
+	pending map[int][]Fragment
+}
+
+// NewTileSequencer creates a sequencer that sends fragments on ch, starting
+// with tile zero.
+func NewTileSequencer(ch chan<- Fragment) *TileSequencer {
+	return &TileSequencer{ch: ch, pending: make(map[int][]Fragment)}
+}
+
+// Finish records the fragments of tile i and sends the fragments of every tile
+// that is next in order.  Finish must be called once for every tile, even the
+// ones without fragments.
+func (seq *TileSequencer) Finish(i int, frags []Fragment) {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+	seq.pending[i] = frags
+	for {
+		frags, ok := seq.pending[seq.next]
+		if !ok {
+			return
+		}
+		delete(seq.pending, seq.next)
+		seq.next++
+		for _, f := range frags {
+			seq.ch <- f
+		}
+	}
+}
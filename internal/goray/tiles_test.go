@@ -0,0 +1,121 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package goray
+
+import (
+	"image"
+	"testing"
+)
+
+func TestTilesCover(t *testing.T) {
+	area := image.Rect(3, 5, 103, 75)
+	orders := []TileOrder{TileScanline, TileSpiral, TileHilbert, TileRandom}
+	for _, order := range orders {
+		tiles := Tiles(area, 16, order)
+		if len(tiles) != 7*5 {
+			t.Errorf("%v: len(tiles) = %d; want %d", order, len(tiles), 7*5)
+		}
+		covered := make(map[image.Point]int)
+		for _, tile := range tiles {
+			if !tile.In(area) {
+				t.Errorf("%v: tile %v outside of %v", order, tile, area)
+			}
+			for y := tile.Min.Y; y < tile.Max.Y; y++ {
+				for x := tile.Min.X; x < tile.Max.X; x++ {
+					covered[image.Pt(x, y)]++
+				}
+			}
+		}
+		for p, n := range covered {
+			if n != 1 {
+				t.Errorf("%v: pixel %v covered %d times", order, p, n)
+			}
+		}
+		if len(covered) != area.Dx()*area.Dy() {
+			t.Errorf("%v: covered %d pixels; want %d", order, len(covered), area.Dx()*area.Dy())
+		}
+	}
+}
+
+func TestTilesSpiral(t *testing.T) {
+	tiles := Tiles(image.Rect(0, 0, 50, 50), 10, TileSpiral)
+	if want := image.Rect(20, 20, 30, 30); tiles[0] != want {
+		t.Errorf("first tile = %v; want %v", tiles[0], want)
+	}
+	for i := 1; i < 9; i++ {
+		if !tiles[i].In(image.Rect(10, 10, 40, 40)) {
+			t.Errorf("tiles[%d] = %v; want in the first ring", i, tiles[i])
+		}
+	}
+}
+
+func TestTilesHilbert(t *testing.T) {
+	tiles := Tiles(image.Rect(0, 0, 64, 64), 8, TileHilbert)
+	for i := 1; i < len(tiles); i++ {
+		d := tiles[i].Min.Sub(tiles[i-1].Min)
+		if d.X*d.X+d.Y*d.Y != 64 {
+			t.Errorf("tiles[%d] = %v is not next to tiles[%d] = %v", i, tiles[i], i-1, tiles[i-1])
+		}
+	}
+}
+
+func TestTileProgressFraction(t *testing.T) {
+	tests := []struct {
+		Progress TileProgress
+		Fraction float64
+	}{
+		{TileProgress{Pass: 0, Passes: 2, Done: 5, Total: 10}, 0.25},
+		{TileProgress{Pass: 1, Passes: 2, Done: 10, Total: 10}, 1},
+		{TileProgress{Pass: 3, Passes: 0, Done: 1, Total: 4}, 0.25},
+		{TileProgress{}, 0},
+	}
+	for _, test := range tests {
+		if f := test.Progress.Fraction(); f != test.Fraction {
+			t.Errorf("%+v.Fraction() = %v; want %v", test.Progress, f, test.Fraction)
+		}
+	}
+}
+
+func TestTileSequencer(t *testing.T) {
+	ch := make(chan Fragment, 10)
+	seq := NewTileSequencer(ch)
+	seq.Finish(2, []Fragment{{X: 3}})
+	seq.Finish(1, nil)
+	if len(ch) != 0 {
+		t.Fatalf("%d fragments sent before tile 0 finished", len(ch))
+	}
+	seq.Finish(0, []Fragment{{X: 0}, {X: 1}})
+	seq.Finish(3, []Fragment{{X: 4}})
+	close(ch)
+	var xs []int
+	for f := range ch {
+		xs = append(xs, f.X)
+	}
+	want := []int{0, 1, 3, 4}
+	if len(xs) != len(want) {
+		t.Fatalf("fragments = %v; want %v", xs, want)
+	}
+	for i := range want {
+		if xs[i] != want[i] {
+			t.Fatalf("fragments = %v; want %v", xs, want)
+		}
+	}
+}
@@ -69,10 +69,27 @@ func (tri *Triangle) getVertices() (v [3]vec64.Vector) {
 	return
 }
 
-func (tri *Triangle) getNormals() (n [3]vec64.Vector) {
+// verticesAt returns the triangle's vertices at a time.  Moving meshes are
+// only defined between times zero and one, so other times are clamped.
+func (tri *Triangle) verticesAt(t float64) (v [3]vec64.Vector) {
+	if !tri.mesh.Moving() {
+		return tri.getVertices()
+	}
+	t = math.Min(math.Max(t, 0), 1)
+	for i := 0; i < 3; i++ {
+		v[i] = lerpVector(tri.mesh.vertices[tri.v[i]], tri.mesh.endVertices[tri.v[i]], t)
+	}
+	return
+}
+
+func (tri *Triangle) getNormals(t float64) (n [3]vec64.Vector) {
+	t = math.Min(math.Max(t, 0), 1)
 	for i := 0; i < 3; i++ {
 		if tri.n[i] >= 0 && tri.mesh.normals != nil {
 			n[i] = tri.mesh.normals[tri.n[i]]
+			if tri.mesh.endNormals != nil {
+				n[i] = lerpVector(n[i], tri.mesh.endNormals[tri.n[i]], t)
+			}
 		}
 	}
 	return
@@ -89,12 +106,18 @@ func (tri *Triangle) getUVs() (uv [3]UV) {
 
 func (tri *Triangle) Intersect(r Ray) (coll Collision) {
 	coll.Ray = r
-	rayDepth, u, v := intersect(
-		tri.mesh.vertices[tri.v[0]],
-		tri.mesh.vertices[tri.v[1]],
-		tri.mesh.vertices[tri.v[2]],
-		r.Dir, r.From,
-	)
+	var rayDepth, u, v float64
+	if tri.mesh.Moving() {
+		vert := tri.verticesAt(r.Time)
+		rayDepth, u, v = intersect(vert[0], vert[1], vert[2], r.Dir, r.From)
+	} else {
+		rayDepth, u, v = intersect(
+			tri.mesh.vertices[tri.v[0]],
+			tri.mesh.vertices[tri.v[1]],
+			tri.mesh.vertices[tri.v[2]],
+			r.Dir, r.From,
+		)
+	}
 	if rayDepth < 0 {
 		return
 	}
@@ -107,7 +130,10 @@ func (tri *Triangle) Intersect(r Ray) (coll Collision) {
 
 func (tri *Triangle) Surface(coll Collision) (sp SurfacePoint) {
 	sp.GeometricNormal = tri.normal
-	vert := tri.getVertices()
+	vert := tri.verticesAt(coll.Ray.Time)
+	if tri.mesh.Moving() {
+		sp.GeometricNormal = vec64.Cross(vec64.Sub(vert[1], vert[0]), vec64.Sub(vert[2], vert[0])).Normalize()
+	}
 
 	// The u and v in intersection code are actually v and w
 	dat := coll.UserData.([2]float64)
@@ -115,17 +141,20 @@ func (tri *Triangle) Surface(coll Collision) (sp SurfacePoint) {
 	u := 1.0 - v - w
 
 	if tri.mesh.normals != nil {
-		n := tri.getNormals()
+		n := tri.getNormals(coll.Ray.Time)
 		sp.Normal = vec64.Sum(n[0].Scale(u), n[1].Scale(v), n[2].Scale(w)).Normalize()
 	} else {
-		sp.Normal = tri.normal
+		sp.Normal = sp.GeometricNormal
 	}
 
 	sp.HasOrco = tri.mesh.hasOrco
 	if tri.mesh.hasOrco {
 		// TODO: Yafaray uses index+1 for each one of the vertices. Why?
-		sp.OrcoPosition = vec64.Sum(vert[0].Scale(u), vert[1].Scale(v), vert[2].Scale(w))
-		sp.OrcoNormal = vec64.Cross(vec64.Sub(vert[1], vert[0]), vec64.Sub(vert[2], vert[0])).Normalize()
+		// Moving meshes use their starting position so that textures stick
+		// to the mesh.
+		orco := tri.getVertices()
+		sp.OrcoPosition = vec64.Sum(orco[0].Scale(u), orco[1].Scale(v), orco[2].Scale(w))
+		sp.OrcoNormal = vec64.Cross(vec64.Sub(orco[1], orco[0]), vec64.Sub(orco[2], orco[0])).Normalize()
 	} else {
 		sp.OrcoPosition = coll.Point()
 		sp.OrcoNormal = sp.GeometricNormal
@@ -175,8 +204,17 @@ func (tri *Triangle) Surface(coll Collision) (sp SurfacePoint) {
 	return
 }
 
+// Bound returns the triangle's bounding box.  The bound of a moving triangle
+// contains it at every time.
 func (tri *Triangle) Bound() (bd bound.Bound) {
-	v := tri.getVertices()
+	bd = triangleBound(tri.getVertices())
+	if tri.mesh.Moving() {
+		bd = bound.Union(bd, triangleBound(tri.verticesAt(1)))
+	}
+	return
+}
+
+func triangleBound(v [3]vec64.Vector) (bd bound.Bound) {
 	for axis := range bd.Min {
 		bd.Min[axis] = math.Min(math.Min(v[0][axis], v[1][axis]), v[2][axis])
 		bd.Max[axis] = math.Max(math.Max(v[0][axis], v[1][axis]), v[2][axis])
@@ -185,6 +223,10 @@ func (tri *Triangle) Bound() (bd bound.Bound) {
 }
 
 func (tri *Triangle) IntersectsBound(bd bound.Bound) bool {
+	if tri.mesh.Moving() {
+		_, overlap := bound.Intersection(tri.Bound(), bd)
+		return overlap
+	}
 	var points [3][3]float64
 	vert := tri.getVertices()
 	for i := 0; i < 3; i++ {
@@ -199,11 +241,17 @@ func (tri *Triangle) IntersectsBound(bd bound.Bound) bool {
 
 func (tri *Triangle) Material() Material { return tri.material }
 
-func (tri *Triangle) Clip(bound bound.Bound, axis vecutil.Axis, lower bool, oldData interface{}) (clipped bound.Bound, newData interface{}) {
+func (tri *Triangle) Clip(bd bound.Bound, axis vecutil.Axis, lower bool, oldData interface{}) (clipped bound.Bound, newData interface{}) {
+	if tri.mesh.Moving() {
+		// A moving triangle sweeps through its whole bound, so clip that
+		// instead of the triangle.
+		clipped, _ = bound.Intersection(tri.Bound(), bd)
+		return clipped, nil
+	}
 	if axis >= 0 {
-		return tri.clipPlane(bound, axis, lower, oldData)
+		return tri.clipPlane(bd, axis, lower, oldData)
 	}
-	return tri.clipBox(bound)
+	return tri.clipBox(bd)
 }
 
 func (tri *Triangle) clipPlane(bound bound.Bound, axis vecutil.Axis, lower bool, oldData interface{}) (clipped bound.Bound, newData interface{}) {
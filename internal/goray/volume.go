@@ -42,8 +42,14 @@ type VolumeRegion interface {
 	// Attenuation returns how much the volumetric effect dissipates over distance.
 	Attenuation(p vec64.Vector, l Light) float64
 
+	// P returns the phase function for light arriving from the direction l and
+	// leaving in the direction s.  Both directions point away from the point.
 	P(l, s vec64.Vector) float64
 
+	// Tau returns the optical thickness of the volume along a ray, between
+	// TMin and TMax (or without a limit if TMax is negative).  Volumes that
+	// are not uniform are ray marched with the given step size, starting at a
+	// fraction offset in [0, 1) of the first step.
 	Tau(r Ray, step, offset float64) color.Color
 
 	// Intersect returns whether a ray intersects the volume.
@@ -0,0 +1,736 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package integrators
+
+import (
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+type vertexKind int
+
+const (
+	cameraVertex vertexKind = iota
+	lightVertex
+	surfaceVertex
+	backgroundVertex
+)
+
+// pathVertex is a single vertex of a camera or light subpath.
+//
+// The PDFs of a vertex are area densities in the true measure: unlike the
+// BSDF and light PDFs in goray, directional densities are divided by pi
+// before they are converted, so that they can be compared with the area PDFs
+// of lights.
+type pathVertex struct {
+	kind    vertexKind
+	sp      goray.SurfacePoint
+	matData interface{}  // material data from InitBSDF
+	bsdfs   goray.BSDF   // BSDF components of the surface
+	light   goray.Light  // light that emitted the subpath (light vertices only)
+	wo      vec64.Vector // direction toward the previous vertex
+	beta    color.Color  // throughput of the subpath up to this vertex
+	delta   bool         // whether the next direction was sampled from a Dirac distribution
+
+	// scattered is the light that volumes scatter toward the previous vertex
+	// along the way to this one, weighted by the throughput.  Only camera
+	// subpaths gather it.
+	scattered color.Color
+
+	pdfFwd float64 // density of sampling this vertex from the previous one
+	pdfRev float64 // density of sampling this vertex from the next one, in the opposite direction
+}
+
+func (v *pathVertex) position() vec64.Vector {
+	return v.sp.Position
+}
+
+// onSurface reports whether the vertex lies on a surface with a normal.
+func (v *pathVertex) onSurface() bool {
+	switch v.kind {
+	case surfaceVertex:
+		return true
+	case lightVertex:
+		return !v.sp.Normal.IsZero()
+	}
+	return false
+}
+
+// connectible reports whether the vertex can be joined to another subpath.
+func (v *pathVertex) connectible() bool {
+	const connectFlags = goray.BSDFDiffuse | goray.BSDFGlossy | goray.BSDFDispersive
+	return v.kind == surfaceVertex && v.bsdfs&connectFlags != 0
+}
+
+// emitter returns the light that the vertex lies on, or nil.
+func (v *pathVertex) emitter() goray.Light {
+	if v.kind == lightVertex {
+		return v.light
+	}
+	return v.sp.Light
+}
+
+func isDeltaLight(l goray.Light) bool {
+	return l.LightFlags()&(goray.LightTypeDiracDir|goray.LightTypeSingular) != 0
+}
+
+// onSurfaces reports whether a light lies on the scene's surfaces, so that a
+// camera subpath can find it.  Lights that can only be intersected on their
+// own are left to the other strategies.
+func onSurfaces(l goray.Light) bool {
+	_, ok := l.(goray.LightIntersecter)
+	return !ok
+}
+
+// convertDensity converts a directional density at from toward to into an
+// area density at to.
+func convertDensity(pdfDir float64, from, to *pathVertex) float64 {
+	d := vec64.Sub(to.position(), from.position())
+	distSqr := d.LengthSqr()
+	if distSqr == 0 {
+		return 0
+	}
+	pdf := pdfDir / distSqr
+	if to.onSurface() {
+		pdf *= math.Abs(vec64.Dot(to.sp.Normal, d)) / math.Sqrt(distSqr)
+	}
+	return pdf
+}
+
+func remap0(f float64) float64 {
+	if f == 0 {
+		return 1
+	}
+	return f
+}
+
+type bidirTracer struct {
+	background goray.Background
+	camera     goray.Camera
+	maxDepth   int
+	numSamples int
+
+	// lights are the lights that can start a light subpath.  They are picked in
+	// proportion to their power.
+	lights     []goray.Light
+	lightPower sampleutil.Pdf1D
+	lightPick  map[goray.Light]float64
+
+	// directLights are only reached by next event estimation, like the
+	// background's light and other lights at infinity.
+	directLights []goray.Light
+
+	splats *goray.SplatBuffer
+}
+
+var _ goray.SurfaceIntegrator = &bidirTracer{}
+var _ goray.SplatIntegrator = &bidirTracer{}
+
+// NewBidirectional creates a new bidirectional path tracing integrator.
+//
+// For each of the numSamples samples in a pixel, a subpath is traced from the
+// camera and another from a light, and every pair of their vertices is
+// connected.  The strategies are combined with multiple importance sampling.
+// Paths are at most maxDepth bounces long.  Light that is traced from a light
+// directly to the camera is returned by Splats.
+func NewBidirectional(maxDepth, numSamples int) goray.SurfaceIntegrator {
+	if numSamples < 1 {
+		numSamples = 1
+	}
+	if maxDepth < 0 {
+		maxDepth = 0
+	}
+	return &bidirTracer{
+		maxDepth:   maxDepth,
+		numSamples: numSamples,
+	}
+}
+
+func (bt *bidirTracer) SurfaceIntegrator() {}
+
+func (bt *bidirTracer) Preprocess(sc *goray.Scene) {
+	bt.camera = sc.Camera()
+	bt.background = sc.Background()
+	bt.splats = goray.NewSplatBuffer(bt.camera.ResolutionX(), bt.camera.ResolutionY())
+
+	bt.lights, bt.directLights = nil, nil
+	energies := make([]float64, 0, len(sc.Lights()))
+	totalEnergy := 0.0
+	for _, l := range sc.Lights() {
+		e := color.Energy(l.TotalEnergy())
+		if l.LightFlags()&(goray.LightTypeDiracDir|goray.LightTypeInfinite) != 0 || e <= 0 {
+			bt.directLights = append(bt.directLights, l)
+			continue
+		}
+		bt.lights = append(bt.lights, l)
+		energies = append(energies, e)
+		totalEnergy += e
+	}
+	bt.directLights = append(bt.directLights, goray.BackgroundLights(bt.background)...)
+
+	bt.lightPick = make(map[goray.Light]float64, len(bt.lights))
+	if len(bt.lights) > 0 {
+		bt.lightPower = sampleutil.NewPdf1D(energies)
+		for i, l := range bt.lights {
+			bt.lightPick[l] = energies[i] / totalEnergy
+		}
+	}
+}
+
+func (bt *bidirTracer) Splats() *goray.SplatBuffer {
+	return bt.splats
+}
+
+func (bt *bidirTracer) Integrate(sc *goray.Scene, state *goray.RenderState, r goray.DifferentialRay) color.AlphaColor {
+	level := state.RayLevel
+	defer func() {
+		state.RayLevel = level
+	}()
+
+	// Light subpaths are traced even if the camera ray misses the scene;
+	// otherwise the light traced to the camera would be too dim.
+	alpha := 0.0
+	coll := sc.Intersect(r.Ray, -1)
+	if coll.Hit() {
+		alpha = 1.0
+		if state.AOVs != nil {
+			sp := coll.Surface()
+			sp.Material.(goray.Material).InitBSDF(state, sp)
+			goray.RecordSurface(sc, state, coll, sp)
+		}
+	} else if bt.background != nil {
+		alpha = 1.0
+	}
+
+	var u, v float64
+	camPdf, ok := bt.camera.Project(r.Ray, &u, &v)
+	if !ok {
+		camPdf = 0
+	}
+
+	var light lightSplit
+	col := colorSum(bt.numSamples, func(i int) color.Color {
+		return bt.samplePath(sc, state, r.Ray, coll, camPdf, &light)
+	})
+	state.RayLevel = level
+	light.record(state, 1/float64(bt.numSamples))
+	return color.NewRGBAFromColor(color.ScalarDiv(col, float64(bt.numSamples)), alpha)
+}
+
+// samplePath traces a camera subpath and a light subpath and evaluates all of
+// the ways of connecting them.  coll is the camera ray's intersection with the
+// scene, which is the same for every path.  Light traced to the camera is
+// added to the splat buffer; everything else is returned and added to light,
+// which does not include the splats.
+func (bt *bidirTracer) samplePath(sc *goray.Scene, state *goray.RenderState, r goray.Ray, coll goray.Collision, camPdf float64, light *lightSplit) color.Color {
+	cam := bt.cameraSubpath(sc, state, r, coll, camPdf)
+	lit := bt.lightSubpath(sc, state)
+
+	maxS := len(lit)
+	if maxS == 0 && len(bt.lights) > 0 {
+		// Next event estimation does not need the light subpath.
+		maxS = 1
+	}
+
+	col := color.Black
+	add := func(bounces int, c color.Color) {
+		col = color.Add(col, c)
+		if state.AOVs != nil {
+			light.add(bounces, c)
+		}
+	}
+	for t := 1; t <= len(cam); t++ {
+		if t >= 2 && cam[t-1].scattered != nil {
+			add(t-1, cam[t-1].scattered)
+		}
+		if t >= 2 && t-1 <= bt.maxDepth && len(bt.directLights) > 0 && cam[t-1].connectible() {
+			pt := &cam[t-1]
+			state.MaterialData = pt.matData
+			direct := estimateDirectPH(state, pt.sp, bt.directLights, sc, pt.wo, false, 0)
+			add(t-1, color.Mul(pt.beta, direct))
+		}
+		for s := 0; s <= maxS; s++ {
+			depth := s + t - 2
+			if (s == 1 && t == 1) || depth < 0 || depth > bt.maxDepth {
+				continue
+			}
+			switch {
+			case s == 0:
+				add(depth, bt.connectEmitter(state, cam[:t]))
+			case t == 1:
+				bt.connectCamera(sc, state, lit[:s], cam[0])
+			case s == 1:
+				add(depth, bt.connectLight(sc, state, cam[:t]))
+			default:
+				add(depth, bt.connect(sc, state, lit[:s], cam[:t]))
+			}
+		}
+	}
+	return col
+}
+
+// cameraSubpath traces a subpath from the camera, whose ray r hits the scene
+// at coll.  If the subpath leaves the scene, it ends with a background vertex.
+func (bt *bidirTracer) cameraSubpath(sc *goray.Scene, state *goray.RenderState, r goray.Ray, coll goray.Collision, camPdf float64) []pathVertex {
+	path := make([]pathVertex, 1, bt.maxDepth+2)
+	path[0] = pathVertex{
+		kind:  cameraVertex,
+		sp:    goray.SurfacePoint{Position: r.From},
+		beta:  color.White,
+		delta: camPdf == 0,
+	}
+	return bt.randomWalk(sc, state, path, r, coll, color.White, camPdf, bt.maxDepth+2)
+}
+
+// lightSubpath traces a subpath from a light picked in proportion to its
+// power.
+func (bt *bidirTracer) lightSubpath(sc *goray.Scene, state *goray.RenderState) []pathVertex {
+	if len(bt.lights) == 0 {
+		return nil
+	}
+	lightNum, _ := bt.lightPower.DiscreteSample(state.Sampler.Get1D())
+	l := bt.lights[lightNum]
+	pick := bt.lightPick[l]
+
+	var ls goray.LightSample
+	ls.S1, ls.S2 = state.Sampler.Get2D()
+	ls.S3, ls.S4 = state.Sampler.Get2D()
+	ls.Time = state.Time
+	wo, col := l.EmitSample(&ls)
+	if ls.AreaPdf <= pdfCutoff || ls.DirPdf <= pdfCutoff || color.IsBlack(col) {
+		return nil
+	}
+	_, _, cosWo := l.EmitPdf(ls.Point, wo)
+
+	path := make([]pathVertex, 1, bt.maxDepth+1)
+	path[0] = pathVertex{
+		kind:   lightVertex,
+		sp:     ls.Point,
+		light:  l,
+		beta:   color.ScalarDiv(col, pick*ls.AreaPdf),
+		pdfFwd: pick * ls.AreaPdf,
+	}
+	beta := color.ScalarMul(col, math.Abs(cosWo)/(pick*ls.AreaPdf*ls.DirPdf))
+	r := goray.Ray{
+		From: ls.Point.Position,
+		Dir:  wo,
+		TMin: raySelfBias,
+		TMax: -1.0,
+		Time: state.Time,
+	}
+	return bt.randomWalk(sc, state, path, r, sc.Intersect(r, -1), beta, ls.DirPdf/math.Pi, bt.maxDepth+1)
+}
+
+// randomWalk extends a subpath by sampling BSDFs until it has maxVerts
+// vertices or the path is absorbed.  r is the ray leaving the last vertex of
+// path and coll is its intersection with the scene, beta is the throughput
+// carried by r, and pdfDir is the density of sampling r's direction.
+func (bt *bidirTracer) randomWalk(sc *goray.Scene, state *goray.RenderState, path []pathVertex, r goray.Ray, coll goray.Collision, beta color.Color, pdfDir float64, maxVerts int) []pathVertex {
+	for len(path) < maxVerts {
+		state.RayLevel = len(path) - 1
+		prev := len(path) - 1
+
+		var scattered color.Color
+		if path[0].kind == cameraVertex {
+			var tr color.Color
+			scattered, tr = goray.TraceVolume(sc, state, segment(r, coll))
+			scattered = color.Mul(beta, scattered)
+			beta = color.Mul(beta, tr)
+		} else {
+			beta = color.Mul(beta, transmittance(sc, state, segment(r, coll)))
+		}
+		if !coll.Hit() {
+			if path[0].kind == cameraVertex {
+				path = append(path, pathVertex{
+					kind:      backgroundVertex,
+					sp:        goray.SurfacePoint{Position: r.From},
+					wo:        r.Dir.Negate(),
+					beta:      beta,
+					scattered: scattered,
+				})
+			}
+			break
+		}
+
+		sp := coll.Surface()
+		mat := sp.Material.(goray.Material)
+		v := pathVertex{
+			kind:  surfaceVertex,
+			sp:    sp,
+			bsdfs: mat.InitBSDF(state, sp),
+			wo:    r.Dir.Negate(),
+			beta:  beta,
+
+			scattered: scattered,
+		}
+		v.matData = state.MaterialData
+		v.pdfFwd = convertDensity(pdfDir, &path[prev], &v)
+		path = append(path, v)
+		if len(path) >= maxVerts {
+			break
+		}
+
+		curr := &path[len(path)-1]
+		s := goray.NewMaterialSample(state.Sampler.Get2D())
+		surfCol, wi := mat.Sample(state, sp, curr.wo, &s)
+		if s.Pdf <= pdfCutoff || color.IsBlack(surfCol) {
+			break
+		}
+		beta = color.Mul(beta, color.ScalarMul(surfCol, math.Abs(vec64.Dot(sp.Normal, wi))/s.Pdf))
+
+		pdfRev := 0.0
+		if s.SampledFlags&(goray.BSDFSpecular|goray.BSDFFilter) != 0 {
+			curr.delta = true
+			pdfDir = 0
+		} else {
+			pdfDir = mat.Pdf(state, sp, curr.wo, wi, nonSpecularBSDF) / math.Pi
+			pdfRev = mat.Pdf(state, sp, wi, curr.wo, nonSpecularBSDF) / math.Pi
+		}
+		path[prev].pdfRev = convertDensity(pdfRev, curr, &path[prev])
+
+		r = goray.Ray{
+			From: sp.Position,
+			Dir:  wi,
+			TMin: raySelfBias,
+			TMax: -1.0,
+			Time: state.Time,
+		}
+		coll = sc.Intersect(r, -1)
+	}
+	return path
+}
+
+// connectEmitter evaluates the strategy that uses only the camera subpath,
+// which must have found a light source on its own.
+func (bt *bidirTracer) connectEmitter(state *goray.RenderState, cam []pathVertex) color.Color {
+	pt := &cam[len(cam)-1]
+	switch pt.kind {
+	case backgroundVertex:
+		// The background's light is handled by next event estimation unless
+		// it could not have been sampled.
+		prev := &cam[len(cam)-2]
+		if bt.background == nil || (bt.background.Light() != nil && !prev.delta && prev.kind != cameraVertex) {
+			return color.Black
+		}
+		r := goray.Ray{From: pt.position(), Dir: pt.wo.Negate(), TMax: -1.0, Time: state.Time}
+		return color.Mul(pt.beta, bt.background.Color(r, state, false))
+	case surfaceVertex:
+		emat, ok := pt.sp.Material.(goray.EmitMaterial)
+		if !ok {
+			return color.Black
+		}
+		state.MaterialData = pt.matData
+		emit := emat.Emit(state, pt.sp, pt.wo)
+		if color.IsBlack(emit) {
+			return color.Black
+		}
+		col := color.Mul(pt.beta, emit)
+		if l := pt.sp.Light; l != nil && bt.lightPick[l] > 0 {
+			col = color.ScalarMul(col, bt.misWeight(state, nil, cam))
+		}
+		return col
+	}
+	return color.Black
+}
+
+// connectCamera evaluates the strategy that traces light to the camera and
+// adds the result to the splat buffer.
+func (bt *bidirTracer) connectCamera(sc *goray.Scene, state *goray.RenderState, lit []pathVertex, eye pathVertex) {
+	qs := &lit[len(lit)-1]
+	if eye.delta || !qs.connectible() {
+		return
+	}
+	d := vec64.Sub(qs.position(), eye.position())
+	distSqr := d.LengthSqr()
+	if distSqr == 0 {
+		return
+	}
+	dist := math.Sqrt(distSqr)
+	d = d.Scale(1 / dist)
+
+	var u, v float64
+	camPdf, ok := bt.camera.Project(goray.Ray{From: eye.position(), Dir: d, TMax: -1.0, Time: state.Time}, &u, &v)
+	if !ok || camPdf <= 0 {
+		return
+	}
+	tr, visible := bt.visible(sc, state, qs.position(), eye.position())
+	if !visible {
+		return
+	}
+
+	state.MaterialData = qs.matData
+	mat := qs.sp.Material.(goray.Material)
+	surfCol := mat.Eval(state, qs.sp, qs.wo, d.Negate(), goray.BSDFAll)
+	if color.IsBlack(surfCol) {
+		return
+	}
+	// Camera importance carries the same factor of pi as the BSDFs.
+	col := color.Mul(color.Mul(qs.beta, surfCol), tr)
+	col = color.ScalarMul(col, math.Abs(vec64.Dot(qs.sp.Normal, d))/distSqr*math.Pi*camPdf)
+	col = color.ScalarMul(col, bt.misWeight(state, lit, []pathVertex{eye})/float64(bt.numSamples))
+	bt.splats.Add(u, v, col)
+}
+
+// connectLight evaluates the strategy that samples a new point on a light for
+// the last vertex of the camera subpath.
+func (bt *bidirTracer) connectLight(sc *goray.Scene, state *goray.RenderState, cam []pathVertex) color.Color {
+	pt := &cam[len(cam)-1]
+	if !pt.connectible() {
+		return color.Black
+	}
+	lightNum, _ := bt.lightPower.DiscreteSample(state.Sampler.Get1D())
+	l := bt.lights[lightNum]
+	pick := bt.lightPick[l]
+
+	state.MaterialData = pt.matData
+	lightRay := goray.Ray{
+		From: pt.position(),
+		TMax: -1.0,
+		Time: state.Time,
+	}
+	ls := goray.LightSample{}
+	ls.S1, ls.S2 = state.Sampler.Get2D()
+	var lcol color.Color
+	if dl, ok := l.(goray.DiracLight); ok {
+		if lcol, ok = dl.Illuminate(pt.sp, &lightRay); !ok {
+			return color.Black
+		}
+	} else {
+		if ok := l.IlluminateSample(pt.sp, &lightRay, &ls); !ok || ls.Pdf <= pdfCutoff {
+			return color.Black
+		}
+		lcol = color.ScalarDiv(ls.Color, ls.Pdf)
+	}
+	lightRay.TMin = raySelfBias
+	if sc.Shadowed(lightRay, math.Inf(1)) {
+		return color.Black
+	}
+	lcol = color.Mul(lcol, transmittance(sc, state, lightRay))
+
+	mat := pt.sp.Material.(goray.Material)
+	surfCol := mat.Eval(state, pt.sp, pt.wo, lightRay.Dir, goray.BSDFAll)
+	if color.IsBlack(surfCol) {
+		return color.Black
+	}
+	col := color.Mul(pt.beta, color.Mul(surfCol, lcol))
+	col = color.ScalarMul(col, math.Abs(vec64.Dot(pt.sp.Normal, lightRay.Dir))/pick)
+
+	// Build the light vertex that would have been sampled to weight the strategy.
+	sampled := pathVertex{kind: lightVertex, sp: ls.Point, light: l}
+	sampled.sp.Position = pt.position()
+	if lightRay.TMax >= 0 {
+		sampled.sp.Position = vec64.Add(pt.position(), lightRay.Dir.Scale(lightRay.TMax))
+	}
+	areaPdf, _, _ := l.EmitPdf(sampled.sp, lightRay.Dir.Negate())
+	sampled.pdfFwd = pick * areaPdf
+	return color.ScalarMul(col, bt.misWeight(state, []pathVertex{sampled}, cam))
+}
+
+// connect evaluates the strategy that joins the last vertices of a light
+// subpath and a camera subpath with at least two vertices each.
+func (bt *bidirTracer) connect(sc *goray.Scene, state *goray.RenderState, lit, cam []pathVertex) color.Color {
+	qs, pt := &lit[len(lit)-1], &cam[len(cam)-1]
+	if !qs.connectible() || !pt.connectible() {
+		return color.Black
+	}
+	d := vec64.Sub(qs.position(), pt.position())
+	distSqr := d.LengthSqr()
+	if distSqr == 0 {
+		return color.Black
+	}
+	d = d.Scale(1 / math.Sqrt(distSqr))
+
+	state.MaterialData = pt.matData
+	ptCol := pt.sp.Material.(goray.Material).Eval(state, pt.sp, pt.wo, d, goray.BSDFAll)
+	if color.IsBlack(ptCol) {
+		return color.Black
+	}
+	state.MaterialData = qs.matData
+	qsCol := qs.sp.Material.(goray.Material).Eval(state, qs.sp, qs.wo, d.Negate(), goray.BSDFAll)
+	if color.IsBlack(qsCol) {
+		return color.Black
+	}
+	tr, visible := bt.visible(sc, state, pt.position(), qs.position())
+	if !visible {
+		return color.Black
+	}
+
+	g := math.Abs(vec64.Dot(pt.sp.Normal, d)) * math.Abs(vec64.Dot(qs.sp.Normal, d)) / distSqr
+	col := color.Mul(color.Mul(color.Mul(pt.beta, ptCol), color.Mul(qs.beta, qsCol)), tr)
+	return color.ScalarMul(col, g*bt.misWeight(state, lit, cam))
+}
+
+// visible reports whether nothing lies between two points.  If so, the
+// fraction of light that passes through the scene's volumes between them is
+// also returned.
+func (bt *bidirTracer) visible(sc *goray.Scene, state *goray.RenderState, p, q vec64.Vector) (tr color.Color, ok bool) {
+	d := vec64.Sub(q, p)
+	dist := d.Length()
+	r := goray.Ray{
+		From: p,
+		Dir:  d.Scale(1 / dist),
+		TMin: raySelfBias,
+		TMax: dist,
+		Time: state.Time,
+	}
+	if sc.Shadowed(r, dist) {
+		return color.Black, false
+	}
+	return transmittance(sc, state, r), true
+}
+
+// pdf returns the area density of sampling next from v, given that v was
+// reached from prev.
+func (bt *bidirTracer) pdf(state *goray.RenderState, v, prev, next *pathVertex) float64 {
+	if v.kind == lightVertex {
+		return bt.pdfLight(v, next)
+	}
+	wn := vec64.Sub(next.position(), v.position()).Normalize()
+	var pdfDir float64
+	switch v.kind {
+	case cameraVertex:
+		var lu, lv float64
+		pdfDir, _ = bt.camera.Project(goray.Ray{From: v.position(), Dir: wn, TMax: -1.0, Time: state.Time}, &lu, &lv)
+	case surfaceVertex:
+		wp := vec64.Sub(prev.position(), v.position()).Normalize()
+		state.MaterialData = v.matData
+		pdfDir = v.sp.Material.(goray.Material).Pdf(state, v.sp, wp, wn, nonSpecularBSDF) / math.Pi
+	}
+	return convertDensity(pdfDir, v, next)
+}
+
+// pdfLight returns the area density of the emitter at v sending light to next.
+func (bt *bidirTracer) pdfLight(v, next *pathVertex) float64 {
+	wn := vec64.Sub(next.position(), v.position()).Normalize()
+	_, dirPdf, _ := v.emitter().EmitPdf(v.sp, wn)
+	return convertDensity(dirPdf/math.Pi, v, next)
+}
+
+// pdfLightOrigin returns the area density of a light subpath starting at the
+// emitter at v toward next.
+func (bt *bidirTracer) pdfLightOrigin(v, next *pathVertex) float64 {
+	l := v.emitter()
+	wn := vec64.Sub(next.position(), v.position()).Normalize()
+	areaPdf, _, _ := l.EmitPdf(v.sp, wn)
+	return bt.lightPick[l] * areaPdf
+}
+
+// misWeight computes the balance heuristic weight of the strategy that joins
+// the given light and camera subpaths, by comparing the densities of every
+// other strategy that could have produced the same path.
+func (bt *bidirTracer) misWeight(state *goray.RenderState, lit, cam []pathVertex) float64 {
+	s, t := len(lit), len(cam)
+	if s+t == 2 {
+		return 1
+	}
+
+	// Work on copies so the connection does not change the subpaths.
+	lit = append([]pathVertex(nil), lit...)
+	cam = append([]pathVertex(nil), cam...)
+	var qs, qsMinus, pt, ptMinus *pathVertex
+	if s > 0 {
+		qs = &lit[s-1]
+		qs.delta = false
+	}
+	if s > 1 {
+		qsMinus = &lit[s-2]
+	}
+	if t > 0 {
+		pt = &cam[t-1]
+		pt.delta = false
+	}
+	if t > 1 {
+		ptMinus = &cam[t-2]
+	}
+
+	// Update the reverse densities of the vertices around the connection.
+	var ptRev, ptMinusRev, qsRev, qsMinusRev float64
+	if pt != nil {
+		if s > 0 {
+			ptRev = bt.pdf(state, qs, qsMinus, pt)
+		} else {
+			ptRev = bt.pdfLightOrigin(pt, ptMinus)
+		}
+	}
+	if ptMinus != nil {
+		if s > 0 {
+			ptMinusRev = bt.pdf(state, pt, qs, ptMinus)
+		} else {
+			ptMinusRev = bt.pdfLight(pt, ptMinus)
+		}
+	}
+	if qs != nil {
+		qsRev = bt.pdf(state, pt, ptMinus, qs)
+	}
+	if qsMinus != nil {
+		qsMinusRev = bt.pdf(state, qs, pt, qsMinus)
+	}
+	if pt != nil {
+		pt.pdfRev = ptRev
+	}
+	if ptMinus != nil {
+		ptMinus.pdfRev = ptMinusRev
+	}
+	if qs != nil {
+		qs.pdfRev = qsRev
+	}
+	if qsMinus != nil {
+		qsMinus.pdfRev = qsMinusRev
+	}
+
+	sumRi := 0.0
+	ri := 1.0
+	for i := t - 1; i > 0; i-- {
+		ri *= remap0(cam[i].pdfRev) / remap0(cam[i].pdfFwd)
+		if !cam[i].delta && !cam[i-1].delta {
+			sumRi += ri
+		}
+	}
+	ri = 1.0
+	for i := s - 1; i >= 0; i-- {
+		ri *= remap0(lit[i].pdfRev) / remap0(lit[i].pdfFwd)
+		deltaLight := isDeltaLight(lit[0].light)
+		if i > 0 {
+			deltaLight = lit[i-1].delta
+		}
+		if !lit[i].delta && !deltaLight && (i > 0 || onSurfaces(lit[0].light)) {
+			sumRi += ri
+		}
+	}
+	return 1 / (1 + sumRi)
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"integrators/bidirectional"] = yamlscene.MapConstruct(constructBidirectional)
+}
+
+func constructBidirectional(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	maxDepth, _ := yamldata.AsInt(m.SetDefault("maxDepth", 5))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	return NewBidirectional(maxDepth, samples), nil
+}
@@ -42,7 +42,8 @@ type directLighting struct {
 	aoDist    float64
 	aoColor   color.Color
 
-	lights []goray.Light
+	lights     []goray.Light
+	causticMap *goray.PhotonMap
 }
 
 // NewDirectLight creates a new direct lighting integrator.
@@ -67,10 +68,11 @@ func (dl *directLighting) Preprocess(sc *goray.Scene) {
 	copy(dl.lights, sceneLights)
 	// Set up background
 	dl.background = sc.Background()
-	if dl.background != nil {
-		if bgLight := dl.background.Light(); bgLight != nil {
-			dl.lights = append(dl.lights, bgLight)
-		}
+	dl.lights = append(dl.lights, goray.BackgroundLights(dl.background)...)
+	// Build caustic photon map
+	if dl.caustics {
+		dl.causticMap = goray.NewMap()
+		shootPhotons(sc, dl.lights, dl.numPhotons, dl.causticsDepth, dl.causticMap, nil)
 	}
 	return
 }
@@ -82,7 +84,8 @@ func (dl *directLighting) Integrate(sc *goray.Scene, state *goray.RenderState, r
 		state.IncludeLights = il
 	}(state.IncludeLights)
 
-	if coll := sc.Intersect(r.Ray, -1); coll.Hit() {
+	coll := sc.Intersect(r.Ray, -1)
+	if coll.Hit() {
 		sp := coll.Surface()
 
 		// Camera ray
@@ -93,23 +96,33 @@ func (dl *directLighting) Integrate(sc *goray.Scene, state *goray.RenderState, r
 		mat := sp.Material.(goray.Material)
 		bsdfs := mat.InitBSDF(state, sp)
 		wo := r.Dir.Negate()
+		goray.RecordSurface(sc, state, coll, sp)
 
 		// Contribution of light-emitting surfaces
 		if emat, ok := mat.(goray.EmitMaterial); ok {
-			col = color.Add(col, emat.Emit(state, sp, wo))
+			emit := emat.Emit(state, sp, wo)
+			state.AddLight(0, emit)
+			col = color.Add(col, emit)
 		}
 
 		// Normal lighting
 		if bsdfs&(goray.BSDFGlossy|goray.BSDFDiffuse|goray.BSDFDispersive) != 0 {
-			col = color.Add(col, estimateDirectPH(state, sp, dl.lights, sc, wo, dl.transparentShadows, dl.shadowDepth))
+			direct := estimateDirectPH(state, sp, dl.lights, sc, wo, dl.transparentShadows, dl.shadowDepth)
+			state.AddLight(1, direct)
+			col = color.Add(col, direct)
 		}
-		if bsdfs&(goray.BSDFDiffuse|goray.BSDFGlossy) != 0 {
-			// TODO: estimatePhotons
+		if bsdfs&(goray.BSDFDiffuse|goray.BSDFGlossy) != 0 && dl.caustics {
+			caustics := estimatePhotons(state, sp, dl.causticMap, wo, dl.numSearch, dl.causticsRadius*dl.causticsRadius)
+			state.AddLight(2, caustics)
+			col = color.Add(col, caustics)
 		}
 		if bsdfs&goray.BSDFDiffuse != 0 && dl.doAO {
-			col = color.Add(col, sampleAO(sc, state, sp, wo, dl.aoSamples, dl.aoDist, dl.aoColor))
+			ao := sampleAO(sc, state, sp, wo, dl.aoSamples, dl.aoDist, dl.aoColor)
+			state.AddLight(1, ao)
+			col = color.Add(col, ao)
 		}
 
+		reflected := color.Black
 		state.RayLevel++
 		if state.RayLevel <= dl.rayDepth {
 			// Dispersive effects with recursive raytracing
@@ -134,12 +147,13 @@ func (dl *directLighting) Integrate(sc *goray.Scene, state *goray.RenderState, r
 							Dir:  dir[0],
 							TMin: 0.0005,
 							TMax: -1.0,
+							Time: state.Time,
 						},
 					}
 
 					integ := dl.Integrate(sc, state, refRay)
-					// TODO: Multiply by volume integrator result
 					col = color.Add(col, color.Mul(integ, rcol[0]))
+					reflected = color.Add(reflected, color.Mul(integ, rcol[0]))
 				}
 				if refract {
 					refRay := goray.DifferentialRay{
@@ -148,26 +162,30 @@ func (dl *directLighting) Integrate(sc *goray.Scene, state *goray.RenderState, r
 							Dir:  dir[1],
 							TMin: 0.0005,
 							TMax: -1.0,
+							Time: state.Time,
 						},
 					}
 
 					integ := dl.Integrate(sc, state, refRay)
-					// TODO: Multiply by volume integrator result
 					col, alpha = color.Add(col, color.Mul(integ, rcol[1])), integ.Alpha()
+					reflected = color.Add(reflected, color.Mul(integ, rcol[1]))
 				}
 			}
 		}
 		state.RayLevel--
+		state.AddLight(2, reflected)
 
 		matAlpha := mat.Alpha(state, sp, wo)
 		alpha = matAlpha + (1-matAlpha)*alpha
 	} else {
 		// Nothing was hit, use the background.
 		if dl.background != nil {
-			col = color.Add(col, dl.background.Color(r.Ray, state, false))
+			bg := dl.background.Color(r.Ray, state, false)
+			state.AddLight(0, bg)
+			col, alpha = color.Add(col, bg), 1.0
 		}
 	}
-	return color.NewRGBAFromColor(col, alpha)
+	return goray.IntegrateVolume(sc, state, segment(r.Ray, coll), color.NewRGBAFromColor(col, alpha))
 }
 
 func init() {
@@ -178,5 +196,19 @@ func constructDirectLight(m yamldata.Map) (interface{}, error) {
 	trShad, _ := yamldata.AsBool(m["transparentShadows"])
 	shadowDepth, _ := yamldata.AsInt(m["shadowDepth"])
 	rayDepth, _ := yamldata.AsInt(m["rayDepth"])
-	return NewDirectLight(trShad, shadowDepth, rayDepth), nil
+	dl := NewDirectLight(trShad, shadowDepth, rayDepth).(*directLighting)
+	dl.caustics, _ = yamldata.AsBool(m["caustics"])
+	if photons, ok := yamldata.AsInt(m["photons"]); ok {
+		dl.numPhotons = photons
+	}
+	if search, ok := yamldata.AsInt(m["search"]); ok {
+		dl.numSearch = search
+	}
+	if depth, ok := yamldata.AsInt(m["causticsDepth"]); ok {
+		dl.causticsDepth = depth
+	}
+	if radius, ok := yamldata.AsFloat(m["causticsRadius"]); ok {
+		dl.causticsRadius = radius
+	}
+	return dl, nil
 }
@@ -0,0 +1,261 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package integrators
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/intersect"
+	"zombiezen.com/go/goray/internal/log"
+	"zombiezen.com/go/goray/internal/yamlscene"
+
+	_ "zombiezen.com/go/goray/internal/cameras"
+	_ "zombiezen.com/go/goray/internal/lights"
+	_ "zombiezen.com/go/goray/internal/materials"
+	_ "zombiezen.com/go/goray/internal/volumes"
+)
+
+const sceneHeader = `%YAML 1.2
+%TAG !goray! tag:goray/
+%TAG !std! tag:goray/std/
+---
+`
+
+// furnaceScene is a closed box that glows and reflects half of the light
+// that reaches it.  Every point inside sees a radiance of
+// emit / (1 - albedo) = 0.5.
+const furnaceScene = sceneHeader + `objects:
+   -  &box !std!objects/mesh
+      vertices:
+         -  [-1.0, -1.0, -1.0]
+         -  [1.0, -1.0, -1.0]
+         -  [1.0, 1.0, -1.0]
+         -  [-1.0, 1.0, -1.0]
+         -  [-1.0, -1.0, 1.0]
+         -  [1.0, -1.0, 1.0]
+         -  [1.0, 1.0, 1.0]
+         -  [-1.0, 1.0, 1.0]
+      faces:
+         -  vertices: [0, 1, 2]
+            material: &glow !std!materials/shinydiffuse
+               color: !goray!rgb [1.0, 1.0, 1.0]
+               mirrorColor: !goray!rgb [1.0, 1.0, 1.0]
+               diffuseReflect: 0.5
+               emit: !goray!rgb [0.25, 0.25, 0.25]
+         -  {vertices: [0, 2, 3], material: *glow}
+         -  {vertices: [5, 4, 7], material: *glow}
+         -  {vertices: [5, 7, 6], material: *glow}
+         -  {vertices: [4, 0, 3], material: *glow}
+         -  {vertices: [4, 3, 7], material: *glow}
+         -  {vertices: [1, 5, 6], material: *glow}
+         -  {vertices: [1, 6, 2], material: *glow}
+         -  {vertices: [3, 2, 6], material: *glow}
+         -  {vertices: [3, 6, 7], material: *glow}
+         -  {vertices: [4, 5, 1], material: *glow}
+         -  {vertices: [4, 1, 0], material: *glow}
+lights:
+   -  !std!lights/mesh
+      mesh: *box
+      samples: 4
+camera: !std!cameras/perspective
+   position: !goray!vec [0.0, 0.0, 0.0]
+   look: !goray!vec [0.0, 0.0, -1.0]
+   up: !goray!vec [0.0, 1.0, 0.0]
+   width: 8
+   height: 8
+   focalDistance: 1.0
+`
+
+// diffuseScene is an open box lit by an area light.
+const diffuseScene = sceneHeader + `objects:
+   -  !std!objects/mesh
+      vertices:
+         -  [-2.0, 0.0, -2.0]
+         -  [2.0, 0.0, -2.0]
+         -  [2.0, 0.0, 2.0]
+         -  [-2.0, 0.0, 2.0]
+         -  [-2.0, 3.0, -2.0]
+         -  [2.0, 3.0, -2.0]
+      faces:
+         -  vertices: [2, 1, 0]
+            material: &white !std!materials/shinydiffuse
+               color: !goray!rgb [0.8, 0.8, 0.8]
+               mirrorColor: !goray!rgb [1.0, 1.0, 1.0]
+               diffuseReflect: 1.0
+         -  {vertices: [0, 3, 2], material: *white}
+         -  {vertices: [0, 1, 5], material: *white}
+         -  {vertices: [0, 5, 4], material: *white}
+lights:
+   -  !std!lights/area
+      corner: !goray!vec [-0.5, 2.5, -0.5]
+      point1: !goray!vec [0.5, 2.5, -0.5]
+      point2: !goray!vec [-0.5, 2.5, 0.5]
+      intensity: 2.0
+      samples: 16
+camera: !std!cameras/perspective
+   position: !goray!vec [0.0, 1.5, 5.5]
+   look: !goray!vec [0.0, 1.5, 0.0]
+   up: !goray!vec [0.0, 2.5, 5.5]
+   width: 16
+   height: 16
+   focalDistance: 1.0
+`
+
+var testLog = log.New(ioutil.Discard)
+
+func render(t *testing.T, scene string) *goray.Image {
+	sc := goray.NewScene(goray.IntersecterBuilder(intersect.NewKD), testLog)
+	integ, err := yamlscene.Load(strings.NewReader(scene), sc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return goray.Render(sc, integ, testLog)
+}
+
+// renderMean renders a scene and returns the mean of its pixels' channels.
+func renderMean(t *testing.T, scene string) float64 {
+	img := render(t, scene)
+	sum := 0.0
+	for _, c := range img.Pix {
+		sum += c.Red() + c.Green() + c.Blue()
+	}
+	return sum / float64(3*len(img.Pix))
+}
+
+func TestPathTraceFurnace(t *testing.T) {
+	const expected = 0.5
+	mean := renderMean(t, furnaceScene+`integrator: !std!integrators/pathtrace
+   maxDepth: 40
+   minDepth: 3
+   samples: 32
+...
+`)
+	if math.Abs(mean-expected) > 0.03*expected {
+		t.Errorf("mean radiance = %.4f; want %.4f", mean, expected)
+	}
+}
+
+func TestPathTraceMatchesDirectLight(t *testing.T) {
+	want := renderMean(t, diffuseScene+`integrator: !std!integrators/directlight
+...
+`)
+	got := renderMean(t, diffuseScene+`integrator: !std!integrators/pathtrace
+   maxDepth: 0
+   samples: 4
+...
+`)
+	if math.Abs(got-want) > 0.02*want {
+		t.Errorf("pathtrace mean = %.4f; directlight mean = %.4f", got, want)
+	}
+}
+
+// fog fills diffuseScene with a scattering medium.
+const fog = `volumeIntegrator: !std!integrators/singlescatter
+   stepSize: 0.1
+volumes:
+   -  !std!volumes/homogeneous
+      min: !goray!vec [-2.0, 0.0, -2.0]
+      max: !goray!vec [2.0, 3.0, 2.0]
+      sigmaS: !goray!rgb [0.2, 0.2, 0.2]
+      sigmaA: !goray!rgb [0.05, 0.05, 0.05]
+`
+
+func TestVolumeIndirect(t *testing.T) {
+	clear := renderMean(t, diffuseScene+`integrator: !std!integrators/pathtrace
+   samples: 16
+...
+`)
+	pt := renderMean(t, diffuseScene+fog+`integrator: !std!integrators/pathtrace
+   samples: 16
+...
+`)
+	bd := renderMean(t, diffuseScene+fog+`integrator: !std!integrators/bidirectional
+   samples: 16
+...
+`)
+	if math.Abs(pt-clear) < 0.05*clear {
+		t.Errorf("pathtrace mean with fog = %.4f; without fog = %.4f", pt, clear)
+	}
+	if math.Abs(pt-bd) > 0.05*pt {
+		t.Errorf("pathtrace mean = %.4f; bidirectional mean = %.4f", pt, bd)
+	}
+}
+
+func TestBidirectionalDeterministic(t *testing.T) {
+	const scene = diffuseScene + `integrator: !std!integrators/bidirectional
+   samples: 4
+...
+`
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	want := render(t, scene)
+	runtime.GOMAXPROCS(4)
+	got := render(t, scene)
+	for j := range want.Pix {
+		if got.Pix[j] != want.Pix[j] {
+			t.Errorf("pixel (%d, %d) with 4 procs = %v; with 1 proc = %v", j%want.Width, j/want.Width, got.Pix[j], want.Pix[j])
+		}
+	}
+}
+
+func TestRenderContextCancel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	sc := goray.NewScene(goray.IntersecterBuilder(intersect.NewKD), testLog)
+	integ, err := yamlscene.Load(strings.NewReader(diffuseScene+`integrator: !std!integrators/pathtrace
+   samples: 4
+...
+`), sc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.SetTiles(2, goray.TileScanline)
+
+	// Cancel the render once its first tile is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last goray.TileProgress
+	sc.SetTileFunc(func(tp goray.TileProgress) {
+		last = tp
+		cancel()
+	})
+	before := runtime.NumGoroutine()
+	if _, err := goray.RenderContext(ctx, sc, integ, testLog); err != context.Canceled {
+		t.Errorf("RenderContext error = %v; want %v", err, context.Canceled)
+	}
+	if last.Done >= last.Total {
+		t.Errorf("render finished all %d tiles after being canceled", last.Total)
+	}
+
+	// The workers must not outlive the render.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after render; %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package integrators
+
+import (
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+type pathTracer struct {
+	background         goray.Background
+	transparentShadows bool
+	shadowDepth        int
+	maxDepth, minDepth int
+	numSamples         int
+
+	lights []goray.Light
+}
+
+var _ goray.SurfaceIntegrator = &pathTracer{}
+
+// NewPathTrace creates a new unbiased path tracing integrator.
+//
+// Each pixel traces numSamples paths.  Paths are terminated after maxDepth
+// bounces, and Russian roulette may end a path after it has made minDepth
+// bounces.
+func NewPathTrace(transparentShadows bool, shadowDepth, maxDepth, minDepth, numSamples int) goray.SurfaceIntegrator {
+	if numSamples < 1 {
+		numSamples = 1
+	}
+	if minDepth > maxDepth {
+		minDepth = maxDepth
+	}
+	return &pathTracer{
+		transparentShadows: transparentShadows,
+		shadowDepth:        shadowDepth,
+		maxDepth:           maxDepth,
+		minDepth:           minDepth,
+		numSamples:         numSamples,
+	}
+}
+
+func (pt *pathTracer) SurfaceIntegrator() {}
+
+func (pt *pathTracer) Preprocess(sc *goray.Scene) {
+	sceneLights := sc.Lights()
+	pt.lights = make([]goray.Light, len(sceneLights), len(sceneLights)+1)
+	copy(pt.lights, sceneLights)
+	pt.background = sc.Background()
+	pt.lights = append(pt.lights, goray.BackgroundLights(pt.background)...)
+}
+
+func (pt *pathTracer) Integrate(sc *goray.Scene, state *goray.RenderState, r goray.DifferentialRay) color.AlphaColor {
+	il, level := state.IncludeLights, state.RayLevel
+	defer func() {
+		state.IncludeLights, state.RayLevel = il, level
+	}()
+
+	// The background covers everything that the camera ray misses.
+	alpha := 1.0
+	coll := sc.Intersect(r.Ray, -1)
+	if !coll.Hit() && pt.background == nil {
+		return goray.IntegrateVolume(sc, state, segment(r.Ray, coll), color.RGBA{})
+	}
+
+	var light lightSplit
+	col := colorSum(pt.numSamples, func(i int) color.Color {
+		return pt.tracePath(sc, state, r.Ray, coll, &light)
+	})
+	state.RayLevel = level
+	light.record(state, 1/float64(pt.numSamples))
+	return color.NewRGBAFromColor(color.ScalarDiv(col, float64(pt.numSamples)), alpha)
+}
+
+// tracePath follows a single light path from the camera, adding next-event
+// estimates of direct lighting at every non-specular vertex.  coll is the
+// camera ray's intersection with the scene, which is the same for every path.
+// The light is also added to light, split by the number of bounces.
+func (pt *pathTracer) tracePath(sc *goray.Scene, state *goray.RenderState, r goray.Ray, coll goray.Collision, light *lightSplit) color.Color {
+	col, throughput := color.Black, color.White
+	add := func(bounces int, c color.Color) {
+		col = color.Add(col, c)
+		if state.AOVs != nil {
+			light.add(bounces, c)
+		}
+	}
+
+	// specularBounce is whether the ray came from the camera or a specular
+	// bounce, so next event estimation could not have found what it hits.
+	specularBounce := true
+
+	for depth := 0; ; depth++ {
+		state.RayLevel = depth
+		state.IncludeLights = specularBounce
+
+		if depth > 0 {
+			coll = sc.Intersect(r, -1)
+		}
+
+		// Light scattered by the volumes along the ray, which also dim
+		// whatever lies behind them.
+		vol, tr := goray.TraceVolume(sc, state, segment(r, coll))
+		add(depth+1, color.Mul(throughput, vol))
+		throughput = color.Mul(throughput, tr)
+
+		if !coll.Hit() {
+			if pt.background != nil && (specularBounce || pt.background.Light() == nil) {
+				add(depth, color.Mul(throughput, pt.background.Color(r, state, false)))
+			}
+			break
+		}
+
+		sp := coll.Surface()
+		mat := sp.Material.(goray.Material)
+		bsdfs := mat.InitBSDF(state, sp)
+		wo := r.Dir.Negate()
+		goray.RecordSurface(sc, state, coll, sp)
+
+		// Contribution of light-emitting surfaces.  Surfaces that belong to a
+		// light have already been accounted for by direct lighting, unless we
+		// arrived here through a specular bounce.
+		if emat, ok := mat.(goray.EmitMaterial); ok && (sp.Light == nil || specularBounce) {
+			add(depth, color.Mul(throughput, emat.Emit(state, sp, wo)))
+		}
+
+		// Next event estimation
+		if bsdfs&(goray.BSDFGlossy|goray.BSDFDiffuse|goray.BSDFDispersive) != 0 {
+			direct := estimateDirectPH(state, sp, pt.lights, sc, wo, pt.transparentShadows, pt.shadowDepth)
+			add(depth+1, color.Mul(throughput, direct))
+		}
+
+		if depth >= pt.maxDepth {
+			break
+		}
+
+		// Sample the BSDF to find the next direction
+		s := goray.NewMaterialSample(state.Sampler.Get2D())
+		surfCol, wi := mat.Sample(state, sp, wo, &s)
+		if s.Pdf <= pdfCutoff || color.IsBlack(surfCol) {
+			break
+		}
+		throughput = color.Mul(throughput, color.ScalarMul(surfCol, math.Abs(vec64.Dot(sp.Normal, wi))/s.Pdf))
+		specularBounce = s.SampledFlags&goray.BSDFSpecular != 0
+
+		// Russian roulette
+		if depth >= pt.minDepth {
+			p := math.Min(1.0, math.Max(math.Max(throughput.Red(), throughput.Green()), throughput.Blue()))
+			if state.Sampler.Get1D() >= p {
+				break
+			}
+			throughput = color.ScalarDiv(throughput, p)
+		}
+
+		r = goray.Ray{
+			From: sp.Position,
+			Dir:  wi,
+			TMin: raySelfBias,
+			TMax: -1.0,
+			Time: state.Time,
+		}
+	}
+	return col
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"integrators/pathtrace"] = yamlscene.MapConstruct(constructPathTrace)
+}
+
+func constructPathTrace(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	trShad, _ := yamldata.AsBool(m.SetDefault("transparentShadows", false))
+	shadowDepth, _ := yamldata.AsInt(m.SetDefault("shadowDepth", 4))
+	maxDepth, _ := yamldata.AsInt(m.SetDefault("maxDepth", 5))
+	minDepth, _ := yamldata.AsInt(m.SetDefault("minDepth", 3))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	return NewPathTrace(trShad, shadowDepth, maxDepth, minDepth, samples), nil
+}
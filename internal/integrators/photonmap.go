@@ -0,0 +1,343 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package integrators
+
+import (
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// photonSequence is the sampler sequence that photons are shot with.
+const photonSequence = -1
+
+// shootPhotons emits n photons from the lights and follows them through the
+// scene for at most maxBounces bounces.  Photons that reach a diffuse surface
+// after only specular scattering are stored in caustic; photons that reach a
+// diffuse surface after any other indirect scattering are stored in diffuse.
+// Either map may be nil.  The maps are updated before shootPhotons returns.
+func shootPhotons(sc *goray.Scene, lights []goray.Light, n, maxBounces int, caustic, diffuse *goray.PhotonMap) {
+	if len(lights) == 0 || n <= 0 {
+		return
+	}
+
+	// Choose lights in proportion to their power
+	energies := make([]float64, len(lights))
+	totalEnergy := 0.0
+	for i, l := range lights {
+		energies[i] = color.Energy(l.TotalEnergy())
+		totalEnergy += energies[i]
+	}
+	if totalEnergy <= 0 {
+		return
+	}
+	lightPower := sampleutil.NewPdf1D(energies)
+
+	state := new(goray.RenderState)
+	state.Init()
+	smp := sc.Sampler().Clone(sc.Seed())
+	state.Sampler = smp
+	open, close := sc.Shutter()
+
+	for i := 0; i < n; i++ {
+		smp.StartSample(photonSequence, i)
+		s1, s2 := smp.Get2D()
+		s3, s4 := smp.Get2D()
+		lightNum, lightPdf := lightPower.DiscreteSample((float64(i) + 0.5) / float64(n))
+		lightPdf /= float64(lightPower.Len())
+		if lightPdf <= pdfCutoff {
+			continue
+		}
+
+		col, r, ipdf := lights[lightNum].EmitPhoton(s1, s2, s3, s4)
+		col = color.ScalarMul(col, ipdf/lightPdf)
+		if color.IsBlack(col) {
+			continue
+		}
+		r.TMin, r.TMax = raySelfBias, -1.0
+
+		// Each photon sees the scene at a single time while the shutter is
+		// open, so the maps blur moving objects like the camera does.
+		state.Time = open
+		if close > open {
+			state.Time += (close - open) * smp.Get1D()
+		}
+		r.Time = state.Time
+		tracePhoton(sc, state, r, col, maxBounces, caustic, diffuse)
+	}
+
+	for _, m := range []*goray.PhotonMap{caustic, diffuse} {
+		if m != nil {
+			m.SetNumPaths(n)
+			m.Update()
+		}
+	}
+}
+
+func tracePhoton(sc *goray.Scene, state *goray.RenderState, r goray.Ray, col color.Color, maxBounces int, caustic, diffuse *goray.PhotonMap) {
+	causticPhoton, directPhoton := false, true
+	for bounce := 0; bounce < maxBounces; bounce++ {
+		coll := sc.Intersect(r, -1)
+		if !coll.Hit() {
+			return
+		}
+		col = color.Mul(col, transmittance(sc, state, segment(r, coll)))
+		sp := coll.Surface()
+		mat := sp.Material.(goray.Material)
+		bsdfs := mat.InitBSDF(state, sp)
+		wi := r.Dir.Negate()
+
+		if bsdfs&goray.BSDFDiffuse != 0 {
+			phot := goray.Photon{Position: sp.Position, Direction: wi, Color: col}
+			switch {
+			case causticPhoton && caustic != nil:
+				caustic.AddPhoton(phot)
+			case !causticPhoton && !directPhoton && diffuse != nil:
+				diffuse.AddPhoton(phot)
+			}
+		}
+
+		s1, s2 := state.Sampler.Get2D()
+		s := goray.NewPhotonSample(s1, s2, state.Sampler.Get1D(), goray.BSDFAll, col)
+		wo, scattered := mat.ScatterPhoton(state, sp, wi, &s)
+		if !scattered {
+			return
+		}
+		col = s.Color
+
+		const specularFlags = goray.BSDFSpecular | goray.BSDFGlossy | goray.BSDFDispersive
+		causticPhoton = (s.SampledFlags&specularFlags != 0 && directPhoton) ||
+			(s.SampledFlags&(specularFlags|goray.BSDFFilter) != 0 && causticPhoton)
+		directPhoton = s.SampledFlags&goray.BSDFFilter != 0 && directPhoton
+
+		r = goray.Ray{
+			From: sp.Position,
+			Dir:  wo,
+			TMin: raySelfBias,
+			TMax: -1.0,
+			Time: state.Time,
+		}
+	}
+}
+
+type photonMapper struct {
+	background            goray.Background
+	transparentShadows    bool
+	shadowDepth, rayDepth int
+
+	numPhotons, maxBounces       int
+	numSearch                    int
+	diffuseRadius, causticRadius float64
+	finalGather                  bool
+	fgSamples                    int
+
+	lights     []goray.Light
+	causticMap *goray.PhotonMap
+	diffuseMap *goray.PhotonMap
+}
+
+var _ goray.SurfaceIntegrator = &photonMapper{}
+
+// NewPhotonMap creates a new photon mapping integrator.  Direct lighting is
+// computed with light sampling, while caustics and indirect diffuse lighting
+// are estimated from photon maps built during Preprocess.
+func NewPhotonMap(transparentShadows bool, shadowDepth, rayDepth, numPhotons, numSearch int) goray.SurfaceIntegrator {
+	return &photonMapper{
+		transparentShadows: transparentShadows,
+		shadowDepth:        shadowDepth,
+		rayDepth:           rayDepth,
+		numPhotons:         numPhotons,
+		maxBounces:         5,
+		numSearch:          numSearch,
+		diffuseRadius:      0.1,
+		causticRadius:      0.1,
+		finalGather:        true,
+		fgSamples:          16,
+	}
+}
+
+func (pm *photonMapper) SurfaceIntegrator() {}
+
+func (pm *photonMapper) Preprocess(sc *goray.Scene) {
+	sceneLights := sc.Lights()
+	pm.lights = make([]goray.Light, len(sceneLights), len(sceneLights)+1)
+	copy(pm.lights, sceneLights)
+	pm.background = sc.Background()
+	pm.lights = append(pm.lights, goray.BackgroundLights(pm.background)...)
+
+	pm.causticMap, pm.diffuseMap = goray.NewMap(), goray.NewMap()
+	shootPhotons(sc, pm.lights, pm.numPhotons, pm.maxBounces, pm.causticMap, pm.diffuseMap)
+}
+
+func (pm *photonMapper) Integrate(sc *goray.Scene, state *goray.RenderState, r goray.DifferentialRay) color.AlphaColor {
+	col, alpha := color.Black, 0.0
+
+	defer func(il bool) {
+		state.IncludeLights = il
+	}(state.IncludeLights)
+
+	coll := sc.Intersect(r.Ray, -1)
+	if !coll.Hit() {
+		if pm.background != nil {
+			bg := pm.background.Color(r.Ray, state, false)
+			state.AddLight(0, bg)
+			col, alpha = color.Add(col, bg), 1.0
+		}
+		return goray.IntegrateVolume(sc, state, segment(r.Ray, coll), color.NewRGBAFromColor(col, alpha))
+	}
+
+	sp := coll.Surface()
+	if state.RayLevel == 0 {
+		state.IncludeLights = true
+	}
+	mat := sp.Material.(goray.Material)
+	bsdfs := mat.InitBSDF(state, sp)
+	wo := r.Dir.Negate()
+	goray.RecordSurface(sc, state, coll, sp)
+
+	add := func(bounces int, c color.Color) {
+		state.AddLight(bounces, c)
+		col = color.Add(col, c)
+	}
+	if emat, ok := mat.(goray.EmitMaterial); ok {
+		add(0, emat.Emit(state, sp, wo))
+	}
+	if bsdfs&(goray.BSDFGlossy|goray.BSDFDiffuse|goray.BSDFDispersive) != 0 {
+		add(1, estimateDirectPH(state, sp, pm.lights, sc, wo, pm.transparentShadows, pm.shadowDepth))
+	}
+	if bsdfs&(goray.BSDFDiffuse|goray.BSDFGlossy) != 0 {
+		add(2, estimatePhotons(state, sp, pm.causticMap, wo, pm.numSearch, pm.causticRadius*pm.causticRadius))
+	}
+	if bsdfs&goray.BSDFDiffuse != 0 {
+		if pm.finalGather && state.RayLevel == 0 {
+			add(2, pm.gather(sc, state, sp, wo))
+		} else {
+			add(2, estimatePhotons(state, sp, pm.diffuseMap, wo, pm.numSearch, pm.diffuseRadius*pm.diffuseRadius))
+		}
+	}
+
+	// Perfect specular reflection/refraction with recursive raytracing
+	reflected := color.Black
+	state.RayLevel++
+	if state.RayLevel <= pm.rayDepth {
+		state.IncludeLights = true
+		reflect, refract, dir, rcol := mat.Specular(state, sp, wo)
+		if reflect {
+			refRay := goray.DifferentialRay{
+				Ray: goray.Ray{From: sp.Position, Dir: dir[0], TMin: raySelfBias, TMax: -1.0, Time: state.Time},
+			}
+			integ := pm.Integrate(sc, state, refRay)
+			col = color.Add(col, color.Mul(integ, rcol[0]))
+			reflected = color.Add(reflected, color.Mul(integ, rcol[0]))
+		}
+		if refract {
+			refRay := goray.DifferentialRay{
+				Ray: goray.Ray{From: sp.Position, Dir: dir[1], TMin: raySelfBias, TMax: -1.0, Time: state.Time},
+			}
+			integ := pm.Integrate(sc, state, refRay)
+			col, alpha = color.Add(col, color.Mul(integ, rcol[1])), integ.Alpha()
+			reflected = color.Add(reflected, color.Mul(integ, rcol[1]))
+		}
+	}
+	state.RayLevel--
+	state.AddLight(2, reflected)
+
+	matAlpha := mat.Alpha(state, sp, wo)
+	alpha = matAlpha + (1-matAlpha)*alpha
+	return goray.IntegrateVolume(sc, state, segment(r.Ray, coll), color.NewRGBAFromColor(col, alpha))
+}
+
+// gather estimates indirect diffuse lighting at a surface point by sampling
+// the BSDF and looking up the diffuse photon map wherever the rays land.
+func (pm *photonMapper) gather(sc *goray.Scene, state *goray.RenderState, sp goray.SurfacePoint, wo vec64.Vector) color.Color {
+	// Looking up other surfaces will overwrite the material data.
+	matData := state.MaterialData
+	defer func() { state.MaterialData = matData }()
+
+	mat := sp.Material.(goray.Material)
+	col := colorSum(pm.fgSamples, func(i int) color.Color {
+		state.MaterialData = matData
+		s := goray.NewMaterialSample(state.Sampler.Get2D())
+		s.Flags = goray.BSDFDiffuse | goray.BSDFReflect | goray.BSDFTransmit
+		surfCol, wi := mat.Sample(state, sp, wo, &s)
+		if s.Pdf <= pdfCutoff || s.SampledFlags&goray.BSDFDiffuse == 0 {
+			return color.Black
+		}
+
+		gRay := goray.Ray{From: sp.Position, Dir: wi, TMin: raySelfBias, TMax: -1.0, Time: state.Time}
+		coll := sc.Intersect(gRay, -1)
+		vol, tr := goray.TraceVolume(sc, state, segment(gRay, coll))
+		lcol := color.Black
+		if !coll.Hit() {
+			// Background lighting is handled by direct lighting if it has a light.
+			if pm.background != nil && pm.background.Light() == nil {
+				lcol = pm.background.Color(gRay, state, false)
+			}
+		} else {
+			gsp := coll.Surface()
+			gmat := gsp.Material.(goray.Material)
+			gbsdfs := gmat.InitBSDF(state, gsp)
+			gwo := wi.Negate()
+			if emat, ok := gmat.(goray.EmitMaterial); ok && gsp.Light == nil {
+				lcol = color.Add(lcol, emat.Emit(state, gsp, gwo))
+			}
+			if gbsdfs&goray.BSDFDiffuse != 0 {
+				lcol = color.Add(lcol, estimateDirectPH(state, gsp, pm.lights, sc, gwo, pm.transparentShadows, pm.shadowDepth))
+				lcol = color.Add(lcol, estimatePhotons(state, gsp, pm.diffuseMap, gwo, pm.numSearch, pm.diffuseRadius*pm.diffuseRadius))
+			}
+		}
+		lcol = color.Add(vol, color.Mul(tr, lcol))
+		return color.ScalarMul(color.Mul(surfCol, lcol), math.Abs(vec64.Dot(sp.Normal, wi))/s.Pdf)
+	})
+	return color.ScalarDiv(col, float64(pm.fgSamples))
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"integrators/photonmap"] = yamlscene.MapConstruct(constructPhotonMap)
+}
+
+func constructPhotonMap(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	trShad, _ := yamldata.AsBool(m.SetDefault("transparentShadows", false))
+	shadowDepth, _ := yamldata.AsInt(m.SetDefault("shadowDepth", 4))
+	rayDepth, _ := yamldata.AsInt(m.SetDefault("rayDepth", 5))
+	photons, _ := yamldata.AsInt(m.SetDefault("photons", 100000))
+	search, _ := yamldata.AsInt(m.SetDefault("search", 100))
+	bounces, _ := yamldata.AsInt(m.SetDefault("bounces", 5))
+	diffuseRadius, _ := yamldata.AsFloat(m.SetDefault("diffuseRadius", 0.1))
+	causticRadius, _ := yamldata.AsFloat(m.SetDefault("causticRadius", 0.1))
+	finalGather, _ := yamldata.AsBool(m.SetDefault("finalGather", true))
+	fgSamples, _ := yamldata.AsInt(m.SetDefault("fgSamples", 16))
+
+	pm := NewPhotonMap(trShad, shadowDepth, rayDepth, photons, search).(*photonMapper)
+	pm.maxBounces = bounces
+	pm.diffuseRadius, pm.causticRadius = diffuseRadius, causticRadius
+	pm.finalGather = finalGather
+	if fgSamples > 0 {
+		pm.fgSamples = fgSamples
+	}
+	return pm, nil
+}
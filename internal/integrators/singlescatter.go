@@ -0,0 +1,199 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package integrators
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+type singleScatter struct {
+	stepSize float64
+
+	volumes []goray.VolumeRegion
+	lights  []goray.Light
+}
+
+var _ goray.VolumeIntegrator = &singleScatter{}
+
+// NewSingleScatter creates a volume integrator that accounts for light that is
+// scattered once by the scene's volumes.  Volumes are ray marched in steps of
+// at most stepSize.
+func NewSingleScatter(stepSize float64) goray.VolumeIntegrator {
+	return &singleScatter{stepSize: stepSize}
+}
+
+func (ss *singleScatter) VolumeIntegrator() {}
+
+func (ss *singleScatter) Preprocess(sc *goray.Scene) {
+	ss.volumes = sc.VolumeRegions()
+	ss.lights = sc.Lights()
+}
+
+// span returns the part of a ray that passes through any of the volumes.
+func (ss *singleScatter) span(r goray.Ray) (t0, t1 float64, ok bool) {
+	t0, t1 = math.Inf(1), math.Inf(-1)
+	for _, vr := range ss.volumes {
+		if a, b, hit := vr.Intersect(r); hit {
+			t0, t1 = math.Min(t0, a), math.Max(t1, b)
+			ok = true
+		}
+	}
+	if !ok {
+		return
+	}
+	t0 = math.Max(t0, r.TMin)
+	if r.TMax >= 0 {
+		t1 = math.Min(t1, r.TMax)
+	}
+	ok = t1 > t0
+	return
+}
+
+// tau returns the optical thickness of all of the volumes along a ray.
+func (ss *singleScatter) tau(r goray.Ray, offset float64) color.Color {
+	tau := color.Black
+	for _, vr := range ss.volumes {
+		tau = color.Add(tau, vr.Tau(r, ss.stepSize, offset))
+	}
+	return tau
+}
+
+// extinction returns the fraction of light that remains after passing
+// through a given optical thickness.
+func extinction(tau color.Color) color.RGB {
+	return color.RGB{
+		R: math.Exp(-tau.Red()),
+		G: math.Exp(-tau.Green()),
+		B: math.Exp(-tau.Blue()),
+	}
+}
+
+func (ss *singleScatter) Transmittance(sc *goray.Scene, state *goray.RenderState, r goray.Ray) color.AlphaColor {
+	if len(ss.volumes) == 0 {
+		return color.RGBA{1, 1, 1, 1}
+	}
+	tr := extinction(ss.tau(r, state.Sampler.Get1D()))
+	return color.NewRGBAFromColor(tr, (tr.R+tr.G+tr.B)/3)
+}
+
+func (ss *singleScatter) Integrate(sc *goray.Scene, state *goray.RenderState, r goray.DifferentialRay) color.AlphaColor {
+	t0, t1, ok := ss.span(r.Ray)
+	if !ok {
+		return color.RGBA{}
+	}
+	smp := state.Sampler
+
+	// March through the volumes, starting each step at a random offset.
+	n := int(math.Ceil((t1 - t0) / ss.stepSize))
+	dt := (t1 - t0) / float64(n)
+	offset := smp.Get1D()
+	wo := r.Dir.Negate()
+	col, tr := color.Black, color.Color(color.White)
+	for i := 0; i < n; i++ {
+		p := vec64.Add(r.From, r.Dir.Scale(t0+(float64(i)+offset)*dt))
+		sigmaT := color.Black
+		stepCol := color.Black
+		for _, vr := range ss.volumes {
+			sigmaT = color.Add(sigmaT, vr.SigmaT(p, r.Dir))
+			stepCol = color.Add(stepCol, vr.Emission(p, r.Dir))
+		}
+		stepCol = color.Add(stepCol, ss.inScatter(sc, state, p, wo))
+
+		// Light from p is attenuated by the part of the step in front of it.
+		trP := color.Mul(tr, extinction(color.ScalarMul(sigmaT, offset*dt)))
+		col = color.Add(col, color.ScalarMul(color.Mul(trP, stepCol), dt))
+		tr = color.Mul(tr, extinction(color.ScalarMul(sigmaT, dt)))
+
+		// Russian roulette once little light gets through
+		if color.Energy(tr) < 1e-3 {
+			if smp.Get1D() < 0.5 {
+				tr = color.Black
+				break
+			}
+			tr = color.ScalarMul(tr, 2)
+		}
+	}
+	return color.NewRGBAFromColor(col, 1-(tr.Red()+tr.Green()+tr.Blue())/3)
+}
+
+// inScatter returns the light from the scene's lights that is scattered
+// toward wo at p.
+func (ss *singleScatter) inScatter(sc *goray.Scene, state *goray.RenderState, p, wo vec64.Vector) color.Color {
+	smp := state.Sampler
+	col := color.Black
+	sp := goray.SurfacePoint{Position: p}
+	for _, l := range ss.lights {
+		lightRay := goray.Ray{
+			From: p,
+			TMax: -1.0,
+			Time: state.Time,
+		}
+		var lcol color.Color
+		if dl, ok := l.(goray.DiracLight); ok {
+			if lcol, ok = dl.Illuminate(sp, &lightRay); !ok {
+				continue
+			}
+		} else {
+			var ls goray.LightSample
+			ls.S1, ls.S2 = smp.Get2D()
+			if ok := l.IlluminateSample(sp, &lightRay, &ls); !ok || ls.Pdf <= pdfCutoff {
+				continue
+			}
+			lcol = color.ScalarDiv(ls.Color, ls.Pdf)
+		}
+		if sc.Shadowed(lightRay, math.Inf(1)) {
+			continue
+		}
+		lcol = color.Mul(lcol, extinction(ss.tau(lightRay, smp.Get1D())))
+
+		// Lights are scaled to match BSDFs, which leave out the 1/pi of a
+		// diffuse surface, so the phase functions are scaled the same way.
+		for _, vr := range ss.volumes {
+			sigmaS := vr.SigmaS(p, wo)
+			if color.IsBlack(sigmaS) {
+				continue
+			}
+			phase := math.Pi * vr.P(lightRay.Dir, wo)
+			col = color.Add(col, color.ScalarMul(color.Mul(lcol, sigmaS), phase))
+		}
+	}
+	return col
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"integrators/singlescatter"] = yamlscene.MapConstruct(constructSingleScatter)
+}
+
+func constructSingleScatter(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	stepSize, _ := yamldata.AsFloat(m.SetDefault("stepSize", 0.1))
+	if stepSize <= 0 {
+		return nil, errors.New("Volume step size must be positive")
+	}
+	return NewSingleScatter(stepSize), nil
+}
@@ -39,8 +39,10 @@ func (ti trivial) SurfaceIntegrator()         {}
 func (ti trivial) Preprocess(sc *goray.Scene) {}
 
 func (ti trivial) Integrate(sc *goray.Scene, s *goray.RenderState, r goray.DifferentialRay) color.AlphaColor {
-	if coll := sc.Intersect(r.Ray, -1); coll.Hit() {
-		return color.NewRGBAFromColor(color.White, 1.0)
+	coll := sc.Intersect(r.Ray, -1)
+	col := color.NewRGBAFromColor(color.Gray(0.1), 0.0)
+	if coll.Hit() {
+		col = color.NewRGBAFromColor(color.White, 1.0)
 	}
-	return color.NewRGBAFromColor(color.Gray(0.1), 0.0)
+	return goray.IntegrateVolume(sc, s, segment(r.Ray, coll), col)
 }
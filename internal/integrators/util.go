@@ -26,7 +26,6 @@ import (
 	"bitbucket.org/zombiezen/math3/vec64"
 	"zombiezen.com/go/goray/internal/color"
 	"zombiezen.com/go/goray/internal/goray"
-	"zombiezen.com/go/goray/internal/montecarlo"
 	"zombiezen.com/go/goray/internal/sampleutil"
 )
 
@@ -35,61 +34,81 @@ const (
 	pdfCutoff   = 1e-6
 )
 
+// nonSpecularBSDF is the set of BSDF components that can be evaluated for an
+// arbitrary pair of directions.
+const nonSpecularBSDF = goray.BSDFGlossy | goray.BSDFDiffuse | goray.BSDFDispersive | goray.BSDFReflect | goray.BSDFTransmit
+
 type colorFunc func(int) color.Color
 
 // colorSum returns the sum of all of the colors returned by the function.
 //
-// The function given is called with [0, n) and it should return a color.
-// If concurrent is true, then these calls happen concurrently.  The result of
-// each function call is summed to get the final return value.
-func colorSum(n int, concurrent bool, f colorFunc) (col color.Color) {
+// The function given is called with [0, n) in order and it should return a
+// color.  The calls are not made concurrently (pixels are already rendered in
+// parallel), so the sum is always added up in the same order.
+func colorSum(n int, f colorFunc) (col color.Color) {
 	col = color.Black
-	if concurrent {
-		// Set up channels
-		channels := make([]chan color.Color, n)
-		for i, _ := range channels {
-			channels[i] = make(chan color.Color, 1)
-		}
+	for i := 0; i < n; i++ {
+		col = color.Add(col, f(i))
+	}
+	return
+}
 
-		// Start sampling
-		for i := 0; i < n; i++ {
-			go func(i int) {
-				defer close(channels[i])
-				channels[i] <- f(i)
-			}(i)
-		}
+// lightSplit accumulates light that reaches the camera, split into direct
+// and indirect light for the AOVs.
+type lightSplit struct {
+	direct, indirect color.Color
+}
 
-		// Collect samples
-		for _, ch := range channels {
-			col = color.Add(col, <-ch)
-		}
+// add adds light that was scattered by the given number of surfaces.
+func (ls *lightSplit) add(bounces int, c color.Color) {
+	if ls.direct == nil {
+		ls.direct, ls.indirect = color.Black, color.Black
+	}
+	if bounces <= 1 {
+		ls.direct = color.Add(ls.direct, c)
 	} else {
-		for i := 0; i < n; i++ {
-			col = color.Add(col, f(i))
-		}
+		ls.indirect = color.Add(ls.indirect, c)
 	}
-	return
 }
 
-func sample(n int, f colorFunc) color.Color {
-	return color.ScalarDiv(colorSum(n, true, f), float64(n))
+// record adds the light, multiplied by scale, to the state's AOVs.
+func (ls *lightSplit) record(state *goray.RenderState, scale float64) {
+	if ls.direct == nil {
+		return
+	}
+	state.AddLight(1, color.ScalarMul(ls.direct, scale))
+	state.AddLight(2, color.ScalarMul(ls.indirect, scale))
 }
 
-func halSeq(n int, base, start uint) (seq []float64) {
-	seq = make([]float64, n)
-	hal := montecarlo.NewHalton(base)
-	hal.SetStart(start)
-	for i, _ := range seq {
-		seq[i] = hal.Float64()
+// segment returns the part of r that ends where it hit the scene, which is
+// what the volume integrator needs.
+func segment(r goray.Ray, coll goray.Collision) goray.Ray {
+	r.TMax = -1.0
+	if coll.Hit() {
+		r.TMax = coll.RayDepth
 	}
-	return
+	return r
+}
+
+// transmittance returns the fraction of light that passes through the scene's
+// volumes along a segment.
+func transmittance(sc *goray.Scene, state *goray.RenderState, r goray.Ray) color.Color {
+	vi := sc.VolumeIntegrator()
+	if vi == nil {
+		return color.White
+	}
+	return vi.Transmittance(sc, state, r)
+}
+
+func sample(n int, f colorFunc) color.Color {
+	return color.ScalarDiv(colorSum(n, f), float64(n))
 }
 
 // estimateDirectPH computes an estimate of direct lighting with multiple importance sampling using the power heuristic with exponent=2.
 func estimateDirectPH(state *goray.RenderState, sp goray.SurfacePoint, lights []goray.Light, sc *goray.Scene, wo vec64.Vector, trShad bool, sDepth int) (col color.Color) {
 	params := directParams{state, sp, lights, sc, wo, trShad, sDepth}
 
-	return colorSum(len(lights), false, func(i int) (col color.Color) {
+	return colorSum(len(lights), func(i int) (col color.Color) {
 		switch l := lights[i].(type) {
 		case goray.DiracLight:
 			// Light with delta distribution
@@ -112,12 +131,17 @@ type directParams struct {
 	SDepth int
 }
 
-func checkShadow(params directParams, r goray.Ray) bool {
+// checkShadow reports whether a light ray is blocked.  If it is not, the
+// fraction of the light that passes through the scene's volumes is returned.
+func checkShadow(params directParams, r goray.Ray) (tr color.Color, shadowed bool) {
 	r.TMin = raySelfBias
 	if params.TrShad {
 		// TODO
 	}
-	return params.Scene.Shadowed(r, math.Inf(1))
+	if params.Scene.Shadowed(r, math.Inf(1)) {
+		return color.Black, true
+	}
+	return transmittance(params.Scene, params.State, r), false
 }
 
 func estimateDiracDirect(params directParams, l goray.DiracLight) color.Color {
@@ -125,19 +149,20 @@ func estimateDiracDirect(params directParams, l goray.DiracLight) color.Color {
 	lightRay := goray.Ray{
 		From: sp.Position,
 		TMax: -1.0,
+		Time: params.State.Time,
 	}
 	mat := sp.Material.(goray.Material)
 
 	lcol, ok := l.Illuminate(sp, &lightRay)
 	if ok {
-		if shadowed := checkShadow(params, lightRay); !shadowed {
+		if tr, shadowed := checkShadow(params, lightRay); !shadowed {
 			if params.TrShad {
 				//lcol = color.Mul(lcol, scol)
 			}
 			surfCol := mat.Eval(params.State, sp, params.Wo, lightRay.Dir, goray.BSDFAll)
 			//TODO: transmitCol
 			return color.ScalarMul(
-				color.Mul(surfCol, lcol),
+				color.Mul(color.Mul(surfCol, lcol), tr),
 				math.Abs(vec64.Dot(sp.Normal, lightRay.Dir)),
 			)
 		}
@@ -156,27 +181,20 @@ func estimateAreaDirect(params directParams, l goray.Light) (ccol color.Color) {
 			n = 1
 		}
 	}
-	// TODO: Add a unique offset for every light
-	offset := uint(n*params.State.PixelSample) + params.State.SamplingOffset
-
+	smp := params.State.Sampler
 	isect, canIntersect := l.(goray.LightIntersecter)
 
 	// Sample from light
-	hals1 := halSeq(n, 3, offset-1)
 	ccol = sample(n, func(i int) color.Color {
-		lightSamp := goray.LightSample{
-			S1: montecarlo.VanDerCorput(uint32(offset)+uint32(i), 0),
-			S2: hals1[i],
-		}
+		var lightSamp goray.LightSample
+		lightSamp.S1, lightSamp.S2 = smp.Get2D()
 		return sampleLight(params, l, canIntersect, lightSamp)
 	})
 
 	// Sample from BSDF
-	hals1 = halSeq(n, 5, offset)
-	hals2 := halSeq(n, 7, offset)
 	if canIntersect {
 		ccol2 := sample(n, func(i int) color.Color {
-			s1, s2 := hals1[i], hals2[i]
+			s1, s2 := smp.Get2D()
 			return sampleBSDF(params, isect, s1, s2)
 		})
 		ccol = color.Add(ccol, ccol2)
@@ -198,14 +216,15 @@ func sampleLight(params directParams, l goray.Light, canIntersect bool, lightSam
 	lightRay := goray.Ray{ // Illuminate will fill in most of the ray
 		From: sp.Position,
 		TMax: -1.0,
+		Time: params.State.Time,
 	}
 	if ok := l.IlluminateSample(sp, &lightRay, &lightSamp); ok {
-		if shadowed := checkShadow(params, lightRay); !shadowed && lightSamp.Pdf > pdfCutoff {
+		if tr, shadowed := checkShadow(params, lightRay); !shadowed && lightSamp.Pdf > pdfCutoff {
 			// TODO: if trShad
 			// TODO: transmitCol
 			surfCol := mat.Eval(params.State, sp, params.Wo, lightRay.Dir, goray.BSDFAll)
 			col = color.ScalarMul(
-				color.Mul(surfCol, lightSamp.Color),
+				color.Mul(color.Mul(surfCol, lightSamp.Color), tr),
 				math.Abs(vec64.Dot(sp.Normal, lightRay.Dir)),
 			)
 			if canIntersect {
@@ -226,12 +245,14 @@ func sampleLight(params directParams, l goray.Light, canIntersect bool, lightSam
 }
 
 func sampleBSDF(params directParams, l goray.LightIntersecter, s1, s2 float64) (col color.Color) {
+	col = color.Black
 	sp := params.Surf
 	mat := sp.Material.(goray.Material)
 	bRay := goray.Ray{
 		From: sp.Position,
 		TMin: raySelfBias,
 		TMax: -1.0,
+		Time: params.State.Time,
 	}
 
 	if params.State.RayDivision > 1 {
@@ -246,7 +267,7 @@ func sampleBSDF(params directParams, l goray.LightIntersecter, s1, s2 float64) (
 
 	if dist, lcol, lightPdf, ok := l.Intersect(bRay); s.Pdf > pdfCutoff && ok {
 		bRay.TMax = dist
-		if !checkShadow(params, bRay) {
+		if tr, shadowed := checkShadow(params, bRay); !shadowed {
 			// TODO: if trShad
 			// TODO: transmitCol
 			lPdf := 1.0 / lightPdf
@@ -256,7 +277,7 @@ func sampleBSDF(params directParams, l goray.LightIntersecter, s1, s2 float64) (
 			cos2 := math.Abs(vec64.Dot(sp.Normal, bRay.Dir))
 			if s.Pdf > pdfCutoff {
 				col = color.ScalarMul(
-					color.Mul(surfCol, lcol),
+					color.Mul(color.Mul(surfCol, lcol), tr),
 					cos2*w/s.Pdf,
 				)
 			}
@@ -307,12 +328,8 @@ func sampleAO(sc *goray.Scene, state *goray.RenderState, sp goray.SurfacePoint,
 		}
 	}
 
-	offset := uint(n*state.PixelSample) + state.SamplingOffset
-
-	hals := halSeq(n, 3, offset-1)
 	return sample(n, func(i int) color.Color {
-		s1 := montecarlo.VanDerCorput(uint32(offset)+uint32(i), 0)
-		s2 := hals[i]
+		s1, s2 := state.Sampler.Get2D()
 		if state.RayDivision > 1 {
 			s1 = sampleutil.AddMod1(s1, state.Dc1)
 			s2 = sampleutil.AddMod1(s2, state.Dc2)
@@ -321,6 +338,7 @@ func sampleAO(sc *goray.Scene, state *goray.RenderState, sp goray.SurfacePoint,
 			From: sp.Position,
 			TMin: raySelfBias,
 			TMax: aoDist,
+			Time: state.Time,
 		}
 
 		s := goray.NewMaterialSample(s1, s2)
@@ -26,17 +26,82 @@ import (
 	"image"
 	"image/jpeg"
 	"image/png"
+
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/exr"
+	"zombiezen.com/go/goray/internal/goray"
 )
 
 // A Format holds information about how to encode an job's image.
 type Format struct {
 	Extension string
 	Encode    func(io.Writer, image.Image) error
+
+	// Layers reports whether Encode writes the AOV images of a *goray.Image
+	// into the same file.
+	Layers bool
 }
 
 const DefaultFormat = "png"
 
 var FormatMap = map[string]Format{
-	"png":  Format{".png", png.Encode},
-	"jpeg": Format{".jpg", func(w io.Writer, i image.Image) error { return jpeg.Encode(w, i, nil) }},
+	"png":  Format{".png", png.Encode, false},
+	"jpeg": Format{".jpg", func(w io.Writer, i image.Image) error { return jpeg.Encode(w, i, nil) }, false},
+	"exr":  Format{".exr", encodeEXR, true},
+}
+
+// aovChannels names the channels that each AOV is written to in a multi-layer
+// file.  The channels take the red, green and blue of the AOV image, in order.
+var aovChannels = map[goray.AOV][]string{
+	goray.AOVDepth:         {"Z"},
+	goray.AOVNormal:        {"X", "Y", "Z"},
+	goray.AOVShadingNormal: {"X", "Y", "Z"},
+	goray.AOVAlbedo:        {"R", "G", "B"},
+	goray.AOVUV:            {"U", "V"},
+	goray.AOVObjectID:      {"id"},
+	goray.AOVPrimitiveID:   {"id"},
+	goray.AOVDirect:        {"R", "G", "B"},
+	goray.AOVIndirect:      {"R", "G", "B"},
+}
+
+// encodeEXR writes an image as an OpenEXR file with premultiplied RGBA
+// channels.  The AOV images of a *goray.Image are written as layers named
+// after the AOV, with their values left as they are.
+func encodeEXR(w io.Writer, i image.Image) error {
+	img, ok := i.(*goray.Image)
+	if !ok {
+		img = goray.NewGoImage(i)
+	}
+	chans := make([]exr.Channel, 0, 4)
+	for _, name := range []string{"R", "G", "B", "A"} {
+		chans = append(chans, exr.Channel{Name: name, Pix: make([]float32, len(img.Pix))})
+	}
+	for j, c := range img.Pix {
+		c = c.AlphaPremultiply()
+		chans[0].Pix[j] = float32(c.R)
+		chans[1].Pix[j] = float32(c.G)
+		chans[2].Pix[j] = float32(c.B)
+		chans[3].Pix[j] = float32(c.A)
+	}
+	for a, layer := range img.AOVs {
+		for k, name := range aovChannels[a] {
+			ch := exr.Channel{Name: a.String() + "." + name, Pix: make([]float32, len(layer.Pix))}
+			for j, c := range layer.Pix {
+				ch.Pix[j] = float32(component(c, k))
+			}
+			chans = append(chans, ch)
+		}
+	}
+	return exr.Encode(w, img.Width, img.Height, chans)
+}
+
+// component returns the red, green or blue channel of a color.
+func component(c color.RGBA, k int) float64 {
+	switch k {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	}
+	return c.B
 }
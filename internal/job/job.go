@@ -22,10 +22,15 @@
 package job
 
 import (
+	"context"
+	"errors"
+	"image"
 	"io"
+	"math"
 	"sync"
 	"time"
 
+	"zombiezen.com/go/goray/internal/distrib"
 	"zombiezen.com/go/goray/internal/goray"
 	"zombiezen.com/go/goray/internal/intersect"
 	"zombiezen.com/go/goray/internal/log"
@@ -39,9 +44,52 @@ type Job struct {
 	SceneLog  log.Logger
 	RenderLog log.Logger
 
-	status Status
-	lock   sync.RWMutex
-	cond   *sync.Cond
+	// Progressive, if not nil, renders the image in passes over the whole
+	// image instead of using the scene's antialiasing parameters.
+	Progressive *goray.Progressive
+
+	// Snapshot, if not nil, is called with the image rendered so far after
+	// each pass of a progressive render.
+	Snapshot func(*goray.Image, goray.Progress)
+
+	// Region, if not empty, limits the render to a rectangle of pixels.  If
+	// Crop is true, the output is only the region.  Otherwise, the output is
+	// the full-size image with only the region filled in.
+	Region image.Rectangle
+	Crop   bool
+
+	// Coordinator, if not nil, renders the job on worker processes.  The
+	// coordinator loads textures from its own directory, so the ImageLoader
+	// and GridLoader params are not used.
+	Coordinator *distrib.Coordinator
+
+	// AOVs lists arbitrary output variables to render besides the ones that
+	// the scene asks for.
+	AOVs []goray.AOV
+
+	// AOVWriter, if not nil, opens the file that each AOV image is written to
+	// in the output format.  Otherwise, the AOV images are written as layers
+	// of the output if the format supports layers and are dropped if it does
+	// not.
+	AOVWriter func(goray.AOV) (io.WriteCloser, error)
+
+	// EyeWriter, if not nil, opens the file that each eye of a stereo camera
+	// is written to in the output format.  Nothing is written to the job's
+	// writer in that case.  Otherwise, the eyes are written as one image in
+	// the camera's layout.
+	EyeWriter func(goray.Eye) (io.WriteCloser, error)
+
+	// EyeAOVWriter, if not nil, opens the file that each AOV image of an eye
+	// is written to when EyeWriter is used, like AOVWriter does for the whole
+	// image.  Otherwise, the AOVs of each eye are written as layers of its
+	// file if the format supports layers and are dropped if it does not.
+	EyeAOVWriter func(goray.Eye, goray.AOV) (io.WriteCloser, error)
+
+	status   Status
+	lock     sync.RWMutex
+	cond     *sync.Cond
+	cancel   context.CancelFunc
+	canceled bool
 }
 
 // New returns a newly allocated job structure.
@@ -61,21 +109,26 @@ func (job *Job) Status() Status {
 	return job.status
 }
 
+// StatusChan returns a channel that receives the job's status every time that
+// it changes, and is closed after the job finishes.  The job does not wait for
+// the receiver: changes that happen while the receiver is busy are coalesced,
+// so it gets the latest status when it is ready.
 func (job *Job) StatusChan() <-chan Status {
 	ch := make(chan Status)
 	go func() {
 		defer close(ch)
 		job.cond.L.Lock()
-		defer job.cond.L.Unlock()
-
 		stat := job.status
+		job.cond.L.Unlock()
 		ch <- stat
 		for !stat.Finished() {
-			job.cond.Wait()
-			if job.status.Code != stat.Code {
-				ch <- job.status
+			job.cond.L.Lock()
+			for job.status.Code == stat.Code && job.status.Progress == stat.Progress {
+				job.cond.Wait()
 			}
 			stat = job.status
+			job.cond.L.Unlock()
+			ch <- stat
 		}
 	}()
 	return ch
@@ -88,44 +141,168 @@ func (job *Job) ChangeStatus(stat Status) {
 	job.cond.Broadcast()
 }
 
+// setProgress changes the progress of the job without changing the rest of
+// its status.
+func (job *Job) setProgress(progress float64, eta time.Duration) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.status.Progress, job.status.ETA = progress, eta
+	job.cond.Broadcast()
+}
+
+// tileDone updates the job's progress after a tile of the render finishes.
+// The render started at start.
+func (job *Job) tileDone(start time.Time, tp goray.TileProgress) {
+	elapsed := time.Since(start)
+	var progress float64
+	known := false
+	if tp.Passes > 0 {
+		progress, known = tp.Fraction(), true
+	}
+	if job.Progressive != nil && job.Progressive.MaxTime > 0 {
+		// The render stops after the first pass to end past MaxTime.
+		f := math.Min(float64(elapsed)/float64(job.Progressive.MaxTime), 1)
+		if !known || f > progress {
+			progress = f
+		}
+		known = true
+	}
+	if !known || progress <= 0 {
+		return
+	}
+	eta := time.Duration(float64(elapsed) * (1 - progress) / progress)
+	job.setProgress(progress, eta)
+}
+
+// Cancel stops the job.  A job that has not started rendering is marked as
+// canceled immediately and will not be rendered.
+func (job *Job) Cancel() {
+	job.lock.Lock()
+	job.canceled = true
+	cancel := job.cancel
+	if !job.status.Started() {
+		job.status = Status{Code: StatusCanceled}
+		job.cond.Broadcast()
+	}
+	job.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
 func (job *Job) Render(w io.Writer) (err error) {
+	return job.RenderContext(context.Background(), w)
+}
+
+// RenderContext renders the job, stopping early once the context is done or
+// the job is canceled.  A job that is stopped early does not write an image
+// and finishes with StatusCanceled.
+func (job *Job) RenderContext(ctx context.Context, w io.Writer) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	job.lock.Lock()
+	job.cancel = cancel
+	if job.canceled {
+		cancel()
+	}
+	job.lock.Unlock()
+
 	var status Status
 	defer func() {
-		if err != nil {
+		switch {
+		case err == context.Canceled || err == context.DeadlineExceeded:
+			status.Code, status.Error = StatusCanceled, err
+		case err != nil:
 			status.Code, status.Error = StatusError, err
-			job.ChangeStatus(status)
-		} else {
+		default:
 			status.Code = StatusDone
-			job.ChangeStatus(status)
 		}
+		job.ChangeStatus(status)
 	}()
+	if err = ctx.Err(); err != nil {
+		return
+	}
 
 	// 1. Read
 	status.Code = StatusReading
 	job.ChangeStatus(status)
 	sc := goray.NewScene(goray.IntersecterBuilder(intersect.NewKD), job.SceneLog)
+	for _, a := range job.AOVs {
+		sc.AddAOV(a)
+	}
 	var integ goray.Integrator
+	var sess *distrib.Session
 	status.ReadTime = stopwatch(func() {
-		integ, err = yamlscene.Load(job.Source, sc, job.Params)
+		if job.Coordinator != nil {
+			sess, err = job.Coordinator.Load(ctx, job.Source, sc, job.SceneLog)
+		} else {
+			integ, err = yamlscene.Load(job.Source, sc, job.Params)
+		}
 	})
 	if err != nil {
 		return
 	}
+	if sess != nil {
+		defer sess.Close()
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if !job.Region.Empty() {
+		if sc.Camera() == nil {
+			return errors.New("Region requires a camera")
+		}
+		bounds := image.Rect(0, 0, sc.Camera().ResolutionX(), sc.Camera().ResolutionY())
+		if !job.Region.In(bounds) {
+			return errors.New("Region must be inside of the image")
+		}
+		sc.SetRegion(job.Region)
+	}
 
 	// 2. Update
 	status.Code = StatusUpdating
 	job.ChangeStatus(status)
 	status.UpdateTime = stopwatch(func() {
-		sc.Update()
+		// Workers update their own copies of the scene.
+		if sess == nil {
+			sc.Update()
+		}
 	})
+	if err = ctx.Err(); err != nil {
+		return
+	}
 
 	// 3. Render
 	var outputImage *goray.Image
 	status.Code = StatusRendering
 	job.ChangeStatus(status)
+	snapshot := job.Snapshot
+	if snapshot != nil && job.Crop && !job.Region.Empty() {
+		snapshot = func(img *goray.Image, p goray.Progress) {
+			job.Snapshot(img.Crop(job.Region), p)
+		}
+	}
+	renderStart := time.Now()
+	sc.SetTileFunc(func(tp goray.TileProgress) {
+		job.tileDone(renderStart, tp)
+	})
 	status.RenderTime = stopwatch(func() {
-		outputImage = goray.Render(sc, integ, job.RenderLog)
+		switch {
+		case sess != nil && job.Progressive != nil:
+			outputImage, err = sess.RenderProgressive(ctx, *job.Progressive, snapshot, job.RenderLog)
+		case sess != nil:
+			outputImage, err = sess.Render(ctx, job.RenderLog)
+		case job.Progressive != nil:
+			outputImage, err = goray.RenderProgressiveContext(ctx, sc, integ, *job.Progressive, snapshot, job.RenderLog)
+		default:
+			outputImage, err = goray.RenderContext(ctx, sc, integ, job.RenderLog)
+		}
 	})
+	if err != nil {
+		return
+	}
+	status.Progress = 1
 
 	// 4. Write
 	status.Code = StatusWriting
@@ -134,12 +311,81 @@ func (job *Job) Render(w io.Writer) (err error) {
 	if !ok {
 		format = FormatMap[DefaultFormat]
 	}
+	if stereo, ok := sc.Camera().(goray.StereoCamera); ok && job.EyeWriter != nil {
+		status.WriteTime = stopwatch(func() {
+			err = job.writeEyes(stereo, format, outputImage)
+		})
+		return
+	}
+	if job.Crop && !job.Region.Empty() {
+		outputImage = outputImage.Crop(job.Region)
+	}
 	status.WriteTime = stopwatch(func() {
-		err = format.Encode(w, outputImage)
+		err = job.write(w, format, outputImage, job.AOVWriter)
 	})
 	return
 }
 
+// writeEyes encodes each eye of a stereo image to its own file.
+func (job *Job) writeEyes(cam goray.StereoCamera, format Format, img *goray.Image) error {
+	for _, e := range []goray.Eye{goray.LeftEye, goray.RightEye} {
+		r := cam.EyeBounds(e)
+		if job.Crop && !job.Region.Empty() {
+			r = r.Intersect(job.Region)
+		}
+		var aovWriter func(goray.AOV) (io.WriteCloser, error)
+		if job.EyeAOVWriter != nil {
+			e := e
+			aovWriter = func(a goray.AOV) (io.WriteCloser, error) {
+				return job.EyeAOVWriter(e, a)
+			}
+		}
+		ew, err := job.EyeWriter(e)
+		if err != nil {
+			return err
+		}
+		err = job.write(ew, format, img.Crop(r), aovWriter)
+		if cerr := ew.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write encodes a rendered image, and its AOV images to the files opened by
+// aovWriter.  If aovWriter is nil, the AOVs are written as layers of the image.
+func (job *Job) write(w io.Writer, format Format, img *goray.Image, aovWriter func(goray.AOV) (io.WriteCloser, error)) error {
+	if len(img.AOVs) > 0 && aovWriter == nil && !format.Layers {
+		job.RenderLog.Warningf("Output format does not support layers; AOVs are not written")
+	}
+	if aovWriter == nil || len(img.AOVs) == 0 {
+		return format.Encode(w, img)
+	}
+
+	beauty := *img
+	beauty.AOVs = nil
+	if err := format.Encode(w, &beauty); err != nil {
+		return err
+	}
+	for a, layer := range img.AOVs {
+		aw, err := aovWriter(a)
+		if err != nil {
+			return err
+		}
+		err = format.Encode(aw, layer)
+		if cerr := aw.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // stopwatch calls a function and returns how long it took for the function to return.
 func stopwatch(f func()) time.Duration {
 	startTime := time.Now()
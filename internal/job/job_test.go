@@ -0,0 +1,220 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package job
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zombiezen.com/go/goray/internal/log"
+	"zombiezen.com/go/goray/internal/yamlscene"
+
+	_ "zombiezen.com/go/goray/internal/cameras"
+	_ "zombiezen.com/go/goray/internal/integrators"
+	_ "zombiezen.com/go/goray/internal/lights"
+	_ "zombiezen.com/go/goray/internal/materials"
+)
+
+// testScene is a floor lit by an area light, rendered in many small tiles.
+const testScene = `%YAML 1.2
+%TAG !goray! tag:goray/
+%TAG !std! tag:goray/std/
+---
+objects:
+   -  !std!objects/mesh
+      vertices:
+         -  [-2.0, 0.0, -2.0]
+         -  [2.0, 0.0, -2.0]
+         -  [2.0, 0.0, 2.0]
+         -  [-2.0, 0.0, 2.0]
+      faces:
+         -  vertices: [2, 1, 0]
+            material: &white !std!materials/shinydiffuse
+               color: !goray!rgb [0.8, 0.8, 0.8]
+               mirrorColor: !goray!rgb [1.0, 1.0, 1.0]
+               diffuseReflect: 1.0
+         -  {vertices: [0, 3, 2], material: *white}
+lights:
+   -  !std!lights/area
+      corner: !goray!vec [-0.5, 2.5, -0.5]
+      point1: !goray!vec [0.5, 2.5, -0.5]
+      point2: !goray!vec [-0.5, 2.5, 0.5]
+      intensity: 2.0
+camera: !std!cameras/perspective
+   position: !goray!vec [0.0, 1.5, 5.5]
+   look: !goray!vec [0.0, 0.0, 0.0]
+   up: !goray!vec [0.0, 2.5, 5.5]
+   width: 64
+   height: 64
+   focalDistance: 1.0
+tiles:
+   size: 4
+integrator: !std!integrators/pathtrace
+   samples: 8
+...
+`
+
+var testLog = log.New(ioutil.Discard)
+
+func newTestJob(name string) *Job {
+	j := New(name, strings.NewReader(testScene), yamlscene.Params{})
+	j.SceneLog, j.RenderLog = testLog, testLog
+	return j
+}
+
+func TestStatusChanSlowReader(t *testing.T) {
+	j := New("test", nil, nil)
+	ch := j.StatusChan()
+	if stat := <-ch; stat.Code != StatusNew {
+		t.Fatalf("first status = %v; want %v", stat, StatusCode(StatusNew))
+	}
+
+	// The job must not wait for a reader that has stopped reading.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		j.ChangeStatus(Status{Code: StatusRendering})
+		for i := 1; i <= 10; i++ {
+			// Give the status goroutine time to pick up each change.
+			time.Sleep(time.Millisecond)
+			j.setProgress(float64(i)/10, time.Duration(10-i)*time.Second)
+		}
+		j.ChangeStatus(Status{Code: StatusDone})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("status changes blocked on the reader")
+	}
+
+	var last Status
+	for stat := range ch {
+		last = stat
+	}
+	if last.Code != StatusDone {
+		t.Errorf("last status = %v; want %v", last, StatusCode(StatusDone))
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	j := newTestJob("test")
+	ch := j.StatusChan()
+	go func() {
+		// Cancel the job once its first tile is done.
+		for stat := range ch {
+			if stat.Progress > 0 {
+				j.Cancel()
+				return
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	if err := j.Render(&buf); err != context.Canceled {
+		t.Errorf("Render error = %v; want %v", err, context.Canceled)
+	}
+	if stat := j.Status(); stat.Code != StatusCanceled {
+		t.Errorf("status = %v; want %v", stat, StatusCode(StatusCanceled))
+	}
+	if buf.Len() > 0 {
+		t.Errorf("canceled job wrote %d bytes", buf.Len())
+	}
+}
+
+func TestJobRenderContextDone(t *testing.T) {
+	j := newTestJob("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	if err := j.RenderContext(ctx, &buf); err != context.Canceled {
+		t.Errorf("RenderContext error = %v; want %v", err, context.Canceled)
+	}
+	if stat := j.Status(); stat.Code != StatusCanceled {
+		t.Errorf("status = %v; want %v", stat, StatusCode(StatusCanceled))
+	}
+}
+
+// memStorage keeps the output of jobs in memory.
+type memStorage struct {
+	mu     sync.Mutex
+	output map[string]*bytes.Buffer
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func (s *memStorage) OpenReader(j *Job) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ioutil.NopCloser(bytes.NewReader(s.output[j.Name].Bytes())), nil
+}
+
+func (s *memStorage) OpenWriter(j *Job) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := new(bytes.Buffer)
+	s.output[j.Name] = buf
+	return nopCloser{buf}, nil
+}
+
+func TestManagerCancelQueued(t *testing.T) {
+	storage := &memStorage{output: make(map[string]*bytes.Buffer)}
+	m := NewManager(storage, 2)
+	j1, err := m.New(strings.NewReader(testScene), yamlscene.Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2, err := m.New(strings.NewReader(testScene), yamlscene.Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j1.SceneLog, j1.RenderLog = testLog, testLog
+	j2.SceneLog, j2.RenderLog = testLog, testLog
+
+	if err := m.Cancel(j2.Name); err != nil {
+		t.Fatal(err)
+	}
+	if stat := j2.Status(); stat.Code != StatusCanceled {
+		t.Errorf("queued job status = %v; want %v", stat, StatusCode(StatusCanceled))
+	}
+	if err := m.Cancel("nope"); err == nil {
+		t.Error("Cancel of unknown job succeeded")
+	}
+
+	m.Stop()
+	m.RenderJobs()
+	if stat := j1.Status(); stat.Code != StatusDone {
+		t.Errorf("first job status = %v; want %v", stat, StatusCode(StatusDone))
+	}
+	if stat := j2.Status(); stat.Code != StatusCanceled {
+		t.Errorf("canceled job status = %v; want %v", stat, StatusCode(StatusCanceled))
+	}
+	if _, ok := storage.output[j2.Name]; ok {
+		t.Error("canceled job was rendered")
+	}
+}
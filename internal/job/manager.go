@@ -83,6 +83,17 @@ func (manager *Manager) Get(name string) (j *Job, ok bool) {
 	return
 }
 
+// Cancel stops the job with the given name.  A job that is waiting in the
+// queue will not be rendered.
+func (manager *Manager) Cancel(name string) error {
+	j, ok := manager.Get(name)
+	if !ok {
+		return errors.New("No job named " + name)
+	}
+	j.Cancel()
+	return nil
+}
+
 func (manager *Manager) List() (jobs []*Job) {
 	manager.lock.RLock()
 	defer manager.lock.RUnlock()
@@ -101,6 +112,10 @@ func (manager *Manager) Stop() {
 // RenderJobs renders jobs in the queue until Stop is called.
 func (manager *Manager) RenderJobs() {
 	for job := range manager.jobQueue {
+		if job.Status().Finished() {
+			// Canceled while in the queue
+			continue
+		}
 		w, err := manager.Storage.OpenWriter(job)
 		if err == nil {
 			job.Render(w)
@@ -29,6 +29,13 @@ type Status struct {
 	Code  StatusCode
 	Error error
 
+	// Progress is the fraction of the render that has finished, from 0 to 1.
+	// ETA is the estimated time left in the render.  Both are zero until the
+	// first tile finishes, and stay zero for renders whose length is not
+	// known ahead of time.
+	Progress float64
+	ETA      time.Duration
+
 	ReadTime   time.Duration
 	UpdateTime time.Duration
 	RenderTime time.Duration
@@ -37,6 +44,10 @@ type Status struct {
 
 func (status Status) String() string {
 	switch status.Code {
+	case StatusRendering:
+		if status.Progress > 0 {
+			return fmt.Sprintf("%v (%.0f%%, %v left)", status.Code, status.Progress*100, status.ETA)
+		}
 	case StatusDone:
 		return fmt.Sprintf("%v (%v)", status.Code, status.TotalTime())
 	case StatusError:
@@ -64,6 +75,8 @@ const (
 
 	StatusDone = 200
 
+	StatusCanceled = 400
+
 	StatusError = 500
 )
 
@@ -81,6 +94,8 @@ func (code StatusCode) String() string {
 		return "Writing"
 	case StatusDone:
 		return "Done"
+	case StatusCanceled:
+		return "Canceled"
 	case StatusError:
 		return "Failed"
 	}
@@ -101,6 +116,8 @@ func (code StatusCode) GoString() string {
 		return "job.StatusWriting"
 	case StatusDone:
 		return "job.StatusDone"
+	case StatusCanceled:
+		return "job.StatusCanceled"
 	case StatusError:
 		return "job.StatusError"
 	}
@@ -112,5 +129,5 @@ func (code StatusCode) Started() bool {
 }
 
 func (code StatusCode) Finished() bool {
-	return code == StatusDone || code == StatusError
+	return code == StatusDone || code == StatusCanceled || code == StatusError
 }
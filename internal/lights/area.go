@@ -0,0 +1,256 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/vecutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// areaLight is a flat light that shines from one side of a parallelogram or
+// a disk.  Every point on the light has the same radiance in all directions.
+type areaLight struct {
+	origin vec64.Vector // origin is a corner of a parallelogram or the center of a disk
+	edge1  vec64.Vector // edge1 and edge2 are the parallelogram's edges or the disk's radii
+	edge2  vec64.Vector
+	normal vec64.Vector
+	disk   bool
+
+	area    float64
+	color   color.Color
+	samples int
+}
+
+var _ goray.Light = &areaLight{}
+var _ goray.LightIntersecter = &areaLight{}
+
+// NewArea creates a light that is a parallelogram with corners at corner,
+// point1, point2, and point1 + point2 - corner.  The light shines toward the
+// side that the cross product of (point1 - corner) and (point2 - corner)
+// points to.  The light's radiance is col multiplied by intensity.
+func NewArea(corner, point1, point2 vec64.Vector, col color.Color, intensity float64, samples int) goray.Light {
+	l := &areaLight{
+		origin:  corner,
+		edge1:   vec64.Sub(point1, corner),
+		edge2:   vec64.Sub(point2, corner),
+		color:   color.ScalarMul(col, intensity),
+		samples: samples,
+	}
+	n := vec64.Cross(l.edge1, l.edge2)
+	l.area = n.Length()
+	l.normal = n.Normalize()
+	return l
+}
+
+// NewDisk creates a light that is a disk facing toward normal.  The light's
+// radiance is col multiplied by intensity.
+func NewDisk(center, normal vec64.Vector, radius float64, col color.Color, intensity float64, samples int) goray.Light {
+	l := &areaLight{
+		origin:  center,
+		normal:  normal.Normalize(),
+		disk:    true,
+		area:    math.Pi * radius * radius,
+		color:   color.ScalarMul(col, intensity),
+		samples: samples,
+	}
+	du, dv := vecutil.CreateCS(l.normal)
+	l.edge1, l.edge2 = du.Scale(radius), dv.Scale(radius)
+	return l
+}
+
+func (l *areaLight) LightFlags() uint { return goray.LightTypeNone }
+func (l *areaLight) NumSamples() int  { return l.samples }
+
+func (l *areaLight) SetScene(scene *goray.Scene) {
+}
+
+func (l *areaLight) TotalEnergy() color.Color {
+	return color.ScalarMul(l.color, l.area)
+}
+
+// samplePoint picks a point on the light uniformly.
+func (l *areaLight) samplePoint(s1, s2 float64) vec64.Vector {
+	if l.disk {
+		s1, s2 = sampleutil.ShirleyDisk(s1, s2)
+	}
+	return vec64.Add(l.origin, vec64.Add(l.edge1.Scale(s1), l.edge2.Scale(s2)))
+}
+
+// contains reports whether a point on the light's plane is on the light.
+func (l *areaLight) contains(p vec64.Vector) bool {
+	q := vec64.Sub(p, l.origin)
+	if l.disk {
+		return q.LengthSqr() <= l.edge1.LengthSqr()
+	}
+	// Find the coordinates of q along the edges.
+	det := l.area
+	u := vec64.Dot(vec64.Cross(q, l.edge2), l.normal) / det
+	v := vec64.Dot(vec64.Cross(l.edge1, q), l.normal) / det
+	return u >= 0 && u <= 1 && v >= 0 && v <= 1
+}
+
+func (l *areaLight) CanIlluminate(pt vec64.Vector) bool {
+	return vec64.Dot(vec64.Sub(pt, l.origin), l.normal) > 0
+}
+
+func (l *areaLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) bool {
+	p := l.samplePoint(s.S1, s.S2)
+	ldir := vec64.Sub(p, sp.Position)
+	distSqr := ldir.LengthSqr()
+	dist := math.Sqrt(distSqr)
+	if dist == 0 {
+		return false
+	}
+	ldir = ldir.Scale(1 / dist)
+	cos := -vec64.Dot(ldir, l.normal)
+	if cos <= 0 {
+		return false
+	}
+
+	wi.TMax = dist
+	wi.Dir = ldir
+	s.Color = l.color
+	s.Pdf = solidAnglePdf(l.area, distSqr, cos)
+	s.Flags = l.LightFlags()
+	s.Point.Position = p
+	s.Point.Normal = l.normal
+	return true
+}
+
+func (l *areaLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	ldir := vec64.Sub(spLight.Position, sp.Position)
+	distSqr := ldir.LengthSqr()
+	if distSqr == 0 {
+		return 0
+	}
+	cos := -vec64.Dot(ldir, l.normal) / math.Sqrt(distSqr)
+	if cos <= 0 {
+		return 0
+	}
+	return solidAnglePdf(l.area, distSqr, cos)
+}
+
+func (l *areaLight) Intersect(r goray.Ray) (dist float64, col color.Color, ipdf float64, ok bool) {
+	cos := -vec64.Dot(r.Dir, l.normal)
+	if cos <= 0 {
+		return
+	}
+	dist = vec64.Dot(vec64.Sub(r.From, l.origin), l.normal) / cos
+	if dist <= 0 || (r.TMax >= 0 && dist > r.TMax) {
+		return 0, nil, 0, false
+	}
+	if !l.contains(vec64.Add(r.From, r.Dir.Scale(dist))) {
+		return 0, nil, 0, false
+	}
+	return dist, l.color, 1 / solidAnglePdf(l.area, dist*dist, cos), true
+}
+
+// solidAnglePdf converts the density of picking a point uniformly on an
+// emitter with the given area to a density over directions from a point
+// distSqr away, multiplied by pi like the PDFs of materials.  cos is the cosine
+// of the angle between the emitter's normal and the direction from the emitter.
+func solidAnglePdf(area, distSqr, cos float64) float64 {
+	return math.Pi * distSqr / (area * cos)
+}
+
+// emitDir picks a direction for light leaving a Lambertian emitter with the
+// given normal.  The returned PDF is multiplied by pi.
+func emitDir(normal vec64.Vector, s1, s2 float64) (dir vec64.Vector, pdf float64) {
+	du, dv := vecutil.CreateCS(normal)
+	dir = sampleutil.CosHemisphere(normal, du, dv, s1, s2)
+	return dir, vec64.Dot(dir, normal)
+}
+
+func (l *areaLight) EmitPhoton(s1, s2, s3, s4 float64) (color.Color, goray.Ray, float64) {
+	r := goray.Ray{From: l.samplePoint(s3, s4)}
+	r.Dir, _ = emitDir(l.normal, s1, s2)
+	return color.ScalarDiv(l.color, math.Pi), r, math.Pi * l.area
+}
+
+func (l *areaLight) EmitSample(s *goray.LightSample) (wo vec64.Vector, col color.Color) {
+	s.Point.Position = l.samplePoint(s.S1, s.S2)
+	s.Point.Normal = l.normal
+	s.AreaPdf = 1 / l.area
+	wo, s.DirPdf = emitDir(l.normal, s.S3, s.S4)
+	s.Flags = l.LightFlags()
+	return wo, color.ScalarDiv(l.color, math.Pi)
+}
+
+func (l *areaLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	cosWo = vec64.Dot(l.normal, wo)
+	return 1 / l.area, math.Max(cosWo, 0), cosWo
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"lights/area"] = yamlscene.MapConstruct(constructArea)
+	yamlscene.Constructor[yamlscene.StdPrefix+"lights/disk"] = yamlscene.MapConstruct(constructDisk)
+}
+
+func constructArea(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	corner, ok1 := m["corner"].(vec64.Vector)
+	point1, ok2 := m["point1"].(vec64.Vector)
+	point2, ok3 := m["point2"].(vec64.Vector)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("Area light must have corner, point1, and point2")
+	}
+	if vec64.Cross(vec64.Sub(point1, corner), vec64.Sub(point2, corner)).IsZero() {
+		return nil, errors.New("Area light has no area")
+	}
+	col, ok := m.SetDefault("color", color.White).(color.Color)
+	if !ok {
+		return nil, errors.New("Area light color must be a color")
+	}
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	return NewArea(corner, point1, point2, col, intensity, samples), nil
+}
+
+func constructDisk(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	pos, ok := m["position"].(vec64.Vector)
+	if !ok {
+		return nil, errors.New("Disk light must have a position")
+	}
+	normal, ok := m["normal"].(vec64.Vector)
+	if !ok || normal.IsZero() {
+		return nil, errors.New("Disk light must have a nonzero normal")
+	}
+	radius, ok := yamldata.AsFloat(m["radius"])
+	if !ok || radius <= 0 {
+		return nil, errors.New("Disk light must have a positive radius")
+	}
+	col, ok := m.SetDefault("color", color.White).(color.Color)
+	if !ok {
+		return nil, errors.New("Disk light color must be a color")
+	}
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	return NewDisk(pos, normal, radius, col, intensity, samples), nil
+}
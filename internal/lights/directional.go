@@ -0,0 +1,271 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/vecutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// directionalLight is a light infinitely far away, so its light arrives
+// everywhere from the same direction.
+type directionalLight struct {
+	direction vec64.Vector // direction is toward the light
+	du, dv    vec64.Vector
+	color     color.Color
+
+	center vec64.Vector
+	radius float64
+}
+
+var _ goray.DiracLight = &directionalLight{}
+
+// NewDirectional creates a light that shines from dir, which points toward
+// the light.
+func NewDirectional(dir vec64.Vector, col color.Color, intensity float64) goray.Light {
+	l := &directionalLight{
+		direction: dir.Normalize(),
+		color:     color.ScalarMul(col, intensity),
+	}
+	l.du, l.dv = vecutil.CreateCS(l.direction)
+	return l
+}
+
+func (l *directionalLight) LightFlags() uint {
+	return goray.LightTypeDiracDir | goray.LightTypeInfinite
+}
+
+func (l *directionalLight) NumSamples() int {
+	return 1
+}
+
+func (l *directionalLight) SetScene(scene *goray.Scene) {
+	b := scene.Bound()
+	l.center = b.Center()
+	l.radius = vec64.Sub(b.Max, l.center).Length()
+}
+
+func (l *directionalLight) TotalEnergy() color.Color {
+	// The light passes through a disk as wide as the scene.
+	return color.ScalarMul(l.color, math.Pi*l.radius*l.radius)
+}
+
+// emit picks a ray of light that enters the scene from a disk that covers the
+// scene.
+func (l *directionalLight) emit(s1, s2 float64) goray.Ray {
+	u, v := sampleutil.ShirleyDisk(s1, s2)
+	offset := vec64.Add(l.du.Scale(u), l.dv.Scale(v))
+	return goray.Ray{
+		From: vec64.Add(l.center, vec64.Add(l.direction, offset).Scale(l.radius)),
+		Dir:  l.direction.Negate(),
+	}
+}
+
+func (l *directionalLight) EmitPhoton(s1, s2, s3, s4 float64) (color.Color, goray.Ray, float64) {
+	return l.color, l.emit(s1, s2), math.Pi * l.radius * l.radius
+}
+
+func (l *directionalLight) EmitSample(s *goray.LightSample) (vec64.Vector, color.Color) {
+	r := l.emit(s.S1, s.S2)
+	s.Point.Position = r.From
+	s.AreaPdf = 1 / (math.Pi * l.radius * l.radius)
+	s.DirPdf = math.Pi
+	s.Flags = l.LightFlags()
+	return r.Dir, l.color
+}
+
+func (l *directionalLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	return 1 / (math.Pi * l.radius * l.radius), math.Pi, 1
+}
+
+func (l *directionalLight) CanIlluminate(pt vec64.Vector) bool {
+	return true
+}
+
+func (l *directionalLight) Illuminate(sp goray.SurfacePoint, wi *goray.Ray) (col color.Color, ok bool) {
+	wi.Dir = l.direction
+	wi.TMax = -1
+	return l.color, true
+}
+
+func (l *directionalLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) (ok bool) {
+	s.Color, ok = l.Illuminate(sp, wi)
+	s.Flags = l.LightFlags()
+	s.Pdf = 1
+	return
+}
+
+func (l *directionalLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	return 0
+}
+
+// sunLight is a directional light that fills a small cone of directions, like
+// the sun's disk.  Unlike directionalLight, it casts soft shadows.
+type sunLight struct {
+	direction vec64.Vector // direction is toward the center of the light
+	du, dv    vec64.Vector
+	cosAngle  float64 // cosAngle is the cosine of the cone's half-angle
+	color     color.Color
+	samples   int
+
+	center vec64.Vector
+	radius float64
+}
+
+var _ goray.Light = &sunLight{}
+var _ goray.LightIntersecter = &sunLight{}
+
+// NewSun creates a light that shines from a cone around dir, which points
+// toward the light.  angle is the angular diameter of the light in degrees.
+// The light gives the same irradiance as a directional light with the same
+// color and intensity.
+func NewSun(dir vec64.Vector, col color.Color, intensity, angle float64, samples int) goray.Light {
+	halfAngle := angle * math.Pi / 360
+	l := &sunLight{
+		direction: dir.Normalize(),
+		cosAngle:  math.Cos(halfAngle),
+		samples:   samples,
+	}
+	// A cone of constant radiance L gives an irradiance of
+	// pi*L*sin^2(halfAngle) to a surface facing it.
+	sinAngle := math.Sin(halfAngle)
+	l.color = color.ScalarMul(col, intensity/(sinAngle*sinAngle))
+	l.du, l.dv = vecutil.CreateCS(l.direction)
+	return l
+}
+
+func (l *sunLight) LightFlags() uint { return goray.LightTypeInfinite }
+func (l *sunLight) NumSamples() int  { return l.samples }
+
+func (l *sunLight) SetScene(scene *goray.Scene) {
+	b := scene.Bound()
+	l.center = b.Center()
+	l.radius = vec64.Sub(b.Max, l.center).Length()
+}
+
+// pdf returns the density of picking a direction in the cone, multiplied by
+// pi like the PDFs of materials.
+func (l *sunLight) pdf() float64 {
+	return 1 / (2 * (1 - l.cosAngle))
+}
+
+func (l *sunLight) TotalEnergy() color.Color {
+	// The light passes through a disk as wide as the scene from every
+	// direction in the cone.
+	return color.ScalarMul(l.color, math.Pi*l.radius*l.radius/l.pdf())
+}
+
+// emit picks a ray of light that enters the scene from a disk that covers the
+// scene, perpendicular to a direction in the cone.
+func (l *sunLight) emit(s1, s2, s3, s4 float64) goray.Ray {
+	dir := sampleutil.Cone(l.direction, l.du, l.dv, l.cosAngle, s3, s4)
+	du, dv := vecutil.CreateCS(dir)
+	u, v := sampleutil.ShirleyDisk(s1, s2)
+	offset := vec64.Add(du.Scale(u), dv.Scale(v))
+	return goray.Ray{
+		From: vec64.Add(l.center, vec64.Add(dir, offset).Scale(l.radius)),
+		Dir:  dir.Negate(),
+	}
+}
+
+func (l *sunLight) EmitPhoton(s1, s2, s3, s4 float64) (color.Color, goray.Ray, float64) {
+	return l.color, l.emit(s1, s2, s3, s4), math.Pi * l.radius * l.radius / l.pdf()
+}
+
+func (l *sunLight) EmitSample(s *goray.LightSample) (vec64.Vector, color.Color) {
+	r := l.emit(s.S1, s.S2, s.S3, s.S4)
+	s.Point.Position = r.From
+	s.AreaPdf = 1 / (math.Pi * l.radius * l.radius)
+	s.DirPdf = l.pdf()
+	s.Flags = l.LightFlags()
+	return r.Dir, color.ScalarDiv(l.color, math.Pi)
+}
+
+func (l *sunLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	areaPdf, cosWo = 1/(math.Pi*l.radius*l.radius), 1
+	if vec64.Dot(wo.Negate(), l.direction) >= l.cosAngle {
+		dirPdf = l.pdf()
+	}
+	return
+}
+
+func (l *sunLight) CanIlluminate(pt vec64.Vector) bool {
+	return true
+}
+
+func (l *sunLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) bool {
+	dir := sampleutil.Cone(l.direction, l.du, l.dv, l.cosAngle, s.S1, s.S2)
+	wi.Dir = dir
+	wi.TMax = -1
+	s.Color = l.color
+	s.Pdf = l.pdf()
+	s.Flags = l.LightFlags()
+	s.Point.Position = vec64.Add(sp.Position, dir.Scale(2*l.radius))
+	return true
+}
+
+func (l *sunLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	if vec64.Dot(vec64.Sub(spLight.Position, sp.Position).Normalize(), l.direction) < l.cosAngle {
+		return 0
+	}
+	return l.pdf()
+}
+
+func (l *sunLight) Intersect(r goray.Ray) (dist float64, col color.Color, ipdf float64, ok bool) {
+	if vec64.Dot(r.Dir.Normalize(), l.direction) < l.cosAngle {
+		return
+	}
+	return math.Inf(1), l.color, 1 / l.pdf(), true
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"lights/directional"] = yamlscene.MapConstruct(constructDirectional)
+}
+
+func constructDirectional(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	dir, ok := m["direction"].(vec64.Vector)
+	if !ok || dir.IsZero() {
+		return nil, errors.New("Directional light must have a nonzero direction")
+	}
+	col, ok := m.SetDefault("color", color.White).(color.Color)
+	if !ok {
+		return nil, errors.New("Directional light color must be a color")
+	}
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	angle, _ := yamldata.AsFloat(m.SetDefault("angle", 0.0))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	if angle < 0 || angle >= 180 {
+		return nil, errors.New("Directional light angle must be between 0 and 180 degrees")
+	}
+	if angle == 0 {
+		return NewDirectional(dir, col, intensity), nil
+	}
+	return NewSun(dir, col, intensity, angle, samples), nil
+}
@@ -0,0 +1,305 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/vecutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yaml/parser"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// Resolution of the grid of directions that photons are sampled from.
+const (
+	iesGridWidth  = 360 // around the axis
+	iesGridHeight = 180 // from the nadir to the zenith
+)
+
+// iesLight is a point light whose intensity in each direction follows a
+// measured photometric profile.  Like a spot light, it can be limited to a
+// cone.
+type iesLight struct {
+	position         vec64.Vector
+	direction        vec64.Vector // direction is the profile's nadir
+	du, dv           vec64.Vector // du is the profile's 0 degree plane
+	profile          *IESProfile
+	color            color.Color // color is the light per candela
+	cosStart, cosEnd float64
+	icosDiff         float64
+
+	dist  sampleutil.Pdf2D
+	power color.Color
+}
+
+var _ goray.DiracLight = &iesLight{}
+
+// NewIES creates a light at from whose profile's nadir points at to.  The
+// profile is turned by rotation degrees around its axis.  The light's color
+// is col multiplied by intensity and the profile's candela values.  If angle
+// is less than 180 degrees, the light is cut off outside of a cone like a
+// spot light's.
+func NewIES(from, to vec64.Vector, profile *IESProfile, col color.Color, intensity, rotation, angle, falloff float64) goray.Light {
+	l := &iesLight{
+		position:  from,
+		direction: vec64.Sub(to, from).Normalize(),
+		profile:   profile,
+		color:     color.ScalarMul(col, intensity),
+		cosStart:  -1,
+		cosEnd:    -1,
+	}
+	du, dv := vecutil.CreateCS(l.direction)
+	rot := rotation * math.Pi / 180
+	l.du = vec64.Add(du.Scale(math.Cos(rot)), dv.Scale(math.Sin(rot)))
+	l.dv = vec64.Sub(dv.Scale(math.Cos(rot)), du.Scale(math.Sin(rot)))
+	if angle < 180 {
+		radAngle := angle * math.Pi / 180
+		l.cosStart = math.Cos(radAngle * (1 - falloff))
+		l.cosEnd = math.Cos(radAngle)
+		if l.cosStart > l.cosEnd {
+			l.icosDiff = 1 / (l.cosStart - l.cosEnd)
+		}
+	}
+
+	// Pick photon directions in proportion to the intensity.  Each cell is
+	// weighted by the brightest of its corners and center so that no
+	// direction that gives off light is left out.
+	f := make([]float64, iesGridWidth*iesGridHeight)
+	power := 0.0
+	for y := 0; y < iesGridHeight; y++ {
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / iesGridHeight)
+		for x := 0; x < iesGridWidth; x++ {
+			power += l.intensity(l.gridDir(float64(x)+0.5, float64(y)+0.5)) * sinTheta
+			m := 0.0
+			for _, c := range [...][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {0.5, 0.5}} {
+				m = math.Max(m, l.intensity(l.gridDir(float64(x)+c[0], float64(y)+c[1])))
+			}
+			f[y*iesGridWidth+x] = m * sinTheta
+		}
+	}
+	// The grid covers 2*pi by pi radians.
+	power *= 2 * math.Pi * math.Pi / (iesGridWidth * iesGridHeight)
+	l.power = color.ScalarMul(l.color, power)
+	if power > 0 {
+		l.dist = sampleutil.NewPdf2D(f, iesGridWidth)
+	}
+	return l
+}
+
+// gridDir returns the direction at a point on the sampling grid.
+func (l *iesLight) gridDir(x, y float64) vec64.Vector {
+	theta := math.Pi * y / iesGridHeight
+	phi := 2 * math.Pi * x / iesGridWidth
+	sinTheta := math.Sin(theta)
+	return vec64.Add(
+		vec64.Add(l.du.Scale(sinTheta*math.Cos(phi)), l.dv.Scale(sinTheta*math.Sin(phi))),
+		l.direction.Scale(math.Cos(theta)),
+	)
+}
+
+// angles returns the angles of a direction in the profile's coordinates.
+func (l *iesLight) angles(dir vec64.Vector) (theta, phi float64) {
+	cosTheta := math.Max(-1, math.Min(1, vec64.Dot(dir, l.direction)))
+	theta = math.Acos(cosTheta)
+	phi = math.Atan2(vec64.Dot(dir, l.dv), vec64.Dot(dir, l.du))
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	return
+}
+
+// intensity returns the candela value in a direction, including the falloff
+// of the cone.
+func (l *iesLight) intensity(dir vec64.Vector) float64 {
+	cosa := vec64.Dot(dir, l.direction)
+	falloff := 1.0
+	switch {
+	case cosa < l.cosEnd:
+		return 0
+	case cosa < l.cosStart:
+		v := (cosa - l.cosEnd) * l.icosDiff
+		falloff = v * v * (3 - 2*v)
+	}
+	return l.profile.At(l.angles(dir)) * falloff
+}
+
+// pdf returns the density of sample picking a direction, multiplied by pi
+// like the PDFs of materials.
+func (l *iesLight) pdf(dir vec64.Vector) float64 {
+	if color.Energy(l.power) <= 0 {
+		return 0
+	}
+	theta, phi := l.angles(dir)
+	sinTheta := math.Sin(theta)
+	if sinTheta <= 0 {
+		return 0
+	}
+	x := phi / (2 * math.Pi) * iesGridWidth
+	y := theta / math.Pi * iesGridHeight
+	return l.dist.Pdf(x, y) / (2 * math.Pi * sinTheta)
+}
+
+// sample picks a direction in proportion to the light's intensity.
+func (l *iesLight) sample(s1, s2 float64) (dir vec64.Vector, pdf float64) {
+	if color.Energy(l.power) <= 0 {
+		return vec64.Vector{}, 0
+	}
+	x, y, pdfImage := l.dist.Sample(s1, s2)
+	sinTheta := math.Sin(math.Pi * y / iesGridHeight)
+	if sinTheta <= 0 {
+		return vec64.Vector{}, 0
+	}
+	return l.gridDir(x, y), pdfImage / (2 * math.Pi * sinTheta)
+}
+
+func (l *iesLight) LightFlags() uint {
+	return goray.LightTypeSingular
+}
+
+func (l *iesLight) NumSamples() int {
+	return 1
+}
+
+func (l *iesLight) SetScene(scene *goray.Scene) {
+}
+
+func (l *iesLight) TotalEnergy() color.Color {
+	return l.power
+}
+
+func (l *iesLight) Illuminate(sp goray.SurfacePoint, wi *goray.Ray) (col color.Color, ok bool) {
+	ldir := vec64.Sub(l.position, sp.Position)
+	distSqr := ldir.LengthSqr()
+	dist := math.Sqrt(distSqr)
+	if dist == 0 {
+		return
+	}
+	ldir = ldir.Scale(1 / dist)
+	i := l.intensity(ldir.Negate())
+	if i <= 0 {
+		return
+	}
+	wi.TMax = dist
+	wi.Dir = ldir
+	return color.ScalarMul(l.color, i/distSqr), true
+}
+
+func (l *iesLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) (ok bool) {
+	s.Color, ok = l.Illuminate(sp, wi)
+	if ok {
+		s.Flags = l.LightFlags()
+		s.Pdf = vec64.Sub(l.position, sp.Position).LengthSqr()
+	}
+	return
+}
+
+func (l *iesLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	return 0
+}
+
+func (l *iesLight) CanIlluminate(pt vec64.Vector) bool {
+	ldir := vec64.Sub(pt, l.position)
+	dist := ldir.Length()
+	if dist == 0 {
+		return false
+	}
+	return l.intensity(ldir.Scale(1/dist)) > 0
+}
+
+func (l *iesLight) EmitPhoton(s1, s2, s3, s4 float64) (col color.Color, r goray.Ray, ipdf float64) {
+	r.From = l.position
+	dir, pdf := l.sample(s1, s2)
+	if pdf <= 0 {
+		return color.Black, r, 0
+	}
+	r.Dir = dir
+	return color.ScalarMul(l.color, l.intensity(dir)), r, math.Pi / pdf
+}
+
+func (l *iesLight) EmitSample(s *goray.LightSample) (wo vec64.Vector, col color.Color) {
+	s.Point.Position = l.position
+	s.AreaPdf = 1
+	s.Flags = l.LightFlags()
+	wo, s.DirPdf = l.sample(s.S1, s.S2)
+	if s.DirPdf <= 0 {
+		return wo, color.Black
+	}
+	return wo, color.ScalarMul(l.color, l.intensity(wo))
+}
+
+func (l *iesLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	return 1, l.pdf(wo), 1
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"lights/ies"] = yamldata.ConstructorFunc(constructIES)
+}
+
+func constructIES(n parser.Node, ud interface{}) (interface{}, error) {
+	mm, ok := n.(*parser.Mapping)
+	if !ok {
+		return nil, errors.New("Constructor requires a mapping")
+	}
+
+	var loader IESLoader
+	if userData, ok := ud.(yamlscene.Params); ok && userData != nil {
+		loader, ok = userData["IESLoader"].(IESLoader)
+		if !ok && userData["IESLoader"] != nil {
+			return nil, errors.New("IESLoader does not implement lights.IESLoader interface")
+		}
+	}
+	if loader == nil {
+		return nil, errors.New("No IES loader provided")
+	}
+
+	m := yamldata.Map(mm.Map()).Copy()
+	pos, ok := m["position"].(vec64.Vector)
+	if !ok {
+		return nil, errors.New("IES light must have a position")
+	}
+	look, ok := m["look"].(vec64.Vector)
+	if !ok || vec64.Sub(look, pos).IsZero() {
+		return nil, errors.New("IES light must look away from its position")
+	}
+	name, ok := m["file"].(string)
+	if !ok {
+		return nil, errors.New("IES light must have a file")
+	}
+	col, ok := m.SetDefault("color", color.White).(color.Color)
+	if !ok {
+		return nil, errors.New("IES light color must be a color")
+	}
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	rotation, _ := yamldata.AsFloat(m.SetDefault("rotation", 0.0))
+	angle, _ := yamldata.AsFloat(m.SetDefault("coneAngle", 180.0))
+	falloff, _ := yamldata.AsFloat(m.SetDefault("falloff", 0.0))
+	profile, err := loader.LoadIES(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewIES(pos, look, profile, col, intensity, rotation, angle, falloff), nil
+}
@@ -0,0 +1,265 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IESProfile is the candela distribution of a luminaire, as measured in an
+// IES LM-63 photometric data file.  Only type C photometry is supported.
+type IESProfile struct {
+	// VerticalAngles are the angles in degrees from the nadir (straight down
+	// from the luminaire) at which the candela values were measured, in
+	// increasing order.
+	VerticalAngles []float64
+
+	// HorizontalAngles are the angles in degrees around the luminaire's axis
+	// at which the candela values were measured, in increasing order.  The
+	// last angle tells how the distribution is symmetric: 0 means that it is
+	// the same in every direction, 90 that each quadrant is the same and 180
+	// that it is symmetric about the 0-180 degree plane.  A profile that goes
+	// from 90 to 270 degrees is symmetric about the 90-270 degree plane.
+	HorizontalAngles []float64
+
+	// Candela holds the luminous intensities for every horizontal angle, each
+	// of which has a value for every vertical angle.  The file's candela
+	// multiplier and ballast factor have already been applied.
+	Candela [][]float64
+}
+
+// At returns the luminous intensity of the profile in a direction, given in
+// radians.  theta is the angle from the nadir and phi is the angle around the
+// luminaire's axis.  Intensities between measured angles are interpolated.
+func (p *IESProfile) At(theta, phi float64) float64 {
+	v := theta * 180 / math.Pi
+	vs := p.VerticalAngles
+	if v < vs[0] || v > vs[len(vs)-1] {
+		return 0
+	}
+	vi, vt := angleInterval(vs, v)
+
+	hs := p.HorizontalAngles
+	h := math.Mod(phi*180/math.Pi, 360)
+	if h < 0 {
+		h += 360
+	}
+	first, last := hs[0], hs[len(hs)-1]
+	switch {
+	case len(hs) == 1:
+		return p.at(0, vi, vt)
+	case first == 90 && last == 270:
+		if h < 90 || h > 270 {
+			h = math.Mod(540-h, 360)
+		}
+	case last == 90:
+		if h > 180 {
+			h = 360 - h
+		}
+		if h > 90 {
+			h = 180 - h
+		}
+	case last == 180:
+		if h > 180 {
+			h = 360 - h
+		}
+	}
+	if h < first || h > last {
+		// The distribution goes all the way around, so the last angle wraps
+		// around to the first.
+		if h < first {
+			h += 360
+		}
+		t := (h - last) / (first + 360 - last)
+		return (1-t)*p.at(len(hs)-1, vi, vt) + t*p.at(0, vi, vt)
+	}
+	hi, ht := angleInterval(hs, h)
+	if ht == 0 {
+		return p.at(hi, vi, vt)
+	}
+	return (1-ht)*p.at(hi, vi, vt) + ht*p.at(hi+1, vi, vt)
+}
+
+// at interpolates the intensities of a horizontal angle between two
+// vertical angles.
+func (p *IESProfile) at(h, v int, t float64) float64 {
+	c := p.Candela[h]
+	if t == 0 {
+		return c[v]
+	}
+	return (1-t)*c[v] + t*c[v+1]
+}
+
+// angleInterval finds the angles in a sorted list that x lies between.  x
+// must be in the list's range.
+func angleInterval(angles []float64, x float64) (i int, t float64) {
+	i = sort.SearchFloat64s(angles, x)
+	if i < len(angles) && angles[i] == x {
+		return i, 0
+	}
+	i--
+	return i, (x - angles[i]) / (angles[i+1] - angles[i])
+}
+
+// ReadIES reads an IES LM-63 photometric data file.
+func ReadIES(r io.Reader) (*IESProfile, error) {
+	br := bufio.NewReader(r)
+	// Skip the header and keywords, up to the TILT line.
+	var tilt string
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "TILT=") {
+			tilt = strings.TrimPrefix(line, "TILT=")
+			break
+		}
+		if err == io.EOF {
+			return nil, errors.New("IES file has no TILT line")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	rest, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.FieldsFunc(string(rest), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	numbers := make([]float64, len(fields))
+	for i := range fields {
+		numbers[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, errors.New("IES file has a bad number: " + fields[i])
+		}
+	}
+	next := func(n int) ([]float64, error) {
+		if len(numbers) < n {
+			return nil, errors.New("IES file is too short")
+		}
+		vals := numbers[:n]
+		numbers = numbers[n:]
+		return vals, nil
+	}
+
+	if tilt == "INCLUDE" {
+		// The tilt data only matters for luminaires that are mounted at an
+		// angle, so it is skipped.
+		head, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := next(2 * int(head[1])); err != nil {
+			return nil, err
+		}
+	}
+	head, err := next(13)
+	if err != nil {
+		return nil, err
+	}
+	multiplier, nv, nh, photometricType, ballast := head[2], int(head[3]), int(head[4]), head[5], head[10]
+	if photometricType != 1 {
+		return nil, errors.New("Only type C IES photometry is supported")
+	}
+	if nv < 1 || nh < 1 {
+		return nil, errors.New("IES file must have at least one angle")
+	}
+	p := new(IESProfile)
+	if p.VerticalAngles, err = next(nv); err != nil {
+		return nil, err
+	}
+	if p.HorizontalAngles, err = next(nh); err != nil {
+		return nil, err
+	}
+	if !sort.Float64sAreSorted(p.VerticalAngles) || !sort.Float64sAreSorted(p.HorizontalAngles) {
+		return nil, errors.New("IES angles must be in increasing order")
+	}
+	p.Candela = make([][]float64, nh)
+	for i := range p.Candela {
+		if p.Candela[i], err = next(nv); err != nil {
+			return nil, err
+		}
+		for j := range p.Candela[i] {
+			p.Candela[i][j] *= multiplier * ballast
+		}
+	}
+	return p, nil
+}
+
+// IESLoader is an interface for retrieving photometric profiles with a name.
+type IESLoader interface {
+	LoadIES(name string) (*IESProfile, error)
+}
+
+// IESLoaderFunc uses a function to perform loads.
+type IESLoaderFunc func(string) (*IESProfile, error)
+
+func (f IESLoaderFunc) LoadIES(name string) (*IESProfile, error) {
+	return f(name)
+}
+
+type fileIESLoader struct {
+	Base  string
+	Clean bool
+}
+
+func (l *fileIESLoader) LoadIES(name string) (*IESProfile, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+	if l.Clean {
+		name = slashpath.Clean("/" + name)
+	}
+	path := filepath.FromSlash(name)
+	if l.Clean || name[0] != '/' {
+		path = filepath.Join(l.Base, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadIES(f)
+}
+
+// NewIESLoader creates a profile loader that reads IES files relative to the
+// given directory.  Users of the loader can access anything in local storage.
+func NewIESLoader(base string) IESLoader {
+	return &fileIESLoader{Base: base}
+}
+
+// NewIESLoaderDirectory creates a profile loader that is rooted at a given
+// directory.  Users of the loader will not directly be able to access
+// anything outside the directory, but symlinks inside the directory will be
+// followed.
+func NewIESLoaderDirectory(base string) IESLoader {
+	return &fileIESLoader{Base: base, Clean: true}
+}
@@ -0,0 +1,81 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testIES = `IESNA:LM-63-2002
+[TEST] quadrant symmetric
+TILT=INCLUDE
+1
+2
+0 90
+1 1
+1 -1 2.0 3 3 1 2 0 0 0
+0.5 1.0 100
+0 45 90
+0 45 90
+10 6 2
+10 4 1
+10, 2, 0
+`
+
+func TestReadIES(t *testing.T) {
+	p, err := ReadIES(strings.NewReader(testIES))
+	if err != nil {
+		t.Fatal("ReadIES:", err)
+	}
+	if len(p.VerticalAngles) != 3 || len(p.HorizontalAngles) != 3 {
+		t.Fatalf("angles = %v, %v; want 3 of each", p.VerticalAngles, p.HorizontalAngles)
+	}
+	const deg = math.Pi / 180
+	tests := []struct {
+		Theta, Phi float64
+		Expected   float64
+	}{
+		{0, 0, 10},
+		{45 * deg, 0, 6},
+		{45 * deg, 45 * deg, 4},
+		{45 * deg, 90 * deg, 2},
+		{67.5 * deg, 0, 4},
+		{45 * deg, 22.5 * deg, 5},
+		{45 * deg, 135 * deg, 4},
+		{45 * deg, 270 * deg, 2},
+		{45 * deg, 315 * deg, 4},
+		{120 * deg, 0, 0},
+	}
+	for _, test := range tests {
+		if i := p.At(test.Theta, test.Phi); math.Abs(i-test.Expected) > 1e-9 {
+			t.Errorf("At(%.1f°, %.1f°) = %v; want %v", test.Theta/deg, test.Phi/deg, i, test.Expected)
+		}
+	}
+}
+
+func TestReadIESShort(t *testing.T) {
+	short := testIES[:strings.LastIndex(testIES, "10,")]
+	if _, err := ReadIES(strings.NewReader(short)); err == nil {
+		t.Error("ReadIES did not fail on a truncated file")
+	}
+}
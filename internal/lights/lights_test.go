@@ -0,0 +1,294 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/mat64"
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+)
+
+var areaLightTests = []struct {
+	Name  string
+	Light goray.Light
+}{
+	{"area", NewArea(vec64.Vector{-1, 0, -0.5}, vec64.Vector{1, 0, -0.5}, vec64.Vector{-0.5, 0, 0.5}, color.White, 2, 1)},
+	{"disk", NewDisk(vec64.Vector{0, 1, 0}, vec64.Vector{1, 1, 0}, 0.75, color.White, 2, 1)},
+	{"sphere", NewSphere(vec64.Vector{0, 0, 1}, 0.5, color.White, 2, 1)},
+}
+
+// receivedPower estimates the power that reaches a sphere of radius r around
+// the origin from a light, in the units of TotalEnergy.
+func receivedPower(l goray.Light, r float64, n int) float64 {
+	rng := rand.New(rand.NewSource(1))
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		normal := sampleutil.Sphere(rng.Float64(), rng.Float64()).Negate()
+		sp := goray.SurfacePoint{Position: normal.Scale(-r), Normal: normal}
+		wi := goray.Ray{From: sp.Position, TMax: -1}
+		var col color.Color
+		if dl, ok := l.(goray.DiracLight); ok {
+			if col, ok = dl.Illuminate(sp, &wi); !ok {
+				continue
+			}
+		} else {
+			s := goray.LightSample{S1: rng.Float64(), S2: rng.Float64()}
+			if !l.IlluminateSample(sp, &wi, &s) || s.Pdf <= 0 {
+				continue
+			}
+			// Pdf is pi times the solid angle density.
+			col = color.ScalarMul(s.Color, math.Pi/s.Pdf)
+		}
+		sum += col.Red() * math.Max(vec64.Dot(normal, wi.Dir), 0)
+	}
+	// The power that arrives is the irradiance integrated over the sphere,
+	// and TotalEnergy leaves out a factor of pi.
+	return sum / float64(n) * 4 * r * r
+}
+
+func TestAreaLightPdf(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range areaLightTests {
+		li := test.Light.(goray.LightIntersecter)
+		n := 0
+		for i := 0; i < 1000; i++ {
+			sp := goray.SurfacePoint{Position: sampleutil.Sphere(rng.Float64(), rng.Float64()).Scale(3)}
+			wi := goray.Ray{From: sp.Position, TMax: -1}
+			s := goray.LightSample{S1: rng.Float64(), S2: rng.Float64()}
+			if !test.Light.IlluminateSample(sp, &wi, &s) {
+				continue
+			}
+			n++
+			if pdf := test.Light.IlluminatePdf(sp, s.Point); math.Abs(pdf-s.Pdf) > 1e-6*s.Pdf {
+				t.Errorf("%s: IlluminatePdf = %v; IlluminateSample pdf = %v", test.Name, pdf, s.Pdf)
+			}
+			dist, _, ipdf, ok := li.Intersect(goray.Ray{From: sp.Position, Dir: wi.Dir, TMax: -1})
+			if !ok {
+				t.Errorf("%s: sampled ray from %v toward %v misses the light", test.Name, sp.Position, wi.Dir)
+				continue
+			}
+			if math.Abs(dist-wi.TMax) > 1e-6 || math.Abs(ipdf*s.Pdf-1) > 1e-6 {
+				t.Errorf("%s: Intersect = %v, 1/%v; want %v, 1/%v", test.Name, dist, 1/ipdf, wi.TMax, s.Pdf)
+			}
+		}
+		if n == 0 {
+			t.Errorf("%s: light illuminates nothing", test.Name)
+		}
+	}
+}
+
+func TestAreaLightEmitPdf(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range areaLightTests {
+		for i := 0; i < 100; i++ {
+			s := goray.LightSample{S1: rng.Float64(), S2: rng.Float64(), S3: rng.Float64(), S4: rng.Float64()}
+			wo, _ := test.Light.EmitSample(&s)
+			areaPdf, dirPdf, _ := test.Light.EmitPdf(s.Point, wo)
+			if math.Abs(areaPdf-s.AreaPdf) > 1e-9*s.AreaPdf || math.Abs(dirPdf-s.DirPdf) > 1e-9*s.DirPdf {
+				t.Errorf("%s: EmitPdf = %v, %v; EmitSample pdfs = %v, %v", test.Name, areaPdf, dirPdf, s.AreaPdf, s.DirPdf)
+			}
+		}
+	}
+}
+
+func TestAreaLightPower(t *testing.T) {
+	for _, test := range areaLightTests {
+		want := test.Light.TotalEnergy().Red()
+		if got := receivedPower(test.Light, 10, 200000); math.Abs(got-want) > 0.02*want {
+			t.Errorf("%s: power = %v; TotalEnergy = %v", test.Name, got, want)
+		}
+	}
+}
+
+func TestIESMatchesPoint(t *testing.T) {
+	pos := vec64.Vector{1, 2, 3}
+	profile := &IESProfile{
+		VerticalAngles:   []float64{0, 90, 180},
+		HorizontalAngles: []float64{0},
+		Candela:          [][]float64{{1, 1, 1}},
+	}
+	ies := NewIES(pos, vec64.Vector{1, 0, 3}, profile, color.White, 2, 0, 180, 0).(goray.DiracLight)
+	point := NewPoint(pos, color.White, 2).(goray.DiracLight)
+
+	for _, p := range []vec64.Vector{{0, 0, 0}, {1, 5, 3}, {-2, 2, 4}, {1, 1.5, 3}} {
+		sp := goray.SurfacePoint{Position: p}
+		var iesRay, pointRay goray.Ray
+		got, ok1 := ies.Illuminate(sp, &iesRay)
+		want, ok2 := point.Illuminate(sp, &pointRay)
+		if ok1 != ok2 || math.Abs(got.Red()-want.Red()) > 1e-6*want.Red() {
+			t.Errorf("Illuminate(%v) = %v, %t; point light = %v, %t", p, got, ok1, want, ok2)
+		}
+	}
+	if got, want := ies.TotalEnergy().Red(), point.TotalEnergy().Red(); math.Abs(got-want) > 1e-3*want {
+		t.Errorf("TotalEnergy() = %v; point light = %v", got, want)
+	}
+
+	s := goray.LightSample{S1: 0.3, S2: 0.7}
+	_, got := ies.EmitSample(&s)
+	iesPdf := s.DirPdf
+	_, want := point.EmitSample(&s)
+	if math.Abs(got.Red()-want.Red()) > 1e-6*want.Red() || math.Abs(iesPdf-s.DirPdf) > 1e-3*s.DirPdf {
+		t.Errorf("EmitSample = %v with pdf %v; point light = %v with pdf %v", got, iesPdf, want, s.DirPdf)
+	}
+}
+
+// glowMaterial is a material that only emits light from its front side.
+type glowMaterial struct {
+	goray.Material
+	col color.Color
+}
+
+func (m glowMaterial) InitBSDF(state *goray.RenderState, sp goray.SurfacePoint) goray.BSDF {
+	return goray.BSDFDiffuse
+}
+
+func (m glowMaterial) Emit(state *goray.RenderState, sp goray.SurfacePoint, wo vec64.Vector) color.Color {
+	if vec64.Dot(sp.GeometricNormal, wo) <= 0 {
+		return color.Black
+	}
+	return m.col
+}
+
+func TestMeshLightMoving(t *testing.T) {
+	mesh := goray.NewMesh(1, false)
+	mesh.SetData([]vec64.Vector{
+		{0.0, 0.0, 1.0},
+		{1.0, 0.0, 1.0},
+		{0.0, 1.0, 1.0},
+	}, nil, nil)
+	// Slide the triangle two units along X over the shutter.
+	end := mat64.Identity
+	end[0][3] = 2.0
+	mesh.SetMotion(mat64.Identity, end)
+	tri := goray.NewTriangle(0, 1, 2, mesh)
+	tri.SetMaterial(glowMaterial{col: color.White})
+	mesh.AddTriangle(tri)
+	l := NewMesh(mesh, 1)
+
+	for _, time := range []float64{0, 0.5, 1} {
+		minX := 2 * time
+		sp := goray.SurfacePoint{Position: vec64.Vector{minX + 0.25, 0.25, 3.0}, Normal: vec64.Vector{0, 0, -1}}
+		wi := goray.Ray{From: sp.Position, TMax: -1, Time: time}
+		s := goray.LightSample{S1: 0.3, S2: 0.6}
+		if !l.IlluminateSample(sp, &wi, &s) {
+			t.Errorf("IlluminateSample at time %.1f failed", time)
+			continue
+		}
+		if x := s.Point.Position[0]; x < minX || x > minX+1 {
+			t.Errorf("IlluminateSample at time %.1f point = %v; want X in [%v, %v]", time, s.Point.Position, minX, minX+1)
+		}
+		// The light's sample must be where a ray at the same time finds it.
+		if coll := tri.Intersect(wi); !coll.Hit() || math.Abs(coll.RayDepth-wi.TMax) > 1e-9 {
+			t.Errorf("IlluminateSample at time %.1f gave a ray that does not hit the light", time)
+		}
+
+		es := goray.LightSample{S1: 0.3, S2: 0.6, S3: 0.5, S4: 0.5, Time: time}
+		l.EmitSample(&es)
+		if x := es.Point.Position[0]; x < minX || x > minX+1 {
+			t.Errorf("EmitSample at time %.1f point = %v; want X in [%v, %v]", time, es.Point.Position, minX, minX+1)
+		}
+	}
+}
+
+// directionalTests holds lights from one direction that give the same
+// irradiance.  Their scene is a sphere of radius 4 around the origin.
+var directionalTests = []struct {
+	Name  string
+	Light goray.Light
+}{
+	{"directional", inSphere(NewDirectional(vec64.Vector{0, 0, 1}, color.White, 2), 4)},
+	{"sun", inSphere(NewSun(vec64.Vector{0, 0, 1}, color.White, 2, 5, 1), 4)},
+}
+
+// inSphere sets up a directional light as SetScene would for a scene that is
+// bounded by a sphere of radius r around the origin.
+func inSphere(l goray.Light, r float64) goray.Light {
+	switch l := l.(type) {
+	case *directionalLight:
+		l.center, l.radius = vec64.Vector{}, r
+	case *sunLight:
+		l.center, l.radius = vec64.Vector{}, r
+	}
+	return l
+}
+
+func TestDirectionalPhotons(t *testing.T) {
+	const n, rho = 200000, 1.0
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range directionalTests {
+		want := test.Light.TotalEnergy().Red()
+		sum, hit := 0.0, 0.0
+		for i := 0; i < n; i++ {
+			col, r, ipdf := test.Light.EmitPhoton(rng.Float64(), rng.Float64(), rng.Float64(), rng.Float64())
+			power := col.Red() * ipdf
+			sum += power
+			// Count the photons that cross a disk of radius rho at the
+			// center of the scene, facing the light.
+			if t := -r.From[2] / r.Dir[2]; math.Hypot(r.From[0]+t*r.Dir[0], r.From[1]+t*r.Dir[1]) < rho {
+				hit += power
+			}
+		}
+		if got := sum / n; math.Abs(got-want) > 1e-9*want {
+			t.Errorf("%s: mean photon power = %v; TotalEnergy = %v", test.Name, got, want)
+		}
+		if e := hit / n / (math.Pi * rho * rho); math.Abs(e-2) > 0.04 {
+			t.Errorf("%s: photon irradiance = %v; want 2", test.Name, e)
+		}
+	}
+}
+
+func TestDirectionalIrradiance(t *testing.T) {
+	const n = 10000
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range directionalTests {
+		for _, cos := range []float64{1, 0.5} {
+			sp := goray.SurfacePoint{Normal: vec64.Vector{math.Sqrt(1 - cos*cos), 0, cos}}
+			sum := 0.0
+			for i := 0; i < n; i++ {
+				wi := goray.Ray{From: sp.Position, TMax: -1}
+				var col color.Color
+				if dl, ok := test.Light.(goray.DiracLight); ok {
+					col, _ = dl.Illuminate(sp, &wi)
+				} else {
+					s := goray.LightSample{S1: rng.Float64(), S2: rng.Float64()}
+					if !test.Light.IlluminateSample(sp, &wi, &s) {
+						t.Fatalf("%s: IlluminateSample failed", test.Name)
+					}
+					if pdf := test.Light.IlluminatePdf(sp, s.Point); math.Abs(pdf-s.Pdf) > 1e-9*s.Pdf {
+						t.Errorf("%s: IlluminatePdf = %v; IlluminateSample pdf = %v", test.Name, pdf, s.Pdf)
+					}
+					// Pdf is pi times the solid angle density.
+					col = color.ScalarMul(s.Color, 1/s.Pdf)
+				}
+				sum += col.Red() * vec64.Dot(sp.Normal, wi.Dir)
+			}
+			if e := sum / n; math.Abs(e-2*cos) > 0.01*cos {
+				t.Errorf("%s: irradiance at cos %v = %v; want %v", test.Name, cos, e, 2*cos)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// meshLight is a light made from a mesh whose materials emit light.  Each
+// triangle shines from the side its geometric normal points to.
+type meshLight struct {
+	mesh    *goray.Mesh
+	area    float64
+	power   color.Color
+	samples int
+}
+
+var _ goray.Light = &meshLight{}
+
+// powerSamples is the number of points on each side of the grid used to find
+// the power of a mesh light.
+const powerSamples = 32
+
+// NewMesh creates a light from a mesh.  The light given off by the mesh comes
+// from the Emit method of its materials, so they must be EmitMaterials.  The
+// mesh is told that it belongs to the light.  NewMesh returns nil if the mesh
+// has no area.
+func NewMesh(mesh *goray.Mesh, samples int) goray.Light {
+	if !mesh.EnableSampling() {
+		return nil
+	}
+	l := &meshLight{
+		mesh:    mesh,
+		area:    mesh.SurfaceArea(),
+		samples: samples,
+	}
+
+	// Estimate the power of the light with a grid of samples, since the
+	// materials may be textured.
+	sum := color.Black
+	for i := 0; i < powerSamples; i++ {
+		for j := 0; j < powerSamples; j++ {
+			sp := mesh.SampleSurface((float64(i)+0.5)/powerSamples, (float64(j)+0.5)/powerSamples, 0)
+			sum = color.Add(sum, emission(sp, sp.GeometricNormal))
+		}
+	}
+	l.power = color.ScalarMul(sum, l.area/(powerSamples*powerSamples))
+
+	mesh.SetLight(l)
+	return l
+}
+
+// emission returns the radiance that leaves a point on the mesh toward wo.
+func emission(sp goray.SurfacePoint, wo vec64.Vector) color.Color {
+	emat, ok := sp.Material.(goray.EmitMaterial)
+	if !ok {
+		return color.Black
+	}
+	var state goray.RenderState
+	sp.Material.InitBSDF(&state, sp)
+	return emat.Emit(&state, sp, wo)
+}
+
+func (l *meshLight) LightFlags() uint { return goray.LightTypeNone }
+func (l *meshLight) NumSamples() int  { return l.samples }
+
+func (l *meshLight) SetScene(scene *goray.Scene) {
+}
+
+func (l *meshLight) TotalEnergy() color.Color {
+	return l.power
+}
+
+func (l *meshLight) CanIlluminate(pt vec64.Vector) bool {
+	return true
+}
+
+func (l *meshLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) bool {
+	lsp := l.mesh.SampleSurface(s.S1, s.S2, wi.Time)
+	ldir := vec64.Sub(lsp.Position, sp.Position)
+	distSqr := ldir.LengthSqr()
+	dist := math.Sqrt(distSqr)
+	if dist == 0 {
+		return false
+	}
+	ldir = ldir.Scale(1 / dist)
+	cos := -vec64.Dot(ldir, lsp.GeometricNormal)
+	if cos <= 0 {
+		return false
+	}
+	col := emission(lsp, ldir.Negate())
+	if color.IsBlack(col) {
+		return false
+	}
+
+	wi.TMax = dist
+	wi.Dir = ldir
+	s.Color = col
+	s.Pdf = solidAnglePdf(l.area, distSqr, cos)
+	s.Flags = l.LightFlags()
+	s.Point = lsp
+	return true
+}
+
+func (l *meshLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	ldir := vec64.Sub(spLight.Position, sp.Position)
+	distSqr := ldir.LengthSqr()
+	if distSqr == 0 {
+		return 0
+	}
+	cos := -vec64.Dot(ldir, spLight.GeometricNormal) / math.Sqrt(distSqr)
+	if cos <= 0 {
+		return 0
+	}
+	return solidAnglePdf(l.area, distSqr, cos)
+}
+
+func (l *meshLight) EmitPhoton(s1, s2, s3, s4 float64) (color.Color, goray.Ray, float64) {
+	// Photons are emitted without a time, so they leave moving meshes from
+	// where the meshes are at time zero.
+	lsp := l.mesh.SampleSurface(s3, s4, 0)
+	r := goray.Ray{From: lsp.Position}
+	r.Dir, _ = emitDir(lsp.GeometricNormal, s1, s2)
+	return color.ScalarDiv(emission(lsp, r.Dir), math.Pi), r, math.Pi * l.area
+}
+
+func (l *meshLight) EmitSample(s *goray.LightSample) (wo vec64.Vector, col color.Color) {
+	s.Point = l.mesh.SampleSurface(s.S1, s.S2, s.Time)
+	s.AreaPdf = 1 / l.area
+	wo, s.DirPdf = emitDir(s.Point.GeometricNormal, s.S3, s.S4)
+	s.Flags = l.LightFlags()
+	return wo, color.ScalarDiv(emission(s.Point, wo), math.Pi)
+}
+
+func (l *meshLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	cosWo = vec64.Dot(sp.GeometricNormal, wo)
+	return 1 / l.area, math.Max(cosWo, 0), cosWo
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"lights/mesh"] = yamlscene.MapConstruct(constructMesh)
+}
+
+func constructMesh(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	mesh, ok := m["mesh"].(*goray.Mesh)
+	if !ok {
+		return nil, errors.New("Mesh light must have a mesh")
+	}
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	l := NewMesh(mesh, samples)
+	if l == nil {
+		return nil, errors.New("Mesh light has no area")
+	}
+	return l, nil
+}
@@ -0,0 +1,195 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/vecutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// sphereLight is a ball that shines the same radiance from every point on its
+// surface in all directions.
+type sphereLight struct {
+	center  vec64.Vector
+	radius  float64
+	area    float64
+	color   color.Color
+	samples int
+}
+
+var _ goray.Light = &sphereLight{}
+var _ goray.LightIntersecter = &sphereLight{}
+
+// NewSphere creates a spherical light.  The light's radiance is col
+// multiplied by intensity.
+func NewSphere(center vec64.Vector, radius float64, col color.Color, intensity float64, samples int) goray.Light {
+	return &sphereLight{
+		center:  center,
+		radius:  radius,
+		area:    4 * math.Pi * radius * radius,
+		color:   color.ScalarMul(col, intensity),
+		samples: samples,
+	}
+}
+
+func (l *sphereLight) LightFlags() uint { return goray.LightTypeNone }
+func (l *sphereLight) NumSamples() int  { return l.samples }
+
+func (l *sphereLight) SetScene(scene *goray.Scene) {
+}
+
+func (l *sphereLight) TotalEnergy() color.Color {
+	return color.ScalarMul(l.color, l.area)
+}
+
+// cone returns the cosine of the half-angle of the cone that the sphere fills
+// as seen from pt.  ok is false if pt is inside the sphere.
+func (l *sphereLight) cone(pt vec64.Vector) (cosAlpha float64, ok bool) {
+	distSqr := vec64.Sub(l.center, pt).LengthSqr()
+	rSqr := l.radius * l.radius
+	if distSqr <= rSqr {
+		return 0, false
+	}
+	return math.Sqrt(1 - rSqr/distSqr), true
+}
+
+// conePdf returns the density of sampling a direction in the cone, multiplied
+// by pi like the PDFs of materials.
+func conePdf(cosAlpha float64) float64 {
+	return 1 / (2 * (1 - cosAlpha))
+}
+
+// intersect finds the nearest point where a ray enters the sphere.
+func (l *sphereLight) intersect(from, dir vec64.Vector) (dist float64, ok bool) {
+	// Solve |from + t*dir - center|^2 = r^2 for t.
+	oc := vec64.Sub(from, l.center)
+	b := vec64.Dot(oc, dir)
+	c := oc.LengthSqr() - l.radius*l.radius
+	disc := b*b - c
+	if c <= 0 || disc < 0 {
+		return 0, false
+	}
+	dist = -b - math.Sqrt(disc)
+	return dist, dist > 0
+}
+
+func (l *sphereLight) CanIlluminate(pt vec64.Vector) bool {
+	_, ok := l.cone(pt)
+	return ok
+}
+
+func (l *sphereLight) IlluminateSample(sp goray.SurfacePoint, wi *goray.Ray, s *goray.LightSample) bool {
+	cosAlpha, ok := l.cone(sp.Position)
+	if !ok {
+		return false
+	}
+	cdir := vec64.Sub(l.center, sp.Position).Normalize()
+	du, dv := vecutil.CreateCS(cdir)
+	dir := sampleutil.Cone(cdir, du, dv, cosAlpha, s.S1, s.S2)
+	dist, ok := l.intersect(sp.Position, dir)
+	if !ok {
+		// The direction grazes the sphere.
+		dist = math.Sqrt(vec64.Sub(l.center, sp.Position).LengthSqr() - l.radius*l.radius)
+	}
+
+	wi.TMax = dist
+	wi.Dir = dir
+	s.Color = l.color
+	s.Pdf = conePdf(cosAlpha)
+	s.Flags = l.LightFlags()
+	s.Point.Position = vec64.Add(sp.Position, dir.Scale(dist))
+	s.Point.Normal = vec64.Sub(s.Point.Position, l.center).Normalize()
+	return true
+}
+
+func (l *sphereLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
+	cosAlpha, ok := l.cone(sp.Position)
+	if !ok {
+		return 0
+	}
+	return conePdf(cosAlpha)
+}
+
+func (l *sphereLight) Intersect(r goray.Ray) (dist float64, col color.Color, ipdf float64, ok bool) {
+	cosAlpha, ok := l.cone(r.From)
+	if !ok {
+		return
+	}
+	dist, ok = l.intersect(r.From, r.Dir)
+	if !ok || (r.TMax >= 0 && dist > r.TMax) {
+		return 0, nil, 0, false
+	}
+	return dist, l.color, 1 / conePdf(cosAlpha), true
+}
+
+func (l *sphereLight) EmitPhoton(s1, s2, s3, s4 float64) (color.Color, goray.Ray, float64) {
+	normal := sampleutil.Sphere(s3, s4)
+	r := goray.Ray{From: vec64.Add(l.center, normal.Scale(l.radius))}
+	r.Dir, _ = emitDir(normal, s1, s2)
+	return color.ScalarDiv(l.color, math.Pi), r, math.Pi * l.area
+}
+
+func (l *sphereLight) EmitSample(s *goray.LightSample) (wo vec64.Vector, col color.Color) {
+	normal := sampleutil.Sphere(s.S1, s.S2)
+	s.Point.Position = vec64.Add(l.center, normal.Scale(l.radius))
+	s.Point.Normal = normal
+	s.AreaPdf = 1 / l.area
+	wo, s.DirPdf = emitDir(normal, s.S3, s.S4)
+	s.Flags = l.LightFlags()
+	return wo, color.ScalarDiv(l.color, math.Pi)
+}
+
+func (l *sphereLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
+	normal := vec64.Sub(sp.Position, l.center).Normalize()
+	cosWo = vec64.Dot(normal, wo)
+	return 1 / l.area, math.Max(cosWo, 0), cosWo
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"lights/sphere"] = yamlscene.MapConstruct(constructSphere)
+}
+
+func constructSphere(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	pos, ok := m["position"].(vec64.Vector)
+	if !ok {
+		return nil, errors.New("Sphere light must have a position")
+	}
+	radius, ok := yamldata.AsFloat(m["radius"])
+	if !ok || radius <= 0 {
+		return nil, errors.New("Sphere light must have a positive radius")
+	}
+	col, ok := m.SetDefault("color", color.White).(color.Color)
+	if !ok {
+		return nil, errors.New("Sphere light color must be a color")
+	}
+	intensity, _ := yamldata.AsFloat(m.SetDefault("intensity", 1.0))
+	samples, _ := yamldata.AsInt(m.SetDefault("samples", 16))
+	return NewSphere(pos, radius, col, intensity, samples), nil
+}
@@ -21,12 +21,14 @@
 package lights
 
 import (
+	"errors"
 	"math"
 
 	"bitbucket.org/zombiezen/math3/vec64"
 	"zombiezen.com/go/goray/internal/color"
 	"zombiezen.com/go/goray/internal/goray"
 	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/shaders/texmap"
 	"zombiezen.com/go/goray/internal/vecutil"
 	yamldata "zombiezen.com/go/goray/internal/yaml/data"
 	"zombiezen.com/go/goray/internal/yamlscene"
@@ -42,6 +44,14 @@ type spotLight struct {
 	color            color.Color
 	pdf              sampleutil.Pdf1D
 	interv1, interv2 float64
+
+	// A texture projected along the cone multiplies the light's color.
+	// Photons are then emitted in proportion to texDist, a grid laid over
+	// the texture's [-1, 1] square.
+	texture  texmap.Texture
+	tanAngle float64
+	texDist  sampleutil.Pdf2D
+	texPower color.Color
 }
 
 var _ goray.DiracLight = &spotLight{}
@@ -82,6 +92,116 @@ func NewSpot(from, to vec64.Vector, col color.Color, power, angle, falloff float
 	return newSpot
 }
 
+// Largest resolution of the grid that photons from a textured spot light are
+// sampled from.
+const maxSpotTextureGrid = 512
+
+// NewTexturedSpot creates a spot light that projects a texture along its cone,
+// like a slide projector.  The texture's [-1, 1] square covers the cone, with
+// its Y axis pointing toward up.  angle must be less than 90 degrees.
+func NewTexturedSpot(from, to, up vec64.Vector, col color.Color, power, angle, falloff float64, tex texmap.Texture) goray.Light {
+	spot := NewSpot(from, to, col, power, angle, falloff).(*spotLight)
+	if v := vec64.Sub(up, spot.direction.Scale(vec64.Dot(up, spot.direction))); !v.IsZero() {
+		spot.dv = v.Normalize()
+		spot.du = vec64.Cross(spot.direction, spot.dv)
+	}
+	spot.texture = tex
+	spot.tanAngle = math.Tan(angle * math.Pi / 180)
+
+	w, h := 128, 128
+	if dt, ok := tex.(texmap.DiscreteTexture); ok {
+		w, h, _ = dt.Resolution()
+		if w > maxSpotTextureGrid {
+			w = maxSpotTextureGrid
+		}
+		if h > maxSpotTextureGrid {
+			h = maxSpotTextureGrid
+		}
+	}
+	// Each cell is weighted by the brightest of its corners and center so
+	// that no direction that gives off light is left out.  The cells are
+	// scaled by the solid angle that they cover.
+	f := make([]float64, w*h)
+	total := color.Black
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dir, jacobian := spot.texDir(float64(x)+0.5, float64(y)+0.5, w, h)
+			total = color.Add(total, color.ScalarMul(spot.texColor(dir), jacobian))
+			m := 0.0
+			for _, c := range [...][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {0.5, 0.5}} {
+				d, _ := spot.texDir(float64(x)+c[0], float64(y)+c[1], w, h)
+				m = math.Max(m, color.Energy(spot.texColor(d)))
+			}
+			f[y*w+x] = m * jacobian
+		}
+	}
+	spot.texPower = color.ScalarMul(total, 1/float64(w*h))
+	if color.Energy(spot.texPower) > 0 {
+		spot.texDist = sampleutil.NewPdf2D(f, w)
+	}
+	return spot
+}
+
+// texDir returns the direction through a point on a w by h grid laid over the
+// texture, along with the solid angle that the unit square of the grid covers
+// there.
+func (spot *spotLight) texDir(x, y float64, w, h int) (dir vec64.Vector, jacobian float64) {
+	u := (2*x/float64(w) - 1) * spot.tanAngle
+	v := (1 - 2*y/float64(h)) * spot.tanAngle
+	dir = vec64.Add(spot.direction, vec64.Add(spot.du.Scale(u), spot.dv.Scale(v)))
+	lenSqr := dir.LengthSqr()
+	cosTheta := 1 / math.Sqrt(lenSqr)
+	// The texture's square has an area of 4*tan^2(angle) on the plane one
+	// unit in front of the light.
+	return dir.Scale(cosTheta), 4 * spot.tanAngle * spot.tanAngle * cosTheta * cosTheta * cosTheta
+}
+
+// texColor returns the color of the light in a direction, including the
+// falloff of the cone and the texture.
+func (spot *spotLight) texColor(dir vec64.Vector) color.Color {
+	cosa := vec64.Dot(dir, spot.direction)
+	if cosa < spot.cosEnd || cosa <= 0 {
+		return color.Black
+	}
+	col := spot.color
+	if cosa < spot.cosStart {
+		v := (cosa - spot.cosEnd) * spot.icosDiff
+		col = color.ScalarMul(col, v*v*(3-2*v))
+	}
+	u := vec64.Dot(dir, spot.du) / (cosa * spot.tanAngle)
+	v := vec64.Dot(dir, spot.dv) / (cosa * spot.tanAngle)
+	return color.Mul(col, spot.texture.ColorAt(vec64.Vector{u, v, 0}))
+}
+
+// texPdf returns the density of emitTextured picking a direction, multiplied
+// by pi like the PDFs of materials.
+func (spot *spotLight) texPdf(dir vec64.Vector) float64 {
+	cosa := vec64.Dot(dir, spot.direction)
+	if cosa <= 0 || color.Energy(spot.texPower) <= 0 {
+		return 0
+	}
+	w, h := spot.texDist.Width(), spot.texDist.Height()
+	u := vec64.Dot(dir, spot.du) / (cosa * spot.tanAngle)
+	v := vec64.Dot(dir, spot.dv) / (cosa * spot.tanAngle)
+	if u < -1 || u > 1 || v < -1 || v > 1 {
+		return 0
+	}
+	x, y := (u+1)/2*float64(w), (1-v)/2*float64(h)
+	_, jacobian := spot.texDir(x, y, w, h)
+	return math.Pi * spot.texDist.Pdf(x, y) / jacobian
+}
+
+// emitTextured picks a direction in proportion to the projected texture.
+func (spot *spotLight) emitTextured(s1, s2 float64) (col color.Color, wo vec64.Vector, pdf float64) {
+	if color.Energy(spot.texPower) <= 0 {
+		return color.Black, spot.direction, 0
+	}
+	w, h := spot.texDist.Width(), spot.texDist.Height()
+	x, y, pdfImage := spot.texDist.Sample(s1, s2)
+	wo, jacobian := spot.texDir(x, y, w, h)
+	return spot.texColor(wo), wo, math.Pi * pdfImage / jacobian
+}
+
 func (spot *spotLight) LightFlags() uint {
 	return goray.LightTypeSingular
 }
@@ -94,6 +214,9 @@ func (spot *spotLight) SetScene(scene *goray.Scene) {
 }
 
 func (spot *spotLight) TotalEnergy() color.Color {
+	if spot.texture != nil {
+		return spot.texPower
+	}
 	return color.ScalarMul(spot.color, 2*math.Pi*(1-0.5*(spot.cosStart+spot.cosEnd)))
 }
 
@@ -118,6 +241,9 @@ func (spot *spotLight) Illuminate(sp goray.SurfacePoint, wi *goray.Ray) (col col
 		v = v * v * (3 - 2*v)
 		col = color.ScalarMul(spot.color, v/distSqr)
 	}
+	if spot.texture != nil {
+		col = color.ScalarDiv(spot.texColor(ldir.Negate()), distSqr)
+	}
 	wi.TMax = dist
 	wi.Dir = ldir
 	ok = true
@@ -138,6 +264,9 @@ func (spot *spotLight) IlluminatePdf(sp, spLight goray.SurfacePoint) float64 {
 }
 
 func (spot *spotLight) emit(s1, s2, s3 float64) (col color.Color, wo vec64.Vector, pdf float64) {
+	if spot.texture != nil {
+		return spot.emitTextured(s1, s2)
+	}
 	col = spot.color
 	if s3 <= spot.interv1 {
 		// Sample from cone not affected by falloff
@@ -159,6 +288,9 @@ func (spot *spotLight) emit(s1, s2, s3 float64) (col color.Color, wo vec64.Vecto
 
 func (spot *spotLight) EmitPhoton(s1, s2, s3, s4 float64) (col color.Color, r goray.Ray, ipdf float64) {
 	col, r.Dir, ipdf = spot.emit(s1, s2, s3)
+	if ipdf <= 0 {
+		return color.Black, r, 0
+	}
 	ipdf = math.Pi / ipdf
 	r.From = spot.position
 	return
@@ -174,6 +306,10 @@ func (spot *spotLight) EmitSample(s *goray.LightSample) (wo vec64.Vector, col co
 
 func (spot *spotLight) EmitPdf(sp goray.SurfacePoint, wo vec64.Vector) (areaPdf, dirPdf, cosWo float64) {
 	areaPdf, cosWo = 1, 1
+	if spot.texture != nil {
+		dirPdf = spot.texPdf(wo)
+		return
+	}
 	cosa := vec64.Dot(spot.direction, wo)
 	switch {
 	case cosa < spot.cosEnd:
@@ -210,5 +346,19 @@ func constructSpot(m yamldata.Map) (interface{}, error) {
 	power, _ := yamldata.AsFloat(m["intensity"])
 	angle, _ := yamldata.AsFloat(m["coneAngle"])
 	falloff, _ := yamldata.AsFloat(m["falloff"])
-	return NewSpot(pos, look, col, power, angle, falloff), nil
+	if m["texture"] == nil {
+		return NewSpot(pos, look, col, power, angle, falloff), nil
+	}
+	tex, ok := m["texture"].(texmap.Texture)
+	if !ok {
+		return nil, errors.New("Spot light texture must be a texture")
+	}
+	if angle <= 0 || angle >= 90 {
+		return nil, errors.New("Textured spot light must have a cone angle between 0 and 90 degrees")
+	}
+	up, ok := m["up"].(vec64.Vector)
+	if !ok && m["up"] != nil {
+		return nil, errors.New("Spot light up must be a vector")
+	}
+	return NewTexturedSpot(pos, look, up, col, power, angle, falloff, tex), nil
 }
@@ -0,0 +1,118 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lights
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/sampleutil"
+	"zombiezen.com/go/goray/internal/shaders/texmap"
+)
+
+// funcTexture is a gray texture whose brightness is a function of the
+// texture coordinates.
+type funcTexture func(u, v float64) float64
+
+var _ texmap.Texture = funcTexture(nil)
+
+func (f funcTexture) ColorAt(pt vec64.Vector) color.AlphaColor {
+	x := f(pt[0], pt[1])
+	return color.RGBA{x, x, x, 1}
+}
+
+func (f funcTexture) ScalarAt(pt vec64.Vector) float64 { return f(pt[0], pt[1]) }
+func (f funcTexture) Is3D() bool                       { return false }
+func (f funcTexture) IsNormalMap() bool                { return false }
+
+// stripes is dark on its left half and has a black band across its middle.
+func stripes(u, v float64) float64 {
+	switch {
+	case math.Abs(v) < 0.2:
+		return 0
+	case u < 0:
+		return 0.1
+	}
+	return 1
+}
+
+func TestTexturedSpotEmitPdf(t *testing.T) {
+	const angle = 30
+	l := NewTexturedSpot(vec64.Vector{0, 0, 0}, vec64.Vector{1, 2, 0}, vec64.Vector{0, 0, 1}, color.White, 2, angle, 0.2, funcTexture(stripes))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		s := goray.LightSample{S1: rng.Float64(), S2: rng.Float64(), S3: rng.Float64()}
+		wo, col := l.EmitSample(&s)
+		if s.DirPdf <= 0 {
+			if !color.IsBlack(col) {
+				t.Errorf("EmitSample = %v with pdf %v", col, s.DirPdf)
+			}
+			continue
+		}
+		if _, dirPdf, _ := l.EmitPdf(goray.SurfacePoint{}, wo); math.Abs(dirPdf-s.DirPdf) > 1e-6*s.DirPdf {
+			t.Errorf("EmitPdf(%v) = %v; EmitSample pdf = %v", wo, dirPdf, s.DirPdf)
+		}
+	}
+
+	// The density is pi times a solid angle density over the cone around the
+	// texture's square, so it must integrate to pi.
+	tan := math.Tan(angle * math.Pi / 180)
+	cosMax := 1 / math.Sqrt(1+2*tan*tan)
+	dir := vec64.Vector{1, 2, 0}.Normalize()
+	du, dv := vec64.Vector{0, 0, 1}, vec64.Cross(vec64.Vector{0, 0, 1}, dir)
+	const n = 200000
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		wo := sampleutil.Cone(dir, du, dv, cosMax, rng.Float64(), rng.Float64())
+		_, dirPdf, _ := l.EmitPdf(goray.SurfacePoint{}, wo)
+		sum += dirPdf
+	}
+	if got := sum / n * 2 * math.Pi * (1 - cosMax) / math.Pi; math.Abs(got-1) > 0.02 {
+		t.Errorf("EmitPdf integrates to %v; want 1", got)
+	}
+}
+
+func TestTexturedSpotBlack(t *testing.T) {
+	black := funcTexture(func(u, v float64) float64 { return 0 })
+	l := NewTexturedSpot(vec64.Vector{0, 0, 0}, vec64.Vector{1, 2, 0}, vec64.Vector{0, 0, 1}, color.White, 2, 30, 0.2, black)
+	if e := l.TotalEnergy(); !color.IsBlack(e) {
+		t.Errorf("TotalEnergy() = %v; want black", e)
+	}
+	s := goray.LightSample{S1: 0.3, S2: 0.6, S3: 0.9}
+	if wo, col := l.EmitSample(&s); !color.IsBlack(col) || s.DirPdf != 0 || math.IsNaN(wo[0]+wo[1]+wo[2]) {
+		t.Errorf("EmitSample = %v, %v with pdf %v", wo, col, s.DirPdf)
+	}
+	if col, _, ipdf := l.EmitPhoton(0.3, 0.6, 0.9, 0.5); !color.IsBlack(col) || ipdf != 0 {
+		t.Errorf("EmitPhoton = %v with ipdf %v", col, ipdf)
+	}
+	if _, dirPdf, _ := l.EmitPdf(goray.SurfacePoint{}, vec64.Vector{1, 2, 0}.Normalize()); dirPdf != 0 {
+		t.Errorf("EmitPdf = %v; want 0", dirPdf)
+	}
+	wi := goray.Ray{TMax: -1}
+	if col, _ := l.(goray.DiracLight).Illuminate(goray.SurfacePoint{Position: vec64.Vector{1, 2, 0}}, &wi); col != nil && (!color.IsBlack(col) || math.IsNaN(col.Red())) {
+		t.Errorf("Illuminate = %v; want black", col)
+	}
+}
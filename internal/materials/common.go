@@ -86,7 +86,7 @@ func getReflectivity(mat sampler, state *goray.RenderState, sp goray.SurfacePoin
 	h1 := montecarlo.NewHalton(3)
 	h2 := montecarlo.NewHalton(5)
 	for i := 0; i < N; i++ {
-		s1 := 1/(N*2) + 1/N*float64(i)
+		s1 := (float64(i) + 0.5) / N
 		s2 := montecarlo.VanDerCorput(uint32(i), 0)
 		s3 := h1.Float64()
 		s4 := h2.Float64()
@@ -98,5 +98,5 @@ func getReflectivity(mat sampler, state *goray.RenderState, sp goray.SurfacePoin
 			col = color.Add(col, color.ScalarMul(c, math.Abs(vec64.Dot(wi, sp.Normal))/s.Pdf))
 		}
 	}
-	return color.ScalarMul(col, 1/N)
+	return color.ScalarDiv(col, N)
 }
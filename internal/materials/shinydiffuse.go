@@ -287,7 +287,6 @@ func getComps(sd *ShinyDiffuse, data sdData) (comps sdComps) {
 func (sd *ShinyDiffuse) Sample(state *goray.RenderState, sp goray.SurfacePoint, wo vec64.Vector, s *goray.MaterialSample) (col color.Color, wi vec64.Vector) {
 	data := state.MaterialData.(sdData)
 	cosNgWo := vec64.Dot(sp.GeometricNormal, wo)
-	cosNgWi := vec64.Dot(sp.GeometricNormal, wi)
 	n := sp.Normal
 	if cosNgWo < 0 {
 		n = n.Negate()
@@ -350,7 +349,7 @@ func (sd *ShinyDiffuse) Sample(state *goray.RenderState, sp goray.SurfacePoint,
 		}
 	case goray.BSDFDiffuse | goray.BSDFTransmit:
 		wi = sampleutil.CosHemisphere(n.Negate(), sp.NormalU, sp.NormalV, s1, s.S2)
-		if cosNgWo*cosNgWi < 0 {
+		if cosNgWo*vec64.Dot(sp.GeometricNormal, wi) < 0 {
 			col = color.ScalarMul(accumC.DiffuseColor, accumC.Transl)
 		}
 		s.Pdf = math.Abs(vec64.Dot(wi, n)) * comps[pick].Value
@@ -358,7 +357,7 @@ func (sd *ShinyDiffuse) Sample(state *goray.RenderState, sp goray.SurfacePoint,
 		fallthrough
 	default:
 		wi = sampleutil.CosHemisphere(n, sp.NormalU, sp.NormalV, s1, s.S2)
-		if cosNgWo*cosNgWi > 0 {
+		if cosNgWo*vec64.Dot(sp.GeometricNormal, wi) > 0 {
 			col = color.ScalarMul(accumC.DiffuseColor, accumC.Diffuse)
 		}
 		// TODO: if OrenNayer
@@ -452,7 +451,12 @@ func (sd *ShinyDiffuse) ScatterPhoton(state *goray.RenderState, sp goray.Surface
 	return scatterPhoton(sd, state, sp, wi, s)
 }
 
+// Emit returns the light given off by the surface.  Light is only given off
+// on the side that the geometric normal points to.
 func (sd *ShinyDiffuse) Emit(state *goray.RenderState, sp goray.SurfacePoint, wo vec64.Vector) color.Color {
+	if sd.EmitValue == 0 || vec64.Dot(sp.GeometricNormal, wo) <= 0 {
+		return color.Black
+	}
 	if sd.DiffuseColorShad != nil {
 		data := state.MaterialData.(sdData)
 		return color.ScalarMul(data.DiffuseColor, sd.EmitValue)
@@ -498,6 +502,20 @@ func constructShinyDiffuse(m yamldata.Map) (interface{}, error) {
 		transmit = 0
 	}
 
+	emitCol := color.Black
+	emitStrength := 0.0
+	if c, ok := m["emit"]; ok {
+		if emitCol, ok = c.(color.Color); !ok {
+			return nil, errors.New("Emit must be an RGB")
+		}
+		emitStrength = 1.0
+		if v, ok := m["emitStrength"]; ok {
+			if emitStrength, ok = yamldata.AsFloat(v); !ok {
+				return nil, errors.New("Emit strength must be a float")
+			}
+		}
+	}
+
 	diffuseColorShad, _ := m["diffuseColorShader"].(shader.Node)
 	specReflShad, _ := m["specularReflectionShader"].(shader.Node)
 	mirrorColorShad, _ := m["mirrorColorShader"].(shader.Node)
@@ -507,7 +525,8 @@ func constructShinyDiffuse(m yamldata.Map) (interface{}, error) {
 	mat := &ShinyDiffuse{
 		Color:            col,
 		SpecReflColor:    srcol,
-		EmitColor:        color.Black,
+		EmitColor:        emitCol,
+		EmitValue:        emitStrength,
 		Diffuse:          diffuse,
 		SpecRefl:         specRefl,
 		Transp:           transp,
@@ -78,10 +78,133 @@ func (hal *Halton) Float64() float64 {
 func VanDerCorput(bits, r uint32) float64 {
 	const multRatio = 0.00000000023283064365386962890625
 
+	return float64(ReverseBits(bits)^r) * multRatio
+}
+
+// Hash mixes a list of numbers into a well-distributed 64-bit hash.  It is
+// used to derive independent random streams from seeds and indices.
+func Hash(vals ...uint64) uint64 {
+	h := uint64(0x9e3779b97f4a7c15)
+	for _, v := range vals {
+		h = mix64(h ^ mix64(v))
+	}
+	return h
+}
+
+// mix64 is the finalizer of the SplitMix64 generator.
+func mix64(z uint64) uint64 {
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// Float64 converts a hash into a number in [0, 1).
+func Float64(h uint64) float64 {
+	return float64(h>>11) * (1.0 / (1 << 53))
+}
+
+// Fraction converts the bits of a binary fraction into a number in [0, 1).
+func Fraction(bits uint32) float64 {
+	return float64(bits) * (1.0 / (1 << 32))
+}
+
+// ReverseBits reverses the order of the bits in a 32-bit number.
+func ReverseBits(bits uint32) uint32 {
 	bits = bits<<16 | bits>>16
 	bits = bits&0x00ff00ff<<8 | bits&0xff00ff00>>8
 	bits = bits&0x0f0f0f0f<<4 | bits&0xf0f0f0f0>>4
 	bits = bits&0x33333333<<2 | bits&0xcccccccc>>2
 	bits = bits&0x55555555<<1 | bits&0xaaaaaaaa>>1
-	return float64(bits^r) * multRatio
+	return bits
+}
+
+// OwenScramble applies a random nested uniform (Owen) scramble to a binary
+// fraction, using the hash-based permutation from "Practical Hash-based Owen
+// Scrambling" by Brent Burley.  Scrambling the index of a (0,2)-sequence the
+// same way shuffles its points without breaking their stratification.
+func OwenScramble(bits, seed uint32) uint32 {
+	x := ReverseBits(bits)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return ReverseBits(x)
+}
+
+// Sobol2 returns the binary fraction of the second dimension of the Sobol
+// sequence at index i.  The first dimension is ReverseBits(i), and the two
+// dimensions together form a (0,2)-sequence.
+func Sobol2(i uint32) uint32 {
+	var result uint32
+	for v := uint32(1 << 31); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			result ^= v
+		}
+		v ^= v >> 1
+	}
+	return result
+}
+
+// PermutationElement returns the element at index i of a random permutation
+// of [0, n), chosen by seed, without building the permutation.  The algorithm
+// is from "Correlated Multi-Jittered Sampling" by Andrew Kensler.
+func PermutationElement(i, n, seed uint32) uint32 {
+	w := n - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	for {
+		i ^= seed
+		i *= 0xe170893d
+		i ^= seed >> 16
+		i ^= (i & w) >> 4
+		i ^= seed >> 8
+		i *= 0x0929eb3f
+		i ^= seed >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | seed>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < n {
+			break
+		}
+	}
+	return (i + seed) % n
+}
+
+// ScrambledRadicalInverse returns the radical inverse of index in the given
+// base, with the digits scrambled by random permutations chosen by seed.  Each
+// digit's permutation depends on the digits before it, so the scramble is a
+// nested (Owen) scramble.
+func ScrambledRadicalInverse(base uint, index, seed uint64) float64 {
+	const oneMinusEpsilon = 0x1.fffffffffffffp-1
+
+	b := uint64(base)
+	invBase := 1 / float64(base)
+	factor := invBase
+	var result float64
+	// Digits past the end of index are zero, but they are still scrambled
+	// until they are too small to matter.
+	for prefix := uint64(0); factor > 1e-10; factor *= invBase {
+		digit := index % b
+		index /= b
+		perm := uint32(Hash(seed, prefix) & 0xffffffff)
+		result += float64(PermutationElement(uint32(digit), uint32(base), perm)) * factor
+		prefix = prefix*b + digit + 1
+	}
+	if result > oneMinusEpsilon {
+		result = oneMinusEpsilon
+	}
+	return result
 }
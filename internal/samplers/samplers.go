@@ -0,0 +1,209 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package samplers
+
+import (
+	"errors"
+	"math"
+
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/montecarlo"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+type stratified struct {
+	n, nx int
+	seed  int64
+	seq   int
+	index int
+	dim   uint64
+}
+
+var _ goray.Sampler = &stratified{}
+
+// NewStratified creates a sampler that divides each dimension into n strata
+// and puts each of n consecutive samples in a different stratum, jittered
+// within it.  Pairs of dimensions are divided into a grid of n cells that is
+// as close to square as possible.
+func NewStratified(n int) goray.Sampler {
+	return newStratified(n, 0)
+}
+
+func newStratified(n int, seed int64) *stratified {
+	nx := int(math.Sqrt(float64(n)))
+	for n%nx != 0 {
+		nx--
+	}
+	return &stratified{n: n, nx: nx, seed: seed}
+}
+
+func (s *stratified) StartSample(seq, index int) {
+	s.seq, s.index = seq, index
+	s.dim = 0
+}
+
+// stratum returns the stratum of the current sample in the next dimension,
+// along with the hash that jitters it.
+func (s *stratified) stratum() (int, uint64) {
+	s.dim++
+	epoch, i := s.index/s.n, s.index%s.n
+	perm := montecarlo.Hash(uint64(s.seed), uint64(s.seq), uint64(epoch), s.dim)
+	p := montecarlo.PermutationElement(uint32(i), uint32(s.n), uint32(perm))
+	return int(p), montecarlo.Hash(perm, uint64(i))
+}
+
+func (s *stratified) Get1D() float64 {
+	p, h := s.stratum()
+	return (float64(p) + montecarlo.Float64(h)) / float64(s.n)
+}
+
+func (s *stratified) Get2D() (u, v float64) {
+	p, h := s.stratum()
+	ny := s.n / s.nx
+	u = (float64(p%s.nx) + montecarlo.Float64(h)) / float64(s.nx)
+	v = (float64(p/s.nx) + montecarlo.Float64(montecarlo.Hash(h))) / float64(ny)
+	return
+}
+
+func (s *stratified) Clone(seed int64) goray.Sampler {
+	return newStratified(s.n, seed)
+}
+
+// primes holds the bases of the Halton sequence's dimensions.
+var primes = [...]uint{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53,
+	59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+}
+
+type halton struct {
+	seed  int64
+	seq   int
+	index int
+	dim   int
+}
+
+var _ goray.Sampler = &halton{}
+
+// NewHalton creates a sampler that uses the Halton sequence, with the digits
+// of each dimension scrambled differently for every pixel.  Dimensions past
+// the first 32 are independent random numbers.
+func NewHalton() goray.Sampler {
+	return &halton{}
+}
+
+func (h *halton) StartSample(seq, index int) {
+	h.seq, h.index = seq, index
+	h.dim = 0
+}
+
+func (h *halton) Get1D() float64 {
+	d := h.dim
+	h.dim++
+	key := montecarlo.Hash(uint64(h.seed), uint64(h.seq), uint64(d))
+	if d >= len(primes) {
+		return montecarlo.Float64(montecarlo.Hash(key, uint64(h.index)))
+	}
+	return montecarlo.ScrambledRadicalInverse(primes[d], uint64(h.index), key)
+}
+
+func (h *halton) Get2D() (u, v float64) {
+	return h.Get1D(), h.Get1D()
+}
+
+func (h *halton) Clone(seed int64) goray.Sampler {
+	return &halton{seed: seed}
+}
+
+type sobol struct {
+	seed  int64
+	seq   int
+	index uint32
+	dim   uint64
+}
+
+var _ goray.Sampler = &sobol{}
+
+// NewSobol creates a sampler that uses the first two dimensions of the Sobol
+// sequence, Owen-scrambled.  Each pair of dimensions is shuffled
+// independently, so every pair is well stratified but the pairs are not
+// correlated with each other.
+func NewSobol() goray.Sampler {
+	return &sobol{}
+}
+
+func (s *sobol) StartSample(seq, index int) {
+	s.seq, s.index = seq, uint32(index)
+	s.dim = 0
+}
+
+// next returns the shuffled index of the current sample for the next
+// dimension along with the dimension's scramble seed.
+func (s *sobol) next() (uint32, uint64) {
+	s.dim++
+	key := montecarlo.Hash(uint64(s.seed), uint64(s.seq), s.dim)
+	return montecarlo.OwenScramble(s.index, uint32(key)), key
+}
+
+func (s *sobol) Get1D() float64 {
+	i, key := s.next()
+	return montecarlo.Fraction(montecarlo.OwenScramble(montecarlo.ReverseBits(i), uint32(key>>32)))
+}
+
+func (s *sobol) Get2D() (u, v float64) {
+	i, key := s.next()
+	u = montecarlo.Fraction(montecarlo.OwenScramble(montecarlo.ReverseBits(i), uint32(key>>32)))
+	v = montecarlo.Fraction(montecarlo.OwenScramble(montecarlo.Sobol2(i), uint32(montecarlo.Hash(key))))
+	return
+}
+
+func (s *sobol) Clone(seed int64) goray.Sampler {
+	return &sobol{seed: seed}
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"samplers/random"] = yamlscene.MapConstruct(constructRandom)
+	yamlscene.Constructor[yamlscene.StdPrefix+"samplers/stratified"] = yamlscene.MapConstruct(constructStratified)
+	yamlscene.Constructor[yamlscene.StdPrefix+"samplers/halton"] = yamlscene.MapConstruct(constructHalton)
+	yamlscene.Constructor[yamlscene.StdPrefix+"samplers/sobol"] = yamlscene.MapConstruct(constructSobol)
+}
+
+func constructRandom(m yamldata.Map) (interface{}, error) {
+	return goray.NewRandomSampler(0), nil
+}
+
+func constructStratified(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	m.SetDefault("samples", 16)
+	n, ok := yamldata.AsInt(m["samples"])
+	if !ok || n < 1 {
+		return nil, errors.New("Samples must be a positive integer")
+	}
+	return NewStratified(n), nil
+}
+
+func constructHalton(m yamldata.Map) (interface{}, error) {
+	return NewHalton(), nil
+}
+
+func constructSobol(m yamldata.Map) (interface{}, error) {
+	return NewSobol(), nil
+}
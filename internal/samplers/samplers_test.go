@@ -0,0 +1,141 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package samplers
+
+import (
+	"testing"
+
+	"zombiezen.com/go/goray/internal/goray"
+)
+
+var testSamplers = []struct {
+	Name    string
+	Sampler goray.Sampler
+}{
+	{"random", goray.NewRandomSampler(0)},
+	{"stratified", NewStratified(16)},
+	{"halton", NewHalton()},
+	{"sobol", NewSobol()},
+}
+
+// draw returns the first dims dimensions of n samples.
+func draw(smp goray.Sampler, seq, n, dims int) [][]float64 {
+	result := make([][]float64, n)
+	for i := range result {
+		smp.StartSample(seq, i)
+		result[i] = make([]float64, dims)
+		for d := 0; d+1 < dims; d += 2 {
+			result[i][d], result[i][d+1] = smp.Get2D()
+		}
+		if dims%2 == 1 {
+			result[i][dims-1] = smp.Get1D()
+		}
+	}
+	return result
+}
+
+func TestSamplerRange(t *testing.T) {
+	for _, test := range testSamplers {
+		for i, s := range draw(test.Sampler.Clone(42), 7, 256, 75) {
+			for d, x := range s {
+				if x < 0 || x >= 1 {
+					t.Errorf("%s: sample %d, dimension %d = %v; want in [0, 1)", test.Name, i, d, x)
+				}
+			}
+		}
+	}
+}
+
+func TestSamplerDeterminism(t *testing.T) {
+	for _, test := range testSamplers {
+		a := draw(test.Sampler.Clone(1), 3, 16, 6)
+		b := draw(test.Sampler.Clone(1), 3, 16, 6)
+		c := draw(test.Sampler.Clone(2), 3, 16, 6)
+		d := draw(test.Sampler.Clone(1), 4, 16, 6)
+		same, seedDiff, seqDiff := true, false, false
+		for i := range a {
+			for j := range a[i] {
+				same = same && a[i][j] == b[i][j]
+				seedDiff = seedDiff || a[i][j] != c[i][j]
+				seqDiff = seqDiff || a[i][j] != d[i][j]
+			}
+		}
+		if !same {
+			t.Errorf("%s: samples differ with the same seed", test.Name)
+		}
+		if !seedDiff {
+			t.Errorf("%s: samples are the same with different seeds", test.Name)
+		}
+		if !seqDiff {
+			t.Errorf("%s: samples are the same in different sequences", test.Name)
+		}
+	}
+}
+
+func TestStratified1D(t *testing.T) {
+	const n = 16
+	smp := NewStratified(n).Clone(5)
+	for epoch := 0; epoch < 3; epoch++ {
+		var seen [n]bool
+		for i := 0; i < n; i++ {
+			smp.StartSample(0, epoch*n+i)
+			smp.Get2D()
+			seen[int(smp.Get1D()*n)] = true
+		}
+		for k, ok := range seen {
+			if !ok {
+				t.Errorf("epoch %d: no sample in stratum %d", epoch, k)
+			}
+		}
+	}
+}
+
+// checkGrid checks that n samples of the first two dimensions fall in
+// different cells of an nx by ny grid.
+func checkGrid(t *testing.T, name string, smp goray.Sampler, nx, ny int) {
+	seen := make(map[[2]int]bool)
+	for _, s := range draw(smp, 9, nx*ny, 2) {
+		cell := [2]int{int(s[0] * float64(nx)), int(s[1] * float64(ny))}
+		if seen[cell] {
+			t.Errorf("%s: two samples in cell %v of a %dx%d grid", name, cell, nx, ny)
+		}
+		seen[cell] = true
+	}
+}
+
+func TestStratified2D(t *testing.T) {
+	checkGrid(t, "stratified", NewStratified(16).Clone(3), 4, 4)
+	checkGrid(t, "stratified", NewStratified(8).Clone(3), 2, 4)
+}
+
+func TestSobolStratification(t *testing.T) {
+	smp := NewSobol().Clone(11)
+	for _, grid := range [][2]int{{2, 2}, {4, 4}, {2, 8}, {16, 1}, {1, 16}} {
+		checkGrid(t, "sobol", smp, grid[0], grid[1])
+	}
+}
+
+func TestHaltonStratification(t *testing.T) {
+	smp := NewHalton().Clone(11)
+	// The first 6 points of bases 2 and 3 fall in different cells of a
+	// 2x3 grid.
+	checkGrid(t, "halton", smp, 2, 3)
+}
@@ -61,6 +61,33 @@ func Cone(d, u, v vec64.Vector, maxCosAngle, s1, s2 float64) vec64.Vector {
 	return vec64.Add(vec64.Add(u.Scale(math.Cos(t1)), v.Scale(math.Sin(t1))).Scale(sinAngle), d.Scale(cosAngle))
 }
 
+// ShirleyDisk maps a square to a disk using P. Shirley's concentric disk algorithm.
+func ShirleyDisk(r1, r2 float64) (u, v float64) {
+	var phi, r float64
+	a, b := 2*r1-1, 2*r2-1
+
+	switch {
+	case a > -b && a > b:
+		r = a
+		phi = math.Pi / 4 * (b / a)
+	case a > -b && a <= b:
+		r = b
+		phi = math.Pi / 4 * (2 - a/b)
+	case a <= -b && a < b:
+		r = -a
+		phi = math.Pi / 4 * (4 + b/a)
+	default:
+		r = -b
+		if b != 0 {
+			phi = math.Pi / 4 * (6 - a/b)
+		} else {
+			phi = 0
+		}
+	}
+
+	return r * math.Cos(phi), r * math.Sin(phi)
+}
+
 // AddMod1 performs an floating-point addition modulo 1. Both values must be in the range [0,1].
 func AddMod1(a, b float64) (s float64) {
 	s = a + b
@@ -95,9 +122,21 @@ func NewPdf1D(f []float64) (p Pdf1D) {
 
 func (p Pdf1D) Len() int { return len(p.F) }
 
+// Sample picks a point in [0, Len()) with density proportional to the
+// function, given a uniform sample u in [0, 1].  The returned PDF is the
+// density of picking the point divided by Len(), as if the function were
+// spread over [0, 1].
 func (p Pdf1D) Sample(u float64) (offset, pdf float64) {
-	index := sort.Search(len(p.Cdf), func(i int) bool { return p.Cdf[i] >= u }) - 1
-	delta := (u - p.Cdf[index]) / (p.Cdf[index+1] - p.Cdf[index])
+	index := sort.Search(len(p.Cdf), func(i int) bool { return p.Cdf[i] > u }) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= len(p.F) {
+		index = len(p.F) - 1
+	}
+	delta := 0.0
+	if d := p.Cdf[index+1] - p.Cdf[index]; d > 0 {
+		delta = math.Min((u-p.Cdf[index])/d, 1)
+	}
 	return float64(index) + delta, p.F[index] / p.Integral
 }
 
@@ -110,3 +149,64 @@ func (p Pdf1D) DiscreteSample(u float64) (index int, pdf float64) {
 	pdf = p.F[index] / p.Integral
 	return
 }
+
+// Pdf2D stores a 2-dimensional probability distribution function over a grid
+// of cells.  Rows are picked by their total and columns are picked by their
+// value in the picked row.
+type Pdf2D struct {
+	Rows     []Pdf1D
+	Marginal Pdf1D
+}
+
+// NewPdf2D creates a new probability distribution function from a function
+// stored by rows, with w cells in each row.  The function must have at least
+// one positive cell.
+func NewPdf2D(f []float64, w int) (p Pdf2D) {
+	h := len(f) / w
+	p.Rows = make([]Pdf1D, h)
+	totals := make([]float64, h)
+	for y := range p.Rows {
+		p.Rows[y] = NewPdf1D(f[y*w : (y+1)*w])
+		totals[y] = p.Rows[y].Integral
+	}
+	p.Marginal = NewPdf1D(totals)
+	return
+}
+
+// Width returns the number of cells in each row.
+func (p Pdf2D) Width() int { return p.Rows[0].Len() }
+
+// Height returns the number of rows.
+func (p Pdf2D) Height() int { return len(p.Rows) }
+
+// Sample picks a point in [0, Width()) x [0, Height()) with density
+// proportional to the function, given uniform samples u1 (for the column) and
+// u2 (for the row).  Like Pdf1D, the returned PDF is the density over the
+// unit square.
+func (p Pdf2D) Sample(u1, u2 float64) (x, y, pdf float64) {
+	y, pdfY := p.Marginal.Sample(u2)
+	x, pdfX := p.Rows[int(y)].Sample(u1)
+	return x, y, pdfX * pdfY
+}
+
+// Pdf returns the density over the unit square of Sample picking the cell
+// that contains the point (x, y).
+func (p Pdf2D) Pdf(x, y float64) float64 {
+	iy := clampIndex(int(y), p.Height())
+	ix := clampIndex(int(x), p.Width())
+	row := p.Rows[iy]
+	if row.Integral <= 0 {
+		return 0
+	}
+	return row.F[ix] / row.Integral * p.Marginal.F[iy] / p.Marginal.Integral
+}
+
+func clampIndex(i, n int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i >= n:
+		return n - 1
+	}
+	return i
+}
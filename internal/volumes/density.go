@@ -0,0 +1,81 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volumes
+
+import (
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+)
+
+// densityRegion is a box filled with a medium whose density varies over space.
+// The medium's coefficients are scaled by the density at each point.
+type densityRegion struct {
+	Medium
+	bound   bound.Bound
+	density func(p vec64.Vector) float64
+}
+
+var _ goray.VolumeRegion = &densityRegion{}
+
+func (d *densityRegion) densityAt(p vec64.Vector) float64 {
+	if !d.bound.Includes(p) {
+		return 0
+	}
+	return d.density(p)
+}
+
+func (d *densityRegion) SigmaA(p, v vec64.Vector) color.Color {
+	return color.ScalarMul(d.Medium.SigmaA, d.densityAt(p))
+}
+
+func (d *densityRegion) SigmaS(p, v vec64.Vector) color.Color {
+	return color.ScalarMul(d.Medium.SigmaS, d.densityAt(p))
+}
+
+func (d *densityRegion) SigmaT(p, v vec64.Vector) color.Color {
+	return color.ScalarMul(d.sigmaT(), d.densityAt(p))
+}
+
+func (d *densityRegion) Emission(p, v vec64.Vector) color.Color {
+	return color.ScalarMul(d.Medium.Emission, d.densityAt(p))
+}
+
+func (d *densityRegion) Attenuation(p vec64.Vector, l goray.Light) float64 { return 1 }
+
+func (d *densityRegion) Tau(r goray.Ray, step, offset float64) color.Color {
+	t0, t1, ok := d.Intersect(r)
+	if !ok {
+		return color.Black
+	}
+	return color.ScalarMul(d.sigmaT(), march(r, t0, t1, step, offset, d.density))
+}
+
+func (d *densityRegion) Intersect(r goray.Ray) (t0, t1 float64, ok bool) {
+	t0, t1, ok = crossBound(d.bound, r)
+	if !ok {
+		return
+	}
+	return clip(r, t0, t1)
+}
+
+func (d *densityRegion) Bound() bound.Bound { return d.bound }
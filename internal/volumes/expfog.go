@@ -0,0 +1,75 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volumes
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
+	"zombiezen.com/go/goray/internal/goray"
+	"zombiezen.com/go/goray/internal/vecutil"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// NewExpFog creates a volume region that fills a box with fog that thins out
+// exponentially with height.  The density at a height h above the bottom of
+// the box is a·exp(-b·h).
+func NewExpFog(box bound.Bound, a, b float64, med Medium) goray.VolumeRegion {
+	base := box.Min[vecutil.Y]
+	return &densityRegion{
+		Medium: med,
+		bound:  box,
+		density: func(p vec64.Vector) float64 {
+			return a * math.Exp(-b*(p[vecutil.Y]-base))
+		},
+	}
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"volumes/expfog"] = yamlscene.MapConstruct(constructExpFog)
+}
+
+func constructExpFog(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	m.SetDefault("a", 1.0)
+	m.SetDefault("b", 1.0)
+
+	med, err := constructMedium(m)
+	if err != nil {
+		return nil, err
+	}
+	box, err := constructBound(m)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := yamldata.AsFloat(m["a"])
+	if !ok || a < 0 {
+		return nil, errors.New("a must be a non-negative float")
+	}
+	b, ok := yamldata.AsFloat(m["b"])
+	if !ok {
+		return nil, errors.New("b must be a float")
+	}
+	return NewExpFog(box, a, b, med), nil
+}
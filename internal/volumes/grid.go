@@ -0,0 +1,235 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volumes
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	slashpath "path"
+	"path/filepath"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yaml/parser"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// maxGridSize limits the number of voxels read from a grid file.
+const maxGridSize = 1 << 28
+
+// gridChunkSize is the number of voxels that ReadGrid reads at a time, so
+// that a header cannot make it allocate more than the file holds.
+const gridChunkSize = 1 << 16
+
+// Grid is a three-dimensional array of density samples.
+type Grid struct {
+	NX, NY, NZ int
+	Data       []float32 // X varies fastest, then Y, then Z
+}
+
+// At returns the density of the voxel at the given indices, which are
+// clamped to the grid.
+func (g *Grid) At(x, y, z int) float64 {
+	x = clampIndex(x, g.NX)
+	y = clampIndex(y, g.NY)
+	z = clampIndex(z, g.NZ)
+	return float64(g.Data[(z*g.NY+y)*g.NX+x])
+}
+
+func clampIndex(i, n int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i >= n:
+		return n - 1
+	}
+	return i
+}
+
+// Lookup trilinearly interpolates the density at a point in [0, 1]³, where
+// the voxel samples lie at the centers of the cells.
+func (g *Grid) Lookup(u, v, w float64) float64 {
+	x, dx := gridCoord(u, g.NX)
+	y, dy := gridCoord(v, g.NY)
+	z, dz := gridCoord(w, g.NZ)
+	lerp := func(t, a, b float64) float64 { return a + t*(b-a) }
+	d00 := lerp(dx, g.At(x, y, z), g.At(x+1, y, z))
+	d10 := lerp(dx, g.At(x, y+1, z), g.At(x+1, y+1, z))
+	d01 := lerp(dx, g.At(x, y, z+1), g.At(x+1, y, z+1))
+	d11 := lerp(dx, g.At(x, y+1, z+1), g.At(x+1, y+1, z+1))
+	return lerp(dz, lerp(dy, d00, d10), lerp(dy, d01, d11))
+}
+
+func gridCoord(u float64, n int) (i int, frac float64) {
+	f := u*float64(n) - 0.5
+	fi := math.Floor(f)
+	return int(fi), f - fi
+}
+
+// ReadGrid reads a raw voxel file.  The file starts with the dimensions of
+// the grid as three little-endian 32-bit unsigned integers (X, Y, then Z),
+// followed by one little-endian 32-bit float density for every voxel, with X
+// varying fastest and Z slowest.
+func ReadGrid(r io.Reader) (*Grid, error) {
+	var dims [3]uint32
+	if err := binary.Read(r, binary.LittleEndian, &dims); err != nil {
+		return nil, err
+	}
+	n := uint64(dims[0]) * uint64(dims[1]) * uint64(dims[2])
+	if n == 0 {
+		return nil, errors.New("Grid must not be empty")
+	}
+	if n > maxGridSize {
+		return nil, errors.New("Grid is too large")
+	}
+	g := &Grid{
+		NX: int(dims[0]),
+		NY: int(dims[1]),
+		NZ: int(dims[2]),
+	}
+	chunk := make([]float32, gridChunkSize)
+	for left := int(n); left > 0; left -= len(chunk) {
+		if left < len(chunk) {
+			chunk = chunk[:left]
+		}
+		if err := binary.Read(r, binary.LittleEndian, chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		g.Data = append(g.Data, chunk...)
+	}
+	return g, nil
+}
+
+// GridLoader is an interface for retrieving voxel grids with a name.
+type GridLoader interface {
+	LoadGrid(name string) (*Grid, error)
+}
+
+// GridLoaderFunc uses a function to perform loads.
+type GridLoaderFunc func(string) (*Grid, error)
+
+func (f GridLoaderFunc) LoadGrid(name string) (*Grid, error) {
+	return f(name)
+}
+
+type fileGridLoader struct {
+	Base  string
+	Clean bool
+}
+
+func (l *fileGridLoader) LoadGrid(name string) (*Grid, error) {
+	if name == "" {
+		return nil, errors.New("name must not be empty")
+	}
+	if l.Clean {
+		name = slashpath.Clean("/" + name)
+	}
+	path := filepath.FromSlash(name)
+	if l.Clean || name[0] != '/' {
+		path = filepath.Join(l.Base, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadGrid(f)
+}
+
+// NewGridLoader creates a grid loader that reads raw voxel files relative to
+// the given directory.  Users of the loader can access anything in local
+// storage.
+func NewGridLoader(base string) GridLoader {
+	return &fileGridLoader{Base: base}
+}
+
+// NewGridLoaderDirectory creates a grid loader that is rooted at a given
+// directory.  Users of the loader will not directly be able to access anything
+// outside the directory, but symlinks inside the directory will be followed.
+func NewGridLoaderDirectory(base string) GridLoader {
+	return &fileGridLoader{Base: base, Clean: true}
+}
+
+// NewGridVolume creates a volume region whose density is given by a voxel
+// grid stretched over a box.
+func NewGridVolume(box bound.Bound, g *Grid, med Medium) goray.VolumeRegion {
+	size := box.Size()
+	return &densityRegion{
+		Medium: med,
+		bound:  box,
+		density: func(p vec64.Vector) float64 {
+			return g.Lookup(
+				(p[0]-box.Min[0])/size[0],
+				(p[1]-box.Min[1])/size[1],
+				(p[2]-box.Min[2])/size[2],
+			)
+		},
+	}
+}
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"volumes/grid"] = yamldata.ConstructorFunc(constructGrid)
+}
+
+func constructGrid(n parser.Node, ud interface{}) (interface{}, error) {
+	mm, ok := n.(*parser.Mapping)
+	if !ok {
+		return nil, errors.New("Constructor requires a mapping")
+	}
+
+	var loader GridLoader
+	if userData, ok := ud.(yamlscene.Params); ok && userData != nil {
+		loader, ok = userData["GridLoader"].(GridLoader)
+		if !ok && userData["GridLoader"] != nil {
+			return nil, errors.New("GridLoader does not implement volumes.GridLoader interface")
+		}
+	}
+	if loader == nil {
+		return nil, errors.New("No grid loader provided")
+	}
+
+	m := yamldata.Map(mm.Map())
+	med, err := constructMedium(m)
+	if err != nil {
+		return nil, err
+	}
+	box, err := constructBound(m)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := m["name"].(string)
+	if !ok {
+		return nil, errors.New("Grid must contain name")
+	}
+	g, err := loader.LoadGrid(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewGridVolume(box, g, med), nil
+}
@@ -0,0 +1,178 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volumes
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+	"zombiezen.com/go/goray/internal/yamlscene"
+)
+
+// shape is the region of space that a homogeneous medium fills.
+type shape interface {
+	intersect(r goray.Ray) (t0, t1 float64, ok bool)
+	includes(p vec64.Vector) bool
+	bound() bound.Bound
+}
+
+type boxShape bound.Bound
+
+func (b boxShape) intersect(r goray.Ray) (t0, t1 float64, ok bool) {
+	return crossBound(bound.Bound(b), r)
+}
+
+func (b boxShape) includes(p vec64.Vector) bool { return bound.Bound(b).Includes(p) }
+func (b boxShape) bound() bound.Bound           { return bound.Bound(b) }
+
+type sphereShape struct {
+	center vec64.Vector
+	radius float64
+}
+
+func (s sphereShape) intersect(r goray.Ray) (t0, t1 float64, ok bool) {
+	vf := vec64.Sub(r.From, s.center)
+	a := vec64.Dot(r.Dir, r.Dir)
+	b := 2 * vec64.Dot(vf, r.Dir)
+	c := vec64.Dot(vf, vf) - s.radius*s.radius
+	d := b*b - 4*a*c
+	if a == 0 || d < 0 {
+		return 0, 0, false
+	}
+	sq := math.Sqrt(d)
+	t0, t1 = (-b-sq)/(2*a), (-b+sq)/(2*a)
+	return t0, t1, t1 >= 0
+}
+
+func (s sphereShape) includes(p vec64.Vector) bool {
+	return vec64.Sub(p, s.center).LengthSqr() <= s.radius*s.radius
+}
+
+func (s sphereShape) bound() bound.Bound {
+	r := vec64.Vector{s.radius, s.radius, s.radius}
+	return bound.Bound{Min: vec64.Sub(s.center, r), Max: vec64.Add(s.center, r)}
+}
+
+type homogeneous struct {
+	Medium
+	shape shape
+}
+
+var _ goray.VolumeRegion = &homogeneous{}
+
+// NewHomogeneousBox creates a volume region that fills a box with a medium
+// of uniform density.
+func NewHomogeneousBox(b bound.Bound, med Medium) goray.VolumeRegion {
+	return &homogeneous{Medium: med, shape: boxShape(b)}
+}
+
+// NewHomogeneousSphere creates a volume region that fills a sphere with a
+// medium of uniform density.
+func NewHomogeneousSphere(center vec64.Vector, radius float64, med Medium) goray.VolumeRegion {
+	return &homogeneous{Medium: med, shape: sphereShape{center, radius}}
+}
+
+func (h *homogeneous) SigmaA(p, v vec64.Vector) color.Color {
+	if !h.shape.includes(p) {
+		return color.Black
+	}
+	return h.Medium.SigmaA
+}
+
+func (h *homogeneous) SigmaS(p, v vec64.Vector) color.Color {
+	if !h.shape.includes(p) {
+		return color.Black
+	}
+	return h.Medium.SigmaS
+}
+
+func (h *homogeneous) SigmaT(p, v vec64.Vector) color.Color {
+	if !h.shape.includes(p) {
+		return color.Black
+	}
+	return h.sigmaT()
+}
+
+func (h *homogeneous) Emission(p, v vec64.Vector) color.Color {
+	if !h.shape.includes(p) {
+		return color.Black
+	}
+	return h.Medium.Emission
+}
+
+func (h *homogeneous) Attenuation(p vec64.Vector, l goray.Light) float64 { return 1 }
+
+func (h *homogeneous) Tau(r goray.Ray, step, offset float64) color.Color {
+	t0, t1, ok := h.Intersect(r)
+	if !ok {
+		return color.Black
+	}
+	return color.ScalarMul(h.sigmaT(), t1-t0)
+}
+
+func (h *homogeneous) Intersect(r goray.Ray) (t0, t1 float64, ok bool) {
+	t0, t1, ok = h.shape.intersect(r)
+	if !ok {
+		return
+	}
+	return clip(r, t0, t1)
+}
+
+func (h *homogeneous) Bound() bound.Bound { return h.shape.bound() }
+
+func init() {
+	yamlscene.Constructor[yamlscene.StdPrefix+"volumes/homogeneous"] = yamlscene.MapConstruct(constructHomogeneous)
+}
+
+func constructHomogeneous(m yamldata.Map) (interface{}, error) {
+	m = m.Copy()
+	m.SetDefault("shape", "box")
+
+	med, err := constructMedium(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m["shape"] {
+	case "box":
+		b, err := constructBound(m)
+		if err != nil {
+			return nil, err
+		}
+		return NewHomogeneousBox(b, med), nil
+	case "sphere":
+		center, ok := m["center"].(vec64.Vector)
+		if !ok {
+			return nil, errors.New("Center must be a vector")
+		}
+		radius, ok := yamldata.AsFloat(m["radius"])
+		if !ok || radius <= 0 {
+			return nil, errors.New("Radius must be a positive float")
+		}
+		return NewHomogeneousSphere(center, radius, med), nil
+	}
+	return nil, errors.New("Shape must be box or sphere")
+}
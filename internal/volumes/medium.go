@@ -0,0 +1,141 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volumes
+
+import (
+	"errors"
+	"math"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+	yamldata "zombiezen.com/go/goray/internal/yaml/data"
+)
+
+// Medium holds the optical properties of a participating medium at unit density.
+type Medium struct {
+	SigmaA   color.Color // absorption coefficient
+	SigmaS   color.Color // scattering coefficient
+	Emission color.Color
+
+	// G is the asymmetry parameter of the Henyey-Greenstein phase function.
+	// It must be in (-1, 1): negative values scatter light backward, positive
+	// values scatter light forward, and zero scatters light uniformly.
+	G float64
+}
+
+// P evaluates the Henyey-Greenstein phase function for the medium.
+func (m Medium) P(l, s vec64.Vector) float64 {
+	return HenyeyGreenstein(l, s, m.G)
+}
+
+func (m Medium) sigmaT() color.Color {
+	return color.Add(m.SigmaA, m.SigmaS)
+}
+
+// HenyeyGreenstein evaluates the Henyey-Greenstein phase function for light
+// arriving from the direction l and leaving in the direction s.  Both
+// directions point away from the scattering point and must be normalized.
+func HenyeyGreenstein(l, s vec64.Vector, g float64) float64 {
+	cosTheta := -vec64.Dot(l, s)
+	denom := 1 + g*g - 2*g*cosTheta
+	return (1 - g*g) / (4 * math.Pi * denom * math.Sqrt(denom))
+}
+
+// clip restricts the interval [t0, t1] to the extent of a ray.
+func clip(r goray.Ray, t0, t1 float64) (float64, float64, bool) {
+	t0 = math.Max(t0, r.TMin)
+	if r.TMax >= 0 {
+		t1 = math.Min(t1, r.TMax)
+	}
+	return t0, t1, t1 > t0
+}
+
+// crossBound returns where a ray enters and leaves a bounding box.
+func crossBound(b bound.Bound, r goray.Ray) (t0, t1 float64, ok bool) {
+	t0, t1, ok = b.Cross(r.From, r.Dir, math.Inf(1))
+	if !ok {
+		return
+	}
+	// Cross doesn't reject rays parallel to a slab that they lie outside of.
+	mid := vec64.Add(r.From, r.Dir.Scale((t0+t1)/2))
+	return t0, t1, b.Includes(mid)
+}
+
+// march integrates a density function along the part of a ray between t0 and
+// t1 with the midpoint-offset rule described by goray.VolumeRegion.Tau.
+func march(r goray.Ray, t0, t1, step, offset float64, density func(vec64.Vector) float64) float64 {
+	n := int(math.Ceil((t1 - t0) / step))
+	if n < 1 {
+		n = 1
+	}
+	dt := (t1 - t0) / float64(n)
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		p := vec64.Add(r.From, r.Dir.Scale(t0+(float64(i)+offset)*dt))
+		sum += density(p)
+	}
+	return sum * dt
+}
+
+func constructMedium(m yamldata.Map) (med Medium, err error) {
+	m = m.Copy()
+	m.SetDefault("sigmaA", color.Black)
+	m.SetDefault("sigmaS", color.Black)
+	m.SetDefault("emission", color.Black)
+	m.SetDefault("g", 0.0)
+
+	var ok bool
+	if med.SigmaA, ok = m["sigmaA"].(color.Color); !ok {
+		return Medium{}, errors.New("sigmaA must be an RGB")
+	}
+	if med.SigmaS, ok = m["sigmaS"].(color.Color); !ok {
+		return Medium{}, errors.New("sigmaS must be an RGB")
+	}
+	if med.Emission, ok = m["emission"].(color.Color); !ok {
+		return Medium{}, errors.New("Emission must be an RGB")
+	}
+	if med.G, ok = yamldata.AsFloat(m["g"]); !ok {
+		return Medium{}, errors.New("g must be a float")
+	}
+	if med.G <= -1 || med.G >= 1 {
+		return Medium{}, errors.New("g must be between -1 and 1")
+	}
+	return med, nil
+}
+
+func constructBound(m yamldata.Map) (bound.Bound, error) {
+	min, ok := m["min"].(vec64.Vector)
+	if !ok {
+		return bound.Bound{}, errors.New("Min must be a vector")
+	}
+	max, ok := m["max"].(vec64.Vector)
+	if !ok {
+		return bound.Bound{}, errors.New("Max must be a vector")
+	}
+	for axis := 0; axis < 3; axis++ {
+		if min[axis] >= max[axis] {
+			return bound.Bound{}, errors.New("Min must be less than max")
+		}
+	}
+	return bound.Bound{Min: min, Max: max}, nil
+}
@@ -0,0 +1,142 @@
+/*
+	Copyright (c) 2011 Ross Light.
+	Copyright (c) 2005 Mathias Wein, Alejandro Conty, and Alfredo de Greef.
+
+	This file is part of goray.
+
+	goray is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	goray is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with goray.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package volumes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"bitbucket.org/zombiezen/math3/vec64"
+	"zombiezen.com/go/goray/internal/bound"
+	"zombiezen.com/go/goray/internal/color"
+	"zombiezen.com/go/goray/internal/goray"
+)
+
+func TestHenyeyGreensteinNormalized(t *testing.T) {
+	const n = 20000
+	s := vec64.Vector{0, 0, 1}
+	for _, g := range []float64{-0.7, 0, 0.3, 0.9} {
+		// Integrate over the sphere of incoming directions.
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			cosTheta := -1 + 2*(float64(i)+0.5)/n
+			sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+			l := vec64.Vector{sinTheta, 0, cosTheta}
+			sum += HenyeyGreenstein(l, s, g) * 2 * math.Pi * 2 / n
+		}
+		if math.Abs(sum-1) > 1e-3 {
+			t.Errorf("HenyeyGreenstein(g=%v) integrates to %v", g, sum)
+		}
+	}
+}
+
+func TestHenyeyGreensteinForward(t *testing.T) {
+	s := vec64.Vector{0, 0, 1}
+	forward := HenyeyGreenstein(s.Negate(), s, 0.5)
+	backward := HenyeyGreenstein(s, s, 0.5)
+	if forward <= backward {
+		t.Errorf("g=0.5 scatters more backward (%v) than forward (%v)", backward, forward)
+	}
+}
+
+func TestHomogeneousTau(t *testing.T) {
+	med := Medium{SigmaA: color.Gray(0.25), SigmaS: color.Gray(0.25)}
+	box := NewHomogeneousBox(bound.Bound{vec64.Vector{-1, -1, -1}, vec64.Vector{1, 1, 1}}, med)
+	sphere := NewHomogeneousSphere(vec64.Vector{}, 1, med)
+	tests := []struct {
+		Name     string
+		Region   goray.VolumeRegion
+		Ray      goray.Ray
+		Expected float64
+	}{
+		{"box", box, goray.Ray{From: vec64.Vector{-5, 0, 0}, Dir: vec64.Vector{1, 0, 0}, TMax: -1}, 1.0},
+		{"box inside", box, goray.Ray{From: vec64.Vector{0, 0, 0}, Dir: vec64.Vector{1, 0, 0}, TMax: -1}, 0.5},
+		{"box clipped", box, goray.Ray{From: vec64.Vector{-5, 0, 0}, Dir: vec64.Vector{1, 0, 0}, TMax: 4.5}, 0.25},
+		{"box miss", box, goray.Ray{From: vec64.Vector{-5, 2, 0}, Dir: vec64.Vector{1, 0, 0}, TMax: -1}, 0},
+		{"sphere", sphere, goray.Ray{From: vec64.Vector{0, 0, -5}, Dir: vec64.Vector{0, 0, 1}, TMax: -1}, 1.0},
+		{"sphere behind", sphere, goray.Ray{From: vec64.Vector{0, 0, 5}, Dir: vec64.Vector{0, 0, 1}, TMax: -1}, 0},
+	}
+	for _, test := range tests {
+		tau := test.Region.Tau(test.Ray, 0.1, 0.5).Red()
+		if math.Abs(tau-test.Expected) > 1e-9 {
+			t.Errorf("%s: Tau = %v; want %v", test.Name, tau, test.Expected)
+		}
+	}
+}
+
+func TestExpFogTau(t *testing.T) {
+	med := Medium{SigmaA: color.Gray(1), SigmaS: color.Gray(0)}
+	fog := NewExpFog(bound.Bound{vec64.Vector{-1, 0, -1}, vec64.Vector{1, 2, 1}}, 2, 1, med)
+	r := goray.Ray{From: vec64.Vector{0, -1, 0}, Dir: vec64.Vector{0, 1, 0}, TMax: -1}
+	tau := fog.Tau(r, 0.01, 0.5).Red()
+	expected := 2 * (1 - math.Exp(-2))
+	if math.Abs(tau-expected) > 1e-4 {
+		t.Errorf("Tau = %v; want %v", tau, expected)
+	}
+}
+
+func TestReadGrid(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint32{2, 1, 1})
+	binary.Write(&buf, binary.LittleEndian, []float32{1, 3})
+	g, err := ReadGrid(&buf)
+	if err != nil {
+		t.Fatal("ReadGrid:", err)
+	}
+	if g.NX != 2 || g.NY != 1 || g.NZ != 1 {
+		t.Fatalf("dimensions = %dx%dx%d; want 2x1x1", g.NX, g.NY, g.NZ)
+	}
+	lookups := []struct {
+		U, Expected float64
+	}{
+		{0, 1},
+		{0.25, 1},
+		{0.5, 2},
+		{0.75, 3},
+		{1, 3},
+	}
+	for _, l := range lookups {
+		if d := g.Lookup(l.U, 0.5, 0.5); math.Abs(d-l.Expected) > 1e-9 {
+			t.Errorf("Lookup(%v, 0.5, 0.5) = %v; want %v", l.U, d, l.Expected)
+		}
+	}
+}
+
+func TestReadGridShort(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint32{2, 2, 2})
+	binary.Write(&buf, binary.LittleEndian, []float32{1, 2, 3})
+	if _, err := ReadGrid(&buf); err == nil {
+		t.Error("ReadGrid did not fail on a truncated file")
+	}
+}
+
+func TestReadGridHugeHeader(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint32{1 << 9, 1 << 9, 1 << 9})
+	binary.Write(&buf, binary.LittleEndian, []float32{1, 2, 3})
+	if _, err := ReadGrid(&buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadGrid error = %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+}
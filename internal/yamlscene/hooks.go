@@ -23,6 +23,7 @@ package yamlscene
 import (
 	"errors"
 
+	"bitbucket.org/zombiezen/math3/mat64"
 	"bitbucket.org/zombiezen/math3/vec64"
 	"zombiezen.com/go/goray/internal/color"
 	"zombiezen.com/go/goray/internal/goray"
@@ -33,9 +34,13 @@ import (
 type MapConstruct func(yamldata.Map) (interface{}, error)
 
 func (f MapConstruct) Construct(n parser.Node, userData interface{}) (data interface{}, err error) {
-	if node, ok := n.(*parser.Mapping); ok {
+	switch node := n.(type) {
+	case *parser.Mapping:
 		data, err = f(node.Map())
-	} else {
+	case *parser.Empty:
+		// A tag without a value takes all of the defaults.
+		data, err = f(yamldata.Map{})
+	default:
 		err = errors.New("Constructor requires a mapping")
 	}
 	return
@@ -103,6 +108,26 @@ func constructVector(n parser.Node, userData interface{}) (data interface{}, err
 	return vec64.Vector{comps[0], comps[1], comps[2]}, nil
 }
 
+// asMatrix converts a sequence of four rows of four floats into a matrix.
+func asMatrix(data interface{}) (mat mat64.Matrix, ok bool) {
+	rows, ok := yamldata.AsSequence(data)
+	if !ok || len(rows) != 4 {
+		return mat, false
+	}
+	for i := range rows {
+		row, ok := yamldata.AsSequence(rows[i])
+		if !ok || len(row) != 4 {
+			return mat, false
+		}
+		for j := range row {
+			if mat[i][j], ok = yamldata.AsFloat(row[j]); !ok {
+				return mat, false
+			}
+		}
+	}
+	return mat, true
+}
+
 func constructMesh(m yamldata.Map) (data interface{}, err error) {
 	m = m.Copy()
 	m.SetDefault("vertices", []interface{}{})
@@ -156,6 +181,26 @@ func constructMesh(m yamldata.Map) (data interface{}, err error) {
 	}
 	mesh.SetData(vertexData, nil, uvData)
 
+	// Motion
+	startData, hasStart := m["startTransform"]
+	endData, hasEnd := m["endTransform"]
+	if hasStart || hasEnd {
+		start, end := mat64.Identity, mat64.Identity
+		if hasStart {
+			if start, ok = asMatrix(startData); !ok {
+				err = errors.New("Start transform must be 4 rows of 4 floats")
+				return
+			}
+		}
+		if hasEnd {
+			if end, ok = asMatrix(endData); !ok {
+				err = errors.New("End transform must be 4 rows of 4 floats")
+				return
+			}
+		}
+		mesh.SetMotion(start, end)
+	}
+
 	// Parse faces
 	// TODO: Error handling
 	for i, _ := range faces {
@@ -21,6 +21,7 @@
 package yamlscene
 
 import (
+	"errors"
 	"io"
 
 	"zombiezen.com/go/goray/internal/goray"
@@ -58,14 +59,134 @@ func Load(r io.Reader, sc *goray.Scene, params Params) (i goray.Integrator, err
 		sc.AddLight(l)
 	}
 
+	volumes, _ := yamldata.AsSequence(root["volumes"])
+	for i := range volumes {
+		vr := volumes[i].(goray.VolumeRegion)
+		sc.AddVolumeRegion(vr)
+	}
+
 	camera, _ := root["camera"].(goray.Camera)
 	sc.SetCamera(camera)
 
+	if bg, ok := root["background"].(goray.Background); ok {
+		sc.SetBackground(bg)
+	}
+
+	if vi, ok := root["volumeIntegrator"].(goray.VolumeIntegrator); ok {
+		sc.SetVolumeIntegrator(vi)
+	}
+
+	if f, ok := root["filter"].(goray.Filter); ok {
+		sc.SetFilter(f)
+	}
+
+	if smp, ok := root["sampler"].(goray.Sampler); ok {
+		sc.SetSampler(smp)
+	}
+
+	if aa, ok := yamldata.AsMap(root["antialiasing"]); ok {
+		if err = setAntialiasing(sc, aa); err != nil {
+			return
+		}
+	}
+
+	if seed, present := root["seed"]; present {
+		n, ok := yamldata.AsInt(seed)
+		if !ok {
+			err = errors.New("Seed must be an integer")
+			return
+		}
+		sc.SetSeed(int64(n))
+	}
+
+	if tiles, ok := yamldata.AsMap(root["tiles"]); ok {
+		if err = setTiles(sc, tiles); err != nil {
+			return
+		}
+	}
+
+	if aovs, present := root["aovs"]; present {
+		if err = addAOVs(sc, aovs); err != nil {
+			return
+		}
+	}
+
 	// Get integrator and finish
 	i = root["integrator"].(goray.Integrator)
 	return
 }
 
+// setAntialiasing reads the scene's antialiasing parameters from a mapping.
+func setAntialiasing(sc *goray.Scene, m yamldata.Map) error {
+	m = m.Copy()
+	m.SetDefault("samples", 1)
+	m.SetDefault("passes", 1)
+	m.SetDefault("incSamples", 0)
+	m.SetDefault("threshold", 0.05)
+
+	samples, ok := yamldata.AsInt(m["samples"])
+	if !ok || samples < 1 {
+		return errors.New("Antialiasing samples must be a positive integer")
+	}
+	passes, ok := yamldata.AsInt(m["passes"])
+	if !ok || passes < 1 {
+		return errors.New("Antialiasing passes must be a positive integer")
+	}
+	incSamples, ok := yamldata.AsInt(m["incSamples"])
+	if !ok || incSamples < 0 {
+		return errors.New("Antialiasing incSamples must be a non-negative integer")
+	}
+	threshold, ok := yamldata.AsFloat(m["threshold"])
+	if !ok || threshold < 0 {
+		return errors.New("Antialiasing threshold must be a non-negative float")
+	}
+	sc.SetAntialiasing(samples, passes, incSamples, threshold)
+	return nil
+}
+
+// setTiles reads the size and order of the scene's render tiles from a mapping.
+func setTiles(sc *goray.Scene, m yamldata.Map) error {
+	m = m.Copy()
+	m.SetDefault("size", goray.DefaultTileSize)
+	m.SetDefault("order", "scanline")
+
+	size, ok := yamldata.AsInt(m["size"])
+	if !ok || size < 1 {
+		return errors.New("Tile size must be a positive integer")
+	}
+	name, ok := m["order"].(string)
+	if !ok {
+		return errors.New("Tile order must be a string")
+	}
+	order, err := goray.ParseTileOrder(name)
+	if err != nil {
+		return err
+	}
+	sc.SetTiles(size, order)
+	return nil
+}
+
+// addAOVs adds the arbitrary output variables named in a sequence to the
+// scene.
+func addAOVs(sc *goray.Scene, data interface{}) error {
+	names, ok := yamldata.AsSequence(data)
+	if !ok {
+		return errors.New("AOVs must be a sequence of names")
+	}
+	for _, n := range names {
+		name, ok := n.(string)
+		if !ok {
+			return errors.New("AOVs must be a sequence of names")
+		}
+		a, err := goray.ParseAOV(name)
+		if err != nil {
+			return err
+		}
+		sc.AddAOV(a)
+	}
+	return nil
+}
+
 func realConstructor(n parser.Node, userData interface{}) (interface{}, error) {
 	if _, ok := Constructor[n.Tag()]; ok {
 		return Constructor.Construct(n, userData)
@@ -124,7 +124,23 @@ def write_camera(f, scene):
         fy = scene.render.resolution_y * scene.render.pixel_aspect_y
         if fx <= fy:
             f_aspect = fx / fy
-        print(indent + "focalDistance: %f" % (cam.lens/(32 * f_aspect)), file=f)
+        # goray's sensor is always fit to the width of the image.  Blender
+        # before 2.61 has no sensor size or focus object, so fall back to its
+        # fixed 32mm sensor.
+        sensor_width = getattr(cam, "sensor_width", 32.0)
+        dof_object = getattr(cam, "dof_object", None)
+        dof_distance = getattr(cam, "dof_distance", 0.0)
+        print(indent + "focalLength: %f" % (cam.lens), file=f)
+        print(indent + "sensorWidth: %f" % (sensor_width * f_aspect), file=f)
+        if dof_object is not None:
+            focus = dof_object.matrix_world[3].xyz
+            print(indent + "focusOn: !goray!vec [%f, %f, %f]" % (focus.x, focus.y, focus.z), file=f)
+        elif dof_distance > 0:
+            print(indent + "dofDistance: %f" % (dof_distance), file=f)
+        if dof_object is not None or dof_distance > 0:
+            cycles = getattr(cam, "cycles", None)
+            if cycles is not None and cycles.aperture_type == 'FSTOP':
+                print(indent + "fStop: %f" % (cycles.aperture_fstop), file=f)
     else:
         raise AssertionError("Unrecognized camera type")
     # Camera transform